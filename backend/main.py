@@ -3,18 +3,37 @@
 import os
 import math
 import re
+import json
+import base64
+import logging
+from datetime import datetime, timezone
+import io
+import time
+import heapq
+import itertools
+import threading
+import urllib.request
 from collections import defaultdict
+from typing import List, Optional
 
-from fastapi import FastAPI, Query
+from fastapi import Depends, FastAPI, File, HTTPException, Query, Response, UploadFile
 from fastapi.middleware.cors import CORSMiddleware
+from fastapi.responses import HTMLResponse
 from dotenv import load_dotenv
-from pymongo import MongoClient
+from pymongo import MongoClient, TEXT
+from pymongo.errors import OperationFailure, PyMongoError
 from bson import ObjectId
+from bson.errors import InvalidId
+from PIL import Image, UnidentifiedImageError
+import strawberry
+from strawberry.fastapi import GraphQLRouter
 
 # ------------------ Config ------------------ #
 
 load_dotenv()
 
+log = logging.getLogger(__name__)
+
 IMG_DB_URI = os.getenv("IMG_DB_URI") or os.getenv("IMG_MONGO_URI") or os.getenv("MONGO_URI")
 IMG_DB_NAME = os.getenv("IMG_DB_NAME", "image_search_engine")
 
@@ -26,12 +45,89 @@ db = client[IMG_DB_NAME]
 
 IMG_DOCS = db["image_documents"]
 IMG_INDEX = db["image_terms"]
+IMG_STATS = db["image_index_stats"]
+DOMAINS = db["domains"]  # favicons, written by the crawler with IMG_FAVICONS=true
+IMG_SUGGEST = db["image_suggestions"]  # alt/caption phrases, written by image_indexer.py
+QUERIES = db["queries"]  # search log with clicks, for tuning ranking
+
+# Weighted text index the search runs on; image_indexer.py builds the same
+# one after each rebuild, since rebuilding drops image_documents.
+TEXT_INDEX_NAME = "image_text"
+TEXT_INDEX_WEIGHTS = {
+    "alt_text": 10,
+    "caption_text": 8,
+    "page_title": 4,
+    "nearest_heading": 3,
+    "context_text": 1,
+}
+IMG_DOCS.create_index(
+    [(field, TEXT) for field in TEXT_INDEX_WEIGHTS],
+    name=TEXT_INDEX_NAME,
+    weights=TEXT_INDEX_WEIGHTS,
+    default_language="english",
+)
+
+# $text matches are read up to this many per query; it bounds how deep
+# cursor pagination can go
+MAX_TEXT_CANDIDATES = 5000
+
+# BM25 parameters: term frequency saturation and document length
+# normalization (the usual defaults)
+BM25_K1 = 1.2
+BM25_B = 0.75
+
+# How much of the field-weighted Mongo text score is added to BM25, so a
+# match in alt text outranks the same match in surrounding text.
+TEXT_SCORE_BOOST = 0.1
+
+# Fields the structured filters query outside the text index
+IMG_DOCS.create_index("domain_name")
+IMG_DOCS.create_index("format")
+IMG_DOCS.create_index("pixel_width")
+IMG_DOCS.create_index("time_fetched")
+IMG_DOCS.create_index("orientation")
+IMG_DOCS.create_index("color_buckets")
+IMG_DOCS.create_index("dhash_bands")
+IMG_DOCS.create_index("nsfw_score")
+IMG_DOCS.create_index("page_url")
+
+QUERIES.create_index("time")
+QUERIES.create_index("query")
+
+# Values returned per facet, most common first
+FACET_LIMIT = 20
+
+# Text side of the model the crawler embedded images with (IMG_CLIP_URL).
+# Only images embedded by the same IMG_CLIP_MODEL are compared.
+CLIP_TEXT_URL = os.getenv("IMG_CLIP_TEXT_URL")
+CLIP_TOKEN = os.getenv("IMG_CLIP_TOKEN")
+CLIP_MODEL = os.getenv("IMG_CLIP_MODEL", "clip")
+# Image side of the same model, for searching by an uploaded image
+CLIP_IMAGE_URL = os.getenv("IMG_CLIP_URL")
+
+# Name of an Atlas Vector Search index on image_documents. Without one the
+# vectors are scanned in process. The index is defined in Atlas as
+#   {"fields": [{"type": "vector", "path": "embedding",
+#                "numDimensions": <model size>, "similarity": "cosine"},
+#               {"type": "filter", "path": "embedding_model"}]}
+VECTOR_INDEX = os.getenv("IMG_VECTOR_INDEX")
+# Results read from the vector index per query, which bounds how deep
+# pagination goes, and candidates it considers per result
+MAX_VECTOR_RESULTS = 1000
+VECTOR_CANDIDATES_PER_RESULT = 10
+
+# Safe search tier used when a request names none: off, moderate or strict
+SAFE_SEARCH_DEFAULT = os.getenv("IMG_SAFE_SEARCH", "moderate")
+# Domain reputation list and keyword blocklist, one entry per line; # starts
+# a comment. Without a keyword file a short built-in list is used.
+SAFE_DOMAINS_FILE = os.getenv("IMG_SAFE_DOMAINS_FILE")
+SAFE_KEYWORDS_FILE = os.getenv("IMG_SAFE_KEYWORDS_FILE")
 
 # ------------------ FastAPI app ------------------ #
 
 app = FastAPI(
     title="Image Search Engine API",
-    description="TF-IDF based image search API",
+    description="BM25 ranked image search API",
     version="1.0.0",
 )
 
@@ -65,88 +161,1130 @@ def tokenize(text: str):
 
 # ------------------ Image Search Logic ------------------ #
 
-def search_images(query: str, limit: int = 25):
+# License filter values: "reusable" matches any Creative Commons or public
+# domain license; anything else matches that normalized id as a prefix
+# (e.g. "CC-BY" also matches CC-BY-SA-4.0).
+REUSABLE_LICENSE_RE = r"^(CC-|CC0-|PDM-)"
+
+
+def license_query(license: str):
+    if not license:
+        return None
+    if license.lower() == "reusable":
+        return {"$regex": REUSABLE_LICENSE_RE}
+    return {"$regex": "^" + re.escape(license.upper())}
+
+
+# Face filter values: "with" keeps images showing at least one face,
+# "without" images checked and found to have none. Images the detector
+# never saw match neither.
+FACES_QUERIES = {
+    "with": {"$gte": 1},
+    "without": 0,
+}
+
+# Orientation filter values, as computed from the displayed aspect ratio
+# once the crawler knows an image's real dimensions.
+ORIENTATIONS = {"landscape", "portrait", "square", "panorama"}
+
+
+# File size bounds are in bytes; 0 leaves that side open. Images whose
+# size was never learned match no bounded query.
+def size_query(min_size: int, max_size: int):
+    bounds = {}
+    if min_size > 0:
+        bounds["$gte"] = min_size
+    if max_size > 0:
+        bounds["$lte"] = max_size
+    return bounds or None
+
+
+# Format filter values are the crawler's format names; "jpeg" is read as "jpg".
+FORMAT_ALIASES = {"jpeg": "jpg"}
+
+
+def parse_date(value: str, name: str):
+    """An ISO date or datetime query parameter, as UTC."""
+    try:
+        when = datetime.fromisoformat(value.strip().replace("Z", "+00:00"))
+    except ValueError:
+        raise HTTPException(status_code=400, detail=f"{name} must be an ISO date, e.g. 2024-01-01")
+    if when.tzinfo is None:
+        when = when.replace(tzinfo=timezone.utc)
+    return when
+
+
+class SearchFilters:
+    """
+    Query parameters that narrow a search. format and domain take a
+    comma-separated list; domain also matches subdomains (wikipedia.org
+    matches en.wikipedia.org). Dimension filters use the real pixel size,
+    so images never measured by the crawler's enrichment are left out.
+    """
+
+    def __init__(self, license: str = "", faces: str = "", orientation: str = "",
+                 min_size: int = 0, max_size: int = 0, format: str = "", domain: str = "",
+                 min_width: int = 0, min_height: int = 0, fetched_after: str = ""):
+        self.license = license
+        self.faces = faces
+        self.orientation = orientation
+        self.min_size = min_size
+        self.max_size = max_size
+        self.formats = [FORMAT_ALIASES.get(f, f) for f in split_list(format.lower())]
+        self.domains = split_list(domain.lower())
+        self.min_width = min_width
+        self.min_height = min_height
+        self.fetched_after = parse_date(fetched_after, "fetched_after") if fetched_after else None
+
+    def query(self):
+        q = {}
+        license_filter = license_query(self.license)
+        if license_filter:
+            q["license"] = license_filter
+        if self.faces in FACES_QUERIES:
+            q["faces_count"] = FACES_QUERIES[self.faces]
+        if self.orientation in ORIENTATIONS:
+            q["orientation"] = self.orientation
+        size_filter = size_query(self.min_size, self.max_size)
+        if size_filter:
+            q["file_size"] = size_filter
+        if self.formats:
+            q["format"] = {"$in": self.formats}
+        if self.domains:
+            hosts = "|".join(re.escape(d) for d in self.domains)
+            q["domain_name"] = {"$regex": f"(^|\\.)({hosts})$"}
+        if self.min_width > 0:
+            q["pixel_width"] = {"$gte": self.min_width}
+        if self.min_height > 0:
+            q["pixel_height"] = {"$gte": self.min_height}
+        if self.fetched_after:
+            q["time_fetched"] = {"$gte": self.fetched_after}
+        return q
+
+
+def split_list(value: str):
+    return [v.strip() for v in value.split(",") if v.strip()]
+
+
+# ------------------ Safe Search ------------------ #
+
+# NSFW score from which an image is hidden, per tier. Images the classifier
+# never scored are kept.
+SAFE_TIERS = {
+    "off": None,
+    "moderate": 0.8,
+    "strict": 0.4,
+}
+
+DEFAULT_BLOCKED_KEYWORDS = [
+    "porn", "porno", "xxx", "nsfw", "nude", "nudes", "naked", "nudity",
+    "sex", "sexy", "erotic", "hentai", "topless", "fetish", "lingerie",
+]
+
+# Text fields a strict search checks for blocked keywords
+SAFE_KEYWORD_FIELDS = ["alt_text", "caption_text", "page_title"]
+
+
+def read_list_file(path: str):
+    """Lowercased entries of a one-per-line list file."""
+    entries = []
+    with open(path, encoding="utf-8") as f:
+        for line in f:
+            line = line.split("#", 1)[0].strip().lower()
+            if line:
+                entries.append(line)
+    return entries
+
+
+SAFE_DOMAINS = read_list_file(SAFE_DOMAINS_FILE) if SAFE_DOMAINS_FILE else []
+SAFE_KEYWORDS = read_list_file(SAFE_KEYWORDS_FILE) if SAFE_KEYWORDS_FILE else DEFAULT_BLOCKED_KEYWORDS
+
+# listed domains match their subdomains too, as the domain filter does
+SAFE_DOMAINS_RE = (
+    re.compile("(^|\\.)(" + "|".join(re.escape(d) for d in SAFE_DOMAINS) + ")$", re.IGNORECASE)
+    if SAFE_DOMAINS else None
+)
+SAFE_KEYWORDS_RE = (
+    re.compile("\\b(" + "|".join(re.escape(k) for k in SAFE_KEYWORDS) + ")\\b", re.IGNORECASE)
+    if SAFE_KEYWORDS else None
+)
+
+
+class SafeSearch:
+    """
+    The safe query parameter, applied on every search endpoint:
+
+    off       nothing is hidden
+    moderate  images the NSFW classifier scores 0.8 or more, and images
+              from domains on the reputation list
+    strict    NSFW scores from 0.4, listed domains, and images whose alt
+              text, caption or page title has a blocked keyword; a query
+              with a blocked keyword finds nothing
+    """
+
+    def __init__(self, safe: str = SAFE_SEARCH_DEFAULT):
+        self.tier = safe.strip().lower()
+        if self.tier not in SAFE_TIERS:
+            raise HTTPException(status_code=400, detail="safe must be one of " + ", ".join(SAFE_TIERS))
+
+    def query(self):
+        threshold = SAFE_TIERS[self.tier]
+        if threshold is None:
+            return {}
+        conds = [{"nsfw_score": {"$not": {"$gte": threshold}}}]
+        if SAFE_DOMAINS_RE:
+            conds.append({"domain_name": {"$not": SAFE_DOMAINS_RE}})
+        if self.tier == "strict" and SAFE_KEYWORDS_RE:
+            conds += [{field: {"$not": SAFE_KEYWORDS_RE}} for field in SAFE_KEYWORD_FIELDS]
+        # under $and, so the filters' own conditions on these fields stay
+        return {"$and": conds}
+
+    def blocks(self, text: str):
+        """Whether a query is refused outright."""
+        return self.tier == "strict" and bool(SAFE_KEYWORDS_RE and SAFE_KEYWORDS_RE.search(text))
+
+
+def restrict(query: dict, filters: SearchFilters = None, safe: SafeSearch = None):
+    """query narrowed by the structured filters and the safe search tier."""
+    if filters:
+        query.update(filters.query())
+    if safe:
+        query.update(safe.query())
+    return query
+
+
+def search_images(query: str, limit: int = 25, filters: SearchFilters = None, cursor: str = "",
+                  safe: SafeSearch = None):
     terms = tokenize(query)
-    if not terms:
-        return []
+    if not terms or (safe and safe.blocks(query)):
+        return [], None
 
-    # Fetch index entries for all query terms
-    index_entries = list(IMG_INDEX.find({"term": {"$in": terms}}))
-    if not index_entries:
-        return []
+    # The text index matches and scores; the structured filters and safe
+    # search run in the same query
+    doc_filter = restrict({"$text": {"$search": " ".join(terms)}}, filters, safe)
+    projection = dict(RESULT_FIELDS, dup_group=1, length=1, text_score={"$meta": "textScore"})
+    try:
+        candidates = list(
+            IMG_DOCS.find(doc_filter, projection)
+            .sort([("text_score", {"$meta": "textScore"})])
+            .limit(MAX_TEXT_CANDIDATES)
+        )
+    except OperationFailure as e:
+        # the indexer drops image_documents, text index included, while it rebuilds
+        raise HTTPException(status_code=503, detail="search index unavailable: " + str(e))
+    if not candidates:
+        return [], None
 
-    scores = defaultdict(float)
+    bm25 = bm25_scores(terms, candidates)
+    scored = sorted(
+        ((bm25.get(doc["_id"], 0.0) + TEXT_SCORE_BOOST * doc["text_score"], doc) for doc in candidates),
+        key=rank_key,
+    )
+
+    # Collapse near-duplicates (same dup_group) to their best scoring copy
+    ranked = []
+    seen_groups = set()
+    for score, doc in scored:
+        group = doc.get("dup_group") or doc["_id"]
+        if group in seen_groups:
+            continue
+        seen_groups.add(group)
+        ranked.append((score, doc))
+
+    return paginate(ranked, limit, cursor)
+
+
+def corpus_stats():
+    """Document count and average token length of the indexed corpus."""
+    stats = IMG_STATS.find_one({"_id": "corpus"}) or {}
+    return stats.get("num_docs") or IMG_DOCS.estimated_document_count(), stats.get("avg_length") or 0.0
+
+
+def bm25_scores(terms, docs):
+    """BM25 score of each candidate document for the query terms, from the
+    term postings image_indexer.py wrote to image_terms."""
+    lengths = {doc["_id"]: doc.get("length") or 0 for doc in docs}
+    num_docs, avg_length = corpus_stats()
+    if not avg_length:
+        avg_length = sum(lengths.values()) / len(lengths) or 1.0
 
-    # TF-IDF scoring
-    for entry in index_entries:
-        term = entry["term"]
-        idf = entry.get("idf", 0.0)
+    # only the postings of the candidates are sent back, but df counts all
+    entries = IMG_INDEX.aggregate([
+        {"$match": {"term": {"$in": list(dict.fromkeys(terms))}}},
+        {"$project": {
+            "df": {"$size": "$docs"},
+            "docs": {"$filter": {"input": "$docs", "cond": {"$in": ["$$this.doc_id", list(lengths)]}}},
+        }},
+    ])
 
-        for posting in entry.get("docs", []):
-            doc_id = posting["doc_id"]
+    scores = defaultdict(float)
+    for entry in entries:
+        df = entry["df"]
+        idf = math.log(1 + (num_docs - df + 0.5) / (df + 0.5))
+        for posting in entry["docs"]:
             tf = posting["tf"]
-            score = (1 + math.log(tf)) * idf
-            scores[doc_id] += score
+            norm = 1 - BM25_B + BM25_B * lengths[posting["doc_id"]] / avg_length
+            scores[posting["doc_id"]] += idf * tf * (BM25_K1 + 1) / (tf + BM25_K1 * norm)
+    return scores
+
+
+RESULT_FIELDS = {
+    "file_url": 1,
+    "alt_text": 1,
+    "caption_text": 1,
+    "page_url": 1,
+    "page_title": 1,
+    "domain_name": 1,
+    "format": 1,
+    "snippet": 1,
+    "license": 1,
+    "license_url": 1,
+    "known_urls": 1,
+    "thumbnail_url": 1,
+    "aspect_ratio": 1,
+    "orientation": 1,
+    "file_size": 1,
+    "pixel_width": 1,
+    "pixel_height": 1,
+}
+
+
+def to_result(doc_id, meta, score):
+    return {
+        "id": str(doc_id),
+        "file_url": meta.get("file_url", ""),
+        "alt": meta.get("alt_text", ""),
+        "caption": meta.get("caption_text", ""),
+        "page_url": meta.get("page_url", ""),
+        "page_title": meta.get("page_title", ""),
+        "domain": meta.get("domain_name", ""),
+        "format": meta.get("format", ""),
+        "snippet": meta.get("snippet", ""),
+        "license": meta.get("license", ""),
+        "license_url": meta.get("license_url", ""),
+        "known_urls": meta.get("known_urls", []),
+        "thumbnail_url": meta.get("thumbnail_url", ""),
+        "aspect_ratio": meta.get("aspect_ratio"),
+        "orientation": meta.get("orientation", ""),
+        "file_size": meta.get("file_size"),
+        "width": meta.get("pixel_width"),
+        "height": meta.get("pixel_height"),
+        "score": score,
+    }
+
+
+def doc_key(doc_id: str):
+    """The _id of an image document: the crawler's ObjectId, as a string."""
+    try:
+        return ObjectId(doc_id)
+    except InvalidId:
+        return doc_id
+
+
+# ------------------ Pagination ------------------ #
+
+# Results are ordered by score, then id. A cursor carries the (score, id)
+# of the last result handed out and the next page starts strictly after
+# it, so images indexed between requests can't shift results into a
+# page twice the way skip/limit would.
+
+def rank_key(scored):
+    score, doc = scored
+    return (-score, str(doc["_id"]))
+
+
+def encode_cursor(score, doc_id):
+    raw = json.dumps({"score": score, "id": str(doc_id)}).encode("utf-8")
+    return base64.urlsafe_b64encode(raw).decode("ascii").rstrip("=")
+
+
+def decode_cursor(cursor: str):
+    """The rank_key of the result a cursor points at, or None for the first page."""
+    if not cursor:
+        return None
+    try:
+        data = json.loads(base64.urlsafe_b64decode(cursor + "=" * (-len(cursor) % 4)))
+        return (-float(data["score"]), str(data["id"]))
+    except (ValueError, KeyError, TypeError):
+        raise HTTPException(status_code=400, detail="invalid cursor")
+
+
+def paginate(ranked, limit: int, cursor: str):
+    """Cut the page after cursor out of ranked (score, doc) pairs, best first."""
+    after = decode_cursor(cursor)
+    if after is not None:
+        ranked = [r for r in ranked if rank_key(r) > after]
+    return page_results(ranked[:limit + 1], limit)
+
 
-    if not scores:
+def page_results(ranked, limit: int):
+    """Results for the first limit pairs, and the cursor to the next page if
+    ranked holds more."""
+    page = ranked[:limit]
+    next_cursor = None
+    if len(ranked) > limit and page:
+        score, doc = page[-1]
+        next_cursor = encode_cursor(score, doc["_id"])
+    return [to_result(doc["_id"], doc, score) for score, doc in page], next_cursor
+
+
+# ------------------ Semantic Search ------------------ #
+
+def embed_text(text: str):
+    """
+    Ask the text encoder for a unit-length query vector.
+    It gets {"text": ...} and answers {"embedding": [...]}.
+    """
+    headers = {"Content-Type": "application/json", "Accept": "application/json"}
+    if CLIP_TOKEN:
+        headers["Authorization"] = "Bearer " + CLIP_TOKEN
+    req = urllib.request.Request(
+        CLIP_TEXT_URL,
+        data=json.dumps({"text": text}).encode("utf-8"),
+        headers=headers,
+        method="POST",
+    )
+    with urllib.request.urlopen(req, timeout=30) as resp:
+        vec = json.load(resp).get("embedding") or []
+
+    norm = math.sqrt(sum(x * x for x in vec))
+    if norm == 0:
         return []
+    return [x / norm for x in vec]
+
+
+def semantic_search(query: str, limit: int = 25, cursor: str = "", safe: SafeSearch = None):
+    """
+    Rank embedded images by cosine similarity to the query. Stored vectors
+    are unit length, so this is a dot product. Brute force: fine for a
+    single-node collection, swap in a vector index when it grows.
+    """
+    after = decode_cursor(cursor)
+    if safe and safe.blocks(query):
+        return [], None
+    vec = embed_text(query)
+    if not vec:
+        return [], None
 
-    # Sort documents by score
-    sorted_docs = sorted(scores.items(), key=lambda x: x[1], reverse=True)
-    top_docs = sorted_docs[:limit]
-
-    doc_ids = [d for d, _ in top_docs]
-    normalized_ids = [ObjectId(d) if not isinstance(d, ObjectId) else d for d in doc_ids]
-
-    # Fetch metadata
-    cursor = IMG_DOCS.find(
-        {"_id": {"$in": normalized_ids}},
-        {
-            "file_url": 1,
-            "alt_text": 1,
-            "caption_text": 1,
-            "page_url": 1,
-            "domain_name": 1,
-            "format": 1,
-            "snippet": 1,
-        }
+    scored = vector_scores(vec, safe)
+    if after is not None:
+        scored = (r for r in scored if rank_key(r) > after)
+    # one extra to know whether another page follows
+    best = heapq.nsmallest(limit + 1, scored, key=rank_key)
+    return page_results(best, limit)
+
+
+def vector_scores(vec, safe: SafeSearch = None):
+    """(cosine similarity, doc) for the images embedded by CLIP_MODEL: all
+    of them, or the nearest MAX_VECTOR_RESULTS with a vector index."""
+    if VECTOR_INDEX:
+        return atlas_vector_scores(vec, safe)
+
+    projection = dict(RESULT_FIELDS, embedding=1)
+    query = restrict({"embedding_model": CLIP_MODEL, "embedding": {"$exists": True}}, safe=safe)
+    docs = IMG_DOCS.find(query, projection)
+    return (
+        (sum(a * b for a, b in zip(vec, doc["embedding"])), doc)
+        for doc in docs
+        if len(doc["embedding"]) == len(vec)
     )
 
-    docs_by_id = {doc["_id"]: doc for doc in cursor}
 
-    results = []
-    for doc_id, score in top_docs:
-        meta = docs_by_id.get(doc_id)
-        if not meta:
+def atlas_vector_scores(vec, safe: SafeSearch = None):
+    pipeline = [
+        {"$vectorSearch": {
+            "index": VECTOR_INDEX,
+            "path": "embedding",
+            "queryVector": vec,
+            "numCandidates": min(MAX_VECTOR_RESULTS * VECTOR_CANDIDATES_PER_RESULT, 10000),
+            "limit": MAX_VECTOR_RESULTS,
+            "filter": {"embedding_model": CLIP_MODEL},
+        }},
+        {"$project": dict(RESULT_FIELDS, vector_score={"$meta": "vectorSearchScore"})},
+    ]
+    # the index only filters on embedding_model, so safe search runs after it
+    # and can leave fewer than MAX_VECTOR_RESULTS
+    safe_filter = safe.query() if safe else {}
+    if safe_filter:
+        pipeline.insert(1, {"$match": safe_filter})
+    try:
+        docs = list(IMG_DOCS.aggregate(pipeline))
+    except OperationFailure as e:
+        raise HTTPException(status_code=503, detail="vector index unavailable: " + str(e))
+    # Atlas reports cosine similarity rescaled to (1 + cos) / 2
+    return [(2 * doc["vector_score"] - 1, doc) for doc in docs]
+
+
+# ------------------ Reverse Image Search ------------------ #
+
+# Uploads larger than this are refused
+MAX_UPLOAD_BYTES = 10 * 1024 * 1024
+
+# Hamming distance (of 64 bits) up to which a hash match counts as
+# similar. The crawler groups near-duplicates at 3; this is looser.
+SIMILAR_HASH_DISTANCE = 10
+
+# Widest distance a similar-images request may ask for; the band lookup
+# grows combinatorially with it
+MAX_HASH_DISTANCE = 15
+
+# The crawler stores each 64-bit dHash as 4 bands of 16 bits, "i:xxxx"
+HASH_BANDS = 4
+HASH_BAND_BITS = 64 // HASH_BANDS
+
+
+def dhash(img):
+    """
+    64-bit difference hash, bit for bit the crawler's dHash (phash.go):
+    luminance averaged over a 9x8 grid, sampling big cells on a 16x16
+    lattice, one bit per cell brighter than its right neighbour.
+    """
+    img = img.convert("RGB")
+    width, height = img.size
+    px = img.load()
+    cols, rows = 9, 8
+
+    def cell_span(size, i, n):
+        start, end = i * size // n, (i + 1) * size // n
+        if end <= start:
+            end = start + 1
+        if start >= size:
+            start, end = size - 1, size
+        return start, end
+
+    gray = []
+    for cy in range(rows):
+        y0, y1 = cell_span(height, cy, rows)
+        for cx in range(cols):
+            x0, x1 = cell_span(width, cx, cols)
+            sx, sy = max((x1 - x0) // 16, 1), max((y1 - y0) // 16, 1)
+            total, n = 0.0, 0
+            for y in range(y0, y1, sy):
+                for x in range(x0, x1, sx):
+                    r, g, b = px[x, y]
+                    total += 0.299 * r + 0.587 * g + 0.114 * b
+                    n += 1
+            gray.append(total / n if n else 0.0)
+
+    h = 0
+    for y in range(8):
+        for x in range(8):
+            h <<= 1
+            if gray[y * cols + x] < gray[y * cols + x + 1]:
+                h |= 1
+    return h
+
+
+def band_keys(h: int, max_dist: int):
+    """
+    Band keys to look up every stored hash within max_dist of h. Split into
+    bands, two hashes that close differ in at most max_dist // bands bits
+    in some band, so each band is widened by all flips up to that many bits.
+    """
+    radius = max_dist // HASH_BANDS
+    keys = []
+    for i in range(HASH_BANDS):
+        shift = 64 - HASH_BAND_BITS * (i + 1)
+        band = (h >> shift) & ((1 << HASH_BAND_BITS) - 1)
+        for r in range(radius + 1):
+            for flips in itertools.combinations(range(HASH_BAND_BITS), r):
+                v = band
+                for bit in flips:
+                    v ^= 1 << bit
+                keys.append(f"{i}:{v:0{HASH_BAND_BITS // 4}x}")
+    return keys
+
+
+def hash_neighbours(h: int, max_dist: int = SIMILAR_HASH_DISTANCE, exclude=None,
+                    safe: SafeSearch = None):
+    """(similarity, doc) for indexed images whose dHash is within max_dist
+    of h, similarity being the share of matching bits."""
+    query = {"dhash_bands": {"$in": band_keys(h, max_dist)}}
+    if exclude is not None:
+        query["_id"] = {"$ne": exclude}
+    restrict(query, safe=safe)
+    out = []
+    for doc in IMG_DOCS.find(query, dict(RESULT_FIELDS, dhash=1, dup_group=1)):
+        try:
+            dist = bin(h ^ int(doc.get("dhash") or "", 16)).count("1")
+        except ValueError:
             continue
+        if dist <= max_dist:
+            out.append((1 - dist / 64, doc))
+    return out
 
-        results.append({
-            "id": str(doc_id),
-            "file_url": meta.get("file_url", ""),
-            "alt": meta.get("alt_text", ""),
-            "caption": meta.get("caption_text", ""),
-            "page_url": meta.get("page_url", ""),
-            "domain": meta.get("domain_name", ""),
-            "format": meta.get("format", ""),
-            "snippet": meta.get("snippet", ""),
-            "score": score,
-        })
 
+def similar_images(doc_id: str, limit: int = 25, max_distance: int = SIMILAR_HASH_DISTANCE,
+                   safe: SafeSearch = None):
+    """
+    "More like this" for an indexed image: its nearest neighbours by dHash
+    Hamming distance, found through the band index (multi-index hashing)
+    rather than a scan. Copies in the image's own dup_group are left out;
+    they are the same picture, not similar ones.
+    """
+    key = doc_key(doc_id)
+    doc = IMG_DOCS.find_one({"_id": key}, {"dhash": 1, "dup_group": 1})
+    if not doc:
+        raise HTTPException(status_code=404, detail="image not found")
+    try:
+        h = int(doc.get("dhash") or "", 16)
+    except ValueError:
+        raise HTTPException(status_code=409, detail="image has no perceptual hash yet")
+
+    group = doc.get("dup_group")
+    matches = [
+        (score, other)
+        for score, other in hash_neighbours(h, min(max(max_distance, 0), MAX_HASH_DISTANCE), exclude=key, safe=safe)
+        if not group or other.get("dup_group") != group
+    ]
+    results, _ = page_results(sorted(matches, key=rank_key), limit)
     return results
 
 
+def embed_image(data: bytes, content_type: str):
+    """Unit-length embedding of an image from the IMG_CLIP_URL model server."""
+    headers = {"Content-Type": content_type or "application/octet-stream", "Accept": "application/json"}
+    if CLIP_TOKEN:
+        headers["Authorization"] = "Bearer " + CLIP_TOKEN
+    req = urllib.request.Request(CLIP_IMAGE_URL, data=data, headers=headers, method="POST")
+    with urllib.request.urlopen(req, timeout=30) as resp:
+        vec = json.load(resp).get("embedding") or []
+
+    norm = math.sqrt(sum(x * x for x in vec))
+    if norm == 0:
+        return []
+    return [x / norm for x in vec]
+
+
+def search_by_image(data: bytes, content_type: str, limit: int = 25, safe: SafeSearch = None):
+    """
+    Images similar to an uploaded one: near matches by perceptual hash and,
+    when the CLIP model is configured, neighbours by embedding. Each image
+    keeps its better score; hash similarity is the share of matching bits,
+    embedding similarity the cosine.
+    """
+    try:
+        img = Image.open(io.BytesIO(data))
+        img.load()
+    except (UnidentifiedImageError, OSError) as e:
+        raise HTTPException(status_code=400, detail="not a readable image: " + str(e))
+
+    matches = hash_neighbours(dhash(img), safe=safe)
+    if CLIP_IMAGE_URL:
+        vec = embed_image(data, content_type)
+        if vec:
+            matches += heapq.nlargest(limit, vector_scores(vec, safe), key=lambda x: x[0])
+
+    best = {}
+    for score, doc in matches:
+        if doc["_id"] not in best or score > best[doc["_id"]][0]:
+            best[doc["_id"]] = (score, doc)
+    ranked = sorted(best.values(), key=rank_key)
+    results, _ = page_results(ranked, limit)
+    return results
+
+
+# ------------------ Facets ------------------ #
+
+# facet name -> image_documents field; array fields count each element
+FACET_FIELDS = {
+    "format": "format",
+    "domain": "domain_name",
+    "orientation": "orientation",
+    "color": "color_buckets",
+}
+
+
+def facet_counts(query: str, filters: SearchFilters = None, safe: SafeSearch = None):
+    """
+    Count the images matching a search by format, domain, orientation and
+    color bucket, in one aggregation. Near-duplicates count once, as they
+    do in the results.
+    """
+    terms = tokenize(query)
+    if not terms or (safe and safe.blocks(query)):
+        return {name: [] for name in FACET_FIELDS}
+
+    match = restrict({"$text": {"$search": " ".join(terms)}}, filters, safe)
+
+    facets = {}
+    for name, field in FACET_FIELDS.items():
+        stages = [{"$unwind": "$" + field}] if field == "color_buckets" else []
+        stages += [
+            {"$match": {field: {"$nin": [None, ""]}}},
+            {"$group": {"_id": "$" + field, "count": {"$sum": 1}}},
+            {"$sort": {"count": -1, "_id": 1}},
+            {"$limit": FACET_LIMIT},
+        ]
+        facets[name] = stages
+
+    pipeline = [
+        {"$match": match},
+        # one document per dup_group, like the collapsed results
+        {"$group": {
+            "_id": {"$cond": [{"$gt": ["$dup_group", ""]}, "$dup_group", "$_id"]},
+            **{field: {"$first": "$" + field} for field in FACET_FIELDS.values()},
+        }},
+        {"$facet": facets},
+    ]
+    try:
+        out = next(IMG_DOCS.aggregate(pipeline), {})
+    except OperationFailure as e:
+        raise HTTPException(status_code=503, detail="search index unavailable: " + str(e))
+    return {
+        name: [{"value": b["_id"], "count": b["count"]} for b in out.get(name, [])]
+        for name in FACET_FIELDS
+    }
+
+
+# ------------------ Suggestions ------------------ #
+
+MAX_SUGGESTIONS = 10
+
+
+def suggest(prefix: str, limit: int = MAX_SUGGESTIONS, safe: SafeSearch = None):
+    """
+    Completions for a partly typed query, most common first. The last two
+    words are completed as a phrase ("red ba" -> "red barn"); when no
+    phrase fits, just the last word is. Earlier words are kept as typed.
+    Strict safe search leaves out completions with a blocked keyword.
+    """
+    words = prefix.lower().split()
+    if not words or (safe and safe.blocks(prefix)):
+        return []
+    hide_blocked = safe and safe.tier == "strict" and SAFE_KEYWORDS_RE
+    limit = max(1, min(limit, MAX_SUGGESTIONS))
+
+    for size in (2, 1):
+        if len(words) < size:
+            continue
+        lead, stem = words[:-size], " ".join(words[-size:])
+        query = {"_id": {"$regex": "^" + re.escape(stem)}}
+        if hide_blocked:
+            query = {"$and": [query, {"_id": {"$not": SAFE_KEYWORDS_RE}}]}
+        docs = list(
+            IMG_SUGGEST.find(query)
+            .sort("count", -1)
+            .limit(limit)
+        )
+        if docs:
+            return [" ".join(lead + [doc["_id"]]) for doc in docs]
+    return []
+
+
+# ------------------ Spell Correction ------------------ #
+
+# A query returning fewer results than this is retried spelled as the
+# indexed alt and caption vocabulary would have it
+FEW_RESULTS = 3
+MAX_EDIT_DISTANCE = 2
+# How long the vocabulary is kept before it is reloaded (the indexer may
+# have rebuilt it)
+VOCABULARY_TTL = 600
+
+
+class Vocabulary:
+    """
+    Single words from image_suggestions with the number of images using
+    them, bucketed by first letter. Corrections keep the first letter,
+    which is rarely the mistyped one and keeps each lookup small.
+    """
+
+    def __init__(self):
+        self.lock = threading.Lock()
+        self.loaded_at = 0.0
+        self.counts = {}
+        self.by_letter = {}
+
+    def refresh(self):
+        with self.lock:
+            if time.monotonic() - self.loaded_at < VOCABULARY_TTL:
+                return
+            counts = {
+                doc["_id"]: doc["count"]
+                for doc in IMG_SUGGEST.find({"_id": {"$regex": "^[^ ]+$"}}, {"count": 1})
+            }
+            by_letter = {}
+            for word in counts:
+                by_letter.setdefault(word[0], []).append(word)
+            self.counts, self.by_letter = counts, by_letter
+            self.loaded_at = time.monotonic()
+
+    def correct(self, word: str):
+        """The most common known word within MAX_EDIT_DISTANCE of word, or
+        word itself when it is known or nothing is close."""
+        if word in self.counts:
+            return word
+        best, best_key = word, None
+        for cand in self.by_letter.get(word[0], []):
+            if abs(len(cand) - len(word)) > MAX_EDIT_DISTANCE:
+                continue
+            dist = edit_distance(word, cand, MAX_EDIT_DISTANCE)
+            if dist > MAX_EDIT_DISTANCE:
+                continue
+            key = (dist, -self.counts[cand])
+            if best_key is None or key < best_key:
+                best, best_key = cand, key
+        return best
+
+
+VOCABULARY = Vocabulary()
+
+
+def edit_distance(a: str, b: str, limit: int):
+    """Damerau-Levenshtein (optimal string alignment) distance, giving up
+    with limit + 1 once every alignment is past limit."""
+    prev2, prev = None, list(range(len(b) + 1))
+    for i in range(1, len(a) + 1):
+        cur = [i] + [0] * len(b)
+        for j in range(1, len(b) + 1):
+            cost = 0 if a[i - 1] == b[j - 1] else 1
+            cur[j] = min(prev[j] + 1, cur[j - 1] + 1, prev[j - 1] + cost)
+            if i > 1 and j > 1 and a[i - 1] == b[j - 2] and a[i - 2] == b[j - 1]:
+                cur[j] = min(cur[j], prev2[j - 2] + 1)
+        if min(cur) > limit:
+            return limit + 1
+        prev2, prev = prev, cur
+    return prev[-1]
+
+
+def correct_query(query: str):
+    """The query with unknown words respelled, or None when nothing changed."""
+    VOCABULARY.refresh()
+    words = query.lower().split()
+    fixed = []
+    for word in words:
+        # only plain words the tokenizer would index are respelled
+        if len(word) > 2 and word not in STOPWORDS and TOKEN_RE.fullmatch(word):
+            word = VOCABULARY.correct(word)
+        fixed.append(word)
+    return " ".join(fixed) if fixed != words else None
+
+
+# ------------------ Source Attribution ------------------ #
+
+def attach_favicons(results):
+    hosts = {r["domain"] for r in results if r["domain"]}
+    if not hosts:
+        return results
+    icons = {
+        d["domain"]: d.get("favicon_url", "")
+        for d in DOMAINS.find({"domain": {"$in": list(hosts)}}, {"domain": 1, "favicon_url": 1})
+    }
+    for r in results:
+        r["favicon_url"] = icons.get(r["domain"], "")
+    return results
+
+
+# ------------------ GraphQL ------------------ #
+
+# Lists reached by traversal (a page's or domain's images) return at most
+# this many
+MAX_GRAPHQL_LIST = 100
+
+
+@strawberry.type
+class DomainNode:
+    name: str
+    favicon_url: str
+
+    @strawberry.field
+    def image_count(self) -> int:
+        return IMG_DOCS.count_documents({"domain_name": self.name})
+
+    @strawberry.field
+    def images(self, limit: int = 25, safe: str = SAFE_SEARCH_DEFAULT) -> List["ImageNode"]:
+        return list_images({"domain_name": self.name}, limit, safe)
+
+
+@strawberry.type
+class PageNode:
+    url: str
+    title: str
+    domain_name: strawberry.Private[str]
+
+    @strawberry.field
+    def domain(self) -> Optional[DomainNode]:
+        return domain_node(self.domain_name)
+
+    @strawberry.field
+    def images(self, limit: int = 25, safe: str = SAFE_SEARCH_DEFAULT) -> List["ImageNode"]:
+        return list_images({"page_url": self.url}, limit, safe)
+
+
+@strawberry.type
+class ImageNode:
+    id: str
+    file_url: str
+    thumbnail_url: str
+    alt: str
+    caption: str
+    format: str
+    width: Optional[int]
+    height: Optional[int]
+    aspect_ratio: Optional[float]
+    orientation: str
+    file_size: Optional[int]
+    license: str
+    license_url: str
+    known_urls: List[str]
+    # set on search results only
+    score: Optional[float]
+    page_url: strawberry.Private[str]
+    page_title: strawberry.Private[str]
+    domain_name: strawberry.Private[str]
+
+    @strawberry.field
+    def page(self) -> Optional[PageNode]:
+        if not self.page_url:
+            return None
+        return PageNode(url=self.page_url, title=self.page_title, domain_name=self.domain_name)
+
+    @strawberry.field
+    def domain(self) -> Optional[DomainNode]:
+        return domain_node(self.domain_name)
+
+
+@strawberry.type
+class ImageSearchResult:
+    count: int
+    results: List[ImageNode]
+    next_cursor: Optional[str]
+
+
+def image_node(result, scored: bool = True):
+    """An ImageNode from a to_result dict."""
+    return ImageNode(
+        id=result["id"],
+        file_url=result["file_url"],
+        thumbnail_url=result["thumbnail_url"],
+        alt=result["alt"],
+        caption=result["caption"],
+        format=result["format"],
+        width=result["width"],
+        height=result["height"],
+        aspect_ratio=result["aspect_ratio"],
+        orientation=result["orientation"],
+        file_size=result["file_size"],
+        license=result["license"],
+        license_url=result["license_url"],
+        known_urls=result["known_urls"],
+        score=result["score"] if scored else None,
+        page_url=result["page_url"],
+        page_title=result["page_title"],
+        domain_name=result["domain"],
+    )
+
+
+def list_images(query: dict, limit: int, safe: str):
+    """Images matching query, newest first, under a safe search tier."""
+    query = restrict(query, safe=SafeSearch(safe))
+    limit = max(1, min(limit, MAX_GRAPHQL_LIST))
+    docs = IMG_DOCS.find(query, RESULT_FIELDS).sort("time_fetched", -1).limit(limit)
+    return [image_node(to_result(doc["_id"], doc, None), scored=False) for doc in docs]
+
+
+def domain_node(name: str):
+    if not name:
+        return None
+    d = DOMAINS.find_one({"domain": name}, {"favicon_url": 1}) or {}
+    return DomainNode(name=name, favicon_url=d.get("favicon_url", ""))
+
+
+@strawberry.type
+class GraphQLQuery:
+    @strawberry.field
+    def search_images(self, query: str, limit: int = 25, cursor: str = "",
+                      safe: str = SAFE_SEARCH_DEFAULT, license: str = "", format: str = "",
+                      domain: str = "", orientation: str = "", min_width: int = 0,
+                      min_height: int = 0) -> ImageSearchResult:
+        filters = SearchFilters(license=license, orientation=orientation, format=format,
+                                domain=domain, min_width=min_width, min_height=min_height)
+        results, next_cursor = search_images(query, limit, filters, cursor, SafeSearch(safe))
+        return ImageSearchResult(
+            count=len(results),
+            results=[image_node(r) for r in results],
+            next_cursor=next_cursor,
+        )
+
+    @strawberry.field
+    def image(self, id: str) -> Optional[ImageNode]:
+        doc = IMG_DOCS.find_one({"_id": doc_key(id)}, RESULT_FIELDS)
+        if not doc:
+            return None
+        return image_node(to_result(doc["_id"], doc, None), scored=False)
+
+    @strawberry.field
+    def page(self, url: str) -> Optional[PageNode]:
+        doc = IMG_DOCS.find_one({"page_url": url}, {"page_title": 1, "domain_name": 1})
+        if not doc:
+            return None
+        return PageNode(url=url, title=doc.get("page_title", ""), domain_name=doc.get("domain_name", ""))
+
+    @strawberry.field
+    def domain(self, name: str) -> Optional[DomainNode]:
+        if not IMG_DOCS.find_one({"domain_name": name}, {"_id": 1}):
+            return None
+        return domain_node(name)
+
+
+# /graphql serves the schema, with GraphiQL for browsers, so a frontend can
+# ask for just the fields it shows and follow image -> page -> domain in
+# one request:
+#   {searchImages(query: "red barn", limit: 10) {
+#      results {fileUrl thumbnailUrl width height page {title domain {faviconUrl}}}}}
+app.include_router(GraphQLRouter(strawberry.Schema(query=GraphQLQuery)), prefix="/graphql")
+
+
+# ------------------ Query Log ------------------ #
+
+def log_query(endpoint: str, query: str, results, cursor: str = "",
+              filters: SearchFilters = None, safe: SafeSearch = None, corrected: str = ""):
+    """
+    Record a search in the queries collection and return its id, which the
+    response hands to the client for /click. Logging never fails a search;
+    without the log the id is None.
+    """
+    entry = {
+        "time": datetime.now(timezone.utc),
+        "endpoint": endpoint,
+        "query": query,
+        "first_page": not cursor,
+        "count": len(results),
+        "result_ids": [r["id"] for r in results],
+        "clicks": [],
+    }
+    if filters:
+        # the parameters as given, not the Mongo query they become
+        entry["filters"] = {k: v for k, v in vars(filters).items() if v}
+    if safe:
+        entry["safe"] = safe.tier
+    if corrected:
+        entry["showing_results_for"] = corrected
+    try:
+        return str(QUERIES.insert_one(entry).inserted_id)
+    except PyMongoError:
+        log.warning("Failed to log query", exc_info=True)
+        return None
+
+
+def log_click(query_id: str, result_id: str, position: int):
+    try:
+        key = ObjectId(query_id)
+    except InvalidId:
+        raise HTTPException(status_code=400, detail="invalid query_id")
+    click = {"result_id": result_id, "time": datetime.now(timezone.utc)}
+    if position >= 0:
+        click["position"] = position
+    try:
+        found = QUERIES.update_one({"_id": key}, {"$push": {"clicks": click}}).matched_count
+    except PyMongoError as e:
+        raise HTTPException(status_code=503, detail="query log unavailable: " + str(e))
+    if not found:
+        raise HTTPException(status_code=404, detail="query not found")
+
+
 # ------------------ API Endpoints ------------------ #
 
 @app.get("/search/images")
-def image_search(q: str = Query(...), limit: int = 25):
-    results = search_images(q, limit)
+def image_search(q: str = Query(...), limit: int = 25, cursor: str = "",
+                 filters: SearchFilters = Depends(), safe: SafeSearch = Depends()):
+    results, next_cursor = search_images(q, limit, filters, cursor, safe)
+    response = {"query": q}
+
+    # "did you mean": on a first page with few hits, show the respelled
+    # query's results if it has more. Later pages must then be requested
+    # with the corrected query.
+    if not cursor and len(results) < FEW_RESULTS:
+        corrected = correct_query(q)
+        if corrected:
+            response["did_you_mean"] = corrected
+            fixed, fixed_cursor = search_images(corrected, limit, filters, safe=safe)
+            if len(fixed) > len(results):
+                response["showing_results_for"] = corrected
+                results, next_cursor = fixed, fixed_cursor
+
+    results = attach_favicons(results)
+    response.update({
+        "query_id": log_query("images", q, results, cursor, filters, safe,
+                              response.get("showing_results_for", "")),
+        "count": len(results),
+        "results": results,
+        "next_cursor": next_cursor,
+    })
+    return response
+
+
+@app.get("/search/semantic")
+def semantic_image_search(q: str = Query(...), limit: int = 25, cursor: str = "",
+                          safe: SafeSearch = Depends()):
+    if not CLIP_TEXT_URL:
+        raise HTTPException(status_code=503, detail="IMG_CLIP_TEXT_URL is not configured")
+    results, next_cursor = semantic_search(q, limit, cursor, safe)
+    results = attach_favicons(results)
     return {
         "query": q,
+        "query_id": log_query("semantic", q, results, cursor, safe=safe),
+        "count": len(results),
+        "results": results,
+        "next_cursor": next_cursor,
+    }
+
+
+@app.post("/search/by-image")
+async def image_upload_search(file: UploadFile = File(...), limit: int = 25,
+                              safe: SafeSearch = Depends()):
+    data = await file.read(MAX_UPLOAD_BYTES + 1)
+    if len(data) > MAX_UPLOAD_BYTES:
+        raise HTTPException(status_code=413, detail=f"upload exceeds {MAX_UPLOAD_BYTES} bytes")
+    results = attach_favicons(search_by_image(data, file.content_type, limit, safe))
+    return {
+        "count": len(results),
+        "results": results,
+    }
+
+
+@app.get("/images/{doc_id}/similar")
+def image_similar(doc_id: str, limit: int = 25, max_distance: int = SIMILAR_HASH_DISTANCE,
+                  safe: SafeSearch = Depends()):
+    results = attach_favicons(similar_images(doc_id, limit, max_distance, safe))
+    return {
+        "id": doc_id,
         "count": len(results),
-        "results": results
+        "results": results,
     }
 
 
-@app.get("/")
+@app.get("/suggest")
+def suggestions(q: str = Query(...), limit: int = MAX_SUGGESTIONS, safe: SafeSearch = Depends()):
+    return {
+        "query": q,
+        "suggestions": suggest(q, limit, safe),
+    }
+
+
+@app.get("/facets")
+def facets(q: str = Query(...), filters: SearchFilters = Depends(), safe: SafeSearch = Depends()):
+    return {
+        "query": q,
+        "facets": facet_counts(q, filters, safe),
+    }
+
+
+@app.post("/click", status_code=204)
+def click(query_id: str, result_id: str, position: int = -1):
+    """Beacon for a clicked result: query_id from the search response, the
+    result's id, and its 0-based position on the page."""
+    log_click(query_id, result_id, position)
+    return Response(status_code=204)
+
+
+# The demo search page: search box, thumbnail grid with infinite scroll and
+# filter chips, all on the endpoints above
+with open(os.path.join(os.path.dirname(os.path.abspath(__file__)), "static", "index.html"), encoding="utf-8") as f:
+    INDEX_HTML = f.read()
+
+
+@app.get("/", response_class=HTMLResponse)
 def root():
-    return {"message": "Image Search API. Use /search/images?q=your+query"}
+    return INDEX_HTML
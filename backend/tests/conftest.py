@@ -0,0 +1,114 @@
+# Fixtures for the API tests.
+#
+# The tests run against a real MongoDB: search depends on $text scoring and
+# $facet, which in-process fakes don't implement. Point IMG_TEST_MONGO_URI
+# at a scratch server; each run uses a database of its own and drops it at
+# the end. Without it the tests are skipped.
+#
+#   IMG_TEST_MONGO_URI=mongodb://localhost:27017 pytest backend/tests
+
+import os
+import sys
+import uuid
+from collections import Counter
+
+import pytest
+from bson import ObjectId
+
+TEST_DB_URI = os.getenv("IMG_TEST_MONGO_URI")
+
+sys.path.insert(0, os.path.dirname(os.path.dirname(os.path.abspath(__file__))))
+
+# image_documents fields image_indexer.py tokenizes for the term index
+INDEXED_FIELDS = ("alt_text", "caption_text", "context_text", "page_title", "nearest_heading")
+
+# listed in the safe search domain file the app is started with
+BLOCKED_DOMAIN = "blocked.test"
+
+
+@pytest.fixture(scope="session")
+def main(tmp_path_factory):
+    """The app module, configured for the test database. The environment is
+    set before import, since main.py reads it at import time and load_dotenv
+    leaves variables already set alone."""
+    if not TEST_DB_URI:
+        pytest.skip("IMG_TEST_MONGO_URI not set")
+
+    domains = tmp_path_factory.mktemp("safe") / "domains.txt"
+    domains.write_text(BLOCKED_DOMAIN + "\n", encoding="utf-8")
+    os.environ.update({
+        "IMG_DB_URI": TEST_DB_URI,
+        "IMG_DB_NAME": "image_search_test_" + uuid.uuid4().hex[:12],
+        "IMG_SAFE_SEARCH": "moderate",
+        "IMG_SAFE_DOMAINS_FILE": str(domains),
+        "IMG_SAFE_KEYWORDS_FILE": "",
+        "IMG_CLIP_TEXT_URL": "",
+        "IMG_CLIP_URL": "",
+        "IMG_VECTOR_INDEX": "",
+    })
+
+    import main as app_main
+
+    yield app_main
+    app_main.client.drop_database(app_main.IMG_DB_NAME)
+
+
+@pytest.fixture(scope="session")
+def client(main):
+    from fastapi.testclient import TestClient
+
+    return TestClient(main.app)
+
+
+@pytest.fixture(autouse=True)
+def clean_db(main):
+    """Empty collections for every test. Documents are deleted rather than
+    collections dropped, so the indexes main.py created on import stay."""
+    for col in (main.IMG_DOCS, main.IMG_INDEX, main.IMG_STATS, main.DOMAINS, main.IMG_SUGGEST, main.QUERIES):
+        col.delete_many({})
+    # the spelling vocabulary is cached; make the next lookup reload it
+    main.VOCABULARY.loaded_at = float("-inf")
+
+
+@pytest.fixture
+def add_images(main):
+    """
+    Store image documents as image_indexer.py leaves them: with a token
+    length, and with the term postings and corpus stats BM25 reads rebuilt
+    over every stored image. Returns the stored documents' ids.
+    """
+
+    def add(*docs):
+        ids = []
+        for doc in docs:
+            doc = dict(doc)
+            doc.setdefault("_id", ObjectId())
+            doc["length"] = len(index_tokens(main, doc))
+            main.IMG_DOCS.insert_one(doc)
+            ids.append(doc["_id"])
+        reindex(main)
+        return ids
+
+    return add
+
+
+def index_tokens(main, doc):
+    return main.tokenize(" ".join(doc.get(field) or "" for field in INDEXED_FIELDS))
+
+
+def reindex(main):
+    postings = {}
+    lengths = []
+    for doc in main.IMG_DOCS.find({}):
+        lengths.append(doc["length"])
+        for term, tf in Counter(index_tokens(main, doc)).items():
+            postings.setdefault(term, []).append({"doc_id": doc["_id"], "tf": tf})
+
+    main.IMG_INDEX.delete_many({})
+    if postings:
+        main.IMG_INDEX.insert_many([{"term": term, "docs": docs} for term, docs in postings.items()])
+    main.IMG_STATS.replace_one(
+        {"_id": "corpus"},
+        {"num_docs": len(lengths), "avg_length": sum(lengths) / len(lengths) if lengths else 0.0},
+        upsert=True,
+    )
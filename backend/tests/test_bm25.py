@@ -0,0 +1,89 @@
+import math
+
+import pytest
+
+
+def test_bm25_single_posting(main):
+    # 4 documents averaging 4 tokens; "barn" once in a 4-token document, so
+    # the length norm is 1 and the score is the idf
+    main.IMG_STATS.insert_one({"_id": "corpus", "num_docs": 4, "avg_length": 4.0})
+    main.IMG_INDEX.insert_one({"term": "barn", "docs": [{"doc_id": "a", "tf": 1}]})
+
+    scores = main.bm25_scores(["barn"], [{"_id": "a", "length": 4}])
+
+    assert scores["a"] == pytest.approx(math.log(1 + 3.5 / 1.5))
+
+
+@pytest.mark.parametrize("postings, lengths, better, worse", [
+    pytest.param(
+        {"barn": [("rare", 1)], "red": [("common", 1), ("x", 1), ("y", 1)]},
+        {"rare": 4, "common": 4},
+        "rare", "common",
+        id="rare term beats a common one",
+    ),
+    pytest.param(
+        {"barn": [("twice", 2), ("once", 1)]},
+        {"twice": 4, "once": 4},
+        "twice", "once",
+        id="more occurrences score higher",
+    ),
+    pytest.param(
+        {"barn": [("short", 1), ("long", 1)]},
+        {"short": 2, "long": 12},
+        "short", "long",
+        id="shorter document scores higher",
+    ),
+])
+def test_bm25_ordering(main, postings, lengths, better, worse):
+    main.IMG_STATS.insert_one({"_id": "corpus", "num_docs": 10, "avg_length": 4.0})
+    main.IMG_INDEX.insert_many([
+        {"term": term, "docs": [{"doc_id": d, "tf": tf} for d, tf in docs]}
+        for term, docs in postings.items()
+    ])
+    candidates = [{"_id": d, "length": n} for d, n in lengths.items()]
+
+    scores = main.bm25_scores(["red", "barn"], candidates)
+
+    assert scores[better] > scores[worse] > 0
+    # postings of documents that aren't candidates are not scored
+    assert set(scores) <= set(lengths)
+
+
+def test_bm25_without_corpus_stats(main):
+    # before the indexer wrote stats, the collection size and the
+    # candidates' own lengths stand in: with one document of average length,
+    # the score is the idf
+    main.IMG_DOCS.insert_one({"_id": "a", "length": 3})
+    main.IMG_INDEX.insert_one({"term": "barn", "docs": [{"doc_id": "a", "tf": 1}]})
+
+    scores = main.bm25_scores(["barn"], [{"_id": "a", "length": 3}])
+
+    assert scores["a"] == pytest.approx(math.log(1 + 0.5 / 1.5))
+
+
+def test_search_ranks_by_bm25(client, add_images):
+    best, longer, copy, _ = add_images(
+        {"alt_text": "red barn", "dup_group": "g1"},
+        {"alt_text": "red barn behind a long fence on a hill above the river valley"},
+        # a near-duplicate of the best match collapses into it
+        {"alt_text": "red barn near the road", "dup_group": "g1"},
+        {"alt_text": "blue sky"},
+    )
+
+    resp = client.get("/search/images", params={"q": "barn"})
+
+    assert resp.status_code == 200
+    ids = [r["id"] for r in resp.json()["results"]]
+    assert ids == [str(best), str(longer)]
+    scores = [r["score"] for r in resp.json()["results"]]
+    assert scores == sorted(scores, reverse=True)
+
+
+def test_search_without_terms(client, add_images):
+    add_images({"alt_text": "red barn"})
+
+    # stopwords and short words leave nothing to search for
+    resp = client.get("/search/images", params={"q": "the of it"})
+
+    assert resp.status_code == 200
+    assert resp.json()["results"] == []
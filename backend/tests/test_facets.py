@@ -0,0 +1,50 @@
+def facet(body, name):
+    return [(b["value"], b["count"]) for b in body["facets"][name]]
+
+
+def test_facets_count_matches(client, add_images):
+    add_images(
+        {"alt_text": "cat on a sofa", "format": "jpg", "domain_name": "a.test",
+         "orientation": "landscape", "color_buckets": ["red", "blue"], "dup_group": "g1"},
+        # a near-duplicate counts once, as it shows once in the results
+        {"alt_text": "cat on the sofa again", "format": "jpg", "domain_name": "a.test",
+         "orientation": "landscape", "color_buckets": ["red", "blue"], "dup_group": "g1"},
+        {"alt_text": "cat in a box", "format": "jpg", "domain_name": "a.test",
+         "orientation": "portrait", "color_buckets": ["red"]},
+        # never measured: no orientation or colors to count
+        {"alt_text": "cat asleep", "format": "png", "domain_name": "b.test", "orientation": ""},
+        {"alt_text": "dog", "format": "gif", "domain_name": "c.test", "color_buckets": ["green"]},
+    )
+
+    resp = client.get("/facets", params={"q": "cat"})
+
+    assert resp.status_code == 200
+    body = resp.json()
+    assert body["query"] == "cat"
+    assert facet(body, "format") == [("jpg", 2), ("png", 1)]
+    assert facet(body, "domain") == [("a.test", 2), ("b.test", 1)]
+    assert facet(body, "orientation") == [("landscape", 1), ("portrait", 1)]
+    assert facet(body, "color") == [("red", 2), ("blue", 1)]
+
+
+def test_facets_apply_filters(client, add_images):
+    add_images(
+        {"alt_text": "cat", "format": "jpg", "domain_name": "a.test"},
+        {"alt_text": "cat", "format": "png", "domain_name": "b.test"},
+        {"alt_text": "cat", "format": "png", "domain_name": "cdn.a.test"},
+    )
+
+    resp = client.get("/facets", params={"q": "cat", "domain": "a.test"})
+
+    assert resp.status_code == 200
+    assert facet(resp.json(), "format") == [("jpg", 1), ("png", 1)]
+    assert facet(resp.json(), "domain") == [("a.test", 1), ("cdn.a.test", 1)]
+
+
+def test_facets_without_terms(client, add_images):
+    add_images({"alt_text": "cat", "format": "jpg"})
+
+    resp = client.get("/facets", params={"q": "the"})
+
+    assert resp.status_code == 200
+    assert resp.json()["facets"] == {"format": [], "domain": [], "orientation": [], "color": []}
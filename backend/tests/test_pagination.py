@@ -0,0 +1,81 @@
+import base64
+
+import pytest
+from bson import ObjectId
+
+
+def test_cursor_round_trip(main):
+    doc_id = ObjectId()
+    cursor = main.encode_cursor(1.5, doc_id)
+
+    assert "=" not in cursor
+    assert main.decode_cursor(cursor) == main.rank_key((1.5, {"_id": doc_id}))
+    assert main.decode_cursor("") is None
+
+
+@pytest.mark.parametrize("cursor", [
+    pytest.param("not base64!", id="not base64"),
+    pytest.param(base64.urlsafe_b64encode(b"[1, 2]").decode(), id="not an object"),
+    pytest.param(base64.urlsafe_b64encode(b'{"score": 1}').decode(), id="no id"),
+    pytest.param(base64.urlsafe_b64encode(b'{"score": "x", "id": "a"}').decode(), id="bad score"),
+])
+def test_invalid_cursor(main, cursor):
+    with pytest.raises(main.HTTPException) as e:
+        main.decode_cursor(cursor)
+    assert e.value.status_code == 400
+
+
+def test_paginate_orders_ties_by_id(main):
+    ranked = sorted(
+        [(2.0, {"_id": "b"}), (1.0, {"_id": "c"}), (2.0, {"_id": "a"}), (1.0, {"_id": "d"})],
+        key=main.rank_key,
+    )
+
+    pages, cursor = [], ""
+    while True:
+        results, cursor = main.paginate(ranked, 3, cursor)
+        pages.append([r["id"] for r in results])
+        if cursor is None:
+            break
+
+    assert pages == [["a", "b", "c"], ["d"]]
+
+
+def test_paginate_exact_fit(main):
+    ranked = [(1.0, {"_id": "a"}), (0.5, {"_id": "b"})]
+
+    results, cursor = main.paginate(ranked, 2, "")
+
+    assert [r["id"] for r in results] == ["a", "b"]
+    # nothing follows, so there is no cursor to an empty page
+    assert cursor is None
+
+
+def test_search_pages(client, main, add_images):
+    ids = add_images(*[{"alt_text": "cat " + word} for word in ("one", "two", "three", "four", "five")])
+
+    seen, cursor, pages = [], "", 0
+    while True:
+        resp = client.get("/search/images", params={"q": "cat", "limit": 2, "cursor": cursor})
+        assert resp.status_code == 200
+        body = resp.json()
+        seen += [r["id"] for r in body["results"]]
+        pages += 1
+        cursor = body["next_cursor"]
+        if pages == 1:
+            # an image stored between requests that outranks the cursor
+            # doesn't push a result already handed out onto the next page
+            main.IMG_DOCS.insert_one({"alt_text": "cat", "length": 1})
+        if not cursor:
+            break
+
+    assert pages == 3
+    assert sorted(seen) == sorted(str(i) for i in ids)
+
+
+def test_search_invalid_cursor(client, add_images):
+    add_images({"alt_text": "cat"})
+
+    resp = client.get("/search/images", params={"q": "cat", "cursor": "bogus"})
+
+    assert resp.status_code == 400
@@ -0,0 +1,73 @@
+import logging
+
+import pytest
+from bson import ObjectId
+from pymongo.errors import PyMongoError
+
+
+def search(client, **params):
+    resp = client.get("/search/images", params=params)
+    assert resp.status_code == 200
+    return resp.json()
+
+
+def test_search_is_logged(client, main, add_images):
+    ids = add_images({"alt_text": "red cat"}, {"alt_text": "grey cat"})
+
+    body = search(client, q="cat", format="jpeg", safe="strict")
+
+    entry = main.QUERIES.find_one({"_id": ObjectId(body["query_id"])})
+    assert entry["endpoint"] == "images"
+    assert entry["query"] == "cat"
+    assert entry["first_page"] is True
+    assert entry["count"] == 0
+    assert entry["result_ids"] == []
+    # the filters as given, leaving out the unset ones
+    assert entry["filters"] == {"formats": ["jpg"]}
+    assert entry["safe"] == "strict"
+    assert entry["clicks"] == []
+
+    body = search(client, q="cat")
+    entry = main.QUERIES.find_one({"_id": ObjectId(body["query_id"])})
+    assert sorted(entry["result_ids"]) == sorted(str(i) for i in ids)
+
+
+def test_click(client, main, add_images):
+    add_images({"alt_text": "red cat"})
+    body = search(client, q="cat")
+    result = body["results"][0]["id"]
+
+    resp = client.post("/click", params={"query_id": body["query_id"], "result_id": result, "position": 0})
+    assert resp.status_code == 204
+    resp = client.post("/click", params={"query_id": body["query_id"], "result_id": result})
+    assert resp.status_code == 204
+
+    clicks = main.QUERIES.find_one({"_id": ObjectId(body["query_id"])})["clicks"]
+    assert [(c["result_id"], c.get("position")) for c in clicks] == [(result, 0), (result, None)]
+    assert all("time" in c for c in clicks)
+
+
+@pytest.mark.parametrize("query_id, status", [
+    pytest.param("not-an-id", 400, id="invalid id"),
+    pytest.param(str(ObjectId()), 404, id="unknown query"),
+])
+def test_click_errors(client, query_id, status):
+    resp = client.post("/click", params={"query_id": query_id, "result_id": "x"})
+
+    assert resp.status_code == status
+
+
+def test_search_survives_log_failure(client, main, add_images, monkeypatch, caplog):
+    class BrokenLog:
+        def insert_one(self, entry):
+            raise PyMongoError("log down")
+
+    add_images({"alt_text": "red cat"})
+    monkeypatch.setattr(main, "QUERIES", BrokenLog())
+
+    with caplog.at_level(logging.WARNING, logger=main.__name__):
+        body = search(client, q="cat")
+
+    assert body["query_id"] is None
+    assert body["count"] == 1
+    assert "Failed to log query" in caplog.text
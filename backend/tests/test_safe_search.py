@@ -0,0 +1,70 @@
+import pytest
+
+from conftest import BLOCKED_DOMAIN
+
+
+@pytest.fixture
+def images(add_images):
+    add_images(
+        {"alt_text": "clean cat", "nsfw_score": 0.1},
+        {"alt_text": "borderline cat", "nsfw_score": 0.5},
+        {"alt_text": "flagged cat", "nsfw_score": 0.9},
+        # never scored by the classifier
+        {"alt_text": "unscored cat"},
+        {"alt_text": "listed cat", "nsfw_score": 0.0, "domain_name": "img." + BLOCKED_DOMAIN},
+        {"alt_text": "sexy cat", "nsfw_score": 0.0},
+    )
+
+
+@pytest.mark.parametrize("params, want", [
+    pytest.param({}, {"clean cat", "borderline cat", "unscored cat", "sexy cat"}, id="moderate by default"),
+    pytest.param({"safe": "off"}, {"clean cat", "borderline cat", "flagged cat", "unscored cat",
+                                   "listed cat", "sexy cat"}, id="off"),
+    pytest.param({"safe": "moderate"}, {"clean cat", "borderline cat", "unscored cat", "sexy cat"},
+                 id="moderate"),
+    pytest.param({"safe": "strict"}, {"clean cat", "unscored cat"}, id="strict"),
+    pytest.param({"safe": " Strict "}, {"clean cat", "unscored cat"}, id="case and space ignored"),
+])
+def test_safe_search_tiers(client, images, params, want):
+    resp = client.get("/search/images", params=dict(params, q="cat"))
+
+    assert resp.status_code == 200
+    assert {r["alt"] for r in resp.json()["results"]} == want
+
+
+def test_strict_refuses_blocked_queries(client, images):
+    resp = client.get("/search/images", params={"q": "nude cat", "safe": "strict"})
+    assert resp.status_code == 200
+    assert resp.json()["results"] == []
+
+    resp = client.get("/search/images", params={"q": "nude cat", "safe": "moderate"})
+    assert len(resp.json()["results"]) == 4
+
+
+@pytest.mark.parametrize("path", ["/search/images", "/facets", "/suggest"])
+def test_unknown_tier(client, path):
+    resp = client.get(path, params={"q": "cat", "safe": "bogus"})
+
+    assert resp.status_code == 400
+
+
+def test_safe_search_facets(client, images):
+    resp = client.get("/facets", params={"q": "cat", "safe": "strict"})
+
+    assert resp.status_code == 200
+    assert resp.json()["facets"]["domain"] == []
+
+    resp = client.get("/facets", params={"q": "cat", "safe": "off"})
+    assert resp.json()["facets"]["domain"] == [{"value": "img." + BLOCKED_DOMAIN, "count": 1}]
+
+
+def test_safe_search_suggestions(client, main):
+    main.IMG_SUGGEST.insert_many([{"_id": "sexy", "count": 9}, {"_id": "sea", "count": 2}])
+
+    def suggest(safe):
+        resp = client.get("/suggest", params={"q": "se", "safe": safe})
+        assert resp.status_code == 200
+        return resp.json()["suggestions"]
+
+    assert suggest("moderate") == ["sexy", "sea"]
+    assert suggest("strict") == ["sea"]
@@ -0,0 +1,78 @@
+import pytest
+from bson import ObjectId
+
+BASE = 0x0123456789ABCDEF
+
+
+def flip(h, *bits):
+    for b in bits:
+        h ^= 1 << b
+    return h
+
+
+def hashed(main, h, **doc):
+    """An image document with dHash h, stored the way the crawler does."""
+    return dict(doc, dhash=f"{h:016x}", dhash_bands=main.band_keys(h, 0))
+
+
+def test_band_keys_exact(main):
+    # the same "band:hex" keys the crawler writes (hashBands in phash.go)
+    assert main.band_keys(BASE, 0) == ["0:0123", "1:4567", "2:89ab", "3:cdef"]
+
+
+@pytest.mark.parametrize("max_dist, per_band", [
+    pytest.param(3, 1, id="under one bit per band"),
+    pytest.param(4, 1 + 16, id="one bit per band"),
+    pytest.param(8, 1 + 16 + 120, id="two bits per band"),
+])
+def test_band_keys_count(main, max_dist, per_band):
+    keys = main.band_keys(BASE, max_dist)
+
+    assert len(keys) == len(set(keys)) == 4 * per_band
+
+
+@pytest.mark.parametrize("bits", [
+    pytest.param((0,), id="one bit"),
+    pytest.param((0, 16, 32, 48, 1, 17, 33, 49, 2, 18), id="spread over every band"),
+    pytest.param(tuple(range(10)), id="all in one band"),
+    pytest.param((63, 47, 31, 15, 62, 46, 30), id="band edges"),
+])
+def test_band_keys_find_hashes_within_distance(main, bits):
+    other = flip(BASE, *bits)
+
+    assert set(main.band_keys(other, 0)) & set(main.band_keys(BASE, 10))
+
+
+def test_similar_images(client, main, add_images):
+    target, near, mid, far, too_far, copy, flagged, _ = add_images(
+        hashed(main, BASE, alt_text="target", dup_group="g1"),
+        hashed(main, flip(BASE, 0, 1, 2), alt_text="near"),
+        hashed(main, flip(BASE, *range(10)), alt_text="mid"),
+        hashed(main, flip(BASE, *range(12)), alt_text="far"),
+        # shares band 0, but past the widest distance a request may ask for
+        hashed(main, flip(BASE, *range(16, 36)), alt_text="too far"),
+        # the same picture, not a similar one
+        hashed(main, BASE, alt_text="copy", dup_group="g1"),
+        dict(hashed(main, flip(BASE, 5), alt_text="flagged"), nsfw_score=0.95),
+        {"alt_text": "no hash"},
+    )
+
+    def similar(**params):
+        resp = client.get(f"/images/{target}/similar", params=params)
+        assert resp.status_code == 200
+        return [(r["id"], r["score"]) for r in resp.json()["results"]]
+
+    assert similar() == [(str(near), pytest.approx(1 - 3 / 64)), (str(mid), pytest.approx(1 - 10 / 64))]
+    assert [i for i, _ in similar(max_distance=12)] == [str(near), str(mid), str(far)]
+    assert [i for i, _ in similar(max_distance=64)] == [str(near), str(mid), str(far)]
+    assert [i for i, _ in similar(safe="off")] == [str(flagged), str(near), str(mid)]
+    assert [i for i, _ in similar(limit=1)] == [str(near)]
+    assert str(copy) not in [i for i, _ in similar(safe="off", max_distance=15)]
+
+
+def test_similar_images_errors(client, main, add_images):
+    (unhashed,) = add_images({"alt_text": "no hash yet"})
+
+    assert client.get(f"/images/{ObjectId()}/similar").status_code == 404
+    assert client.get("/images/not-an-id/similar").status_code == 404
+    assert client.get(f"/images/{unhashed}/similar").status_code == 409
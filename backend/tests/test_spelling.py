@@ -0,0 +1,70 @@
+import pytest
+
+
+@pytest.mark.parametrize("a, b, limit, want", [
+    pytest.param("cat", "cat", 2, 0, id="same"),
+    pytest.param("cat", "cut", 2, 1, id="substitution"),
+    pytest.param("cat", "cats", 2, 1, id="insertion"),
+    pytest.param("barn", "bran", 2, 1, id="transposition"),
+    pytest.param("bran", "bark", 2, 2, id="transposition and substitution"),
+    # optimal string alignment edits no substring twice; full
+    # Damerau-Levenshtein would give 2
+    pytest.param("ca", "abc", 3, 3, id="no edits of moved letters"),
+    pytest.param("", "abc", 5, 3, id="from empty"),
+    pytest.param("abc", "", 5, 3, id="to empty"),
+    pytest.param("kitten", "sitting", 5, 3, id="within the limit"),
+    pytest.param("kitten", "sitting", 2, 3, id="past the limit"),
+    pytest.param("aaaaaa", "bbbbbbbbbbbb", 2, 3, id="gives up early"),
+])
+def test_edit_distance(main, a, b, limit, want):
+    assert main.edit_distance(a, b, limit) == want
+
+
+@pytest.fixture
+def vocabulary(main):
+    main.IMG_SUGGEST.insert_many([
+        {"_id": "barn", "count": 5},
+        {"_id": "bark", "count": 9},
+        {"_id": "kitten", "count": 4},
+        # phrases are for autocomplete, not spelling
+        {"_id": "red barn", "count": 3},
+    ])
+
+
+@pytest.mark.parametrize("query, want", [
+    pytest.param("bran", "barn", id="closest word"),
+    pytest.param("red bran", "red barn", id="unknown words kept"),
+    pytest.param("barj", "bark", id="ties go to the more common word"),
+    pytest.param("Kiten", "kitten", id="lowercased"),
+    pytest.param("barn", None, id="known word"),
+    pytest.param("zzzz", None, id="nothing close"),
+    pytest.param("varn", None, id="first letter kept"),
+    pytest.param("the bran", "the barn", id="stopwords left alone"),
+    pytest.param("bran!", None, id="only plain words respelled"),
+])
+def test_correct_query(main, vocabulary, query, want):
+    assert main.correct_query(query) == want
+
+
+def test_search_shows_corrected_results(client, main, vocabulary, add_images):
+    add_images({"alt_text": "old barn"})
+
+    resp = client.get("/search/images", params={"q": "bran"})
+
+    assert resp.status_code == 200
+    body = resp.json()
+    assert body["did_you_mean"] == "barn"
+    assert body["showing_results_for"] == "barn"
+    assert [r["alt"] for r in body["results"]] == ["old barn"]
+
+
+def test_search_keeps_results_that_are_not_fewer(client, main, vocabulary, add_images):
+    add_images({"alt_text": "red bran muffin"}, {"alt_text": "old barn"})
+
+    resp = client.get("/search/images", params={"q": "bran"})
+
+    body = resp.json()
+    # respelled, the query finds as many, so the original results stay
+    assert body["did_you_mean"] == "barn"
+    assert "showing_results_for" not in body
+    assert [r["alt"] for r in body["results"]] == ["red bran muffin"]
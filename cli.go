@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"image_crawler/crawler"
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   COMMAND LINE
+	==============================
+*/
+
+// CrawlTimeout bounds a crawl command; IMG_CRAWL_TIMEOUT=0 lifts it.
+const CrawlTimeout = 10 * time.Minute
+
+// command is a subcommand: image_crawler <name> [flags] [args]. Without
+// one, IMG_MODE names it, crawl by default. Settings stay environment
+// variables, so .env keeps working; a flag sets its variable and so
+// overrides it.
+type command struct {
+	name    string
+	args    string // positional arguments, for the usage line
+	summary string
+	flags   []envFlag
+
+	mongo  bool // works on Mongo directly
+	crawls bool // runs a crawler, which the admin dashboard shows
+	dryRun bool // can run under IMG_DRY_RUN
+
+	run func(ctx context.Context, st store.Store, col *mongo.Collection, args []string) error
+}
+
+// envFlag is a flag that sets an environment variable.
+type envFlag struct {
+	name    string
+	env     string
+	usage   string
+	boolean bool
+}
+
+// globalFlags are accepted by every command.
+var globalFlags = []envFlag{
+	{"db", "IMG_DB_DRIVER", "record store: mongo, postgres, sqlite or memory", false},
+	{"log-level", "IMG_LOG_LEVEL", "debug, info, warn or error", false},
+	{"log-format", "IMG_LOG_FORMAT", "text or json", false},
+}
+
+var (
+	seedsFlag       = envFlag{"seeds", "IMG_SEED_LINKS", "comma-separated seed links or sitemaps", false}
+	allowedFlag     = envFlag{"allowed", "IMG_ALLOWED_SITES", `comma-separated sites to crawl, "*" for any`, false}
+	concurrencyFlag = envFlag{"concurrency", "IMG_CONCURRENCY", "pages fetched at once", false}
+	adminFlag       = envFlag{"admin-addr", "IMG_ADMIN_ADDR", "serve the admin dashboard on this address", false}
+	pprofFlag       = envFlag{"pprof", "IMG_PPROF", "serve pprof profiles on the admin address", true}
+	dryRunFlag      = envFlag{"dry-run", "IMG_DRY_RUN", "print what would be stored instead of storing it", true}
+	retentionFlag   = envFlag{"retention-days", "IMG_RETENTION_DAYS", "expire images not seen for this many days", false}
+)
+
+var commands = []command{
+	{
+		name:    "crawl",
+		summary: "crawl from the seed links until the page budget is spent",
+		flags: []envFlag{seedsFlag, allowedFlag, concurrencyFlag,
+			{"blocked", "IMG_BLOCKED_SITES", "comma-separated sites never to crawl", false},
+			{"max-pages-per-domain", "IMG_MAX_PAGES_PER_DOMAIN", "page budget per domain, 0 for none", false},
+			{"strategy", "IMG_CRAWL_STRATEGY", "traversal order: best-first, bfs or dfs", false},
+			{"timeout", "IMG_CRAWL_TIMEOUT", "stop the crawl after this long, 0 for never (default 10m)", false},
+			{"amqp", "IMG_AMQP_URL", "share the crawl through this RabbitMQ server; seeds optional", false},
+			{"stages", "IMG_AMQP_STAGES", "with -amqp, the stages this process runs: fetch, extract, enrich", false},
+			adminFlag, pprofFlag, dryRunFlag},
+		crawls: true,
+		dryRun: true,
+		run: func(ctx context.Context, st store.Store, col *mongo.Collection, args []string) error {
+			return crawler.Run(ctx, st)
+		},
+	},
+	{
+		name:    "recrawl",
+		summary: "revisit known pages as they come due, until stopped",
+		flags: []envFlag{allowedFlag, concurrencyFlag, retentionFlag,
+			{"linkcheck", "IMG_LINKCHECK", "check stored image links in the background", true},
+			adminFlag, pprofFlag},
+		crawls: true,
+		run: func(ctx context.Context, st store.Store, col *mongo.Collection, args []string) error {
+			return crawler.RunRecrawler(ctx, st)
+		},
+	},
+	{
+		name:    "serve",
+		summary: "serve the gRPC API, which can start crawls",
+		flags: []envFlag{allowedFlag,
+			{"addr", "IMG_GRPC_ADDR", "gRPC listen address", false},
+			{"search-api", "IMG_SEARCH_API_URL", "search backend the API queries", false},
+			adminFlag, pprofFlag, dryRunFlag},
+		crawls: true,
+		dryRun: true,
+		run: func(ctx context.Context, st store.Store, col *mongo.Collection, args []string) error {
+			return crawler.Serve(ctx, st)
+		},
+	},
+	{
+		name:    "warc",
+		summary: "extract images from WARC files instead of crawling",
+		flags: []envFlag{allowedFlag, concurrencyFlag,
+			{"files", "IMG_WARC_FILES", "comma-separated WARC files, globs or Common Crawl path listings", false},
+			adminFlag, pprofFlag, dryRunFlag},
+		crawls: true,
+		dryRun: true,
+		run: func(ctx context.Context, st store.Store, col *mongo.Collection, args []string) error {
+			return crawler.IngestWARC(ctx, st)
+		},
+	},
+	{
+		name:    "export",
+		summary: "write image records as JSON lines, CSV or Parquet",
+		flags: []envFlag{
+			{"format", "IMG_EXPORT_FORMAT", "jsonl, csv or parquet", false},
+			{"out", "IMG_EXPORT_FILE", "output file (default stdout)", false},
+			{"fields", "IMG_EXPORT_FIELDS", "comma-separated fields to export", false},
+			{"query", "IMG_EXPORT_QUERY", "Mongo filter as extended JSON", false},
+		},
+		mongo: true,
+		run: func(ctx context.Context, st store.Store, col *mongo.Collection, args []string) error {
+			return runExport(ctx, col)
+		},
+	},
+	{
+		name:    "stats",
+		summary: "print counts of stored images, tombstones and top domains",
+		flags: []envFlag{
+			{"top", "IMG_STATS_TOP", "domains to list", false},
+			{"json", "IMG_STATS_JSON", "print JSON", true},
+		},
+		mongo: true,
+		run: func(ctx context.Context, st store.Store, col *mongo.Collection, args []string) error {
+			return runStats(ctx, col, os.Stdout)
+		},
+	},
+	{
+		name:    "runs",
+		summary: "list the latest crawl runs with their totals",
+		flags: []envFlag{
+			{"limit", "IMG_RUNS_LIMIT", "runs to list", false},
+		},
+		mongo: true,
+		run: func(ctx context.Context, st store.Store, col *mongo.Collection, args []string) error {
+			return crawler.ListRuns(ctx, col, os.Stdout)
+		},
+	},
+	{
+		name:    "rollback",
+		args:    "run-id ...",
+		summary: "remove the images the given crawl runs added",
+		mongo:   true,
+		run: func(ctx context.Context, st store.Store, col *mongo.Collection, args []string) error {
+			return crawler.Rollback(ctx, col, args)
+		},
+	},
+	{
+		name:    "purge",
+		summary: "remove images whose files turned out corrupt",
+		mongo:   true,
+		run: func(ctx context.Context, st store.Store, col *mongo.Collection, args []string) error {
+			return crawler.Purge(ctx, col)
+		},
+	},
+	{
+		name:    "elastic",
+		summary: "index live images into Elasticsearch or OpenSearch",
+		flags: []envFlag{
+			{"es-url", "IMG_ES_URL", "cluster URL", false},
+			{"index", "IMG_ES_INDEX", "index alias", false},
+		},
+		mongo: true,
+		run: func(ctx context.Context, st store.Store, col *mongo.Collection, args []string) error {
+			return runElasticIndex(ctx, col)
+		},
+	},
+	{
+		name:    "expire",
+		summary: "apply the retention policy once",
+		flags: []envFlag{retentionFlag,
+			{"action", "IMG_RETENTION_ACTION", "flag or delete", false}},
+		mongo: true,
+		run: func(ctx context.Context, st store.Store, col *mongo.Collection, args []string) error {
+			return crawler.Expire(ctx, col)
+		},
+	},
+	{
+		name:    "migrate",
+		summary: "apply pending schema migrations",
+		mongo:   true,
+		run: func(ctx context.Context, st store.Store, col *mongo.Collection, args []string) error {
+			return store.Migrate(ctx, col.Database())
+		},
+	},
+	{
+		name:    "takedown",
+		args:    "[image-url ...]",
+		summary: "remove images for good; URLs are read from stdin if none are given",
+		mongo:   true,
+		run: func(ctx context.Context, st store.Store, col *mongo.Collection, args []string) error {
+			return runTakedown(ctx, col, args)
+		},
+	},
+	{
+		name:    "linkcheck",
+		summary: "check one batch of stored image links for 404s",
+		flags: []envFlag{
+			{"batch", "IMG_LINKCHECK_BATCH", "images to check", false},
+			{"grace-days", "IMG_DEAD_GRACE_DAYS", "remove images dead this many days", false},
+		},
+		mongo: true,
+		run: func(ctx context.Context, st store.Store, col *mongo.Collection, args []string) error {
+			return crawler.CheckLinks(ctx, col)
+		},
+	},
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// parseCommandLine picks the command and applies its flags. Errors are
+// printed with the usage; flag.ErrHelp means help was asked for.
+func parseCommandLine(args []string) (*command, []string, error) {
+	name := env.Get("IMG_MODE", "crawl")
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		printUsage(os.Stdout)
+		return nil, nil, flag.ErrHelp
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		err := fmt.Errorf("unknown command %q", name)
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		printUsage(os.Stderr)
+		return nil, nil, err
+	}
+
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "usage: %s\n\n%s.\n\nflags:\n", strings.TrimSpace("image_crawler "+cmd.name+" [flags] "+cmd.args), cmd.summary)
+		fs.PrintDefaults()
+	}
+	for _, f := range append(cmd.flags, globalFlags...) {
+		usage := fmt.Sprintf("%s (%s)", f.usage, f.env)
+		set := func(v string) error {
+			return env.Override(f.env, v)
+		}
+		if f.boolean {
+			fs.BoolFunc(f.name, usage, set)
+		} else {
+			fs.Func(f.name, usage, set)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if cmd.args == "" && fs.NArg() > 0 {
+		err := fmt.Errorf("%s takes no arguments, got %q", cmd.name, fs.Args())
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil, err
+	}
+	return cmd, fs.Args(), nil
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: image_crawler [command] [flags]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nWithout a command, IMG_MODE picks one (crawl by default). Every setting\n"+
+		"is an environment variable, read from .env too; a flag overrides the one\n"+
+		"named in its help. See image_crawler <command> -h.\n")
+}
+
+// exitCode is how main exits when the command line is wrong.
+func exitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	return 2
+}
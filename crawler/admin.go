@@ -0,0 +1,364 @@
+package crawler
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   ADMIN DASHBOARD
+	==============================
+*/
+
+const (
+	AdminErrorLog   = 50 // recent errors kept for the dashboard
+	AdminTopDomains = 25
+	// images/sec is averaged over this many seconds
+	AdminRateWindow = 60
+)
+
+//go:embed admin.html
+var adminPage []byte
+
+// admin serves the dashboard for the crawler created last, which in serve
+// mode is the latest StartCrawl.
+var admin adminServer
+
+type adminServer struct {
+	mu      sync.Mutex
+	crawler *imageCrawler
+}
+
+func (a *adminServer) attach(c *imageCrawler) {
+	a.mu.Lock()
+	a.crawler = c
+	a.mu.Unlock()
+}
+
+func (a *adminServer) current() *imageCrawler {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.crawler
+}
+
+// limiter is the current crawler's, or nil.
+func (a *adminServer) limiter() *hostLimiter {
+	if c := a.current(); c != nil {
+		return c.limiter
+	}
+	return nil
+}
+
+// adminStatus is the JSON the dashboard polls.
+type adminStatus struct {
+	Mode         string         `json:"mode"`
+	RunID        string         `json:"run_id,omitempty"`
+	State        string         `json:"state"` // idle, running or finished
+	StartedAt    *time.Time     `json:"started_at,omitempty"`
+	Elapsed      float64        `json:"elapsed_seconds"`
+	Pages        int64          `json:"pages"`
+	Images       int64          `json:"images"`
+	ImagesPerSec float64        `json:"images_per_sec"`
+	Frontier     *FrontierStats `json:"frontier,omitempty"`
+	Domains      []domainStats  `json:"domains"`
+	// hosts pushing back first; all of them at /politeness.json
+	Politeness []politenessStats `json:"politeness"`
+	Errors     []loggedError     `json:"recent_errors"`
+}
+
+func (a *adminServer) status(ctx context.Context, mode string, now time.Time) adminStatus {
+	st := adminStatus{Mode: mode, State: "idle", Domains: []domainStats{}, Errors: recentErrors.recent()}
+	c := a.current()
+	st.Politeness = hostTraffic.politeness(a.limiter(), AdminTopDomains, now)
+	if c == nil {
+		return st
+	}
+
+	started, finished := c.stats.times()
+	st.State = "running"
+	st.RunID = c.runID
+	st.StartedAt = &started
+	st.Elapsed = now.Sub(started).Seconds()
+	if !finished.IsZero() {
+		st.State = "finished"
+		st.Elapsed = finished.Sub(started).Seconds()
+	}
+	st.Pages = c.pages.Load()
+	st.Images = c.images.Load()
+	st.ImagesPerSec = c.stats.rate(now)
+	st.Domains = c.stats.top(AdminTopDomains)
+
+	// recrawl and WARC ingestion run without a frontier
+	if c.frontier != nil {
+		fs, err := c.frontier.Stats(ctx)
+		if err != nil {
+			slog.Error("Reading frontier stats failed", "error", err)
+		} else {
+			st.Frontier = &fs
+		}
+	}
+	return st
+}
+
+// handler serves the page at / and its data at /status.json, every host's
+// politeness stats at /politeness.json, reloads the configuration on a
+// POST to /reload, and with IMG_PPROF=true serves pprof profiles under
+// /debug/pprof/. With a token set, all of them need it as a bearer token
+// or a token query parameter; the page passes its own on to the API.
+func (a *adminServer) handler(mode, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(adminPage)
+	})
+	mux.HandleFunc("GET /status.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(a.status(r.Context(), mode, time.Now()))
+	})
+	mux.HandleFunc("GET /politeness.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(hostTraffic.politeness(a.limiter(), 0, time.Now()))
+	})
+	// the same as a SIGHUP
+	mux.HandleFunc("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		if err := reloadConfig(); err != nil {
+			slog.Error("Reloading configuration failed, keeping the old one", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	if env.Get("IMG_PPROF", "") == "true" {
+		registerPprof(mux)
+	}
+	if token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := r.URL.Query().Get("token")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			given = bearer
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// StartAdminServer serves the dashboard on IMG_ADMIN_ADDR, if set, until
+// ctx is done. IMG_ADMIN_TOKEN protects it; without one, bind it to a
+// private address.
+func StartAdminServer(ctx context.Context, mode string) error {
+	addr := env.Get("IMG_ADMIN_ADDR", "")
+	if addr == "" {
+		return nil
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           admin.handler(mode, env.Get("IMG_ADMIN_TOKEN", "")),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	go func() {
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Admin server failed", "error", err)
+		}
+	}()
+	slog.Info("Admin dashboard listening", "addr", lis.Addr().String(), "pprof", env.Get("IMG_PPROF", "") == "true")
+	return nil
+}
+
+/*
+	==============================
+	   CRAWL STATS
+	==============================
+*/
+
+// domainStats is one domain's row on the dashboard.
+type domainStats struct {
+	Domain string `json:"domain"`
+	Pages  int64  `json:"pages"`
+	Images int64  `json:"images"`
+	Errors int64  `json:"errors"`
+}
+
+// crawlStats keeps what the dashboard shows beyond the crawler's totals:
+// per-domain counts and the images saved in each of the last
+// AdminRateWindow seconds.
+type crawlStats struct {
+	mu       sync.Mutex
+	started  time.Time
+	finished time.Time
+	domains  map[string]*domainStats
+	seconds  [AdminRateWindow]struct{ at, images int64 }
+}
+
+func newCrawlStats() *crawlStats {
+	return &crawlStats{started: time.Now(), domains: map[string]*domainStats{}}
+}
+
+// domain returns the row for domain; callers hold mu.
+func (s *crawlStats) domain(domain string) *domainStats {
+	d := s.domains[domain]
+	if d == nil {
+		d = &domainStats{Domain: domain}
+		s.domains[domain] = d
+	}
+	return d
+}
+
+func (s *crawlStats) page(link string) {
+	s.mu.Lock()
+	s.domain(taskDomain(link)).Pages++
+	s.mu.Unlock()
+}
+
+func (s *crawlStats) failure(link string) {
+	s.mu.Lock()
+	s.domain(taskDomain(link)).Errors++
+	s.mu.Unlock()
+}
+
+func (s *crawlStats) image(img store.ImageRecord) {
+	domain := img.DomainName
+	if domain == "" {
+		domain = taskDomain(img.PageURL)
+	}
+	now := time.Now().Unix()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.domain(domain).Images++
+	b := &s.seconds[now%AdminRateWindow]
+	if b.at != now {
+		b.at, b.images = now, 0
+	}
+	b.images++
+}
+
+// failures is the number of pages that could not be fetched.
+func (s *crawlStats) failures() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, d := range s.domains {
+		n += d.Errors
+	}
+	return n
+}
+
+func (s *crawlStats) finish() {
+	s.mu.Lock()
+	s.finished = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *crawlStats) times() (started, finished time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.started, s.finished
+}
+
+// rate is the images saved per second over the last AdminRateWindow
+// seconds, or since the start if the crawl is younger.
+func (s *crawlStats) rate(now time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, b := range s.seconds {
+		if b.at > now.Unix()-AdminRateWindow {
+			n += b.images
+		}
+	}
+	window := min(now.Sub(s.started).Seconds(), AdminRateWindow)
+	if window < 1 {
+		window = 1
+	}
+	return float64(n) / window
+}
+
+// top returns the n domains with the most pages.
+func (s *crawlStats) top(n int) []domainStats {
+	s.mu.Lock()
+	out := make([]domainStats, 0, len(s.domains))
+	for _, d := range s.domains {
+		out = append(out, *d)
+	}
+	s.mu.Unlock()
+	slices.SortFunc(out, func(a, b domainStats) int {
+		if a.Pages != b.Pages {
+			return int(b.Pages - a.Pages)
+		}
+		return strings.Compare(a.Domain, b.Domain)
+	})
+	return out[:min(n, len(out))]
+}
+
+/*
+	==============================
+	   RECENT ERRORS
+	==============================
+*/
+
+// loggedError is an error-level log record as the dashboard lists it.
+type loggedError struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	URL     string    `json:"url,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Class   string    `json:"error_class,omitempty"`
+}
+
+// errorLog is a ring of the latest errors, filled by the log handler.
+type errorLog struct {
+	mu      sync.Mutex
+	entries []loggedError
+	next    int
+}
+
+var recentErrors = &errorLog{entries: make([]loggedError, 0, AdminErrorLog)}
+
+func (l *errorLog) add(e loggedError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) < cap(l.entries) {
+		l.entries = append(l.entries, e)
+		return
+	}
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+}
+
+// recent returns the errors newest first.
+func (l *errorLog) recent() []loggedError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]loggedError, 0, len(l.entries))
+	for i := range l.entries {
+		out = append(out, l.entries[(l.next+len(l.entries)-1-i)%len(l.entries)])
+	}
+	return out
+}
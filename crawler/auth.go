@@ -0,0 +1,161 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   AUTHENTICATED CRAWLING
+	==============================
+*/
+
+// authFile is the shape of IMG_AUTH_FILE:
+//
+//	{
+//	  "example.com": {"cookies": {"session": "${EXAMPLE_SESSION}"}},
+//	  "api.example.org": {"bearer": "${EXAMPLE_TOKEN}"},
+//	  "intranet.example.net": {"user": "crawler", "password": "${INTRANET_PASS}"}
+//	}
+//
+// Values go through os.ExpandEnv so secrets can stay in the environment.
+// An entry covers the domain and its subdomains; the most specific one
+// wins. Credentials are only sent over https unless allow_http is set.
+type authFile map[string]domainAuth
+
+type domainAuth struct {
+	Cookies   map[string]string `json:"cookies"`
+	Bearer    string            `json:"bearer"`
+	User      string            `json:"user"`
+	Password  string            `json:"password"`
+	Headers   map[string]string `json:"headers"`
+	AllowHTTP bool              `json:"allow_http"`
+}
+
+// crawlJar holds cookies across every request, so a session cookie set
+// by a login redirect or seeded from IMG_AUTH_FILE sticks. nil when
+// IMG_COOKIE_JAR=false.
+var crawlJar http.CookieJar
+
+func loadAuthFile(path string) (authFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg authFile
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("IMG_AUTH_FILE: %w", err)
+	}
+
+	out := authFile{}
+	for domain, a := range cfg {
+		for k, v := range a.Cookies {
+			a.Cookies[k] = os.ExpandEnv(v)
+		}
+		for k, v := range a.Headers {
+			a.Headers[k] = os.ExpandEnv(v)
+		}
+		a.Bearer = os.ExpandEnv(a.Bearer)
+		a.User = os.ExpandEnv(a.User)
+		a.Password = os.ExpandEnv(a.Password)
+		out[strings.ToLower(domain)] = a
+	}
+	return out, nil
+}
+
+// configureAuth sets up the shared cookie jar, seeds it with configured
+// cookies and wraps crawlTransport to add per-domain credentials.
+func configureAuth() error {
+	if env.Get("IMG_COOKIE_JAR", "true") != "false" {
+		jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+		if err != nil {
+			return err
+		}
+		crawlJar = jar
+	}
+
+	path := env.Get("IMG_AUTH_FILE", "")
+	if path == "" {
+		return nil
+	}
+	cfg, err := loadAuthFile(path)
+	if err != nil {
+		return err
+	}
+
+	for domain, a := range cfg {
+		if len(a.Cookies) == 0 {
+			continue
+		}
+		if crawlJar == nil {
+			return fmt.Errorf("IMG_AUTH_FILE: cookies for %s need the cookie jar (IMG_COOKIE_JAR)", domain)
+		}
+		var cookies []*http.Cookie
+		for name, value := range a.Cookies {
+			cookies = append(cookies, &http.Cookie{
+				Name:   name,
+				Value:  value,
+				Domain: domain,
+				Path:   "/",
+				Secure: !a.AllowHTTP,
+			})
+		}
+		crawlJar.SetCookies(&url.URL{Scheme: "https", Host: domain, Path: "/"}, cookies)
+	}
+
+	slog.Info("Loaded credentials", "domains", len(cfg))
+	crawlTransport = &authTransport{base: crawlTransport, domains: cfg}
+	return nil
+}
+
+// authTransport adds the Authorization and extra headers configured for
+// the request's host. Each hop of a redirect is stamped separately, so
+// credentials never follow a redirect to another domain.
+type authTransport struct {
+	base    http.RoundTripper
+	domains authFile
+}
+
+func (t *authTransport) authFor(host string) (domainAuth, bool) {
+	var auth domainAuth
+	best := -1
+	for d, a := range t.domains {
+		if (host == d || strings.HasSuffix(host, "."+d)) && len(d) > best {
+			best = len(d)
+			auth = a
+		}
+	}
+	return auth, best >= 0
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	a, ok := t.authFor(strings.ToLower(req.URL.Hostname()))
+	if !ok || (req.URL.Scheme != "https" && !a.AllowHTTP) {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	if req.Header.Get("Authorization") == "" {
+		switch {
+		case a.Bearer != "":
+			req.Header.Set("Authorization", "Bearer "+a.Bearer)
+		case a.User != "":
+			req.SetBasicAuth(a.User, a.Password)
+		}
+	}
+	for name, value := range a.Headers {
+		req.Header.Set(name, value)
+	}
+	return t.base.RoundTrip(req)
+}
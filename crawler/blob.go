@@ -0,0 +1,78 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   BLOB STORAGE
+	==============================
+*/
+
+// blobStore keeps image bytes the crawler holds itself (decoded data URIs
+// and the like). Put returns the URL the stored object is reachable at.
+type blobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+}
+
+// newBlobStore returns the S3 bucket named by IMG_S3_BUCKET or the
+// directory named by IMG_BLOB_DIR, or nil when neither is configured.
+func newBlobStore() (blobStore, error) {
+	if bucket := env.Get("IMG_S3_BUCKET", ""); bucket != "" {
+		return newS3BlobStore(bucket)
+	}
+
+	dir := env.Get("IMG_BLOB_DIR", "")
+	if dir == "" {
+		return nil, nil
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		return nil, fmt.Errorf("IMG_BLOB_DIR: %w", err)
+	}
+	return &fsBlobStore{dir: abs, baseURL: env.Get("IMG_BLOB_BASE_URL", "")}, nil
+}
+
+// fsBlobStore writes objects under a local directory. With baseURL set
+// (e.g. a static file server in front of dir) the returned URL uses it;
+// otherwise it is a file:// URL.
+type fsBlobStore struct {
+	dir     string
+	baseURL string
+}
+
+func (s *fsBlobStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, s.dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("blob key %q escapes the blob directory", key)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+
+	// keys are content addressed, so an existing file already holds these bytes
+	if _, err := os.Stat(path); err != nil {
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0o644); err != nil {
+			return "", err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return "", err
+		}
+	}
+
+	if s.baseURL != "" {
+		return strings.TrimRight(s.baseURL, "/") + "/" + key, nil
+	}
+	return "file://" + filepath.ToSlash(path), nil
+}
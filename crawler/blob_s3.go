@@ -0,0 +1,231 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   S3 BLOB STORAGE
+	==============================
+*/
+
+const S3Timeout = 60 * time.Second
+
+// s3BlobStore writes objects to an S3 compatible bucket (AWS, MinIO, R2,
+// ...) with a hand-rolled SigV4 PUT, which is all the crawler needs.
+//
+// Keys are content addressed, so an object that already exists is never
+// uploaded twice. Every object is tagged kind=<first key segment>
+// ("data", "originals", "thumbs") and marked immutable, so lifecycle
+// rules can expire one kind by tag or prefix without touching the rest.
+type s3BlobStore struct {
+	endpoint  *url.URL // scheme and host, e.g. https://s3.eu-west-1.amazonaws.com
+	bucket    string
+	prefix    string
+	region    string
+	pathStyle bool
+	baseURL   string
+
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	// not crawlClient: the store is trusted and is often on a private
+	// address the SSRF guard would refuse
+	client *http.Client
+}
+
+// newS3BlobStore reads IMG_S3_BUCKET, IMG_S3_ENDPOINT, IMG_S3_REGION,
+// IMG_S3_PREFIX and IMG_S3_PATH_STYLE (default true, which MinIO needs).
+// Credentials come from IMG_S3_ACCESS_KEY / IMG_S3_SECRET_KEY or the
+// usual AWS_* variables.
+func newS3BlobStore(bucket string) (*s3BlobStore, error) {
+	region := env.Get("IMG_S3_REGION", env.Get("AWS_REGION", "us-east-1"))
+	endpoint, err := url.Parse(env.Get("IMG_S3_ENDPOINT", "https://s3."+region+".amazonaws.com"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("IMG_S3_ENDPOINT: bad URL %q", env.Get("IMG_S3_ENDPOINT", ""))
+	}
+
+	s := &s3BlobStore{
+		endpoint:     endpoint,
+		bucket:       bucket,
+		prefix:       strings.Trim(env.Get("IMG_S3_PREFIX", ""), "/"),
+		region:       region,
+		pathStyle:    env.Get("IMG_S3_PATH_STYLE", "") != "false",
+		baseURL:      env.Get("IMG_BLOB_BASE_URL", ""),
+		accessKey:    env.Get("IMG_S3_ACCESS_KEY", env.Get("AWS_ACCESS_KEY_ID", "")),
+		secretKey:    env.Get("IMG_S3_SECRET_KEY", env.Get("AWS_SECRET_ACCESS_KEY", "")),
+		sessionToken: env.Get("AWS_SESSION_TOKEN", ""),
+		client:       &http.Client{Timeout: S3Timeout},
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("IMG_S3_BUCKET is set but no S3 credentials were given")
+	}
+	return s, nil
+}
+
+// objectURL is where key lives in the bucket.
+func (s *s3BlobStore) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	path := "/" + key
+	if s.pathStyle {
+		path = "/" + s.bucket + path
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = path
+	u.RawPath = awsURIEncode(path)
+	return &u
+}
+
+func (s *s3BlobStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	kind, _, _ := strings.Cut(key, "/")
+	if s.prefix != "" {
+		key = s.prefix + "/" + key
+	}
+	target := s.objectURL(key)
+
+	public := target.String()
+	if s.baseURL != "" {
+		public = strings.TrimRight(s.baseURL, "/") + "/" + key
+	}
+
+	exists, err := s.exists(ctx, target)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return public, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target.String(), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	req.Header.Set("X-Amz-Tagging", "kind="+url.QueryEscape(kind))
+	req.Header.Set("X-Amz-Meta-Crawler", "image_crawler")
+	s.sign(req, data, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("s3 put %s: %d %s", key, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return public, nil
+}
+
+func (s *s3BlobStore) exists(ctx context.Context, target *url.URL) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	s.sign(req, nil, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("s3 head %s: %d", target.Path, resp.StatusCode)
+}
+
+// sign adds AWS Signature Version 4 headers for a request without a
+// query string.
+func (s *s3BlobStore) sign(req *http.Request, body []byte, now time.Time) {
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	canonical.WriteString(req.Method + "\n")
+	canonical.WriteString(req.URL.EscapedPath() + "\n")
+	canonical.WriteString("\n") // no query
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonical.WriteString("\n" + signedHeaders + "\n" + payloadHash)
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	reqHash := sha256.Sum256([]byte(canonical.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(reqHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// awsURIEncode percent-encodes a path the way SigV4 expects: everything
+// but unreserved characters and slashes.
+func awsURIEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,198 @@
+package crawler
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   BATCHED IMAGE WRITES
+	==============================
+*/
+
+const (
+	WriteBatchSize     = 200
+	WriteFlushInterval = 2 * time.Second
+)
+
+// imageWriter buffers the images every worker finds and stores them with
+// one SaveImages call per batch: as soon as IMG_WRITE_BATCH are waiting,
+// every IMG_WRITE_FLUSH otherwise, and on Close. saved runs for each image
+// once its batch is stored, so enrichment never looks for a record that
+// isn't written yet. Batch sizes and write latency are logged on Close.
+// Each batch's span links to the spans of the pages its images came from,
+// and saved gets the page's span back as its context's parent.
+type imageWriter struct {
+	store    store.Store
+	size     int
+	interval time.Duration
+	saved    func(context.Context, store.ImageRecord)
+
+	ctx  context.Context
+	stop chan struct{}
+	done chan struct{}
+
+	mu  sync.Mutex
+	buf []queuedImage
+
+	// one batch is taken and written at a time, so writes keep the order
+	// images were added in
+	flushMu sync.Mutex
+	batches int64
+	written int64
+	failed  int64
+	largest int
+	total   time.Duration
+	slowest time.Duration
+}
+
+// queuedImage is a buffered image and the span of the page it was found on.
+type queuedImage struct {
+	img  store.ImageRecord
+	span trace.SpanContext
+}
+
+func newImageWriter(st store.Store, saved func(context.Context, store.ImageRecord)) *imageWriter {
+	w := &imageWriter{
+		store:    st,
+		size:     max(env.Int("IMG_WRITE_BATCH", WriteBatchSize), 1),
+		interval: env.Duration("IMG_WRITE_FLUSH", WriteFlushInterval),
+		saved:    saved,
+		ctx:      context.Background(),
+	}
+	if w.interval <= 0 {
+		w.interval = WriteFlushInterval
+	}
+	return w
+}
+
+// Start flushes on the interval until Close. Batches are written under ctx.
+func (w *imageWriter) Start(ctx context.Context) {
+	w.ctx = ctx
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		t := time.NewTicker(w.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				w.flush(0)
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Add queues images, writing a batch in the caller when one is full.
+func (w *imageWriter) Add(ctx context.Context, imgs []store.ImageRecord) {
+	span := trace.SpanContextFromContext(ctx)
+	w.mu.Lock()
+	for _, img := range imgs {
+		w.buf = append(w.buf, queuedImage{img, span})
+	}
+	w.mu.Unlock()
+	for w.flush(w.size) {
+	}
+}
+
+// take removes up to size buffered images, or all of them when size is
+// 0. With size set it only takes a full batch.
+func (w *imageWriter) take(size int) []queuedImage {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := len(w.buf)
+	if size > 0 {
+		if n < size {
+			return nil
+		}
+		n = size
+	}
+	if n == 0 {
+		return nil
+	}
+	batch := make([]queuedImage, n)
+	copy(batch, w.buf)
+	w.buf = append(w.buf[:0], w.buf[n:]...)
+	return batch
+}
+
+// flush writes the batch take(size) returns and reports whether there
+// was one. The batch is taken under flushMu, so no later batch can be
+// written before it.
+func (w *imageWriter) flush(size int) bool {
+	w.flushMu.Lock()
+	queued := w.take(size)
+	if len(queued) == 0 {
+		w.flushMu.Unlock()
+		return false
+	}
+	batch := make([]store.ImageRecord, len(queued))
+	var links []trace.Link
+	seen := map[trace.SpanID]bool{}
+	for i, q := range queued {
+		batch[i] = q.img
+		if q.span.IsValid() && !seen[q.span.SpanID()] {
+			seen[q.span.SpanID()] = true
+			links = append(links, trace.Link{SpanContext: q.span})
+		}
+	}
+
+	ctx, span := tracer.Start(w.ctx, "write_batch", trace.WithNewRoot(), trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("images", len(batch)), attribute.Int("pages", len(links))))
+	start := time.Now()
+	err := w.store.SaveImages(ctx, batch)
+	took := time.Since(start)
+	endSpan(span, err)
+
+	w.batches++
+	w.total += took
+	w.slowest = max(w.slowest, took)
+	w.largest = max(w.largest, len(batch))
+	if err != nil {
+		w.failed += int64(len(batch))
+	} else {
+		w.written += int64(len(batch))
+	}
+	w.flushMu.Unlock()
+
+	if err != nil {
+		slog.Error("Saving images failed", "count", len(batch), "error", err)
+		return true
+	}
+	for _, q := range queued {
+		w.saved(trace.ContextWithSpanContext(w.ctx, q.span), q.img)
+	}
+	return true
+}
+
+// Close writes what is left and logs the write metrics.
+func (w *imageWriter) Close() {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+	w.flush(0)
+
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	if w.batches == 0 {
+		return
+	}
+	slog.Info("Image writes",
+		"batches", w.batches, "images", w.written, "failed", w.failed,
+		"per_batch", float64(w.written+w.failed)/float64(w.batches), "largest", w.largest,
+		"duration", (w.total / time.Duration(w.batches)).Round(time.Millisecond),
+		"slowest", w.slowest.Round(time.Millisecond))
+}
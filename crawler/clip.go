@@ -0,0 +1,67 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   CLIP EMBEDDINGS
+	==============================
+*/
+
+// embedder gets an image embedding from the IMG_CLIP_URL model server
+// (CLIP or any model with a shared text/image space). The server gets the
+// raw image bytes and answers
+//
+//	{"embedding": [0.013, -0.092, ...]}
+//
+// Vectors are stored unit length, so a dot product with an equally
+// normalized text embedding is their cosine similarity. IMG_CLIP_MODEL
+// names the model on each record; vectors from different models must
+// never be compared.
+type embedder struct {
+	endpoint *inferenceEndpoint
+	model    string
+}
+
+func newEmbedder() *embedder {
+	endpoint := newInferenceEndpoint("IMG_CLIP")
+	if endpoint == nil {
+		return nil
+	}
+	return &embedder{endpoint: endpoint, model: env.Get("IMG_CLIP_MODEL", "clip")}
+}
+
+func (e *embedder) Embed(ctx context.Context, data []byte, contentType string) ([]float32, error) {
+	var reply struct {
+		Embedding []float64 `json:"embedding"`
+	}
+	if err := e.endpoint.Post(ctx, data, contentType, &reply); err != nil {
+		return nil, err
+	}
+	if len(reply.Embedding) == 0 {
+		return nil, fmt.Errorf("clip reply has no embedding")
+	}
+	return normalizeVector(reply.Embedding)
+}
+
+func normalizeVector(v []float64) ([]float32, error) {
+	var sum float64
+	for _, x := range v {
+		sum += x * x
+	}
+	norm := math.Sqrt(sum)
+	if norm == 0 || math.IsNaN(norm) || math.IsInf(norm, 0) {
+		return nil, fmt.Errorf("embedding has no usable length")
+	}
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = float32(x / norm)
+	}
+	return out, nil
+}
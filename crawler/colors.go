@@ -0,0 +1,176 @@
+package crawler
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"slices"
+	"sort"
+)
+
+/*
+	==============================
+	   DOMINANT COLORS
+	==============================
+*/
+
+const (
+	MaxDominantColors = 5
+	MinColorShare     = 0.05
+	colorSampleGrid   = 64
+)
+
+// colorBin accumulates the samples falling into one quantized colour.
+type colorBin struct {
+	r, g, b float64
+	n       int
+}
+
+// dominantColors returns up to MaxDominantColors colours covering at
+// least MinColorShare of the image each, most common first, as "#rrggbb".
+// Pixels are sampled on a fixed grid and quantized to 3 bits per channel;
+// each colour is the average of the samples in its bin. Fully transparent
+// pixels are ignored.
+func dominantColors(img image.Image) []string {
+	b := img.Bounds()
+	if b.Empty() {
+		return nil
+	}
+	sx := max(b.Dx()/colorSampleGrid, 1)
+	sy := max(b.Dy()/colorSampleGrid, 1)
+
+	bins := map[int]*colorBin{}
+	total := 0
+	for y := b.Min.Y; y < b.Max.Y; y += sy {
+		for x := b.Min.X; x < b.Max.X; x += sx {
+			r, g, bl, a := img.At(x, y).RGBA()
+			if a == 0 {
+				continue
+			}
+			// RGBA is alpha-premultiplied 16-bit; back to straight 8-bit
+			r8, g8, b8 := float64(r*0xff/a), float64(g*0xff/a), float64(bl*0xff/a)
+			key := int(r8)>>5<<6 | int(g8)>>5<<3 | int(b8)>>5
+			bin := bins[key]
+			if bin == nil {
+				bin = &colorBin{}
+				bins[key] = bin
+			}
+			bin.r += r8
+			bin.g += g8
+			bin.b += b8
+			bin.n++
+			total++
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+
+	ranked := make([]*colorBin, 0, len(bins))
+	for _, bin := range bins {
+		ranked = append(ranked, bin)
+	}
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].n > ranked[j].n })
+
+	var out []string
+	for _, bin := range ranked {
+		if len(out) == MaxDominantColors || float64(bin.n)/float64(total) < MinColorShare {
+			break
+		}
+		n := float64(bin.n)
+		out = append(out, fmt.Sprintf("#%02x%02x%02x",
+			uint8(math.Round(bin.r/n)), uint8(math.Round(bin.g/n)), uint8(math.Round(bin.b/n))))
+	}
+	return out
+}
+
+// colorBuckets names the coarse colours of hex values (see colorBucket),
+// without repeats. "monochrome" is added when none of them has a hue.
+func colorBuckets(hexes []string) []string {
+	var out []string
+	mono := len(hexes) > 0
+	for _, h := range hexes {
+		bucket := colorBucket(h)
+		switch bucket {
+		case "black", "white", "gray":
+		default:
+			mono = false
+		}
+		if bucket != "" && !slices.Contains(out, bucket) {
+			out = append(out, bucket)
+		}
+	}
+	if mono {
+		out = append(out, "monochrome")
+	}
+	return out
+}
+
+// colorBucket maps "#rrggbb" to black, white, gray, red, orange, brown,
+// yellow, green, teal, blue, purple or pink.
+func colorBucket(hex string) string {
+	var r, g, b uint8
+	if _, err := fmt.Sscanf(hex, "#%02x%02x%02x", &r, &g, &b); err != nil {
+		return ""
+	}
+	h, s, v := rgbToHSV(float64(r)/255, float64(g)/255, float64(b)/255)
+
+	switch {
+	case v < 0.15:
+		return "black"
+	case s < 0.15 && v > 0.85:
+		return "white"
+	case s < 0.2:
+		return "gray"
+	}
+
+	switch {
+	case h < 15 || h >= 345:
+		return "red"
+	case h < 45:
+		if v < 0.6 {
+			return "brown"
+		}
+		return "orange"
+	case h < 70:
+		return "yellow"
+	case h < 165:
+		return "green"
+	case h < 195:
+		return "teal"
+	case h < 255:
+		return "blue"
+	case h < 290:
+		return "purple"
+	}
+	return "pink"
+}
+
+// rgbToHSV takes channels in [0,1] and returns hue in degrees plus
+// saturation and value in [0,1].
+func rgbToHSV(r, g, b float64) (float64, float64, float64) {
+	hi := max(r, g, b)
+	lo := min(r, g, b)
+	d := hi - lo
+
+	var h float64
+	switch {
+	case d == 0:
+		h = 0
+	case hi == r:
+		h = 60 * math.Mod((g-b)/d, 6)
+	case hi == g:
+		h = 60 * ((b-r)/d + 2)
+	default:
+		h = 60 * ((r-g)/d + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+
+	var s float64
+	if hi > 0 {
+		s = d / hi
+	}
+	return h, s, hi
+}
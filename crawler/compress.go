@@ -0,0 +1,54 @@
+package crawler
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+/*
+	==============================
+	   RESPONSE DECOMPRESSION
+	==============================
+*/
+
+// AcceptEncoding is sent explicitly on page requests. Setting it disables
+// net/http's built-in gzip handling, so decodeBody has to undo every
+// encoding the server applied.
+const AcceptEncoding = "gzip, deflate, br"
+
+// decodeBody wraps the response body in decoders for its Content-Encoding.
+// Encodings are listed in the order they were applied, so they are undone
+// in reverse.
+func decodeBody(resp *http.Response) (io.Reader, error) {
+	var body io.Reader = resp.Body
+
+	encodings := strings.Split(resp.Header.Get("Content-Encoding"), ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		switch enc := strings.ToLower(strings.TrimSpace(encodings[i])); enc {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			zr, err := gzip.NewReader(body)
+			if err != nil {
+				return nil, err
+			}
+			body = zr
+		case "deflate":
+			zr, err := zlib.NewReader(body)
+			if err != nil {
+				return nil, err
+			}
+			body = zr
+		case "br":
+			body = brotli.NewReader(body)
+		default:
+			return nil, fmt.Errorf("unsupported content encoding %q", enc)
+		}
+	}
+	return body, nil
+}
@@ -0,0 +1,66 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   CORRUPT IMAGES
+	==============================
+*/
+
+// MaxDecodePixels caps the images decoded in memory; a small file can
+// declare a huge canvas, which decodes into gigabytes.
+const MaxDecodePixels = 50_000_000
+
+// errOversized is returned by decodeImage for an image above the pixel cap.
+var errOversized = errors.New("image too large to decode")
+
+// decodeImage fully decodes a downloaded file, which catches truncated
+// downloads and error pages served under an image URL. The header is read
+// first, and an image of more than maxPixels is not decoded. AVIF cannot
+// be decoded in Go; a well-formed AVIF header passes with a nil image.
+func decodeImage(data []byte, maxPixels int64) (image.Image, error) {
+	if _, _, ok := avifDimensions(data); ok {
+		return nil, nil
+	}
+	if mime := http.DetectContentType(data); !strings.HasPrefix(mime, "image/") {
+		return nil, fmt.Errorf("served as %s", mime)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return nil, fmt.Errorf("%w: %dx%d", errOversized, cfg.Width, cfg.Height)
+	}
+	pic, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return pic, nil
+}
+
+// Purge removes the records enrichment marked corrupt (IMG_MODE=purge).
+// Their tombstones keep later crawls from fetching them again.
+func Purge(ctx context.Context, col *mongo.Collection) error {
+	n, err := store.RemoveMatching(ctx, col, bson.M{"corrupt": true}, store.TombstoneCorrupt)
+	if err != nil {
+		return err
+	}
+	slog.Info("Purged corrupt images", "count", n)
+	return nil
+}
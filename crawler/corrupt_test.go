@@ -0,0 +1,46 @@
+package crawler
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestDecodeImage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 100, 50))); err != nil {
+		t.Fatal(err)
+	}
+	pngData := buf.Bytes()
+
+	tests := []struct {
+		name      string
+		data      []byte
+		maxPixels int64
+		decoded   bool
+		oversized bool
+		fails     bool
+	}{
+		{name: "within the cap", data: pngData, maxPixels: 5000, decoded: true},
+		{name: "over the cap", data: pngData, maxPixels: 4999, oversized: true},
+		{name: "truncated", data: pngData[:len(pngData)/2], maxPixels: 5000, fails: true},
+		{name: "html error page", data: []byte("<html><body>Not found</body></html>"), maxPixels: 5000, fails: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pic, err := decodeImage(tt.data, tt.maxPixels)
+			if got := errors.Is(err, errOversized); got != tt.oversized {
+				t.Errorf("oversized = %v, want %v (error %v)", got, tt.oversized, err)
+			}
+			if got := err != nil && !tt.oversized; got != tt.fails {
+				t.Errorf("error = %v, want failure %v", err, tt.fails)
+			}
+			if got := pic != nil; got != tt.decoded {
+				t.Errorf("decoded = %v, want %v", got, tt.decoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,801 @@
+// Package crawler is the crawl engine: the frontier, politeness, fetching,
+// enrichment and the modes built on them. Configure applies the process
+// settings and Crawl runs a crawl into a store.Store; most settings are
+// read from IMG_* environment variables, as for the command.
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"image_crawler/extract"
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	  IMAGE CRAWLER CONFIG
+	==============================
+*/
+
+const (
+	MaxImagePages    = 400
+	ImageTimeout     = 9 * time.Second
+	ImageDelay       = 350 * time.Millisecond
+	MaxImageBodySize = 3 * 1024 * 1024
+	MaxImageDepth    = 4
+	ImageConcurrency = 4
+	MaxStoredLinks   = 500
+)
+
+/*
+	==============================
+	   FETCH HTML PAGE
+	==============================
+*/
+
+// FetchResult is a fetched page. Doc is nil when NotModified is set.
+type FetchResult struct {
+	Doc          *goquery.Document
+	FinalURL     string
+	Redirects    []string
+	NotModified  bool
+	ETag         string
+	LastModified string
+	RobotsTags   []string
+
+	// the response as received, body decoded, for the WARC archive
+	Proto  string
+	Status string
+	Header http.Header
+	Body   []byte
+}
+
+// httpFetcher fetches pages live through the crawl client.
+type httpFetcher struct{}
+
+// Fetch makes a conditional request when prev carries validators from an
+// earlier fetch; a 304 comes back as a result with NotModified set.
+func (httpFetcher) Fetch(ctx context.Context, link string, prev *store.PageRecord) (*FetchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", AcceptEncoding)
+	if prev != nil {
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
+
+	resp, err := crawlClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return readFetchResult(resp)
+}
+
+// readFetchResult turns a response into a result, or an error for failure
+// statuses and anything but HTML.
+func readFetchResult(resp *http.Response) (*FetchResult, error) {
+	res := &FetchResult{
+		FinalURL:     resp.Request.URL.String(),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		RobotsTags:   resp.Header.Values("X-Robots-Tag"),
+	}
+	if chain := redirectChain(resp); len(chain) > 1 {
+		res.Redirects = chain
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		res.NotModified = true
+		return res, nil
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, &httpStatusError{
+			Status:     resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+
+	if !strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
+		return nil, fmt.Errorf("not html content")
+	}
+
+	// the size limit applies to the decompressed HTML
+	decoded, err := decodeBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(decoded, MaxImageBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	res.Doc, err = goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	res.Proto, res.Status, res.Header, res.Body = resp.Proto, resp.Status, resp.Header, body
+	return res, nil
+}
+
+/*
+	==============================
+	   IMAGE CRAWLING ENGINE
+	==============================
+*/
+
+// imageCrawler fetches pages claimed from the frontier and hands the
+// discovered links back to it.
+type imageCrawler struct {
+	store    store.Store
+	col      *mongo.Collection // nil unless store is Mongo
+	failures store.FailureLog  // nil when the store keeps none
+	runs     store.RunLog      // nil when the store keeps none
+	frontier Frontier
+	stages   *amqpStages // nil unless the crawl is split into stages
+	rules    atomic.Pointer[crawlRules]
+	robots   *robotsCache
+	sizes    *imageSizeFilter
+	traps    *trapDetector
+	limiter  *hostLimiter
+	styles   *styleCache
+	vimeo    *vimeoCache
+	verified *verifyCache
+	favicons *faviconCache
+	fetchers *fetcherRoutes
+	extract  extract.Chain
+	warc     *warcWriter
+	blobs    blobStore
+	enricher *enricher
+	writer   *imageWriter
+	retry    retryPolicy
+	workers  int
+
+	// progress, reported by GetCrawlStatus in serve mode and on the admin
+	// dashboard
+	pages     atomic.Int64
+	images    atomic.Int64
+	stats     *crawlStats
+	runID     string
+	runRecord store.RunRecord
+
+	// data URI capture, only when blobs is set
+	captureDataURIs bool
+	dataURIMin      int
+
+	mu          sync.Mutex
+	interrupted []Task
+}
+
+func newImageCrawler(st store.Store, frontier Frontier, allowed []string) (*imageCrawler, error) {
+	rules, err := loadCrawlRules(allowed)
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := loadImageSizeFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &imageCrawler{
+		store:    st,
+		col:      store.MongoCollection(st),
+		frontier: frontier,
+		robots:   loadRobotsCache(),
+		sizes:    sizes,
+		traps:    newTrapDetector(),
+		vimeo:    newVimeoCache(),
+		limiter:  loadHostLimiter(),
+		retry:    loadRetryPolicy(),
+		workers:  env.Int("IMG_CONCURRENCY", ImageConcurrency),
+	}
+	if c.workers < 1 {
+		c.workers = 1
+	}
+	c.rules.Store(rules)
+	if c.blobs, err = newBlobStore(); err != nil {
+		return nil, err
+	}
+	if c.warc, err = newWARCWriter(); err != nil {
+		return nil, err
+	}
+	if env.Get("IMG_CAPTURE_DATA_URIS", "") == "true" {
+		if c.blobs == nil {
+			return nil, fmt.Errorf("IMG_CAPTURE_DATA_URIS needs a blob store (IMG_BLOB_DIR or IMG_S3_BUCKET)")
+		}
+		c.captureDataURIs = true
+		c.dataURIMin = env.Int("IMG_DATA_URI_MIN_BYTES", DataURIMinBytes)
+	}
+	if env.Get("IMG_FETCH_STYLESHEETS", "") == "true" {
+		c.styles = newStyleCache()
+	}
+	if c.fetchers, err = loadFetchers(); err != nil {
+		return nil, err
+	}
+	if c.extract, err = c.extractors(); err != nil {
+		return nil, err
+	}
+	if env.Get("IMG_VERIFY_IMAGES", "") == "true" {
+		c.verified = newVerifyCache()
+	}
+	if env.Get("IMG_FAVICONS", "") == "true" {
+		if c.col == nil {
+			return nil, fmt.Errorf("IMG_FAVICONS needs IMG_DB_DRIVER=mongo")
+		}
+		c.favicons = newFaviconCache()
+	}
+	if c.enricher, err = newEnricher(st, c.limiter, c.robots, c.sizes, c.blobs); err != nil {
+		return nil, err
+	}
+	// images are queued for enrichment once their records exist
+	c.writer = newImageWriter(st, func(ctx context.Context, img store.ImageRecord) {
+		c.images.Add(1)
+		c.stats.image(img)
+		c.enricher.Enqueue(ctx, img)
+	})
+	if slices.Contains(allowed, "*") {
+		slog.Info("Open crawl: every host is allowed unless listed in IMG_BLOCKED_SITES")
+	}
+	if log, ok := store.Feature[store.FailureLog](st); ok {
+		c.failures = log
+	} else {
+		slog.Info("This store keeps no fetch failures")
+	}
+	if log, ok := store.Feature[store.RunLog](st); ok {
+		c.runs = log
+	} else {
+		slog.Info("This store keeps no crawl runs")
+	}
+	c.stats = newCrawlStats()
+	c.runID = newRunID()
+	admin.attach(c)
+	return c, nil
+}
+
+// hostAllowed applies IMG_ALLOWED_SITES and then IMG_BLOCKED_SITES, so a
+// blocked host stays out even under an open ("*") allowlist.
+func (c *imageCrawler) hostAllowed(u *url.URL) bool {
+	r := c.rules.Load()
+	return r.allowed.Match(u) && !r.blocked.Match(u)
+}
+
+// worker claims tasks until stop is cancelled or the frontier runs dry.
+// Pages run under work, which outlives stop by the shutdown grace period.
+func (c *imageCrawler) worker(stop, work context.Context) {
+	for {
+		t, ok, err := c.frontier.Next(stop)
+		if err != nil {
+			if stop.Err() == nil {
+				slog.Error("Claiming a task failed", "error", err)
+			}
+			return
+		}
+		if !ok {
+			return
+		}
+
+		ctx, span := tracer.Start(work, "crawl.page", trace.WithNewRoot(), trace.WithAttributes(
+			attribute.String("url", t.Link), attribute.Int("depth", t.Level)))
+		links, fetched := c.crawlPage(ctx, t)
+		span.SetAttributes(attribute.Bool("fetched", fetched), attribute.Int("links", len(links)))
+		span.End()
+		if fetched {
+			c.pages.Add(1)
+			c.stats.page(t.Link)
+		}
+
+		// abandoned at the end of the grace period: crawl it again on resume
+		if !fetched && work.Err() != nil {
+			c.mu.Lock()
+			c.interrupted = append(c.interrupted, t)
+			c.mu.Unlock()
+		}
+
+		if err := c.frontier.Done(context.WithoutCancel(work), t, links, fetched); err != nil {
+			slog.Error("Completing a task failed", "url", t.Link, "error", err)
+		}
+	}
+}
+
+// crawlPage fetches a single page, stores its images and returns the links
+// to follow. The bool reports whether the page counted against the budget.
+func (c *imageCrawler) crawlPage(ctx context.Context, t Task) ([]Task, bool) {
+	parsed, err := url.Parse(t.Link)
+	if err != nil {
+		return nil, false
+	}
+	if !c.hostAllowed(parsed) {
+		return nil, false
+	}
+	if !c.robots.Allowed(parsed) {
+		slog.Info("Blocked by robots.txt", "url", t.Link)
+		return nil, false
+	}
+	// a sitemap seed only queues its pages; it is not a page itself
+	if t.Level == 0 && isSitemapURL(t.Link) {
+		return c.crawlSitemap(ctx, t), false
+	}
+
+	prev, err := c.store.LoadPage(ctx, t.Link)
+	if err != nil {
+		slog.Error("Loading page metadata failed", "url", t.Link, "error", err)
+	}
+
+	slog.Debug("Fetching", "url", t.Link, "depth", t.Level)
+	start := time.Now()
+	res, err := c.fetchPage(ctx, parsed, prev)
+	// a 304 only means something against the validators of a stored page;
+	// without one, or without a document, there is nothing to work from
+	switch {
+	case err != nil:
+	case res.NotModified && prev == nil:
+		err = fmt.Errorf("not modified, but the page was never stored")
+	case !res.NotModified && res.Doc == nil:
+		err = fmt.Errorf("fetcher returned no document")
+	}
+	if err != nil {
+		trace.SpanFromContext(ctx).SetStatus(codes.Error, err.Error())
+		slog.Error("Fetching failed", "url", t.Link, "depth", t.Level, "duration", time.Since(start), "error", err)
+		c.stats.failure(t.Link)
+		return nil, false
+	}
+
+	// after a redirect the page is stored under its final location, and
+	// dropped if that location was already crawled or is off-limits
+	pageURL := t.Link
+	if res.FinalURL != t.Link {
+		final, err := url.Parse(res.FinalURL)
+		if err != nil || !c.hostAllowed(final) {
+			slog.Info("Redirect to disallowed location", "url", t.Link, "location", res.FinalURL)
+			return nil, false
+		}
+		first, err := c.frontier.Visit(ctx, res.FinalURL)
+		if err != nil {
+			slog.Error("Marking a redirect target visited failed", "url", res.FinalURL, "error", err)
+		}
+		if err == nil && !first {
+			slog.Info("Redirect target already crawled", "url", t.Link, "location", res.FinalURL)
+			return nil, false
+		}
+		slog.Debug("Redirected", "url", t.Link, "location", res.FinalURL)
+		pageURL = res.FinalURL
+		parsed = final
+	}
+
+	// unchanged since the last crawl: skip parsing, reuse stored links
+	if res.NotModified {
+		slog.Info("Not modified", "url", t.Link, "depth", t.Level, "duration", time.Since(start))
+		now := time.Now().UTC()
+		page := *prev
+		page.FinalURL = pageURL
+		page.Redirects = res.Redirects
+		page.TimeFetched = now
+		page.RunID = c.runID
+		scheduleRevisit(&page, prev, false, now)
+		if err := c.store.MarkSeen(ctx, prev.ImageURLs, now); err != nil {
+			slog.Error("Refreshing images failed", "url", pageURL, "error", err)
+		}
+		if err := c.store.SavePage(ctx, page); err != nil {
+			slog.Error("Saving page metadata failed", "url", pageURL, "error", err)
+		}
+		return c.linkTasks(parsed, t, prev.OutLinks, prev.ImageCount), true
+	}
+
+	// with split stages the page is parsed wherever the extract stage runs
+	if c.stages != nil {
+		err := c.stages.publishPage(ctx, fetchedPage{
+			Task:      t,
+			URL:       pageURL,
+			Redirects: res.Redirects,
+			Response:  responseBlock(res),
+		})
+		if err != nil {
+			slog.Error("Queueing page for extraction failed", "url", pageURL, "error", err)
+			return nil, false
+		}
+		slog.Debug("Fetched", "url", pageURL, "depth", t.Level, "duration", time.Since(start))
+		return nil, true
+	}
+	return c.processPage(ctx, t, pageURL, parsed, prev, res, start), true
+}
+
+// processPage stores the images of a fetched page, saves the page and
+// returns the links to follow. pageURL is where the page was found after
+// redirects, and prev the page as last stored.
+func (c *imageCrawler) processPage(ctx context.Context, t Task, pageURL string, parsed *url.URL,
+	prev *store.PageRecord, res *FetchResult, start time.Time) []Task {
+	doc := res.Doc
+	if c.warc != nil {
+		if err := c.warc.WriteResponse(pageURL, time.Now(), res); err != nil {
+			slog.Error("Writing WARC record failed", "url", pageURL, "error", err)
+		}
+	}
+	directives := pageRobotsDirectives(doc, res.RobotsTags)
+	if c.favicons != nil {
+		c.captureFavicon(ctx, parsed, doc)
+	}
+
+	// extract filtered images
+	var found []store.ImageRecord
+	if directives.NoImageIndex {
+		slog.Info("Images not indexed (noimageindex)", "url", t.Link)
+	} else {
+		found = c.pageImages(ctx, pageURL, doc)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("images", len(found)))
+	c.writer.Add(ctx, found)
+
+	var hrefs []string
+	if !directives.NoFollow {
+		doc.Find("a[href]").Each(func(i int, a *goquery.Selection) {
+			raw, _ := a.Attr("href")
+			if resolved, err := extract.ResolveURL(parsed, raw); err == nil {
+				hrefs = append(hrefs, resolved.String())
+			}
+		})
+	}
+
+	imageURLs := make([]string, 0, len(found))
+	for _, img := range found {
+		imageURLs = append(imageURLs, img.FileURL)
+	}
+
+	now := time.Now().UTC()
+	page := store.PageRecord{
+		PageURL:      t.Link,
+		FinalURL:     pageURL,
+		Redirects:    res.Redirects,
+		ETag:         res.ETag,
+		LastModified: res.LastModified,
+		ImageCount:   len(found),
+		ImageURLs:    dedupeLinks(imageURLs, 0),
+		OutLinks:     dedupeLinks(hrefs, MaxStoredLinks),
+		TimeFetched:  now,
+		RunID:        c.runID,
+	}
+
+	var gone []string
+	if prev != nil {
+		gone = missingFrom(prev.ImageURLs, page.ImageURLs)
+	}
+	if len(gone) > 0 {
+		slog.Info("Removing images no longer on the page", "url", pageURL, "count", len(gone))
+		if err := c.store.RemoveImages(ctx, pageURL, gone); err != nil {
+			slog.Error("Removing images failed", "url", pageURL, "error", err)
+		}
+	}
+	changed := prev == nil || len(gone) > 0 || len(missingFrom(page.ImageURLs, prev.ImageURLs)) > 0
+	scheduleRevisit(&page, prev, changed, now)
+
+	if err := c.store.SavePage(ctx, page); err != nil {
+		slog.Error("Saving page metadata failed", "url", pageURL, "error", err)
+	}
+
+	slog.Info("Crawled", "url", pageURL, "depth", t.Level, "images", len(found), "links", len(hrefs),
+		"duration", time.Since(start))
+	return c.linkTasks(parsed, t, hrefs, len(found))
+}
+
+// DefaultExtractors is extract.DefaultChain followed by the extractors
+// that need the crawler: video thumbnails, inline data URIs and linked
+// stylesheets. The last two still only run when IMG_CAPTURE_DATA_URIS or
+// IMG_FETCH_STYLESHEETS is on.
+const DefaultExtractors = extract.DefaultChain + ",video,data-uri,stylesheet"
+
+// extractors builds the extraction chain from IMG_EXTRACTORS, a
+// comma-separated list of extractor names run in order; extractors added
+// with extract.Register can be named there too.
+func (c *imageCrawler) extractors() (extract.Chain, error) {
+	names := env.List("IMG_EXTRACTORS")
+	if len(names) == 0 {
+		names = strings.Split(DefaultExtractors, ",")
+	}
+	chain, err := extract.NewChain(names, map[string]extract.Extractor{
+		"video": extract.Func(func(p *extract.PageContext) []store.ImageRecord {
+			return c.videoImages(p.Context, p.URL, p.Doc)
+		}),
+		"data-uri": extract.Func(func(p *extract.PageContext) []store.ImageRecord {
+			if !c.captureDataURIs {
+				return nil
+			}
+			return c.dataURIImages(p.Context, p.URL, p.Doc)
+		}),
+		"stylesheet": extract.Func(func(p *extract.PageContext) []store.ImageRecord {
+			if c.styles == nil {
+				return nil
+			}
+			return c.stylesheetImages(p.Context, p.URL, p.Doc)
+		}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("IMG_EXTRACTORS: %w", err)
+	}
+	return chain, nil
+}
+
+// pageImages runs the extraction chain over a parsed page, then merges,
+// labels and filters what it found.
+func (c *imageCrawler) pageImages(ctx context.Context, pageURL string, doc *goquery.Document) []store.ImageRecord {
+	ctx, span := tracer.Start(ctx, "extract")
+	defer span.End()
+
+	found := c.extract.Extract(extract.NewPageContext(ctx, pageURL, doc))
+	found = extract.Dedupe(found)
+	title := extract.PageTitle(doc)
+	for i := range found {
+		found[i].PageTitle = title
+		found[i].RunID = c.runID
+	}
+	extract.ApplyLicenses(pageURL, doc, found)
+	var skipped int
+	if found, skipped = c.sizes.Filter(found); skipped > 0 {
+		slog.Debug("Skipped tiny or tracking images", "url", pageURL, "count", skipped)
+	}
+	if c.verified != nil {
+		found = c.verifyImages(ctx, pageURL, found)
+	}
+	slog.Debug("Found images", "url", pageURL, "count", len(found))
+	span.SetAttributes(attribute.Int("images", len(found)), attribute.Int("skipped", skipped))
+	return found
+}
+
+// linkTasks turns the links found on a page into frontier tasks.
+func (c *imageCrawler) linkTasks(parsed *url.URL, t Task, hrefs []string, yield int) []Task {
+	if t.Level >= MaxImageDepth {
+		return nil
+	}
+
+	var links []Task
+	for _, raw := range hrefs {
+		resolved, err := extract.ResolveURL(parsed, raw)
+		if err != nil {
+			continue
+		}
+		resolved = canonicalLink(resolved)
+		if !c.followable(resolved) {
+			continue
+		}
+		links = append(links, Task{
+			Link:     resolved.String(),
+			Level:    t.Level + 1,
+			Priority: linkPriority(resolved, yield, t.Level+1),
+		})
+	}
+	return links
+}
+
+// followable reports whether a discovered link, already canonical, may be
+// queued: its host is crawled and it passes the URL filters, robots.txt
+// and the trap heuristics. Only hosts we would actually crawl get the
+// later checks.
+func (c *imageCrawler) followable(u *url.URL) bool {
+	if !c.hostAllowed(u) || !c.rules.Load().filter.Allow(u) || !c.robots.Allowed(u) {
+		return false
+	}
+	ok, _ := c.traps.Allow(u)
+	return ok
+}
+
+// dedupeLinks keeps the first occurrence of each link, up to max links
+// (max <= 0 means no limit).
+func dedupeLinks(links []string, max int) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, l := range links {
+		if seen[l] {
+			continue
+		}
+		seen[l] = true
+		out = append(out, l)
+		if max > 0 && len(out) >= max {
+			break
+		}
+	}
+	return out
+}
+
+// Options are the settings a program embedding the crawler passes to
+// Crawl. The others are read from the IMG_* environment variables, as for
+// the command.
+type Options struct {
+	Seeds        []string // pages or sitemaps to start from
+	AllowedSites []string // hosts to crawl, "*" for any
+	// page budgets: MaxImagePages when 0, and no cap per domain
+	MaxPages          int
+	MaxPagesPerDomain int
+	// pages fetched at once, IMG_CONCURRENCY when 0
+	Workers int
+	// fetches pages instead of IMG_FETCHER; hosts listed in IMG_FETCHERS
+	// still go to theirs
+	Fetcher Fetcher
+}
+
+// OptionsFromEnv reads IMG_SEED_LINKS, IMG_ALLOWED_SITES and
+// IMG_MAX_PAGES_PER_DOMAIN.
+func OptionsFromEnv() Options {
+	return Options{
+		Seeds:             env.List("IMG_SEED_LINKS"),
+		AllowedSites:      env.List("IMG_ALLOWED_SITES"),
+		MaxPagesPerDomain: env.Int("IMG_MAX_PAGES_PER_DOMAIN", 0),
+	}
+}
+
+// Configure applies the process-wide settings from the environment: the
+// HTTP transport, SSRF guard, proxies, headers, site logins, lazy-load
+// attributes, redirects and webhooks. Call it once before crawling.
+func Configure() error {
+	configureTransport()
+	for _, configure := range []func() error{
+		configureDialGuard, configureProxies, configureHeaders, configureAuth, extract.ConfigureLazyLoad,
+	} {
+		if err := configure(); err != nil {
+			return err
+		}
+	}
+	configureRedirects()
+	configureClient()
+	configureWebhooks()
+	logTransport()
+	return nil
+}
+
+// Run crawls with the options in the environment (image_crawler crawl).
+func Run(ctx context.Context, st store.Store) error {
+	return Crawl(ctx, st, OptionsFromEnv())
+}
+
+// Crawl crawls from opts.Seeds into st until the budget is spent or ctx is
+// done. A crawl that fails, even before it starts, is reported to the
+// webhooks.
+func Crawl(ctx context.Context, st store.Store, opts Options) (err error) {
+	var c *imageCrawler
+	defer func() {
+		if err != nil {
+			notifyCrawl(ctx, CrawlFailed, c, err)
+		}
+	}()
+
+	// workers joining a crawl through a broker take their tasks from it
+	if len(opts.Seeds) == 0 && env.Get("IMG_AMQP_URL", "") == "" {
+		return fmt.Errorf("no seed links (IMG_SEED_LINKS)")
+	}
+	if opts.MaxPages <= 0 {
+		opts.MaxPages = MaxImagePages
+	}
+
+	frontier, err := newFrontier(ctx, opts.MaxPages, opts.MaxPagesPerDomain)
+	if err != nil {
+		return err
+	}
+	defer frontier.Close()
+
+	c, err = newImageCrawler(st, frontier, opts.AllowedSites)
+	if err != nil {
+		return err
+	}
+	if opts.Workers > 0 {
+		c.workers = opts.Workers
+	}
+	if opts.Fetcher != nil {
+		c.fetchers.def = opts.Fetcher
+	}
+	if af, ok := frontier.(*amqpFrontier); ok {
+		if c.stages, err = newAMQPStages(af, env.List("IMG_AMQP_STAGES")); err != nil {
+			return err
+		}
+		defer c.stages.Close()
+		if c.enricher != nil {
+			c.enricher.remote = c.stages.publishImage
+		}
+	}
+
+	seeds := seedTasks(opts.Seeds)
+	if err := frontier.Push(ctx, seeds); err != nil {
+		return err
+	}
+	c.beginRun(ctx, "crawl", taskLinks(seeds))
+	err = c.run(ctx)
+	c.endRun(ctx, err)
+	return err
+}
+
+func taskLinks(tasks []Task) []string {
+	links := make([]string, len(tasks))
+	for i, t := range tasks {
+		links[i] = t.Link
+	}
+	return links
+}
+
+// seedTasks turns seed links into level 0 tasks. Sitemap seeds are read
+// by the worker that claims them, which queues their pages (see
+// crawlSitemap).
+func seedTasks(seeds []string) []Task {
+	var start []Task
+	for _, s := range seeds {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		start = append(start, Task{Link: s, Level: 0})
+	}
+	return start
+}
+
+// run crawls until the frontier runs dry or ctx is cancelled. It sends
+// the start and completion webhooks; callers report failures.
+func (c *imageCrawler) run(ctx context.Context) error {
+	slog.Info("Starting crawl", "workers", c.workers)
+	notifyCrawl(ctx, CrawlStarted, c, nil)
+
+	work, cancelWork := workContext(ctx, env.Duration("IMG_SHUTDOWN_GRACE", ShutdownGrace))
+	defer cancelWork()
+
+	c.enricher.Start(work)
+	c.writer.Start(work)
+
+	// with split stages each waits for the ones before it in this process
+	fetched, extracted := make(chan struct{}), make(chan struct{})
+	extractDone := c.stages.consume(ctx, work, StageExtract, c.workers, fetched, c.extractPage)
+	var enrichDone <-chan struct{}
+	if c.enricher != nil {
+		enrichDone = c.stages.consume(ctx, work, StageEnrich, c.enricher.workers, extracted, c.enrichImage)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; c.stages.runs(StageFetch) && i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.worker(ctx, work)
+		}()
+	}
+	wg.Wait()
+	close(fetched)
+	<-extractDone
+	c.writer.Close()
+	close(extracted)
+	if enrichDone != nil {
+		<-enrichDone
+	}
+	c.enricher.Close()
+	c.stats.finish()
+	if err := c.warc.Close(); err != nil {
+		slog.Error("Closing WARC file failed", "error", err)
+	}
+
+	// stopped early: save what is left so the next run picks it up
+	if ctx.Err() != nil {
+		if err := c.frontier.Checkpoint(context.WithoutCancel(ctx), c.interrupted); err != nil {
+			return fmt.Errorf("checkpoint: %w", err)
+		}
+	}
+
+	notifyCrawl(ctx, CrawlCompleted, c, nil)
+	return nil
+}
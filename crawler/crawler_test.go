@@ -0,0 +1,319 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"image_crawler/store"
+)
+
+func TestHTTPFetcherConditional(t *testing.T) {
+	const etag, modified = `"v1"`, "Mon, 02 Jan 2006 15:04:05 GMT"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modified)
+		if r.Header.Get("If-None-Match") == etag || r.Header.Get("If-Modified-Since") == modified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><a href="/b">b</a></body></html>`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name        string
+		prev        *store.PageRecord
+		notModified bool
+	}{
+		{name: "first fetch", prev: nil},
+		{name: "no validators", prev: &store.PageRecord{}},
+		{name: "matching etag", prev: &store.PageRecord{ETag: etag}, notModified: true},
+		{name: "matching last-modified", prev: &store.PageRecord{LastModified: modified}, notModified: true},
+		{name: "stale etag", prev: &store.PageRecord{ETag: `"v0"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := httpFetcher{}.Fetch(context.Background(), srv.URL, tt.prev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.NotModified != tt.notModified {
+				t.Errorf("NotModified = %v, want %v", res.NotModified, tt.notModified)
+			}
+			if got := res.Doc != nil; got == tt.notModified {
+				t.Errorf("got document = %v, want %v", got, !tt.notModified)
+			}
+			if res.ETag != etag || res.LastModified != modified {
+				t.Errorf("validators = %q, %q", res.ETag, res.LastModified)
+			}
+		})
+	}
+}
+
+func TestHTTPFetcherRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html></html>`))
+	})
+	mux.HandleFunc("/one", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/two", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/two", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		path      string
+		wantFinal string
+		wantChain []string
+		wantErr   bool
+	}{
+		{name: "no redirect", path: "/page", wantFinal: "/page"},
+		{name: "chain", path: "/one", wantFinal: "/page", wantChain: []string{"/one", "/two", "/page"}},
+		{name: "loop is cut off", path: "/loop", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := httpFetcher{}.Fetch(context.Background(), srv.URL+tt.path, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %s, want an error", res.FinalURL)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.FinalURL != srv.URL+tt.wantFinal {
+				t.Errorf("final URL = %s, want %s", res.FinalURL, srv.URL+tt.wantFinal)
+			}
+			var want []string
+			for _, p := range tt.wantChain {
+				want = append(want, srv.URL+p)
+			}
+			if !slices.Equal(res.Redirects, want) {
+				t.Errorf("redirects = %v, want %v", res.Redirects, want)
+			}
+		})
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	req := func(link string) *http.Request {
+		r, err := http.NewRequest(http.MethodGet, link, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		to      string
+		via     []string
+		wantErr bool
+	}{
+		{name: "same scheme", to: "https://a.test/2", via: []string{"https://a.test/1"}},
+		{name: "upgrade", to: "https://a.test/2", via: []string{"http://a.test/1"}},
+		{name: "downgrade", to: "http://a.test/2", via: []string{"https://a.test/1"}, wantErr: true},
+		{
+			name:    "too many",
+			to:      "http://a.test/7",
+			via:     []string{"http://a.test/1", "http://a.test/2", "http://a.test/3", "http://a.test/4", "http://a.test/5", "http://a.test/6"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var via []*http.Request
+			for _, v := range tt.via {
+				via = append(via, req(v))
+			}
+			if err := checkRedirect(req(tt.to), via); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// testPage is a page fetched from link, as a fetcher returns it.
+func testPage(t *testing.T, link, html string) *FetchResult {
+	t.Helper()
+	res, err := blockResult(link, []byte("HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/html\r\n"+
+		"ETag: \"v1\"\r\n"+
+		"\r\n"+html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// redirected is res as if it had been reached through from.
+func redirected(res *FetchResult, from ...string) *FetchResult {
+	res.Redirects = from
+	return res
+}
+
+func TestCrawlPageNotModifiedAndRedirects(t *testing.T) {
+	const (
+		link  = "http://example.test/a"
+		final = "http://example.test/final"
+		html  = `<html><head><title>Cats</title></head><body>` +
+			`<img src="/cat.jpg" width="640" height="480" alt="a cat">` +
+			`<a href="/b">b</a></body></html>`
+	)
+	stored := &store.PageRecord{
+		PageURL:    link,
+		FinalURL:   link,
+		ETag:       `"v1"`,
+		ImageCount: 1,
+		ImageURLs:  []string{"http://example.test/old.jpg"},
+		OutLinks:   []string{"http://example.test/old"},
+	}
+
+	tests := []struct {
+		name        string
+		stored      *store.PageRecord
+		visited     []string // already crawled
+		result      func(t *testing.T) *FetchResult
+		wantFetched bool
+		wantLinks   []string
+		wantFinal   string // of the saved page; "" when none is saved
+		wantImages  int
+	}{
+		{
+			name:   "not modified without a stored page fails",
+			result: func(t *testing.T) *FetchResult { return &FetchResult{FinalURL: link, NotModified: true} },
+		},
+		{
+			name:        "not modified reuses the stored page",
+			stored:      stored,
+			result:      func(t *testing.T) *FetchResult { return &FetchResult{FinalURL: link, NotModified: true} },
+			wantFetched: true,
+			wantLinks:   []string{"http://example.test/old"},
+			wantFinal:   link,
+		},
+		{
+			name:   "not modified after a redirect",
+			stored: stored,
+			result: func(t *testing.T) *FetchResult {
+				return redirected(&FetchResult{FinalURL: final, NotModified: true}, link)
+			},
+			wantFetched: true,
+			wantLinks:   []string{"http://example.test/old"},
+			wantFinal:   final,
+		},
+		{
+			name:        "fetched page",
+			result:      func(t *testing.T) *FetchResult { return testPage(t, link, html) },
+			wantFetched: true,
+			wantLinks:   []string{"http://example.test/b"},
+			wantFinal:   link,
+			wantImages:  1,
+		},
+		{
+			name:        "redirect is stored under its final location",
+			result:      func(t *testing.T) *FetchResult { return redirected(testPage(t, final, html), link) },
+			wantFetched: true,
+			wantLinks:   []string{"http://example.test/b"},
+			wantFinal:   final,
+			wantImages:  1,
+		},
+		{
+			name:   "redirect to a disallowed host is dropped",
+			result: func(t *testing.T) *FetchResult { return redirected(testPage(t, "http://other.test/", html), link) },
+		},
+		{
+			name:    "redirect to a crawled page is dropped",
+			visited: []string{final},
+			result:  func(t *testing.T) *FetchResult { return redirected(testPage(t, final, html), link) },
+		},
+		{
+			name:   "page without a document fails",
+			result: func(t *testing.T) *FetchResult { return &FetchResult{FinalURL: link} },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("IMG_DB_DRIVER", store.StoreMemory)
+			t.Setenv("IMG_ROBOTS_IGNORE", "example.test")
+			t.Setenv("IMG_DELAY", "1ms")
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			st, err := store.Open(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.stored != nil {
+				if err := st.SavePage(ctx, *tt.stored); err != nil {
+					t.Fatal(err)
+				}
+			}
+			frontier := newMemoryFrontier(10, 0)
+			for _, v := range tt.visited {
+				if _, err := frontier.Visit(ctx, v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c, err := newImageCrawler(st, frontier, []string{"example.test"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.fetchers.def = FetcherFunc(func(ctx context.Context, link string, prev *store.PageRecord) (*FetchResult, error) {
+				return tt.result(t), nil
+			})
+
+			links, fetched := c.crawlPage(ctx, Task{Link: link})
+			c.writer.Close()
+
+			if fetched != tt.wantFetched {
+				t.Errorf("fetched = %v, want %v", fetched, tt.wantFetched)
+			}
+			if got := taskLinks(links); !slices.Equal(got, tt.wantLinks) {
+				t.Errorf("links = %v, want %v", got, tt.wantLinks)
+			}
+
+			page, err := st.LoadPage(ctx, link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.wantFinal == "" && tt.stored == nil && page != nil:
+				t.Errorf("saved a page, want none")
+			case tt.wantFinal != "" && page == nil:
+				t.Errorf("saved no page")
+			case tt.wantFinal != "" && page.FinalURL != tt.wantFinal:
+				t.Errorf("page stored at %s, want %s", page.FinalURL, tt.wantFinal)
+			case tt.wantFinal != "" && page.TimeFetched.IsZero():
+				t.Errorf("page has no fetch time")
+			}
+
+			images, err := st.Search(ctx, store.Query{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(images) != tt.wantImages {
+				t.Errorf("stored %d images, want %d", len(images), tt.wantImages)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"image_crawler/extract"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   CSS IMAGE CONFIG
+	==============================
+*/
+
+const (
+	MaxStylesheetSize     = 1024 * 1024
+	MaxStylesheetsPerPage = 5
+	MaxCachedStylesheets  = 500
+)
+
+/*
+	==============================
+	   LINKED STYLESHEETS
+	==============================
+*/
+
+// styleCache remembers the background images of each stylesheet, since a
+// site's pages usually share the same few files. Enabled by
+// IMG_FETCH_STYLESHEETS=true.
+type styleCache struct {
+	mu      sync.Mutex
+	entries map[string][]string
+}
+
+func newStyleCache() *styleCache {
+	return &styleCache{entries: map[string][]string{}}
+}
+
+// stylesheetImages fetches up to MaxStylesheetsPerPage linked stylesheets
+// and returns the background images they declare. Stylesheet requests go
+// through robots.txt and the host limiter like pages do.
+func (c *imageCrawler) stylesheetImages(ctx context.Context, page string, doc *goquery.Document) []store.ImageRecord {
+	base, _ := url.Parse(page)
+	domain := base.Hostname()
+
+	var sheets []*url.URL
+	doc.Find("link[href]").Each(func(_ int, el *goquery.Selection) {
+		rel, _ := el.Attr("rel")
+		if !strings.Contains(strings.ToLower(rel), "stylesheet") || len(sheets) >= MaxStylesheetsPerPage {
+			return
+		}
+		href, _ := el.Attr("href")
+		if u, err := extract.ResolveURL(base, href); err == nil {
+			sheets = append(sheets, u)
+		}
+	})
+
+	var out []store.ImageRecord
+	for _, u := range sheets {
+		links, err := c.stylesheetURLs(ctx, u)
+		if err != nil {
+			slog.Error("Fetching stylesheet failed", "url", u, "error", err)
+			continue
+		}
+		for _, link := range links {
+			out = append(out, extract.BackgroundRecord(page, domain, link, ""))
+		}
+	}
+	return out
+}
+
+func (c *imageCrawler) stylesheetURLs(ctx context.Context, u *url.URL) ([]string, error) {
+	key := u.String()
+
+	c.styles.mu.Lock()
+	links, ok := c.styles.entries[key]
+	c.styles.mu.Unlock()
+	if ok {
+		return links, nil
+	}
+
+	if !c.robots.Allowed(u) {
+		return nil, nil
+	}
+	if err := c.limiter.Wait(ctx, u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := crawlClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxStylesheetSize))
+	if err != nil {
+		return nil, err
+	}
+
+	// url() in a stylesheet is relative to the stylesheet, not the page
+	for _, link := range extract.CSSBackgroundURLs(resp.Request.URL, string(data)) {
+		if extract.IsImageURL(link) {
+			links = append(links, link)
+		}
+	}
+
+	c.styles.mu.Lock()
+	if len(c.styles.entries) < MaxCachedStylesheets {
+		c.styles.entries[key] = links
+	}
+	c.styles.mu.Unlock()
+
+	return links, nil
+}
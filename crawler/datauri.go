@@ -0,0 +1,119 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   DATA URI IMAGES
+	==============================
+*/
+
+const (
+	DataURIMinBytes = 2048
+	MaxDataURIBytes = 2 * 1024 * 1024
+)
+
+// dataURIFormats maps the media types worth keeping to our format names.
+var dataURIFormats = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+	"image/bmp":  "bmp",
+	"image/avif": "avif",
+}
+
+// decodeDataURI returns the media type and bytes of a base64 data URI.
+func decodeDataURI(raw string) (string, []byte, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(raw), "data:")
+	if !ok {
+		return "", nil, false
+	}
+	meta, payload, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", nil, false
+	}
+	params := strings.Split(meta, ";")
+	if params[len(params)-1] != "base64" {
+		return "", nil, false
+	}
+	// rough pre-check so a huge inline blob is not decoded just to be dropped
+	if base64.StdEncoding.DecodedLen(len(payload)) > MaxDataURIBytes {
+		return "", nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(payload), ""))
+	if err != nil {
+		return "", nil, false
+	}
+	return strings.ToLower(strings.TrimSpace(params[0])), data, true
+}
+
+// dataURIImages captures inline data:image URIs of at least minBytes
+// (IMG_DATA_URI_MIN_BYTES) when IMG_CAPTURE_DATA_URIS=true. The bytes go
+// to the blob store under their SHA-256, and the record points at the
+// stored copy instead of the URI.
+func (c *imageCrawler) dataURIImages(ctx context.Context, page string, doc *goquery.Document) []store.ImageRecord {
+	base, _ := url.Parse(page)
+	domain := base.Hostname()
+
+	var out []store.ImageRecord
+	doc.Find("img").Each(func(_ int, tag *goquery.Selection) {
+		for _, a := range []string{"src", "data-src"} {
+			raw, _ := tag.Attr(a)
+			if !strings.HasPrefix(strings.TrimSpace(raw), "data:") {
+				continue
+			}
+			mime, data, ok := decodeDataURI(raw)
+			format := dataURIFormats[mime]
+			if !ok || format == "" || len(data) < c.dataURIMin {
+				continue
+			}
+
+			hash := store.ContentHash(data)
+			link, err := c.blobs.Put(ctx, "data/"+hash+"."+format, data, mime)
+			if err != nil {
+				slog.Error("Storing data URI image failed", "url", page, "error", err)
+				continue
+			}
+
+			alt, _ := tag.Attr("alt")
+			rec := store.ImageRecord{
+				FileURL:     link,
+				AltText:     alt,
+				PageURL:     page,
+				DomainName:  domain,
+				Format:      format,
+				ContentHash: hash,
+				FileSize:    int64(len(data)),
+				Source:      "data_uri",
+				LastSeen:    time.Now().UTC(),
+			}
+			if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+				rec.Width = strconv.Itoa(cfg.Width)
+				rec.Height = strconv.Itoa(cfg.Height)
+			}
+			out = append(out, rec)
+			return
+		}
+	})
+	return out
+}
@@ -0,0 +1,83 @@
+package crawler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   RUNTIME DIAGNOSTICS
+	==============================
+*/
+
+const RuntimeStatsInterval = 5 * time.Minute
+
+// registerPprof serves net/http/pprof under /debug/pprof/ on the admin
+// port, behind its token. Opt in with IMG_PPROF=true: profiles show URLs
+// and configuration in flight.
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// LogRuntimeStats logs memory, GC and goroutine figures every
+// IMG_RUNTIME_STATS_INTERVAL (0 turns it off) until ctx is done, along
+// with the sizes of the current crawl's in-memory sets, the usual
+// suspects when a long crawl keeps growing.
+func LogRuntimeStats(ctx context.Context) {
+	interval := env.Duration("IMG_RUNTIME_STATS_INTERVAL", RuntimeStatsInterval)
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				slog.Info("Runtime stats", runtimeStats(ctx)...)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// runtimeStats is the attributes of one Runtime stats line.
+func runtimeStats(ctx context.Context) []any {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	args := []any{
+		"heap_alloc_mb", m.HeapAlloc >> 20,
+		"heap_inuse_mb", m.HeapInuse >> 20,
+		"sys_mb", m.Sys >> 20,
+		"heap_objects", m.HeapObjects,
+		"gc_runs", m.NumGC,
+		"gc_pause_total", time.Duration(m.PauseTotalNs).Round(time.Millisecond),
+		"goroutines", runtime.NumGoroutine(),
+	}
+
+	c := admin.current()
+	if c == nil {
+		return args
+	}
+	if c.frontier != nil {
+		if fs, err := c.frontier.Stats(ctx); err == nil {
+			args = append(args, "frontier_queued", fs.Queued, "frontier_seen", fs.Seen)
+		}
+	}
+	if queued, tracked := c.enricher.backlog(); tracked > 0 {
+		args = append(args, "enrich_queued", queued, "enrich_tracked", tracked)
+	}
+	return args
+}
@@ -0,0 +1,180 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+	==============================
+	   PIXEL DIMENSIONS
+	==============================
+*/
+
+// ProbeChunk is how much more of the file probeDimensions reads before
+// each new attempt; most headers fit in the first chunk.
+const ProbeChunk = 4 * 1024
+
+// imageDimensions reads the pixel size from the start of an image file.
+// image.DecodeConfig covers JPEG, PNG, GIF, BMP and WebP (VP8, VP8L and
+// VP8X headers); AVIF has no Go decoder, so its ispe property is read
+// directly.
+func imageDimensions(data []byte) (int, int, bool) {
+	if w, h, ok := avifDimensions(data); ok {
+		return w, h, true
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		return 0, 0, false
+	}
+	return cfg.Width, cfg.Height, true
+}
+
+// avifDimensions walks the ISO-BMFF boxes ftyp > meta > iprp > ipco to the
+// ispe (image spatial extents) properties. Alpha planes and thumbnails
+// carry their own ispe, so the largest one is taken as the image.
+func avifDimensions(data []byte) (int, int, bool) {
+	ftyp, rest, ok := nextBox(data)
+	if !ok || ftyp.kind != "ftyp" || len(ftyp.body) < 4 {
+		return 0, 0, false
+	}
+	brand := string(ftyp.body[:4])
+	if brand != "avif" && brand != "avis" {
+		return 0, 0, false
+	}
+
+	meta, ok := findBox(rest, "meta")
+	if !ok || len(meta) < 4 {
+		return 0, 0, false
+	}
+	iprp, ok := findBox(meta[4:], "iprp") // meta is a full box: skip version/flags
+	if !ok {
+		return 0, 0, false
+	}
+	ipco, ok := findBox(iprp, "ipco")
+	if !ok {
+		return 0, 0, false
+	}
+
+	var w, h uint32
+	for b, rest, ok := nextBox(ipco); ok; b, rest, ok = nextBox(rest) {
+		if b.kind != "ispe" || len(b.body) < 12 {
+			continue
+		}
+		bw := binary.BigEndian.Uint32(b.body[4:])
+		bh := binary.BigEndian.Uint32(b.body[8:])
+		if uint64(bw)*uint64(bh) > uint64(w)*uint64(h) {
+			w, h = bw, bh
+		}
+	}
+	if w == 0 || h == 0 {
+		return 0, 0, false
+	}
+	return int(w), int(h), true
+}
+
+type isoBox struct {
+	kind string
+	body []byte
+}
+
+// nextBox splits the first box off data. A box running past the end of
+// data (a truncated download) is not returned.
+func nextBox(data []byte) (isoBox, []byte, bool) {
+	if len(data) < 8 {
+		return isoBox{}, nil, false
+	}
+	size := uint64(binary.BigEndian.Uint32(data))
+	kind := string(data[4:8])
+	header := uint64(8)
+	switch size {
+	case 0: // extends to the end of the file
+		size = uint64(len(data))
+	case 1: // 64-bit size follows the type
+		if len(data) < 16 {
+			return isoBox{}, nil, false
+		}
+		size = binary.BigEndian.Uint64(data[8:])
+		header = 16
+	}
+	if size < header || size > uint64(len(data)) {
+		return isoBox{}, nil, false
+	}
+	return isoBox{kind: kind, body: data[header:size]}, data[size:], true
+}
+
+func findBox(data []byte, kind string) ([]byte, bool) {
+	for b, rest, ok := nextBox(data); ok; b, rest, ok = nextBox(rest) {
+		if b.kind == kind {
+			return b.body, true
+		}
+	}
+	return nil, false
+}
+
+// probeDimensions reads just enough of an image to learn its pixel size,
+// asking for at most limit bytes with a Range request and giving up on the
+// connection as soon as the header has been parsed. The full file size is
+// returned too when the response tells it (0 otherwise).
+func probeDimensions(ctx context.Context, link string, limit int64) (int, int, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	req.Header.Set("Accept", "image/*")
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", limit-1))
+
+	resp, err := crawlClient.Do(req)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return 0, 0, 0, &httpStatusError{Status: resp.StatusCode}
+	}
+	size := fullSize(resp)
+
+	// servers that ignore Range send the whole file; stop reading at limit
+	body := io.LimitReader(resp.Body, limit)
+	var buf []byte
+	chunk := make([]byte, ProbeChunk)
+	for {
+		n, err := io.ReadFull(body, chunk)
+		buf = append(buf, chunk[:n]...)
+		if w, h, ok := imageDimensions(buf); ok {
+			return w, h, size, nil
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, 0, 0, fmt.Errorf("no dimensions in the first %d bytes", len(buf))
+		}
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+}
+
+// fullSize is the size of the whole file behind a response: the total in
+// a 206's "Content-Range: bytes 0-65535/123456", or Content-Length when
+// the server ignored the Range header.
+func fullSize(resp *http.Response) int64 {
+	if resp.StatusCode != http.StatusPartialContent {
+		return max(resp.ContentLength, 0)
+	}
+	_, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseInt(total, 10, 64) // "*" when the server doesn't know
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
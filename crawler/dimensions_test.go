@@ -0,0 +1,106 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// box builds an ISO-BMFF box of kind around the concatenated bodies.
+func box(kind string, bodies ...[]byte) []byte {
+	body := bytes.Join(bodies, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(out, kind...), body...)
+}
+
+// box64 is box with the size in the 64-bit field after the kind.
+func box64(kind string, bodies ...[]byte) []byte {
+	body := bytes.Join(bodies, nil)
+	out := append(binary.BigEndian.AppendUint32(nil, 1), kind...)
+	return append(binary.BigEndian.AppendUint64(out, uint64(16+len(body))), body...)
+}
+
+// ispe is an image spatial extents property: version/flags, then width
+// and height.
+func ispe(w, h uint32) []byte {
+	body := binary.BigEndian.AppendUint32(make([]byte, 4), w)
+	return box("ispe", binary.BigEndian.AppendUint32(body, h))
+}
+
+// avif wraps properties in ftyp > meta > iprp > ipco under brand.
+func avif(brand string, props ...[]byte) []byte {
+	ftyp := box("ftyp", []byte(brand), make([]byte, 4), []byte("mif1"))
+	meta := box("meta", make([]byte, 4), box("hdlr", make([]byte, 24)), box("iprp", box("ipco", props...)))
+	return append(ftyp, meta...)
+}
+
+func TestAVIFDimensions(t *testing.T) {
+	full := avif("avif", ispe(1920, 1080))
+
+	tests := []struct {
+		name string
+		data []byte
+		w, h int
+		ok   bool
+	}{
+		{name: "single ispe", data: full, w: 1920, h: 1080, ok: true},
+		{name: "image sequence brand", data: avif("avis", ispe(640, 480)), w: 640, h: 480, ok: true},
+		{
+			name: "largest ispe wins over thumbnails",
+			data: avif("avif", ispe(160, 90), box("pixi", make([]byte, 8)), ispe(4000, 3000), ispe(4000, 3000)),
+			w:    4000, h: 3000, ok: true,
+		},
+		{
+			name: "64-bit box size",
+			data: append(box("ftyp", []byte("avif"), make([]byte, 4)),
+				box64("meta", make([]byte, 4), box("iprp", box("ipco", ispe(8, 6))))...),
+			w: 8, h: 6, ok: true,
+		},
+		{name: "other brand", data: avif("heic", ispe(100, 100))},
+		{name: "no ispe", data: avif("avif", box("pixi", make([]byte, 8)))},
+		{name: "short ispe", data: avif("avif", box("ispe", make([]byte, 8)))},
+		{name: "zero extents", data: avif("avif", ispe(0, 100))},
+		{name: "truncated", data: full[:len(full)-4]},
+		{name: "empty", data: nil},
+		{name: "not a box", data: []byte("GIF89a....")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h, ok := avifDimensions(tt.data)
+			if w != tt.w || h != tt.h || ok != tt.ok {
+				t.Errorf("avifDimensions = %d, %d, %v, want %d, %d, %v", w, h, ok, tt.w, tt.h, tt.ok)
+			}
+		})
+	}
+}
+
+func TestImageDimensions(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		w, h int
+		ok   bool
+	}{
+		{name: "png", data: buf.Bytes(), w: 30, h: 20, ok: true},
+		{name: "png header only", data: buf.Bytes()[:33], w: 30, h: 20, ok: true},
+		{name: "avif", data: avif("avif", ispe(300, 200)), w: 300, h: 200, ok: true},
+		{name: "html", data: []byte("<html></html>")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h, ok := imageDimensions(tt.data)
+			if w != tt.w || h != tt.h || ok != tt.ok {
+				t.Errorf("imageDimensions = %d, %d, %v, want %d, %d, %v", w, h, ok, tt.w, tt.h, tt.ok)
+			}
+		})
+	}
+}
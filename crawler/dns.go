@@ -0,0 +1,283 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+	==============================
+	   DNS CACHE CONFIG
+	==============================
+*/
+
+const (
+	DNSMaxTTL         = 5 * time.Minute
+	DNSNegativeTTL    = 30 * time.Second
+	DNSLookupTimeout  = 10 * time.Second
+	DNSFallbackTTL    = time.Minute
+	DNSMinTTL         = 5 * time.Second
+	DNSMaxPendingTTLs = 1024
+	// expired entries are swept once the cache holds this many hosts
+	DNSSweepEntries = 10000
+)
+
+// crawlDNS caches lookups for the crawl transport; nil disables caching.
+var crawlDNS *dnsCache
+
+/*
+	==============================
+	   CACHING RESOLVER
+	==============================
+*/
+
+type dnsEntry struct {
+	ready   chan struct{}
+	addrs   []netip.Addr
+	err     error
+	expires time.Time
+}
+
+// dnsCache resolves hostnames once and reuses the answer for the record's
+// TTL (capped at maxTTL). Hosts that do not exist are cached for negTTL;
+// temporary failures are not cached. Concurrent lookups of one host share
+// a single query.
+//
+// Go's resolver does not report TTLs, so the cache reads them off the
+// wire: the resolver dials through ttlConn, which peeks at each UDP answer
+// before handing it on.
+type dnsCache struct {
+	resolver *net.Resolver
+	maxTTL   time.Duration
+	negTTL   time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+	ttls    map[string]time.Duration
+	sweepAt int
+}
+
+func newDNSCache(maxTTL, negTTL time.Duration) *dnsCache {
+	c := &dnsCache{
+		maxTTL:  maxTTL,
+		negTTL:  negTTL,
+		entries: map[string]*dnsEntry{},
+		ttls:    map[string]time.Duration{},
+		sweepAt: DNSSweepEntries,
+	}
+
+	var d net.Dialer
+	c.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := d.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			// only datagrams carry one whole message per read
+			if udp, ok := conn.(*net.UDPConn); ok {
+				return &ttlConn{UDPConn: udp, cache: c}, nil
+			}
+			return conn, nil
+		},
+	}
+	return c
+}
+
+// Lookup returns the addresses of host, from the cache when still fresh.
+func (c *dnsCache) Lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	c.mu.Lock()
+	e := c.entries[host]
+	if e != nil {
+		select {
+		case <-e.ready:
+			if time.Now().After(e.expires) {
+				e = nil
+			}
+		default:
+		}
+	}
+	if e == nil {
+		if len(c.entries) >= c.sweepAt {
+			c.sweep()
+		}
+		e = &dnsEntry{ready: make(chan struct{})}
+		c.entries[host] = e
+		c.mu.Unlock()
+		c.resolve(host, e)
+	} else {
+		c.mu.Unlock()
+	}
+
+	select {
+	case <-e.ready:
+		return e.addrs, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// sweep drops expired entries; lookups in flight stay. When most entries
+// are still fresh, the next sweep waits until the cache has doubled, so a
+// crawl of many live hosts does not sweep on every insert. c.mu is held.
+func (c *dnsCache) sweep() {
+	now := time.Now()
+	for host, e := range c.entries {
+		select {
+		case <-e.ready:
+			if now.After(e.expires) {
+				delete(c.entries, host)
+			}
+		default:
+		}
+	}
+	c.sweepAt = max(DNSSweepEntries, 2*len(c.entries))
+}
+
+// resolve fills e. It runs detached from the caller's context so one
+// cancelled page does not poison the entry for everyone waiting on it.
+func (c *dnsCache) resolve(host string, e *dnsEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), DNSLookupTimeout)
+	defer cancel()
+
+	e.addrs, e.err = c.resolver.LookupNetIP(ctx, "ip", host)
+
+	c.mu.Lock()
+	ttl, seen := c.ttls[host]
+	delete(c.ttls, host)
+	c.mu.Unlock()
+
+	var dnsErr *net.DNSError
+	switch {
+	case e.err == nil && seen:
+		e.expires = time.Now().Add(min(max(ttl, DNSMinTTL), c.maxTTL))
+	case e.err == nil:
+		e.expires = time.Now().Add(min(DNSFallbackTTL, c.maxTTL))
+	case errors.As(e.err, &dnsErr) && dnsErr.IsNotFound:
+		e.expires = time.Now().Add(c.negTTL)
+	default:
+		e.expires = time.Now()
+	}
+	close(e.ready)
+}
+
+// noteTTL records the lowest TTL seen in the answers for host. A and AAAA
+// come back separately, so the shorter of the two wins.
+func (c *dnsCache) noteTTL(host string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if prev, ok := c.ttls[host]; ok && prev <= ttl {
+		return
+	}
+	// answers for hosts never looked up through Lookup would pile up
+	if len(c.ttls) >= DNSMaxPendingTTLs {
+		return
+	}
+	c.ttls[host] = ttl
+}
+
+// DialContext resolves through the cache and dials the addresses in turn.
+func (c *dnsCache) DialContext(dial func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := netip.ParseAddr(host); err == nil {
+			return dial(ctx, network, address)
+		}
+
+		addrs, err := c.Lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		var firstErr error
+		for _, a := range addrs {
+			conn, err := dial(ctx, network, net.JoinHostPort(a.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		if firstErr == nil {
+			firstErr = &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+		}
+		return nil, firstErr
+	}
+}
+
+/*
+	==============================
+	   TTL CAPTURE
+	==============================
+*/
+
+// ttlConn is the resolver's UDP socket. Each read is one DNS response,
+// which is parsed only to note its TTL; the bytes pass through unchanged.
+type ttlConn struct {
+	*net.UDPConn
+	cache *dnsCache
+}
+
+func (t *ttlConn) Read(b []byte) (int, error) {
+	n, err := t.UDPConn.Read(b)
+	if n > 0 {
+		t.inspect(b[:n])
+	}
+	return n, err
+}
+
+func (t *ttlConn) inspect(msg []byte) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return
+	}
+	q, err := p.Question()
+	if err != nil {
+		return
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+
+	var lowest uint32
+	found := false
+	for {
+		h, err := p.AnswerHeader()
+		if err != nil {
+			break
+		}
+		switch h.Type {
+		case dnsmessage.TypeA, dnsmessage.TypeAAAA, dnsmessage.TypeCNAME:
+			if !found || h.TTL < lowest {
+				lowest = h.TTL
+				found = true
+			}
+		}
+		if err := p.SkipAnswer(); err != nil {
+			break
+		}
+	}
+	if !found {
+		return
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(q.Name.String(), "."))
+	t.cache.noteTTL(host, time.Duration(lowest)*time.Second)
+}
@@ -0,0 +1,211 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeDNS answers A queries for the hosts in ttls with 192.0.2.1 and the
+// given TTL, AAAA queries with no records, and anything else with
+// NXDOMAIN.
+func fakeDNS(t *testing.T, ttls map[string]uint32) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+			name := q.Name.String()
+			ttl, known := ttls[name[:len(name)-1]]
+
+			rh := dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true, RecursionDesired: h.RecursionDesired}
+			if !known {
+				rh.RCode = dnsmessage.RCodeNameError
+			}
+			b := dnsmessage.NewBuilder(nil, rh)
+			b.EnableCompression()
+			b.StartQuestions()
+			b.Question(q)
+			b.StartAnswers()
+			if known && q.Type == dnsmessage.TypeA {
+				b.AResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: ttl},
+					dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}})
+			}
+			msg, err := b.Finish()
+			if err != nil {
+				continue
+			}
+			pc.WriteTo(msg, addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+// testDNSCache returns a cache resolving through server, reading TTLs the
+// way newDNSCache does.
+func testDNSCache(server string) *dnsCache {
+	c := newDNSCache(time.Hour, 30*time.Second)
+	var d net.Dialer
+	c.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			conn, err := d.DialContext(ctx, "udp", server)
+			if err != nil {
+				return nil, err
+			}
+			return &ttlConn{UDPConn: conn.(*net.UDPConn), cache: c}, nil
+		},
+	}
+	return c
+}
+
+func TestDNSCacheExpiry(t *testing.T) {
+	server := fakeDNS(t, map[string]uint32{
+		"short.test":  1,
+		"minute.test": 60,
+		"day.test":    86400,
+	})
+
+	tests := []struct {
+		name     string
+		host     string
+		ttl      time.Duration
+		notFound bool
+	}{
+		{name: "TTL below the floor", host: "short.test", ttl: DNSMinTTL},
+		{name: "TTL kept", host: "minute.test", ttl: time.Minute},
+		{name: "TTL capped", host: "day.test", ttl: time.Hour},
+		{name: "missing host cached negatively", host: "missing.test", ttl: 30 * time.Second, notFound: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testDNSCache(server)
+			start := time.Now()
+			addrs, err := c.Lookup(context.Background(), tt.host+".")
+			var dnsErr *net.DNSError
+			if tt.notFound {
+				if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+					t.Fatalf("Lookup(%s) error = %v, want not found", tt.host, err)
+				}
+			} else if err != nil || len(addrs) != 1 || addrs[0] != netip.MustParseAddr("192.0.2.1") {
+				t.Fatalf("Lookup(%s) = %v, %v", tt.host, addrs, err)
+			}
+
+			e := c.entries[tt.host]
+			if e == nil {
+				t.Fatalf("no entry for %s", tt.host)
+			}
+			if got := e.expires.Sub(start); got < tt.ttl || got > tt.ttl+5*time.Second {
+				t.Errorf("entry expires after %v, want %v", got, tt.ttl)
+			}
+
+			// a fresh entry is reused, an expired one resolved again
+			c.Lookup(context.Background(), tt.host)
+			if c.entries[tt.host] != e {
+				t.Error("fresh entry was replaced")
+			}
+			e.expires = time.Now().Add(-time.Second)
+			c.Lookup(context.Background(), tt.host)
+			if c.entries[tt.host] == e {
+				t.Error("expired entry was reused")
+			}
+		})
+	}
+}
+
+func TestDNSCacheSweep(t *testing.T) {
+	now := time.Now()
+	done := func(expires time.Time) *dnsEntry {
+		e := &dnsEntry{ready: make(chan struct{}), expires: expires}
+		close(e.ready)
+		return e
+	}
+
+	tests := []struct {
+		name    string
+		entries map[string]*dnsEntry
+		kept    []string
+		sweepAt int
+	}{
+		{
+			name: "expired entries go",
+			entries: map[string]*dnsEntry{
+				"old.test":   done(now.Add(-time.Minute)),
+				"fresh.test": done(now.Add(time.Minute)),
+			},
+			kept:    []string{"fresh.test"},
+			sweepAt: DNSSweepEntries,
+		},
+		{
+			name: "lookups in flight stay",
+			entries: map[string]*dnsEntry{
+				"pending.test": {ready: make(chan struct{})},
+			},
+			kept:    []string{"pending.test"},
+			sweepAt: DNSSweepEntries,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newDNSCache(time.Hour, time.Minute)
+			c.entries = tt.entries
+			c.sweep()
+			if len(c.entries) != len(tt.kept) {
+				t.Errorf("kept %d entries, want %d", len(c.entries), len(tt.kept))
+			}
+			for _, host := range tt.kept {
+				if c.entries[host] == nil {
+					t.Errorf("%s was swept", host)
+				}
+			}
+			if c.sweepAt != tt.sweepAt {
+				t.Errorf("next sweep at %d entries, want %d", c.sweepAt, tt.sweepAt)
+			}
+		})
+	}
+
+	// mostly fresh entries push the next sweep out
+	c := newDNSCache(time.Hour, time.Minute)
+	for i := 0; i < DNSSweepEntries; i++ {
+		c.entries[netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)}).String()] = done(now.Add(time.Minute))
+	}
+	c.sweep()
+	if c.sweepAt != 2*DNSSweepEntries {
+		t.Errorf("next sweep at %d entries, want %d", c.sweepAt, 2*DNSSweepEntries)
+	}
+}
+
+func TestDNSCacheNoteTTL(t *testing.T) {
+	c := newDNSCache(time.Hour, time.Minute)
+	c.noteTTL("a.test", time.Minute)
+	c.noteTTL("a.test", 10*time.Second)
+	c.noteTTL("a.test", time.Hour)
+	if got := c.ttls["a.test"]; got != 10*time.Second {
+		t.Errorf("noted TTL = %v, want the lowest, 10s", got)
+	}
+}
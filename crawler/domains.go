@@ -0,0 +1,152 @@
+package crawler
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+/*
+	==============================
+	   DOMAIN MATCHING
+	==============================
+*/
+
+// domainPolicy decides which hosts an IMG_ALLOWED_SITES entry admits.
+type domainPolicy string
+
+const (
+	// PolicyExact admits the listed host only.
+	PolicyExact domainPolicy = "exact"
+	// PolicySubdomains admits the host and anything below it.
+	PolicySubdomains domainPolicy = "subdomains"
+	// PolicySite admits every host under the entry's registrable domain
+	// (eTLD+1), so www.example.co.uk also admits img.example.co.uk.
+	PolicySite domainPolicy = "site"
+)
+
+func parseDomainPolicy(raw string) (domainPolicy, error) {
+	switch p := domainPolicy(strings.ToLower(strings.TrimSpace(raw))); p {
+	case PolicyExact, PolicySubdomains, PolicySite:
+		return p, nil
+	case "":
+		return PolicySubdomains, nil
+	}
+	return "", fmt.Errorf("unknown subdomain policy %q (want exact, subdomains or site)", raw)
+}
+
+// domainMatcher matches hosts against a domain list under a policy.
+// Entries containing "*" are wildcard patterns whatever the policy; see
+// matchHostPattern. A lone "*" matches every host.
+type domainMatcher struct {
+	policy   domainPolicy
+	hosts    map[string]bool
+	patterns []string
+}
+
+func newDomainMatcher(entries []string, policy domainPolicy) *domainMatcher {
+	m := &domainMatcher{policy: policy, hosts: map[string]bool{}}
+	for _, e := range entries {
+		e = strings.Trim(strings.ToLower(strings.TrimSpace(e)), ".")
+		switch {
+		case e == "":
+		case strings.Contains(e, "*"):
+			m.patterns = append(m.patterns, e)
+		case policy == PolicySite:
+			m.hosts[registrableDomain(e)] = true
+		default:
+			m.hosts[e] = true
+		}
+	}
+	return m
+}
+
+// registrableDomain returns the eTLD+1 of host, or host itself when it
+// has none (IP addresses, bare public suffixes, localhost).
+func registrableDomain(host string) string {
+	d, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return d
+}
+
+// Match reports whether the host of u is covered. An empty or nil
+// matcher matches nothing.
+func (m *domainMatcher) Match(u *url.URL) bool {
+	if m == nil {
+		return false
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return false
+	}
+
+	for _, p := range m.patterns {
+		if matchHostPattern(p, host) {
+			return true
+		}
+	}
+
+	switch m.policy {
+	case PolicyExact:
+		return m.hosts[host]
+	case PolicySite:
+		return m.hosts[registrableDomain(host)]
+	}
+
+	// walk up the labels: a.b.example.com, b.example.com, example.com, com
+	for h := host; h != ""; {
+		if m.hosts[h] {
+			return true
+		}
+		i := strings.IndexByte(h, '.')
+		if i < 0 {
+			break
+		}
+		h = h[i+1:]
+	}
+	return false
+}
+
+// matchHostPattern matches host against a wildcard entry. A leading "*."
+// stands for one or more labels, so "*.wikipedia.org" covers
+// en.wikipedia.org and en.m.wikipedia.org but not wikipedia.org itself.
+// Anywhere else "*" stays inside one label: "images.*.example.net" covers
+// images.eu.example.net only, and "img-*.example.com" covers
+// img-3.example.com.
+func matchHostPattern(pattern, host string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if rest, ok := strings.CutPrefix(pattern, "*."); ok {
+		for h := host; ; {
+			i := strings.IndexByte(h, '.')
+			if i < 0 {
+				return false
+			}
+			h = h[i+1:]
+			if matchLabels(rest, h) {
+				return true
+			}
+		}
+	}
+	return matchLabels(pattern, host)
+}
+
+func matchLabels(pattern, host string) bool {
+	pl := strings.Split(pattern, ".")
+	hl := strings.Split(host, ".")
+	if len(pl) != len(hl) {
+		return false
+	}
+	for i := range pl {
+		if ok, _ := path.Match(pl[i], hl[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
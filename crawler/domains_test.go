@@ -0,0 +1,105 @@
+package crawler
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestDomainMatcherPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		policy  domainPolicy
+		host    string
+		want    bool
+	}{
+		{name: "exact host", entries: []string{"example.com"}, policy: PolicyExact, host: "example.com", want: true},
+		{name: "exact skips subdomains", entries: []string{"example.com"}, policy: PolicyExact, host: "www.example.com", want: false},
+		{name: "subdomains admit the host", entries: []string{"example.com"}, policy: PolicySubdomains, host: "example.com", want: true},
+		{name: "subdomains admit deeper hosts", entries: []string{"example.com"}, policy: PolicySubdomains, host: "a.b.example.com", want: true},
+		{name: "subdomains skip the parent", entries: []string{"www.example.com"}, policy: PolicySubdomains, host: "example.com", want: false},
+		{name: "subdomains skip lookalikes", entries: []string{"example.com"}, policy: PolicySubdomains, host: "badexample.com", want: false},
+		{name: "site admits siblings", entries: []string{"www.example.co.uk"}, policy: PolicySite, host: "img.example.co.uk", want: true},
+		{name: "site skips other sites under the suffix", entries: []string{"www.example.co.uk"}, policy: PolicySite, host: "other.co.uk", want: false},
+		{name: "entries are normalized", entries: []string{" Example.COM. "}, policy: PolicyExact, host: "example.com", want: true},
+		{name: "host case and trailing dot", entries: []string{"example.com"}, policy: PolicyExact, host: "EXAMPLE.com.", want: true},
+		{name: "empty entries match nothing", entries: []string{"", " "}, policy: PolicySubdomains, host: "example.com", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newDomainMatcher(tt.entries, tt.policy)
+			if got := m.Match(&url.URL{Scheme: "https", Host: tt.host}); got != tt.want {
+				t.Errorf("Match(%s) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDomainPolicy(t *testing.T) {
+	tests := []struct {
+		raw   string
+		want  domainPolicy
+		fails bool
+	}{
+		{raw: "", want: PolicySubdomains},
+		{raw: "exact", want: PolicyExact},
+		{raw: " Site ", want: PolicySite},
+		{raw: "subdomains", want: PolicySubdomains},
+		{raw: "everything", fails: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := parseDomainPolicy(tt.raw)
+			if (err != nil) != tt.fails {
+				t.Fatalf("parseDomainPolicy(%q) error = %v, want failure %v", tt.raw, err, tt.fails)
+			}
+			if got != tt.want {
+				t.Errorf("parseDomainPolicy(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchHostPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		{pattern: "*", host: "anything.test", want: true},
+		{pattern: "*.wikipedia.org", host: "en.wikipedia.org", want: true},
+		{pattern: "*.wikipedia.org", host: "en.m.wikipedia.org", want: true},
+		{pattern: "*.wikipedia.org", host: "wikipedia.org", want: false},
+		{pattern: "*.wikipedia.org", host: "en.wikipedia.org.evil.test", want: false},
+		{pattern: "images.*.example.net", host: "images.eu.example.net", want: true},
+		{pattern: "images.*.example.net", host: "images.a.b.example.net", want: false},
+		{pattern: "img-*.example.com", host: "img-3.example.com", want: true},
+		{pattern: "img-*.example.com", host: "cdn.example.com", want: false},
+		{pattern: "*.img-*.example.com", host: "a.img-eu.example.com", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.host, func(t *testing.T) {
+			if got := matchHostPattern(tt.pattern, tt.host); got != tt.want {
+				t.Errorf("matchHostPattern(%s, %s) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDomainMatcherPatterns(t *testing.T) {
+	// patterns apply whatever the policy
+	m := newDomainMatcher([]string{"*.Example.ORG", "static.example.com"}, PolicyExact)
+	for host, want := range map[string]bool{
+		"a.example.org":      true,
+		"example.org":        false,
+		"static.example.com": true,
+		"www.example.com":    false,
+	} {
+		if got := m.Match(&url.URL{Scheme: "https", Host: host}); got != want {
+			t.Errorf("Match(%s) = %v, want %v", host, got, want)
+		}
+	}
+}
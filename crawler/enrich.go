@@ -0,0 +1,449 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   IMAGE ENRICHMENT
+	==============================
+*/
+
+const (
+	EnrichQueueSize   = 1000
+	EnrichConcurrency = 2
+	MaxEnrichBytes    = 10 * 1024 * 1024
+	MaxEnrichQueued   = 100000
+	ProbeBytes        = 64 * 1024
+)
+
+// enricher is the second crawl stage: it downloads the images pages point
+// at and adds what can only be read from the file itself. It has its own
+// bounded queue and workers so slow image hosts never hold up page
+// crawling; when the queue is full new images are dropped rather than
+// blocking the page workers.
+//
+// IMG_ENRICH=true downloads whole files (EXIF, pixel dimensions).
+// IMG_PROBE_DIMENSIONS=true alone only reads the first few KB of each
+// file, enough for its dimensions.
+type enricher struct {
+	store      store.Store
+	col        *mongo.Collection // near-duplicate groups, Mongo only
+	limiter    *hostLimiter
+	robots     *robotsCache
+	sizes      *imageSizeFilter
+	workers    int
+	full       bool
+	maxBytes   int64
+	maxPixels  int64
+	probeBytes int64
+
+	// steps that decode the image, full downloads only; dupDistance < 0
+	// turns near-duplicate grouping off
+	dupDistance int
+	colors      bool
+
+	// copies kept in the blob store, full downloads only
+	blobs          blobStore
+	storeOriginals bool
+	thumbnailSize  int // 0 is off
+
+	// model scoring, full downloads only
+	nsfw  *nsfwScorer
+	embed *embedder
+	faces *faceCounter
+
+	queue   chan enrichJob
+	wg      sync.WaitGroup
+	dropped atomic.Int64
+	// with split stages, images go to the enrich stage's queue instead
+	remote func(ctx context.Context, link string) error
+
+	// file URLs already queued this run, so an image shared by many pages
+	// is downloaded once
+	mu     sync.Mutex
+	queued map[string]bool
+}
+
+// enrichJob is a queued image and the span of the page that found it,
+// which its enrich span goes under.
+type enrichJob struct {
+	link string
+	span trace.SpanContext
+}
+
+// newEnricher returns nil when enrichment is disabled.
+func newEnricher(st store.Store, limiter *hostLimiter, robots *robotsCache, sizes *imageSizeFilter, blobs blobStore) (*enricher, error) {
+	full := env.Get("IMG_ENRICH", "") == "true"
+	if !full && env.Get("IMG_PROBE_DIMENSIONS", "") != "true" {
+		return nil, nil
+	}
+	e := &enricher{
+		store:      st,
+		col:        store.MongoCollection(st),
+		limiter:    limiter,
+		robots:     robots,
+		sizes:      sizes,
+		workers:    env.Int("IMG_ENRICH_CONCURRENCY", EnrichConcurrency),
+		full:       full,
+		maxBytes:   int64(env.Int("IMG_ENRICH_MAX_BYTES", MaxEnrichBytes)),
+		maxPixels:  int64(env.Int("IMG_MAX_DECODE_PIXELS", MaxDecodePixels)),
+		probeBytes: int64(env.Int("IMG_PROBE_BYTES", ProbeBytes)),
+		queue:      make(chan enrichJob, max(env.Int("IMG_ENRICH_QUEUE", EnrichQueueSize), 1)),
+		queued:     map[string]bool{},
+
+		dupDistance: -1,
+	}
+	if e.workers < 1 {
+		e.workers = 1
+	}
+	if full && env.Get("IMG_NEAR_DUPLICATES", "") != "false" {
+		switch {
+		case e.col != nil:
+			e.dupDistance = min(env.Int("IMG_DUP_DISTANCE", DupHashDistance), dHashBands-1)
+		case env.Get("IMG_NEAR_DUPLICATES", "") == "true":
+			return nil, fmt.Errorf("IMG_NEAR_DUPLICATES needs IMG_DB_DRIVER=mongo")
+		default:
+			slog.Info("Near duplicate groups need Mongo; not grouping")
+		}
+	}
+	e.colors = full && env.Get("IMG_DOMINANT_COLORS", "") != "false"
+
+	e.storeOriginals = env.Get("IMG_STORE_ORIGINALS", "") == "true"
+	if env.Get("IMG_THUMBNAILS", "") == "true" {
+		e.thumbnailSize = max(env.Int("IMG_THUMBNAIL_SIZE", ThumbnailSize), 1)
+	}
+	if e.storeOriginals || e.thumbnailSize > 0 {
+		if !full || blobs == nil {
+			return nil, fmt.Errorf("IMG_STORE_ORIGINALS and IMG_THUMBNAILS need IMG_ENRICH=true and a blob store")
+		}
+		e.blobs = blobs
+	}
+
+	if e.nsfw = newNSFWScorer(); e.nsfw != nil && !full {
+		return nil, fmt.Errorf("IMG_NSFW_URL needs IMG_ENRICH=true")
+	}
+	if e.embed = newEmbedder(); e.embed != nil && !full {
+		return nil, fmt.Errorf("IMG_CLIP_URL needs IMG_ENRICH=true")
+	}
+	if e.faces = newFaceCounter(); e.faces != nil && !full {
+		return nil, fmt.Errorf("IMG_FACES_URL needs IMG_ENRICH=true")
+	}
+	return e, nil
+}
+
+// Start launches the workers. They run until Close, under ctx.
+func (e *enricher) Start(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	if e.full {
+		slog.Info("Enriching images", "workers", e.workers, "queue", cap(e.queue), "max_bytes", e.maxBytes)
+	} else {
+		slog.Info("Probing image dimensions", "workers", e.workers, "queue", cap(e.queue), "probe_bytes", e.probeBytes)
+	}
+	for i := 0; i < e.workers; i++ {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			for job := range e.queue {
+				e.run(ctx, job)
+			}
+		}()
+	}
+}
+
+// run enriches one queued image.
+func (e *enricher) run(ctx context.Context, job enrichJob) {
+	ctx, span := tracer.Start(trace.ContextWithSpanContext(ctx, job.span), "enrich",
+		trace.WithAttributes(attribute.String("image.url", job.link), attribute.Bool("full", e.full)))
+	err := e.enrich(ctx, job.link)
+	if err != nil {
+		slog.Error("Enriching failed", "url", job.link, "error", err)
+	}
+	endSpan(span, err)
+}
+
+// Enqueue schedules a stored image for download. Only http(s) images are
+// fetched; blob-store copies of data URIs were read when they were stored.
+func (e *enricher) Enqueue(ctx context.Context, img store.ImageRecord) {
+	if e == nil {
+		return
+	}
+	if !strings.HasPrefix(img.FileURL, "http://") && !strings.HasPrefix(img.FileURL, "https://") {
+		return
+	}
+
+	e.mu.Lock()
+	if e.queued[img.FileURL] {
+		e.mu.Unlock()
+		return
+	}
+	if len(e.queued) < MaxEnrichQueued {
+		e.queued[img.FileURL] = true
+	}
+	e.mu.Unlock()
+
+	if e.remote != nil {
+		if err := e.remote(ctx, img.FileURL); err != nil {
+			slog.Error("Queueing image for enrichment failed", "url", img.FileURL, "error", err)
+		}
+		return
+	}
+	select {
+	case e.queue <- enrichJob{img.FileURL, trace.SpanContextFromContext(ctx)}:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// backlog returns how many images wait in the queue and how many file
+// URLs are remembered as queued.
+func (e *enricher) backlog() (queued, tracked int) {
+	if e == nil {
+		return 0, 0
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.queue), len(e.queued)
+}
+
+// Close stops accepting images and waits for the queue to drain.
+func (e *enricher) Close() {
+	if e == nil {
+		return
+	}
+	close(e.queue)
+	e.wg.Wait()
+	if n := e.dropped.Load(); n > 0 {
+		slog.Warn("Enrichment queue was full", "skipped", n)
+	}
+}
+
+// enrich downloads one image and stores what it learned on every record
+// for that file. Images handled by an earlier run, or already merged into
+// another record, are skipped.
+func (e *enricher) enrich(ctx context.Context, link string) error {
+	marker := "pixel_width"
+	if e.full {
+		marker = "enriched_at"
+	}
+	done, err := e.store.Enriched(ctx, link, marker)
+	if err != nil || done {
+		return err
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return err
+	}
+	if !e.robots.Allowed(u) {
+		return nil
+	}
+	if err := e.limiter.Wait(ctx, u); err != nil {
+		return err
+	}
+
+	update := store.Update{}
+	if e.full {
+		data, err := downloadImage(ctx, link, e.maxBytes)
+		if err != nil {
+			return err
+		}
+
+		hash := store.ContentHash(data)
+		merged, err := e.store.MergeByContentHash(ctx, link, hash)
+		if err != nil || merged {
+			return err
+		}
+		update["content_hash"] = hash
+		update["file_size"] = int64(len(data))
+		update["enriched_at"] = time.Now().UTC()
+
+		keep, err := e.enrichFile(ctx, link, hash, data, update)
+		if err != nil {
+			return err
+		}
+		if !keep {
+			return e.removeTiny(ctx, link)
+		}
+	} else {
+		w, h, size, err := probeDimensions(ctx, link, e.probeBytes)
+		if err != nil {
+			return err
+		}
+		if e.sizes.TooSmall(w, h) {
+			return e.removeTiny(ctx, link)
+		}
+		store.SetDimensions(update, w, h, false)
+		if size > 0 {
+			update["file_size"] = size
+		}
+	}
+
+	return e.store.UpdateImage(ctx, link, update, e.full)
+}
+
+// removeTiny drops an image the HTML gave no usable size for, but which
+// turns out to be an icon or a beacon.
+func (e *enricher) removeTiny(ctx context.Context, link string) error {
+	slog.Info("Removing tiny image", "url", link)
+	return e.store.RemoveImage(ctx, link, store.TombstoneTiny)
+}
+
+// enrichFile runs every step on a downloaded file, adding the results to
+// update. It reports false when the file is too small to keep. A file that
+// does not decode is kept but marked corrupt, so it is neither fetched
+// again nor shown in search. One over IMG_MAX_DECODE_PIXELS is marked
+// oversized and skips the steps that need its pixels.
+func (e *enricher) enrichFile(ctx context.Context, link, hash string, data []byte, update store.Update) (bool, error) {
+	w, h, _ := imageDimensions(data)
+	if e.sizes.TooSmall(w, h) {
+		return false, nil
+	}
+
+	exif, err := parseExif(data)
+	if err != nil {
+		slog.Error("Reading EXIF failed", "url", link, "error", err)
+	}
+	if exif != nil {
+		update["exif"] = exif
+	}
+	if w > 0 && h > 0 {
+		store.SetDimensions(update, w, h, exif != nil && exif.Orientation >= 5)
+	}
+
+	pic, err := decodeImage(data, e.maxPixels)
+	if errors.Is(err, errOversized) {
+		slog.Warn("Not decoding oversized image", "url", link, "error", err)
+		update["oversized"] = true
+	} else if err != nil {
+		slog.Warn("Undecodable image", "url", link, "error", err)
+		update["corrupt"] = true
+		update["decode_error"] = err.Error()
+		return true, nil
+	}
+
+	if e.storeOriginals {
+		key, mime := originalKey(hash, data)
+		stored, err := e.blobs.Put(ctx, key, data, mime)
+		if err != nil {
+			return true, err
+		}
+		update["stored_url"] = stored
+	}
+
+	// a model server being down should not hold up the rest
+	mime := http.DetectContentType(data)
+	if e.nsfw != nil {
+		if score, err := e.nsfw.Score(ctx, data, mime); err != nil {
+			slog.Error("NSFW scoring failed", "url", link, "error", err)
+		} else {
+			update["nsfw_score"] = score
+		}
+	}
+	if e.embed != nil {
+		if vec, err := e.embed.Embed(ctx, data, mime); err != nil {
+			slog.Error("CLIP embedding failed", "url", link, "error", err)
+		} else {
+			update["embedding"] = vec
+			update["embedding_model"] = e.embed.model
+		}
+	}
+	if e.faces != nil {
+		if n, err := e.faces.Count(ctx, data, mime); err != nil {
+			slog.Error("Face detection failed", "url", link, "error", err)
+		} else {
+			update["faces_count"] = n
+		}
+	}
+
+	// AVIF has no Go decoder and oversized images are not decoded: their
+	// header checked out, but there are no pixels for the steps below
+	if pic != nil {
+		return true, e.decoded(ctx, link, hash, pic, update)
+	}
+	return true, nil
+}
+
+// decoded runs the steps that need the decoded pixels.
+func (e *enricher) decoded(ctx context.Context, link, hash string, pic image.Image, update store.Update) error {
+	if e.dupDistance >= 0 {
+		hash := dHash(pic)
+		group, err := dupGroup(ctx, e.col, link, hash, e.dupDistance)
+		if err != nil {
+			return err
+		}
+		update["dhash"] = formatHash(hash)
+		update["dhash_bands"] = hashBands(hash)
+		update["dup_group"] = group
+	}
+	if e.colors {
+		if hexes := dominantColors(pic); len(hexes) > 0 {
+			update["dominant_colors"] = hexes
+			update["color_buckets"] = colorBuckets(hexes)
+		}
+	}
+	if e.thumbnailSize > 0 {
+		thumb, err := makeThumbnail(pic, e.thumbnailSize)
+		if err != nil {
+			return err
+		}
+		stored, err := e.blobs.Put(ctx, "thumbs/"+hash+".jpg", thumb, "image/jpeg")
+		if err != nil {
+			return err
+		}
+		update["thumbnail_url"] = stored
+	}
+	return nil
+}
+
+// downloadImage fetches an image of at most maxBytes.
+func downloadImage(ctx context.Context, link string, maxBytes int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := crawlClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &httpStatusError{Status: resp.StatusCode}
+	}
+	if resp.ContentLength > maxBytes {
+		return nil, fmt.Errorf("image is %d bytes, limit %d", resp.ContentLength, maxBytes)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("image exceeds %d bytes", maxBytes)
+	}
+	return data, nil
+}
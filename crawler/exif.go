@@ -0,0 +1,285 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   EXIF METADATA
+	==============================
+*/
+
+const (
+	tagMake               = 0x010F
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagGPSLatitudeRef     = 0x0001
+	tagGPSLatitude        = 0x0002
+	tagGPSLongitudeRef    = 0x0003
+	tagGPSLongitude       = 0x0004
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// exifPayload finds the TIFF-structured EXIF block in a JPEG (APP1), PNG
+// (eXIf), WebP (EXIF chunk) or bare TIFF file. It returns nil when there
+// is none.
+func exifPayload(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return jpegExif(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return pngExif(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return webpExif(data)
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return data
+	}
+	return nil
+}
+
+func jpegExif(data []byte) []byte {
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		// start of scan: metadata segments all come before it
+		if marker == 0xDA || marker == 0xD9 {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + size
+		if size < 2 || end > len(data) {
+			return nil
+		}
+		if marker == 0xE1 && bytes.HasPrefix(data[i+4:end], exifHeader) {
+			return data[i+4+len(exifHeader) : end]
+		}
+		i = end
+	}
+	return nil
+}
+
+func pngExif(data []byte) []byte {
+	for i := 8; i+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		kind := string(data[i+4 : i+8])
+		end := i + 8 + size
+		if size < 0 || end > len(data) {
+			return nil
+		}
+		if kind == "eXIf" {
+			return data[i+8 : end]
+		}
+		if kind == "IDAT" || kind == "IEND" {
+			return nil
+		}
+		i = end + 4 // CRC
+	}
+	return nil
+}
+
+func webpExif(data []byte) []byte {
+	for i := 12; i+8 <= len(data); {
+		kind := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size
+		if size < 0 || end > len(data) {
+			return nil
+		}
+		if kind == "EXIF" {
+			// some encoders keep the JPEG-style header
+			return bytes.TrimPrefix(data[i+8:end], exifHeader)
+		}
+		i = end + size%2
+	}
+	return nil
+}
+
+// tiffEntry is one IFD entry; raw holds the value bytes.
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	raw   []byte
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+var tiffTypeSize = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+// ifd reads the directory at off. Entries with unknown types or values
+// pointing outside the block are skipped.
+func (r *tiffReader) ifd(off uint32) (map[uint16]tiffEntry, error) {
+	if uint64(off)+2 > uint64(len(r.data)) {
+		return nil, fmt.Errorf("IFD offset %d out of range", off)
+	}
+	n := uint32(r.order.Uint16(r.data[off:]))
+	if uint64(off)+2+uint64(n)*12 > uint64(len(r.data)) {
+		return nil, fmt.Errorf("IFD at %d truncated", off)
+	}
+
+	entries := make(map[uint16]tiffEntry, n)
+	for i := uint32(0); i < n; i++ {
+		e := r.data[off+2+i*12:]
+		tag := r.order.Uint16(e)
+		typ := r.order.Uint16(e[2:])
+		count := r.order.Uint32(e[4:])
+
+		unit, ok := tiffTypeSize[typ]
+		if !ok {
+			continue
+		}
+		size := uint64(unit) * uint64(count)
+		raw := e[8:12]
+		if size > 4 {
+			start := uint64(r.order.Uint32(e[8:]))
+			if start+size > uint64(len(r.data)) {
+				continue
+			}
+			raw = r.data[start : start+size]
+		}
+		entries[tag] = tiffEntry{typ: typ, count: count, raw: raw[:min(size, uint64(len(raw)))]}
+	}
+	return entries, nil
+}
+
+func (r *tiffReader) str(e tiffEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	s, _, _ := strings.Cut(string(e.raw), "\x00")
+	return strings.TrimSpace(s)
+}
+
+func (r *tiffReader) uint(e tiffEntry) (uint32, bool) {
+	switch {
+	case e.typ == 3 && len(e.raw) >= 2:
+		return uint32(r.order.Uint16(e.raw)), true
+	case e.typ == 4 && len(e.raw) >= 4:
+		return r.order.Uint32(e.raw), true
+	}
+	return 0, false
+}
+
+func (r *tiffReader) rationals(e tiffEntry) []float64 {
+	if e.typ != 5 {
+		return nil
+	}
+	var out []float64
+	for i := 0; i+8 <= len(e.raw); i += 8 {
+		num := r.order.Uint32(e.raw[i:])
+		den := r.order.Uint32(e.raw[i+4:])
+		if den == 0 {
+			return nil
+		}
+		out = append(out, float64(num)/float64(den))
+	}
+	return out
+}
+
+// parseExif extracts camera, orientation, capture time and GPS position
+// from an image file. It returns nil without an error when the file
+// carries no EXIF block.
+func parseExif(data []byte) (*store.ExifData, error) {
+	payload := exifPayload(data)
+	if len(payload) < 8 {
+		return nil, nil
+	}
+
+	r := &tiffReader{data: payload}
+	switch string(payload[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("bad TIFF byte order %q", payload[:2])
+	}
+
+	ifd0, err := r.ifd(r.order.Uint32(payload[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	out := &store.ExifData{
+		Make:  r.str(ifd0[tagMake]),
+		Model: r.str(ifd0[tagModel]),
+	}
+	if o, ok := r.uint(ifd0[tagOrientation]); ok && o >= 1 && o <= 8 {
+		out.Orientation = int(o)
+	}
+
+	if off, ok := r.uint(ifd0[tagExifIFD]); ok {
+		if sub, err := r.ifd(off); err == nil {
+			out.TakenAt = exifTime(r.str(sub[tagDateTimeOriginal]), r.str(sub[tagOffsetTimeOriginal]))
+		}
+	}
+
+	if off, ok := r.uint(ifd0[tagGPSIFD]); ok {
+		if gps, err := r.ifd(off); err == nil {
+			out.GPS = exifGPS(r, gps)
+		}
+	}
+
+	if out.Make == "" && out.Model == "" && out.Orientation == 0 && out.TakenAt.IsZero() && out.GPS == nil {
+		return nil, nil
+	}
+	return out, nil
+}
+
+// exifTime parses DateTimeOriginal. EXIF stores local time; without an
+// OffsetTimeOriginal tag it is recorded as if it were UTC.
+func exifTime(value, offset string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", value+offset); err == nil {
+			return t.UTC()
+		}
+	}
+	t, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func exifGPS(r *tiffReader, gps map[uint16]tiffEntry) *store.GeoPoint {
+	lat, ok := gpsDegrees(r.rationals(gps[tagGPSLatitude]), r.str(gps[tagGPSLatitudeRef]), "S")
+	if !ok || lat < -90 || lat > 90 {
+		return nil
+	}
+	lon, ok := gpsDegrees(r.rationals(gps[tagGPSLongitude]), r.str(gps[tagGPSLongitudeRef]), "W")
+	if !ok || lon < -180 || lon > 180 {
+		return nil
+	}
+	return &store.GeoPoint{Type: "Point", Coordinates: []float64{lon, lat}}
+}
+
+// gpsDegrees turns degrees/minutes/seconds into signed decimal degrees.
+func gpsDegrees(dms []float64, ref, negative string) (float64, bool) {
+	if len(dms) != 3 || ref == "" {
+		return 0, false
+	}
+	deg := dms[0] + dms[1]/60 + dms[2]/3600
+	if strings.EqualFold(ref, negative) {
+		deg = -deg
+	}
+	return deg, true
+}
@@ -0,0 +1,50 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+)
+
+/*
+	==============================
+	   FACE DETECTION
+	==============================
+*/
+
+// faceCounter asks the IMG_FACES_URL detector how many faces an image
+// shows. The server gets the raw image bytes and answers either
+//
+//	{"faces_count": 2}
+//
+// or a list of detections, {"faces": [{...}, {...}]}, which is counted.
+// Any small detector (a BlazeFace or Haar cascade sidecar) will do: only
+// the count is stored.
+type faceCounter struct {
+	endpoint *inferenceEndpoint
+}
+
+func newFaceCounter() *faceCounter {
+	endpoint := newInferenceEndpoint("IMG_FACES")
+	if endpoint == nil {
+		return nil
+	}
+	return &faceCounter{endpoint: endpoint}
+}
+
+func (f *faceCounter) Count(ctx context.Context, data []byte, contentType string) (int, error) {
+	var reply struct {
+		FacesCount *int          `json:"faces_count"`
+		Faces      []interface{} `json:"faces"`
+	}
+	if err := f.endpoint.Post(ctx, data, contentType, &reply); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case reply.FacesCount != nil && *reply.FacesCount >= 0:
+		return *reply.FacesCount, nil
+	case reply.Faces != nil:
+		return len(reply.Faces), nil
+	}
+	return 0, fmt.Errorf("face reply has neither faces_count nor faces")
+}
@@ -0,0 +1,187 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"image_crawler/extract"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   FAVICON CONFIG
+	==============================
+*/
+
+const (
+	MaxFaviconBytes   = 256 * 1024
+	MaxCachedFavicons = 10000
+	FaviconRefresh    = 30 * 24 * time.Hour
+)
+
+// faviconExts names stored icons by their sniffed content type.
+var faviconExts = map[string]string{
+	"image/x-icon":             ".ico",
+	"image/vnd.microsoft.icon": ".ico",
+	"image/png":                ".png",
+	"image/gif":                ".gif",
+	"image/jpeg":               ".jpg",
+	"image/webp":               ".webp",
+	"image/bmp":                ".bmp",
+	"image/svg+xml":            ".svg",
+}
+
+// DomainRecord is one crawled host in the domains collection. The search
+// UI shows FaviconURL next to results from the domain.
+type DomainRecord struct {
+	Domain        string    `bson:"domain"`
+	FaviconURL    string    `bson:"favicon_url,omitempty"`    // stored copy, or the source without a blob store
+	FaviconSource string    `bson:"favicon_source,omitempty"` // where the icon was fetched from
+	FaviconType   string    `bson:"favicon_type,omitempty"`
+	FaviconAt     time.Time `bson:"favicon_fetched_at"`
+}
+
+// faviconCache remembers the hosts whose favicon was handled this run.
+type faviconCache struct {
+	mu   sync.Mutex
+	done map[string]bool
+}
+
+func newFaviconCache() *faviconCache {
+	return &faviconCache{done: map[string]bool{}}
+}
+
+// claim reports whether host still needs its favicon looked at, marking
+// it done.
+func (f *faviconCache) claim(host string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.done[host] {
+		return false
+	}
+	if len(f.done) < MaxCachedFavicons {
+		f.done[host] = true
+	}
+	return true
+}
+
+/*
+	==============================
+	   FAVICON CAPTURE
+	==============================
+*/
+
+// faviconLinks lists where a page says its icon is, in order of
+// preference: <link rel="icon"> (and "shortcut icon"), then
+// apple-touch-icon, then the /favicon.ico every browser falls back to.
+func faviconLinks(base *url.URL, doc *goquery.Document) []string {
+	var icons, touch []string
+	doc.Find("link[rel][href]").Each(func(_ int, el *goquery.Selection) {
+		rel, _ := el.Attr("rel")
+		href, _ := el.Attr("href")
+		u, err := extract.ResolveURL(base, href)
+		if err != nil {
+			return
+		}
+		for _, token := range strings.Fields(strings.ToLower(rel)) {
+			switch token {
+			case "icon":
+				icons = append(icons, u.String())
+				return
+			case "apple-touch-icon", "apple-touch-icon-precomposed":
+				touch = append(touch, u.String())
+				return
+			}
+		}
+	})
+	root := &url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/favicon.ico"}
+	return dedupeLinks(append(append(icons, touch...), root.String()), 0)
+}
+
+// captureFavicon stores the favicon of page's host in the domains
+// collection when IMG_FAVICONS=true. Each host is fetched once a run, and
+// not again until its stored icon is FaviconRefresh old. With a blob store
+// the icon bytes are kept too, so results don't hotlink the site.
+func (c *imageCrawler) captureFavicon(ctx context.Context, page *url.URL, doc *goquery.Document) {
+	host := strings.ToLower(page.Hostname())
+	if !c.favicons.claim(host) {
+		return
+	}
+
+	domains := c.col.Database().Collection(store.DomainCollName)
+	var prev DomainRecord
+	err := domains.FindOne(ctx, bson.M{"domain": host}).Decode(&prev)
+	switch {
+	case err == nil && time.Since(prev.FaviconAt) < FaviconRefresh:
+		return
+	case err != nil && err != mongo.ErrNoDocuments:
+		slog.Error("Loading domain failed", "domain", host, "error", err)
+		return
+	}
+
+	rec := DomainRecord{Domain: host, FaviconAt: time.Now().UTC()}
+	for _, link := range faviconLinks(page, doc) {
+		data, ctype, ok := c.fetchFavicon(ctx, link)
+		if !ok {
+			continue
+		}
+		rec.FaviconURL, rec.FaviconSource, rec.FaviconType = link, link, ctype
+		if c.blobs != nil {
+			key := "favicons/" + store.ContentHash(data) + faviconExts[ctype]
+			stored, err := c.blobs.Put(ctx, key, data, ctype)
+			if err != nil {
+				slog.Error("Storing favicon failed", "domain", host, "error", err)
+			} else {
+				rec.FaviconURL = stored
+			}
+		}
+		break
+	}
+	if rec.FaviconURL == "" {
+		slog.Debug("No favicon found", "domain", host)
+	}
+
+	// stored even without an icon, so the host isn't retried on every page
+	opts := options.Update().SetUpsert(true)
+	if _, err := domains.UpdateOne(ctx, bson.M{"domain": host}, bson.M{"$set": rec}, opts); err != nil {
+		slog.Error("Saving domain failed", "domain", host, "error", err)
+	}
+}
+
+// fetchFavicon downloads one candidate icon. Anything that is not an
+// image (the HTML 404 page many sites serve for /favicon.ico) is refused.
+func (c *imageCrawler) fetchFavicon(ctx context.Context, link string) ([]byte, string, bool) {
+	u, err := url.Parse(link)
+	if err != nil || !c.robots.Allowed(u) {
+		return nil, "", false
+	}
+	if err := c.limiter.Wait(ctx, u); err != nil {
+		return nil, "", false
+	}
+	data, err := downloadImage(ctx, link, MaxFaviconBytes)
+	if err != nil || len(data) == 0 {
+		return nil, "", false
+	}
+
+	// content sniffing sees SVG as plain text or XML
+	ctype := http.DetectContentType(data)
+	if strings.HasSuffix(strings.ToLower(u.Path), ".svg") && bytes.Contains(data[:min(len(data), 512)], []byte("<svg")) {
+		ctype = "image/svg+xml"
+	}
+	if !strings.HasPrefix(ctype, "image/") {
+		return nil, "", false
+	}
+	return data, ctype, true
+}
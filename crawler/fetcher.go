@@ -0,0 +1,363 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   PAGE FETCHERS
+	==============================
+*/
+
+// FetchCacheTTL is how long the cache fetcher serves a stored page.
+const FetchCacheTTL = 24 * time.Hour
+
+// Fetcher makes one attempt at downloading a page. Politeness, retries
+// and failure records stay with the crawler, which retries server errors,
+// 429s and timeouts. prev is the page as last stored, or nil.
+type Fetcher interface {
+	Fetch(ctx context.Context, link string, prev *store.PageRecord) (*FetchResult, error)
+}
+
+// FetcherFunc adapts a plain function to Fetcher.
+type FetcherFunc func(ctx context.Context, link string, prev *store.PageRecord) (*FetchResult, error)
+
+func (f FetcherFunc) Fetch(ctx context.Context, link string, prev *store.PageRecord) (*FetchResult, error) {
+	return f(ctx, link, prev)
+}
+
+var (
+	fetcherKindsMu sync.RWMutex
+	fetcherKinds   = map[string]func(arg string) (Fetcher, error){
+		"http":  func(string) (Fetcher, error) { return httpFetcher{}, nil },
+		"dir":   newDirFetcher,
+		"warc":  newWARCFetcher,
+		"cache": newCacheFetcher,
+	}
+)
+
+// RegisterFetcher adds a kind of fetcher, such as a headless browser, to
+// name in IMG_FETCHER and IMG_FETCHERS as "kind" or "kind:arg". open gets
+// the arg. It panics if the kind is taken.
+func RegisterFetcher(kind string, open func(arg string) (Fetcher, error)) {
+	fetcherKindsMu.Lock()
+	defer fetcherKindsMu.Unlock()
+	if _, dup := fetcherKinds[kind]; dup {
+		panic("crawler: RegisterFetcher called twice for " + kind)
+	}
+	fetcherKinds[kind] = open
+}
+
+// openFetcher builds the fetcher a "kind[:arg]" spec names.
+func openFetcher(spec string) (Fetcher, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	fetcherKindsMu.RLock()
+	open, ok := fetcherKinds[kind]
+	fetcherKindsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown fetcher %q", kind)
+	}
+	f, err := open(arg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", spec, err)
+	}
+	return f, nil
+}
+
+type hostFetcher struct {
+	hosts   *domainMatcher
+	fetcher Fetcher
+}
+
+// fetcherRoutes sends each host to its fetcher, and the rest to def.
+type fetcherRoutes struct {
+	def   Fetcher
+	hosts []hostFetcher
+}
+
+// loadFetchers reads IMG_FETCHER, the fetcher for every page ("http" when
+// unset), and IMG_FETCHERS, per-host exceptions such as
+// "example.com=dir:testdata/pages,archive.example=warc:site.warc.gz".
+// A host entry covers its subdomains; the first match wins.
+func loadFetchers() (*fetcherRoutes, error) {
+	def, err := openFetcher(env.Get("IMG_FETCHER", "http"))
+	if err != nil {
+		return nil, fmt.Errorf("IMG_FETCHER: %w", err)
+	}
+	r := &fetcherRoutes{def: def}
+	for _, e := range env.List("IMG_FETCHERS") {
+		host, spec, ok := strings.Cut(e, "=")
+		if !ok {
+			return nil, fmt.Errorf("IMG_FETCHERS: expected host=fetcher, got %q", e)
+		}
+		f, err := openFetcher(spec)
+		if err != nil {
+			return nil, fmt.Errorf("IMG_FETCHERS: %w", err)
+		}
+		r.hosts = append(r.hosts, hostFetcher{
+			hosts:   newDomainMatcher([]string{host}, PolicySubdomains),
+			fetcher: f,
+		})
+		slog.Info("Fetching host through", "host", strings.TrimSpace(host), "fetcher", strings.TrimSpace(spec))
+	}
+	return r, nil
+}
+
+// For returns the fetcher for u.
+func (r *fetcherRoutes) For(u *url.URL) Fetcher {
+	for _, h := range r.hosts {
+		if h.hosts.Match(u) {
+			return h.fetcher
+		}
+	}
+	return r.def
+}
+
+// blockResult reads a stored response block, as written by responseBlock,
+// as the answer to a request for link.
+func blockResult(link string, block []byte) (*FetchResult, error) {
+	req, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(block)), req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return readFetchResult(resp)
+}
+
+/*
+	==============================
+	   FIXTURE DIRECTORY
+	==============================
+*/
+
+// dirFetcher serves pages from files laid out as <dir>/<host>/<path>, with
+// index.html standing in for directories. Missing files are 404s.
+type dirFetcher struct {
+	dir string
+}
+
+func newDirFetcher(dir string) (Fetcher, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("missing directory")
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("not a directory")
+	}
+	return dirFetcher{dir: dir}, nil
+}
+
+func (f dirFetcher) Fetch(ctx context.Context, link string, prev *store.PageRecord) (*FetchResult, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Join(f.dir, u.Hostname(), filepath.FromSlash(filepath.Clean("/"+u.Path)))
+	if strings.HasSuffix(u.Path, "/") || u.Path == "" {
+		name = filepath.Join(name, "index.html")
+	}
+	body, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, &httpStatusError{Status: http.StatusNotFound}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctype := mime.TypeByExtension(filepath.Ext(name))
+	if ctype == "" {
+		ctype = http.DetectContentType(body)
+	}
+	resp := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {ctype}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	resp.Request, err = http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	return readFetchResult(resp)
+}
+
+/*
+	==============================
+	   WARC REPLAY
+	==============================
+*/
+
+// warcFetcher serves pages from WARC files, as IMG_WARC_DIR writes them
+// or as IMG_WARC_FILES takes them. Every HTML response is loaded into
+// memory up front, so it suits replaying a site rather than a Common
+// Crawl segment. Pages not in the archive are 404s.
+type warcFetcher struct {
+	pages map[string][]byte
+}
+
+func newWARCFetcher(pattern string) (Fetcher, error) {
+	sources, err := warcSources(context.Background(), []string{pattern})
+	if err != nil {
+		return nil, err
+	}
+	f := warcFetcher{pages: map[string][]byte{}}
+	for _, src := range sources {
+		if err := f.load(src); err != nil {
+			return nil, fmt.Errorf("%s: %w", src, err)
+		}
+	}
+	slog.Info("Loaded WARC pages", "files", len(sources), "pages", len(f.pages))
+	return f, nil
+}
+
+// load indexes the HTML responses of one file, later records replacing
+// earlier ones for the same URL.
+func (f warcFetcher) load(src string) error {
+	r, err := openWARCSource(context.Background(), src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	wr := newWARCReader(r)
+	for {
+		header, block, err := wr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if block == nil || header.Get("WARC-Type") != "response" ||
+			!strings.HasPrefix(header.Get("Content-Type"), "application/http") {
+			continue
+		}
+		f.pages[strings.Trim(header.Get("WARC-Target-URI"), "<>")] = block
+	}
+}
+
+func (f warcFetcher) Fetch(ctx context.Context, link string, prev *store.PageRecord) (*FetchResult, error) {
+	block, ok := f.pages[link]
+	if !ok {
+		return nil, &httpStatusError{Status: http.StatusNotFound}
+	}
+	return blockResult(link, block)
+}
+
+/*
+	==============================
+	   FETCH CACHE
+	==============================
+*/
+
+// cacheFetcher keeps live-fetched pages in a directory and serves them
+// from there for IMG_FETCH_CACHE_TTL, so a crawl can be rerun without
+// hitting the sites again. Only successful fetches are stored.
+type cacheFetcher struct {
+	dir   string
+	ttl   time.Duration
+	fetch Fetcher
+}
+
+func newCacheFetcher(dir string) (Fetcher, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("missing directory")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return cacheFetcher{
+		dir:   dir,
+		ttl:   env.Duration("IMG_FETCH_CACHE_TTL", FetchCacheTTL),
+		fetch: httpFetcher{},
+	}, nil
+}
+
+// path is where link is cached: the first line holds the final URL, the
+// rest the response block.
+func (f cacheFetcher) path(link string) string {
+	sum := sha256.Sum256([]byte(link))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(f.dir, key[:2], key+".http")
+}
+
+func (f cacheFetcher) Fetch(ctx context.Context, link string, prev *store.PageRecord) (*FetchResult, error) {
+	name := f.path(link)
+	if fi, err := os.Stat(name); err == nil && time.Since(fi.ModTime()) < f.ttl {
+		res, err := f.read(name)
+		if err == nil {
+			return res, nil
+		}
+		slog.Warn("Reading cached page failed", "url", link, "error", err)
+	}
+
+	res, err := f.fetch.Fetch(ctx, link, prev)
+	if err != nil || res.NotModified {
+		return res, err
+	}
+	if err := f.write(name, res); err != nil {
+		slog.Warn("Caching page failed", "url", link, "error", err)
+	}
+	return res, nil
+}
+
+func (f cacheFetcher) read(name string) (*FetchResult, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	final, block, ok := bytes.Cut(data, []byte("\n"))
+	if !ok {
+		return nil, fmt.Errorf("truncated cache entry")
+	}
+	return blockResult(string(final), block)
+}
+
+// write stores the entry through a temporary file, so concurrent workers
+// never read half of one.
+func (f cacheFetcher) write(name string, res *FetchResult) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := fmt.Fprintf(tmp, "%s\n%s", res.FinalURL, responseBlock(res)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,306 @@
+package crawler
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   CRAWL FRONTIER
+	==============================
+*/
+
+type Task struct {
+	Link     string  `json:"link"`
+	Level    int     `json:"level"`
+	Priority float64 `json:"priority"`
+}
+
+// Frontier is the crawl queue together with its visited set and page
+// budgets. Next claims a task (and a budget slot, both global and for the
+// task's domain); every claimed task must be handed back through Done
+// along with the links discovered on it. Visit marks a link as seen
+// outside the queue, e.g. the target of a redirect, and reports whether it
+// was new. Checkpoint persists the remaining crawl when it is interrupted;
+// pending are claimed tasks that were abandoned and must be crawled again.
+// Stats reports progress for the admin dashboard.
+type Frontier interface {
+	Push(ctx context.Context, tasks []Task) error
+	Next(ctx context.Context) (Task, bool, error)
+	Done(ctx context.Context, t Task, links []Task, ok bool) error
+	Visit(ctx context.Context, link string) (bool, error)
+	Checkpoint(ctx context.Context, pending []Task) error
+	Stats(ctx context.Context) (FrontierStats, error)
+	Close() error
+}
+
+// FrontierStats is a snapshot of the crawl's progress against its budget.
+type FrontierStats struct {
+	Queued       int64 `json:"queued"`
+	Processed    int64 `json:"processed"`
+	Seen         int64 `json:"seen"` // size of the visited set
+	Active       int64 `json:"active"`
+	Budget       int64 `json:"budget"`
+	DomainBudget int64 `json:"domain_budget,omitempty"`
+}
+
+// newFrontier returns a shared frontier when IMG_REDIS_URL is set, so
+// several crawler processes can share one crawl, and an in-memory one
+// otherwise. The shared frontier queues tasks in Redis, or in RabbitMQ
+// when IMG_AMQP_URL is set too; the RabbitMQ queue needs Redis for the
+// visited set and budgets. domainBudget caps pages per domain; 0 means no
+// cap. The traversal order comes from IMG_CRAWL_STRATEGY.
+func newFrontier(ctx context.Context, budget, domainBudget int) (Frontier, error) {
+	strategy, err := parseStrategy(env.Get("IMG_CRAWL_STRATEGY", string(StrategyBestFirst)))
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Crawl strategy", "strategy", strategy)
+
+	redisURL, amqpURL := env.Get("IMG_REDIS_URL", ""), env.Get("IMG_AMQP_URL", "")
+	if amqpURL != "" && redisURL == "" {
+		return nil, fmt.Errorf("IMG_AMQP_URL needs IMG_REDIS_URL for the shared visited set and budgets")
+	}
+	if redisURL != "" {
+		rf, err := newRedisFrontier(ctx, redisURL, env.Get("IMG_REDIS_PREFIX", RedisKeyPrefix), budget, domainBudget,
+			env.Duration("IMG_REDIS_LEASE_TTL", RedisLeaseTTL))
+		if err != nil {
+			return nil, err
+		}
+		rf.strategy = strategy
+		if amqpURL == "" {
+			return rf, nil
+		}
+		af, err := newAMQPFrontier(amqpURL, env.Get("IMG_AMQP_QUEUE", AMQPQueue),
+			env.Duration("IMG_AMQP_IDLE_TIMEOUT", AMQPIdleTimeout), rf)
+		if err != nil {
+			rf.Close()
+			return nil, err
+		}
+		af.strategy = strategy
+		return af, nil
+	}
+
+	f := newMemoryFrontier(budget, domainBudget)
+	f.strategy = strategy
+	f.checkpoint = env.Get("IMG_CHECKPOINT_FILE", CheckpointFile)
+	if err := f.restore(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// taskDomain is the key per-domain budgets are counted under.
+func taskDomain(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+/*
+	==============================
+	   IN-MEMORY FRONTIER
+	==============================
+*/
+
+type memoryFrontier struct {
+	budget       int
+	domainBudget int
+	strategy     crawlStrategy
+	checkpoint   string
+
+	mu        sync.Mutex
+	wake      *sync.Cond
+	queue     taskHeap
+	seq       uint64
+	seen      map[string]bool
+	processed int
+	active    int
+	claimed   map[string]int // per-domain processed + active
+}
+
+func newMemoryFrontier(budget, domainBudget int) *memoryFrontier {
+	f := &memoryFrontier{
+		budget:       budget,
+		domainBudget: domainBudget,
+		seen:         map[string]bool{},
+		claimed:      map[string]int{},
+	}
+	f.wake = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *memoryFrontier) enqueue(t Task) {
+	f.seq++
+	heap.Push(&f.queue, queuedTask{Task: t, score: f.strategy.score(t), seq: f.seq})
+}
+
+func (f *memoryFrontier) Push(ctx context.Context, tasks []Task) error {
+	f.mu.Lock()
+	for _, t := range tasks {
+		if !f.seen[t.Link] {
+			f.enqueue(t)
+		}
+	}
+	f.mu.Unlock()
+	f.wake.Broadcast()
+	return nil
+}
+
+// Next blocks until a task is available, or returns false once the queue is
+// drained with no workers left to refill it or the page budget is spent.
+// Pages being fetched count against the budget so workers never overshoot it.
+func (f *memoryFrontier) Next(ctx context.Context) (Task, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for {
+		if ctx.Err() != nil {
+			f.wake.Broadcast()
+			return Task{}, false, ctx.Err()
+		}
+		for len(f.queue) > 0 && f.processed+f.active < f.budget {
+			t := heap.Pop(&f.queue).(queuedTask).Task
+			if f.seen[t.Link] {
+				continue
+			}
+			f.seen[t.Link] = true
+
+			// a domain that used up its budget drops the rest of its links
+			domain := taskDomain(t.Link)
+			if f.domainBudget > 0 && f.claimed[domain] >= f.domainBudget {
+				continue
+			}
+			f.claimed[domain]++
+			f.active++
+			return t, true, nil
+		}
+		if f.active == 0 || f.processed >= f.budget {
+			// let blocked workers re-check the exit condition
+			f.wake.Broadcast()
+			return Task{}, false, nil
+		}
+		f.wake.Wait()
+	}
+}
+
+func (f *memoryFrontier) Done(ctx context.Context, t Task, links []Task, ok bool) error {
+	f.mu.Lock()
+	f.active--
+	if ok {
+		f.processed++
+		slog.Info("Progress", "pages", f.processed)
+	} else {
+		f.claimed[taskDomain(t.Link)]--
+	}
+	for _, l := range links {
+		if !f.seen[l.Link] {
+			f.enqueue(l)
+		}
+	}
+	f.mu.Unlock()
+	f.wake.Broadcast()
+	return nil
+}
+
+func (f *memoryFrontier) Visit(ctx context.Context, link string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.seen[link] {
+		return false, nil
+	}
+	f.seen[link] = true
+	return true, nil
+}
+
+func (f *memoryFrontier) Stats(ctx context.Context) (FrontierStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return FrontierStats{
+		Queued:       int64(len(f.queue)),
+		Processed:    int64(f.processed),
+		Seen:         int64(len(f.seen)),
+		Active:       int64(f.active),
+		Budget:       int64(f.budget),
+		DomainBudget: int64(f.domainBudget),
+	}, nil
+}
+
+// restore resumes from the checkpoint file if an earlier crawl left one.
+// The file is removed once loaded, so a crawl that then runs to completion
+// does not resume again next time.
+func (f *memoryFrontier) restore() error {
+	if f.checkpoint == "" {
+		return nil
+	}
+	cp, err := readCheckpoint(f.checkpoint)
+	if err != nil || cp == nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.processed = cp.Processed
+	for d, n := range cp.Claimed {
+		f.claimed[d] = n
+	}
+	for _, l := range cp.Seen {
+		f.seen[l] = true
+	}
+	for _, t := range cp.Queue {
+		f.enqueue(t)
+	}
+	f.mu.Unlock()
+
+	slog.Info("Resumed from checkpoint", "saved_at", cp.SavedAt, "processed", cp.Processed, "queued", len(cp.Queue))
+	return os.Remove(f.checkpoint)
+}
+
+func (f *memoryFrontier) Checkpoint(ctx context.Context, pending []Task) error {
+	if f.checkpoint == "" {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cp := frontierCheckpoint{
+		SavedAt:   time.Now().UTC(),
+		Processed: f.processed,
+		Claimed:   f.claimed,
+	}
+	abandoned := map[string]bool{}
+	for _, t := range pending {
+		abandoned[t.Link] = true
+		cp.Queue = append(cp.Queue, t)
+	}
+	for l := range f.seen {
+		if !abandoned[l] {
+			cp.Seen = append(cp.Seen, l)
+		}
+	}
+	for _, q := range f.queue {
+		cp.Queue = append(cp.Queue, q.Task)
+	}
+
+	if err := writeCheckpoint(f.checkpoint, cp); err != nil {
+		return err
+	}
+	slog.Info("Checkpoint written", "path", f.checkpoint, "queued", len(cp.Queue))
+	return nil
+}
+
+func (f *memoryFrontier) Close() error {
+	return nil
+}
@@ -0,0 +1,346 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+/*
+	==============================
+	   AMQP FRONTIER CONFIG
+	==============================
+*/
+
+const (
+	AMQPQueue       = "imgcrawl.tasks"
+	AMQPPrefetch    = 16
+	AMQPMaxPriority = 9
+	// how often a worker at the page budget checks for a free slot
+	AMQPPollInterval = 500 * time.Millisecond
+	// a worker with nothing in flight gives up after this long without a
+	// task
+	AMQPIdleTimeout = 30 * time.Second
+)
+
+/*
+	==============================
+	   AMQP FRONTIER
+	==============================
+*/
+
+// amqpFrontier distributes the crawl through a durable RabbitMQ queue:
+// every link a process discovers is published to it, and every process
+// consuming it takes tasks from it, so workers can be added or removed
+// while the crawl runs. A task is acknowledged once its page is done and
+// its links are published; tasks held by a process that dies go back to
+// the queue.
+//
+// The visited set, page budgets and claims live in Redis, under the keys
+// the Redis frontier uses (its queue aside), so IMG_AMQP_URL needs
+// IMG_REDIS_URL: a link is published once however many processes find
+// it, and the budgets hold across all of them. Claims are leases, so the
+// slots of a process that dies are freed once its leases run out.
+//
+// A process stops once the budget is spent, or when it has nothing in
+// flight and the queue stays empty for IMG_AMQP_IDLE_TIMEOUT; tasks it
+// has not taken stay queued for the next. Fetching, extraction and
+// enrichment are split into stages with queues of their own (see
+// amqpStages). RabbitMQ orders by priority and
+// then FIFO, so IMG_CRAWL_STRATEGY only ranks tasks coarsely (see
+// amqpPriority).
+type amqpFrontier struct {
+	conn   *amqp.Connection
+	ch     *amqp.Channel
+	queue  string
+	idle   time.Duration
+	shared *redisFrontier
+
+	strategy crawlStrategy
+
+	deliveries <-chan amqp.Delivery
+	pubMu      sync.Mutex // publishes and acks share the channel
+
+	mu       sync.Mutex
+	active   int
+	inFlight map[string]uint64
+}
+
+func newAMQPFrontier(amqpURL, queue string, idle time.Duration, shared *redisFrontier) (*amqpFrontier, error) {
+	conn, err := amqp.Dial(amqpURL)
+	if err != nil {
+		return nil, fmt.Errorf("IMG_AMQP_URL: %w", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	f := &amqpFrontier{
+		conn:     conn,
+		ch:       ch,
+		queue:    queue,
+		idle:     idle,
+		shared:   shared,
+		inFlight: map[string]uint64{},
+	}
+	if err := f.setup(); err != nil {
+		ch.Close()
+		conn.Close()
+		return nil, err
+	}
+
+	slog.Info("Using shared AMQP frontier", "queue", queue)
+	return f, nil
+}
+
+// setup declares the queue and starts consuming it with manual acks.
+func (f *amqpFrontier) setup() error {
+	_, err := f.ch.QueueDeclare(f.queue, true, false, false, false, amqp.Table{
+		"x-max-priority": AMQPMaxPriority,
+	})
+	if err != nil {
+		return fmt.Errorf("declaring queue %s: %w", f.queue, err)
+	}
+	if err := f.ch.Qos(AMQPPrefetch, 0, false); err != nil {
+		return err
+	}
+	f.deliveries, err = f.ch.Consume(f.queue, "", false, false, false, false, nil)
+	return err
+}
+
+// amqpPriority maps a strategy score onto RabbitMQ's 0-AMQPMaxPriority.
+// Scores are shifted by MaxImageDepth so BFS (-level) and DFS (+level)
+// stay in range; best-first yields above the range share the top.
+func (f *amqpFrontier) amqpPriority(t Task) uint8 {
+	p := math.Round(f.strategy.score(t)) + MaxImageDepth
+	return uint8(min(max(p, 0), AMQPMaxPriority))
+}
+
+// publish sends the tasks no process has seen before. Tasks it could not
+// send are taken out of the visited set again.
+func (f *amqpFrontier) publish(ctx context.Context, tasks []Task) error {
+	fresh, err := f.shared.markSeen(ctx, taskLinks(tasks))
+	if err != nil {
+		return err
+	}
+	isFresh := make(map[string]bool, len(fresh))
+	for _, link := range fresh {
+		isFresh[link] = true
+	}
+	var out []Task
+	for _, t := range tasks {
+		if isFresh[t.Link] {
+			out = append(out, t)
+			delete(isFresh, t.Link)
+		}
+	}
+
+	sent, err := f.send(ctx, out)
+	if err != nil {
+		if uerr := f.shared.unsee(context.WithoutCancel(ctx), taskLinks(out[sent:])); uerr != nil {
+			slog.Error("Unmarking unpublished links failed", "error", uerr)
+		}
+	}
+	return err
+}
+
+// send publishes tasks as they are and returns how many went out.
+func (f *amqpFrontier) send(ctx context.Context, tasks []Task) (int, error) {
+	f.pubMu.Lock()
+	defer f.pubMu.Unlock()
+	for i, t := range tasks {
+		body, err := json.Marshal(t)
+		if err != nil {
+			return i, err
+		}
+		err = f.ch.PublishWithContext(ctx, "", f.queue, false, false, amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Priority:     f.amqpPriority(t),
+			Body:         body,
+		})
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(tasks), nil
+}
+
+func (f *amqpFrontier) Push(ctx context.Context, tasks []Task) error {
+	return f.publish(ctx, tasks)
+}
+
+// Next waits for a delivery and a lease on it. Deliveries for domains
+// over budget are acknowledged and dropped; once the budget is spent, the
+// delivery in hand goes back to the queue.
+func (f *amqpFrontier) Next(ctx context.Context) (Task, bool, error) {
+	idle := time.NewTimer(f.idle)
+	defer idle.Stop()
+
+	for {
+		select {
+		case d, ok := <-f.deliveries:
+			if !ok {
+				return Task{}, false, fmt.Errorf("AMQP channel closed")
+			}
+			t, res, err := f.claim(ctx, d)
+			switch {
+			case err != nil:
+				return Task{}, false, err
+			case res == "claimed":
+				return t, true, nil
+			case res == "done":
+				return Task{}, false, nil
+			}
+		case <-idle.C:
+			f.mu.Lock()
+			active := f.active
+			f.mu.Unlock()
+			// pages in flight may still publish links
+			if active == 0 {
+				return Task{}, false, nil
+			}
+			idle.Reset(f.idle)
+		case <-ctx.Done():
+			return Task{}, false, ctx.Err()
+		}
+	}
+}
+
+// claim leases d's task, waiting while the budget is taken by pages in
+// flight. It reports "claimed"; "done" when the budget is spent, putting
+// d back; or "dropped" when d was acknowledged and dropped.
+func (f *amqpFrontier) claim(ctx context.Context, d amqp.Delivery) (Task, string, error) {
+	var t Task
+	if err := json.Unmarshal(d.Body, &t); err != nil {
+		slog.Error("Bad task in AMQP queue", "error", err)
+		f.ack(d.DeliveryTag)
+		return Task{}, "dropped", nil
+	}
+
+	for {
+		res, err := f.shared.lease(ctx, t)
+		if err != nil {
+			f.nack(d.DeliveryTag, t)
+			return Task{}, "", err
+		}
+		switch res {
+		case "claimed":
+			f.mu.Lock()
+			f.active++
+			f.inFlight[t.Link] = d.DeliveryTag
+			f.mu.Unlock()
+			return t, res, nil
+		case "full":
+			f.ack(d.DeliveryTag)
+			return Task{}, "dropped", nil
+		case "done":
+			f.nack(d.DeliveryTag, t)
+			return Task{}, res, nil
+		}
+
+		// wait for a page in flight anywhere to finish or give its slot
+		// back
+		timer := time.NewTimer(AMQPPollInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			f.nack(d.DeliveryTag, t)
+			return Task{}, "", ctx.Err()
+		}
+	}
+}
+
+func (f *amqpFrontier) ack(tag uint64) {
+	f.pubMu.Lock()
+	defer f.pubMu.Unlock()
+	if err := f.ch.Ack(tag, false); err != nil {
+		slog.Error("Acknowledging AMQP task failed", "error", err)
+	}
+}
+
+// nack puts a delivery back on the queue.
+func (f *amqpFrontier) nack(tag uint64, t Task) {
+	f.pubMu.Lock()
+	defer f.pubMu.Unlock()
+	if err := f.ch.Nack(tag, false, true); err != nil {
+		slog.Error("Requeueing AMQP task failed", "url", t.Link, "error", err)
+	}
+}
+
+// Done publishes the page's links before acknowledging it, so a crash in
+// between repeats the page rather than losing its links. A page whose
+// links could not be published goes back to the queue without counting.
+func (f *amqpFrontier) Done(ctx context.Context, t Task, links []Task, ok bool) error {
+	err := f.publish(ctx, links)
+	processed, ferr := f.shared.finish(ctx, t, nil, ok && err == nil)
+	if err == nil {
+		err = ferr
+	}
+
+	f.mu.Lock()
+	f.active--
+	tag, held := f.inFlight[t.Link]
+	delete(f.inFlight, t.Link)
+	f.mu.Unlock()
+
+	if ok && ferr == nil {
+		slog.Info("Progress", "pages", processed, "shared", true)
+	}
+	switch {
+	case !held:
+	case err != nil:
+		f.nack(tag, t)
+	default:
+		f.ack(tag)
+	}
+	return err
+}
+
+func (f *amqpFrontier) Visit(ctx context.Context, link string) (bool, error) {
+	return f.shared.Visit(ctx, link)
+}
+
+// Checkpoint puts abandoned tasks back on the queue; the rest is already
+// there. They are in the visited set, so they are sent as they are.
+func (f *amqpFrontier) Checkpoint(ctx context.Context, pending []Task) error {
+	_, err := f.send(ctx, pending)
+	return err
+}
+
+// Stats covers every process sharing the crawl; Queued is the broker's
+// count of tasks waiting.
+func (f *amqpFrontier) Stats(ctx context.Context) (FrontierStats, error) {
+	st, err := f.shared.Stats(ctx)
+	if err != nil {
+		return FrontierStats{}, err
+	}
+	f.pubMu.Lock()
+	q, err := f.ch.QueueDeclarePassive(f.queue, true, false, false, false, amqp.Table{
+		"x-max-priority": AMQPMaxPriority,
+	})
+	f.pubMu.Unlock()
+	if err != nil {
+		return FrontierStats{}, err
+	}
+	st.Queued = int64(q.Messages)
+	return st, nil
+}
+
+// Close returns unacknowledged deliveries to the queue.
+func (f *amqpFrontier) Close() error {
+	defer f.shared.Close()
+	if err := f.ch.Close(); err != nil && err != amqp.ErrClosed {
+		f.conn.Close()
+		return err
+	}
+	return f.conn.Close()
+}
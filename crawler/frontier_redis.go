@@ -0,0 +1,498 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+	==============================
+	   REDIS FRONTIER CONFIG
+	==============================
+*/
+
+const (
+	RedisKeyPrefix    = "imgcrawl:"
+	RedisPollInterval = 500 * time.Millisecond
+	// how long a claim outlives its process; live processes renew theirs
+	// every third of it
+	RedisLeaseTTL = 10 * time.Minute
+)
+
+/*
+	==============================
+	   REDIS FRONTIER
+	==============================
+*/
+
+// redisFrontier shares the queue, visited set and page budget between
+// crawler processes. State lives under a key prefix:
+//
+//	<prefix>queue      sorted set of JSON tasks scored by the strategy
+//	<prefix>seen       set of every link ever enqueued
+//	<prefix>processed  pages counted against the budget
+//	<prefix>claims     sorted set of links being fetched, scored by the
+//	                   deadline of their lease
+//	<prefix>leases     hash of each claim's task, score and domain
+//	<prefix>domains    hash of per-domain claims (processed + active)
+//
+// Every state change runs as a Lua script so processes never race on the
+// budget check. A claim is a lease: the process holding it renews it
+// while the page is in flight, and once a lease runs out, because its
+// process died, the next claim puts the task back in the queue and frees
+// its budget slots. The keys outlive the crawl; delete them (or change
+// IMG_REDIS_PREFIX) to start a fresh one.
+type redisFrontier struct {
+	rdb          *redis.Client
+	budget       int
+	domainBudget int
+	strategy     crawlStrategy
+	ttl          time.Duration
+
+	keys []string // queue, seen, processed, claims, leases, domains
+
+	mu   sync.Mutex
+	held map[string]bool // links this process holds leases on
+	stop context.CancelFunc
+}
+
+// Positions in redisFrontier.keys, which every script gets.
+const (
+	redisQueue = iota
+	redisSeen
+	redisProcessed
+	redisClaims
+	redisLeases
+	redisDomains
+)
+
+// reclaimLua expires the leases whose deadline, ARGV[2] in Unix
+// milliseconds, has passed: their tasks go back in the queue, if they came
+// from it, and their domain claims are returned.
+const reclaimLua = `
+local expired = redis.call('ZRANGEBYSCORE', KEYS[4], '-inf', ARGV[2])
+for _, link in ipairs(expired) do
+	local lease = redis.call('HGET', KEYS[5], link)
+	if lease then
+		local l = cjson.decode(lease)
+		if l.task then
+			redis.call('ZADD', KEYS[1], l.score, l.task)
+		end
+		if l.domain then
+			redis.call('HINCRBY', KEYS[6], l.domain, -1)
+		end
+		redis.call('HDEL', KEYS[5], link)
+	end
+	redis.call('ZREM', KEYS[4], link)
+end
+`
+
+// pushScript enqueues links not seen before. ARGV holds
+// link/payload/score triples.
+var pushScript = redis.NewScript(`
+local added = 0
+for i = 1, #ARGV, 3 do
+	if redis.call('SADD', KEYS[2], ARGV[i]) == 1 then
+		redis.call('ZADD', KEYS[1], ARGV[i + 2], ARGV[i + 1])
+		added = added + 1
+	end
+end
+return added
+`)
+
+// nextScript claims a task if the shared budget allows it, leasing it
+// until ARGV[3]. ARGV[1] is the budget and ARGV[2] the time now.
+var nextScript = redis.NewScript(reclaimLua + `
+local budget = tonumber(ARGV[1])
+local processed = tonumber(redis.call('GET', KEYS[3]) or '0')
+local active = redis.call('ZCARD', KEYS[4])
+if processed >= budget then
+	return {'done'}
+end
+if processed + active >= budget then
+	return {'wait'}
+end
+local item = redis.call('ZPOPMAX', KEYS[1])
+if not item[1] then
+	if active <= 0 then
+		return {'done'}
+	end
+	return {'wait'}
+end
+local ok, t = pcall(cjson.decode, item[1])
+if not ok or type(t) ~= 'table' or type(t.link) ~= 'string' then
+	return {'bad', item[1]}
+end
+redis.call('ZADD', KEYS[4], ARGV[3], t.link)
+redis.call('HSET', KEYS[5], t.link, cjson.encode({task = item[1], score = tonumber(item[2])}))
+return {'task', item[1]}
+`)
+
+// domainScript counts the lease on ARGV[1] against the budget of its
+// domain, ARGV[2]. It returns 1 when the domain is full and 2 when the
+// lease has already run out, taking nothing either way.
+var domainScript = redis.NewScript(`
+local lease = redis.call('HGET', KEYS[5], ARGV[1])
+if not lease then
+	return 2
+end
+if redis.call('HINCRBY', KEYS[6], ARGV[2], 1) > tonumber(ARGV[3]) then
+	redis.call('HINCRBY', KEYS[6], ARGV[2], -1)
+	return 1
+end
+local l = cjson.decode(lease)
+l.domain = ARGV[2]
+redis.call('HSET', KEYS[5], ARGV[1], cjson.encode(l))
+return 0
+`)
+
+// doneScript ends the lease on ARGV[1] and enqueues the page's links,
+// link/payload/score triples from ARGV[3] on. ARGV[2] is "1" when the page
+// counts against the budget; otherwise its domain claim is returned. A
+// lease that already ran out counts for nothing, as its task was handed
+// out again.
+var doneScript = redis.NewScript(`
+local held = redis.call('ZREM', KEYS[4], ARGV[1]) == 1
+local lease = redis.call('HGET', KEYS[5], ARGV[1])
+redis.call('HDEL', KEYS[5], ARGV[1])
+local processed = tonumber(redis.call('GET', KEYS[3]) or '0')
+if held then
+	if ARGV[2] == '1' then
+		processed = redis.call('INCR', KEYS[3])
+	elseif lease then
+		local l = cjson.decode(lease)
+		if l.domain then
+			redis.call('HINCRBY', KEYS[6], l.domain, -1)
+		end
+	end
+end
+for i = 3, #ARGV, 3 do
+	if redis.call('SADD', KEYS[2], ARGV[i]) == 1 then
+		redis.call('ZADD', KEYS[1], ARGV[i + 2], ARGV[i + 1])
+	end
+end
+return processed
+`)
+
+// leaseScript claims ARGV[4], a task handed out by something other than
+// the queue (the AMQP frontier), if the budgets allow it: ARGV[1] is the
+// budget, ARGV[3] the lease deadline, ARGV[5] the task's domain and
+// ARGV[6] the domain budget.
+var leaseScript = redis.NewScript(reclaimLua + `
+local budget = tonumber(ARGV[1])
+local processed = tonumber(redis.call('GET', KEYS[3]) or '0')
+if processed >= budget then
+	return 'done'
+end
+if processed + redis.call('ZCARD', KEYS[4]) >= budget then
+	return 'wait'
+end
+local lease = {}
+if tonumber(ARGV[6]) > 0 then
+	if redis.call('HINCRBY', KEYS[6], ARGV[5], 1) > tonumber(ARGV[6]) then
+		redis.call('HINCRBY', KEYS[6], ARGV[5], -1)
+		return 'full'
+	end
+	lease.domain = ARGV[5]
+end
+redis.call('ZADD', KEYS[4], ARGV[3], ARGV[4])
+redis.call('HSET', KEYS[5], ARGV[4], cjson.encode(lease))
+return 'claimed'
+`)
+
+// seenScript adds links to the visited set and returns the ones that
+// were not in it.
+var seenScript = redis.NewScript(`
+local fresh = {}
+for i = 1, #ARGV do
+	if redis.call('SADD', KEYS[2], ARGV[i]) == 1 then
+		table.insert(fresh, ARGV[i])
+	end
+end
+return fresh
+`)
+
+func newRedisFrontier(ctx context.Context, redisURL, prefix string, budget, domainBudget int, ttl time.Duration) (*redisFrontier, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("IMG_REDIS_URL: %w", err)
+	}
+
+	rdb := redis.NewClient(opts)
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		rdb.Close()
+		return nil, fmt.Errorf("redis ping: %w", err)
+	}
+
+	slog.Info("Using shared Redis frontier", "prefix", prefix)
+
+	f := &redisFrontier{
+		rdb:          rdb,
+		budget:       budget,
+		domainBudget: domainBudget,
+		ttl:          max(ttl, time.Second),
+		held:         map[string]bool{},
+	}
+	for _, k := range []string{"queue", "seen", "processed", "claims", "leases", "domains"} {
+		f.keys = append(f.keys, prefix+k)
+	}
+	renew, stop := context.WithCancel(context.Background())
+	f.stop = stop
+	go f.renewLeases(renew)
+	return f, nil
+}
+
+// deadline is when a lease taken or renewed now runs out, in Unix
+// milliseconds.
+func (f *redisFrontier) deadline(now time.Time) int64 {
+	return now.Add(f.ttl).UnixMilli()
+}
+
+// renewLeases pushes back the deadlines of the leases this process holds
+// until ctx is done.
+func (f *redisFrontier) renewLeases(ctx context.Context) {
+	tick := time.NewTicker(f.ttl / 3)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+		f.mu.Lock()
+		deadline := float64(f.deadline(time.Now()))
+		members := make([]redis.Z, 0, len(f.held))
+		for link := range f.held {
+			members = append(members, redis.Z{Score: deadline, Member: link})
+		}
+		f.mu.Unlock()
+		if len(members) == 0 {
+			continue
+		}
+		// XX: a lease that was reclaimed meanwhile stays gone
+		if err := f.rdb.ZAddXX(ctx, f.keys[redisClaims], members...).Err(); err != nil {
+			slog.Error("Renewing Redis leases failed", "error", err)
+		}
+	}
+}
+
+func (f *redisFrontier) hold(link string, held bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if held {
+		f.held[link] = true
+	} else {
+		delete(f.held, link)
+	}
+}
+
+// taskArgs flattens tasks into link/payload/score triples. Redis breaks
+// score ties by member, not insertion order, so BFS and DFS here order by
+// level only.
+func (f *redisFrontier) taskArgs(tasks []Task) ([]interface{}, error) {
+	args := make([]interface{}, 0, 3*len(tasks))
+	for _, t := range tasks {
+		payload, err := json.Marshal(t)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, t.Link, payload, f.strategy.score(t))
+	}
+	return args, nil
+}
+
+func (f *redisFrontier) Push(ctx context.Context, tasks []Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	args, err := f.taskArgs(tasks)
+	if err != nil {
+		return err
+	}
+	return pushScript.Run(ctx, f.rdb, f.keys, args...).Err()
+}
+
+// Next polls until a task can be claimed. Another process may still be
+// adding links, so an empty queue only ends the crawl once no process has
+// a page in flight.
+func (f *redisFrontier) Next(ctx context.Context) (Task, bool, error) {
+	for {
+		now := time.Now()
+		res, err := nextScript.Run(ctx, f.rdb, f.keys, f.budget, now.UnixMilli(), f.deadline(now)).StringSlice()
+		if err != nil {
+			return Task{}, false, err
+		}
+
+		switch res[0] {
+		case "done":
+			return Task{}, false, nil
+		case "bad":
+			// dropped from the queue without taking a claim
+			slog.Error("Bad task in Redis queue", "task", res[1])
+			continue
+		case "task":
+			var t Task
+			if err := json.Unmarshal([]byte(res[1]), &t); err != nil {
+				slog.Error("Bad task in Redis queue", "error", err)
+				continue
+			}
+			f.hold(t.Link, true)
+
+			claimed, err := f.claimDomain(ctx, t)
+			if err != nil {
+				f.release(ctx, t)
+				return Task{}, false, err
+			}
+			if !claimed {
+				f.release(ctx, t)
+				continue
+			}
+			return t, true, nil
+		}
+
+		timer := time.NewTimer(RedisPollInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return Task{}, false, ctx.Err()
+		}
+	}
+}
+
+// claimDomain counts t's lease against its domain's budget. It reports
+// false, taking nothing, when the domain is already full or the lease ran
+// out.
+func (f *redisFrontier) claimDomain(ctx context.Context, t Task) (bool, error) {
+	if f.domainBudget <= 0 {
+		return true, nil
+	}
+	res, err := domainScript.Run(ctx, f.rdb, f.keys, t.Link, taskDomain(t.Link), f.domainBudget).Int()
+	return res == 0, err
+}
+
+// release ends the lease on t without counting a page.
+func (f *redisFrontier) release(ctx context.Context, t Task) {
+	if _, err := f.finish(ctx, t, nil, false); err != nil {
+		slog.Error("Releasing Redis claim failed", "url", t.Link, "error", err)
+	}
+}
+
+// finish ends the lease on t, counting it when ok, enqueues links and
+// returns the pages processed so far.
+func (f *redisFrontier) finish(ctx context.Context, t Task, links []Task, ok bool) (int, error) {
+	args, err := f.taskArgs(links)
+	if err != nil {
+		return 0, err
+	}
+	flag := "0"
+	if ok {
+		flag = "1"
+	}
+	args = append([]interface{}{t.Link, flag}, args...)
+
+	processed, err := doneScript.Run(ctx, f.rdb, f.keys, args...).Int()
+	if err != nil {
+		return 0, err
+	}
+	f.hold(t.Link, false)
+	return processed, nil
+}
+
+func (f *redisFrontier) Done(ctx context.Context, t Task, links []Task, ok bool) error {
+	processed, err := f.finish(ctx, t, links, ok)
+	if err != nil {
+		return err
+	}
+	if ok {
+		slog.Info("Progress", "pages", processed, "shared", true)
+	}
+	return nil
+}
+
+// lease claims t, which came from elsewhere, against the shared budgets
+// as nextScript and claimDomain would. It returns "claimed", "wait",
+// "done", or "full" when t's domain is over its budget.
+func (f *redisFrontier) lease(ctx context.Context, t Task) (string, error) {
+	now := time.Now()
+	res, err := leaseScript.Run(ctx, f.rdb, f.keys, f.budget, now.UnixMilli(), f.deadline(now),
+		t.Link, taskDomain(t.Link), f.domainBudget).Text()
+	if err == nil && res == "claimed" {
+		f.hold(t.Link, true)
+	}
+	return res, err
+}
+
+// markSeen adds links to the visited set and returns the new ones.
+func (f *redisFrontier) markSeen(ctx context.Context, links []string) ([]string, error) {
+	if len(links) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(links))
+	for i, l := range links {
+		args[i] = l
+	}
+	return seenScript.Run(ctx, f.rdb, f.keys, args...).StringSlice()
+}
+
+// unsee takes links out of the visited set, so they can be queued again.
+func (f *redisFrontier) unsee(ctx context.Context, links []string) error {
+	if len(links) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(links))
+	for i, l := range links {
+		args[i] = l
+	}
+	return f.rdb.SRem(ctx, f.keys[redisSeen], args...).Err()
+}
+
+func (f *redisFrontier) Visit(ctx context.Context, link string) (bool, error) {
+	added, err := f.rdb.SAdd(ctx, f.keys[redisSeen], link).Result()
+	return added == 1, err
+}
+
+// Stats covers every process sharing the crawl.
+func (f *redisFrontier) Stats(ctx context.Context) (FrontierStats, error) {
+	pipe := f.rdb.Pipeline()
+	queued := pipe.ZCard(ctx, f.keys[redisQueue])
+	seen := pipe.SCard(ctx, f.keys[redisSeen])
+	processed := pipe.Get(ctx, f.keys[redisProcessed])
+	active := pipe.ZCard(ctx, f.keys[redisClaims])
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return FrontierStats{}, err
+	}
+	st := FrontierStats{
+		Queued:       queued.Val(),
+		Seen:         seen.Val(),
+		Budget:       int64(f.budget),
+		DomainBudget: int64(f.domainBudget),
+	}
+	st.Processed, _ = processed.Int64()
+	st.Active = active.Val()
+	return st, nil
+}
+
+// Checkpoint only has to hand abandoned tasks back: everything else
+// already lives in Redis.
+func (f *redisFrontier) Checkpoint(ctx context.Context, pending []Task) error {
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := f.unsee(ctx, taskLinks(pending)); err != nil {
+		return err
+	}
+	return f.Push(ctx, pending)
+}
+
+// Close stops renewing leases; any still held run out and are reclaimed.
+func (f *redisFrontier) Close() error {
+	f.stop()
+	return f.rdb.Close()
+}
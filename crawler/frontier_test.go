@@ -0,0 +1,108 @@
+package crawler
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMemoryFrontierBudgets(t *testing.T) {
+	tests := []struct {
+		name         string
+		budget       int
+		domainBudget int
+		seeds        []string
+		links        map[string][]string // found on a page
+		fail         map[string]bool     // pages that do not count
+		want         []string            // claimed, in order
+	}{
+		{
+			name:   "budget stops the crawl",
+			budget: 2,
+			seeds:  []string{"http://a.test/1", "http://a.test/2", "http://b.test/1"},
+			want:   []string{"http://a.test/1", "http://a.test/2"},
+		},
+		{
+			name:   "found links count against the budget",
+			budget: 3,
+			seeds:  []string{"http://a.test/1"},
+			links:  map[string][]string{"http://a.test/1": {"http://a.test/2", "http://a.test/3", "http://a.test/4"}},
+			want:   []string{"http://a.test/1", "http://a.test/2", "http://a.test/3"},
+		},
+		{
+			name:   "failed pages do not count",
+			budget: 2,
+			seeds:  []string{"http://a.test/1", "http://a.test/2", "http://b.test/1"},
+			fail:   map[string]bool{"http://a.test/1": true},
+			want:   []string{"http://a.test/1", "http://a.test/2", "http://b.test/1"},
+		},
+		{
+			name:         "domain budget drops the rest of a domain",
+			budget:       10,
+			domainBudget: 1,
+			seeds:        []string{"http://a.test/1", "http://a.test/2", "http://b.test/1"},
+			want:         []string{"http://a.test/1", "http://b.test/1"},
+		},
+		{
+			name:         "a failed page gives its domain slot back",
+			budget:       10,
+			domainBudget: 1,
+			seeds:        []string{"http://a.test/1", "http://a.test/2", "http://b.test/1"},
+			fail:         map[string]bool{"http://a.test/1": true},
+			want:         []string{"http://a.test/1", "http://a.test/2", "http://b.test/1"},
+		},
+		{
+			name:         "domains are matched by host",
+			budget:       10,
+			domainBudget: 1,
+			seeds:        []string{"http://A.test/1", "http://a.test/2", "https://a.test/3"},
+			want:         []string{"http://A.test/1"},
+		},
+		{
+			name:   "links are crawled once",
+			budget: 10,
+			seeds:  []string{"http://a.test/1", "http://a.test/1"},
+			links:  map[string][]string{"http://a.test/1": {"http://a.test/1"}},
+			want:   []string{"http://a.test/1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			f := newMemoryFrontier(tt.budget, tt.domainBudget)
+			var seeds []Task
+			for _, s := range tt.seeds {
+				seeds = append(seeds, Task{Link: s})
+			}
+			if err := f.Push(ctx, seeds); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for {
+				task, ok, err := f.Next(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					break
+				}
+				got = append(got, task.Link)
+				var links []Task
+				for _, l := range tt.links[task.Link] {
+					links = append(links, Task{Link: l, Level: task.Level + 1})
+				}
+				if err := f.Done(ctx, task, links, !tt.fail[task.Link]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("claimed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
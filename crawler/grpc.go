@@ -0,0 +1,309 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "image_crawler/imagesearchpb"
+	"image_crawler/internal/env"
+	"image_crawler/search"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   GRPC CONFIG
+	==============================
+*/
+
+const (
+	GRPCAddr = ":50051"
+	// results streamed when a request gives no limit, and at most
+	DefaultStreamResults = 25
+	MaxStreamResults     = 1000
+)
+
+// Serve serves the ImageSearch gRPC service on IMG_GRPC_ADDR until ctx
+// is cancelled. Search is answered by the HTTP search API at
+// IMG_SEARCH_API_URL, so ranking lives in one place; crawls run in this
+// process.
+func Serve(ctx context.Context, st store.Store) error {
+	addr := env.Get("IMG_GRPC_ADDR", GRPCAddr)
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	crawls := &crawlManager{ctx: ctx, store: st}
+	srv := grpc.NewServer()
+	pb.RegisterImageSearchServer(srv, &searchServer{
+		search: search.NewClient(env.Get("IMG_SEARCH_API_URL", "")),
+		crawls: crawls,
+	})
+
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+	slog.Info("gRPC server listening", "addr", addr)
+	err = srv.Serve(lis)
+
+	// a stopped crawl checkpoints before the process exits
+	crawls.wg.Wait()
+	return err
+}
+
+/*
+	==============================
+	   SEARCH
+	==============================
+*/
+
+type searchServer struct {
+	pb.UnimplementedImageSearchServer
+	search *search.Client
+	crawls *crawlManager
+}
+
+// SearchImages pages through the search API with its cursors, streaming
+// each result as its page arrives.
+func (s *searchServer) SearchImages(req *pb.SearchImagesRequest, stream pb.ImageSearch_SearchImagesServer) error {
+	if s.search.BaseURL == "" {
+		return status.Error(codes.Unavailable, "IMG_SEARCH_API_URL is not set")
+	}
+	if strings.TrimSpace(req.GetQuery()) == "" {
+		return status.Error(codes.InvalidArgument, "query is empty")
+	}
+	remaining := int(req.GetLimit())
+	if remaining <= 0 {
+		remaining = DefaultStreamResults
+	}
+	remaining = min(remaining, MaxStreamResults)
+
+	q := search.Query{
+		Text:        req.GetQuery(),
+		Safe:        req.GetSafe(),
+		Semantic:    req.GetSemantic(),
+		License:     req.GetLicense(),
+		Format:      req.GetFormat(),
+		Domain:      req.GetDomain(),
+		Orientation: req.GetOrientation(),
+	}
+	for remaining > 0 {
+		q.Limit = min(remaining, search.PageSize)
+		page, err := s.search.Search(stream.Context(), q)
+		if err != nil {
+			return searchStatus(err)
+		}
+		for _, r := range page.Results {
+			err := stream.Send(&pb.ImageResult{
+				Id:           r.ID,
+				FileUrl:      r.FileURL,
+				Alt:          r.Alt,
+				Caption:      r.Caption,
+				PageUrl:      r.PageURL,
+				Domain:       r.Domain,
+				Format:       r.Format,
+				License:      r.License,
+				LicenseUrl:   r.LicenseURL,
+				ThumbnailUrl: r.ThumbnailURL,
+				FaviconUrl:   r.FaviconURL,
+				Width:        r.Width,
+				Height:       r.Height,
+				Score:        r.Score,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		remaining -= len(page.Results)
+		if page.NextCursor == "" || len(page.Results) == 0 {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	return nil
+}
+
+// searchStatus turns a search API failure into a gRPC status.
+func searchStatus(err error) error {
+	var apiErr *search.APIError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &apiErr):
+		switch {
+		case apiErr.Status == http.StatusBadRequest || apiErr.Status == http.StatusUnprocessableEntity:
+			return status.Error(codes.InvalidArgument, err.Error())
+		case apiErr.Status >= 500:
+			return status.Error(codes.Unavailable, err.Error())
+		}
+	case errors.As(err, &urlErr):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+/*
+	==============================
+	   CRAWL MANAGEMENT
+	==============================
+*/
+
+// crawlRun is one crawl started by SubmitSeeds.
+type crawlRun struct {
+	crawler  *imageCrawler
+	started  time.Time
+	finished time.Time
+	queued   int64
+	done     bool
+	err      error
+}
+
+// crawlManager runs one crawl at a time. Seeds submitted while a crawl
+// runs join its frontier; otherwise they start the next crawl, with a
+// fresh frontier and page budget.
+type crawlManager struct {
+	ctx   context.Context
+	store store.Store
+
+	mu  sync.Mutex
+	run *crawlRun
+	wg  sync.WaitGroup
+}
+
+func (s *searchServer) SubmitSeeds(ctx context.Context, req *pb.SubmitSeedsRequest) (*pb.SubmitSeedsResponse, error) {
+	if len(req.GetSeeds()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no seeds")
+	}
+	return s.crawls.submit(ctx, req.GetSeeds())
+}
+
+func (m *crawlManager) submit(ctx context.Context, seeds []string) (*pb.SubmitSeedsResponse, error) {
+	resp := &pb.SubmitSeedsResponse{}
+	var valid []string
+	for _, s := range seeds {
+		s = strings.TrimSpace(s)
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			resp.Rejected = append(resp.Rejected, s)
+			continue
+		}
+		valid = append(valid, s)
+	}
+	tasks := seedTasks(valid)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx.Err() != nil {
+		return nil, status.Error(codes.Unavailable, "server is shutting down")
+	}
+
+	run := m.run
+	if run == nil || run.done {
+		frontier, err := newFrontier(m.ctx, MaxImagePages, env.Int("IMG_MAX_PAGES_PER_DOMAIN", 0))
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		// read for each crawl, so a config reload applies to the next one
+		c, err := newImageCrawler(m.store, frontier, env.List("IMG_ALLOWED_SITES"))
+		if err != nil {
+			frontier.Close()
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		run = &crawlRun{crawler: c}
+	}
+
+	var start []Task
+	for _, t := range tasks {
+		if u, err := url.Parse(t.Link); err != nil || !run.crawler.hostAllowed(u) {
+			resp.Rejected = append(resp.Rejected, t.Link)
+			continue
+		}
+		start = append(start, t)
+	}
+	if len(start) > 0 {
+		if err := run.crawler.frontier.Push(ctx, start); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	resp.Queued = int32(len(start))
+	run.queued += int64(len(start))
+
+	if run != m.run {
+		if len(start) == 0 {
+			run.crawler.frontier.Close()
+			return resp, nil
+		}
+		resp.Started = true
+		run.started = time.Now().UTC()
+		m.run = run
+		run.crawler.beginRun(m.ctx, "serve", taskLinks(start))
+		m.wg.Add(1)
+		go m.crawl(run)
+	}
+	return resp, nil
+}
+
+// crawl runs one crawl to the end. Seeds submitted after its workers have
+// drained the frontier, but before it is marked done, are lost.
+func (m *crawlManager) crawl(run *crawlRun) {
+	defer m.wg.Done()
+	err := run.crawler.run(m.ctx)
+	if cerr := run.crawler.frontier.Close(); err == nil {
+		err = cerr
+	}
+	run.crawler.endRun(m.ctx, err)
+
+	if err != nil {
+		slog.Error("Crawl failed", "error", err)
+		notifyCrawl(m.ctx, CrawlFailed, run.crawler, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run.done = true
+	run.finished = time.Now().UTC()
+	run.err = err
+}
+
+func (s *searchServer) GetCrawlStatus(ctx context.Context, req *pb.GetCrawlStatusRequest) (*pb.CrawlStatus, error) {
+	return s.crawls.status(), nil
+}
+
+func (m *crawlManager) status() *pb.CrawlStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run := m.run
+	if run == nil {
+		return &pb.CrawlStatus{State: pb.CrawlState_CRAWL_STATE_IDLE}
+	}
+
+	st := &pb.CrawlStatus{
+		State:        pb.CrawlState_CRAWL_STATE_RUNNING,
+		StartedAt:    timestamppb.New(run.started),
+		PagesCrawled: run.crawler.pages.Load(),
+		ImagesSaved:  run.crawler.images.Load(),
+		SeedsQueued:  run.queued,
+	}
+	if run.done {
+		st.State = pb.CrawlState_CRAWL_STATE_FINISHED
+		st.FinishedAt = timestamppb.New(run.finished)
+		if run.err != nil && !errors.Is(run.err, context.Canceled) {
+			st.State = pb.CrawlState_CRAWL_STATE_FAILED
+			st.Error = run.err.Error()
+		}
+	}
+	return st
+}
@@ -0,0 +1,91 @@
+package crawler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   REQUEST HEADERS
+	==============================
+*/
+
+const DefaultUserAgent = "ImageCrawler/1.0"
+
+// crawlUserAgent is sent on every request and also decides which
+// robots.txt group applies to us.
+var crawlUserAgent = DefaultUserAgent
+
+// parseHeaderList reads "Name: value | Name: value". Pipes separate
+// entries because header values such as Accept-Language contain commas.
+func parseHeaderList(raw string) (http.Header, error) {
+	h := http.Header{}
+	for _, entry := range strings.Split(raw, "|") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q, expected Name: value", entry)
+		}
+		h.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return h, nil
+}
+
+// robotsAgentToken is the product token of the User-Agent, lowercased,
+// e.g. "imagecrawler" for "ImageCrawler/1.0 (+https://example.com)".
+func robotsAgentToken() string {
+	token := crawlUserAgent
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+	return strings.ToLower(token)
+}
+
+// headerTransport stamps the User-Agent and extra headers onto every
+// outgoing request. Headers a request already sets are left alone.
+type headerTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	extra     http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	for name, values := range t.extra {
+		if req.Header.Get(name) == "" {
+			req.Header[name] = values
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// configureHeaders reads IMG_USER_AGENT and IMG_HEADERS and wraps the
+// crawl transport so page, robots, sitemap and image requests all carry
+// them.
+func configureHeaders() error {
+	crawlUserAgent = env.Get("IMG_USER_AGENT", DefaultUserAgent)
+
+	extra, err := parseHeaderList(env.Get("IMG_HEADERS", ""))
+	if err != nil {
+		return fmt.Errorf("IMG_HEADERS: %w", err)
+	}
+
+	slog.Info("Using User-Agent", "user_agent", crawlUserAgent)
+	crawlTransport = &headerTransport{
+		base:      crawlTransport,
+		userAgent: crawlUserAgent,
+		extra:     extra,
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package crawler
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   TINY / TRACKING IMAGE FILTER
+	==============================
+*/
+
+const (
+	MinImageWidth  = 32
+	MinImageHeight = 32
+)
+
+// defaultTrackingPatterns match beacons and spacer images by URL.
+var defaultTrackingPatterns = []string{
+	`(?i)[/_.-](pixel|spacer|blank|transparent|clear|1x1|beacon|tracker)\.(gif|png)\b`,
+	`(?i)/__utm\.gif\b`,
+	`(?i)^https?://([^/?#]+\.)?(google-analytics\.com|doubleclick\.net|scorecardresearch\.com|quantserve\.com|bat\.bing\.com|pixel\.wp\.com|stats\.wp\.com|analytics\.twitter\.com)/`,
+	`(?i)^https?://([^/?#]+\.)?facebook\.com/tr\b`,
+}
+
+// imageSizeFilter drops images too small to be worth indexing and known
+// tracking pixels. Sizes come from the measured pixel size when the
+// enrichment stage has it, otherwise from the width/height attributes; an
+// image whose size is unknown is kept.
+type imageSizeFilter struct {
+	minWidth  int
+	minHeight int
+	tracking  []*regexp.Regexp
+}
+
+// loadImageSizeFilter reads IMG_MIN_WIDTH / IMG_MIN_HEIGHT (0 disables
+// the check) and IMG_TRACKING_PATTERNS, whitespace separated regexps added
+// to the built-in list.
+func loadImageSizeFilter() (*imageSizeFilter, error) {
+	patterns := append(slices.Clone(defaultTrackingPatterns), strings.Fields(env.Get("IMG_TRACKING_PATTERNS", ""))...)
+	tracking, err := compilePatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return &imageSizeFilter{
+		minWidth:  env.Int("IMG_MIN_WIDTH", MinImageWidth),
+		minHeight: env.Int("IMG_MIN_HEIGHT", MinImageHeight),
+		tracking:  tracking,
+	}, nil
+}
+
+// Tracking reports whether link looks like a beacon.
+func (f *imageSizeFilter) Tracking(link string) bool {
+	for _, re := range f.tracking {
+		if re.MatchString(link) {
+			return true
+		}
+	}
+	return false
+}
+
+// TooSmall reports whether a known width or height is under the minimum.
+// Zero means unknown.
+func (f *imageSizeFilter) TooSmall(w, h int) bool {
+	return (w > 0 && w < f.minWidth) || (h > 0 && h < f.minHeight)
+}
+
+// Filter keeps the images worth storing and returns how many it dropped.
+func (f *imageSizeFilter) Filter(images []store.ImageRecord) ([]store.ImageRecord, int) {
+	out := images[:0]
+	for _, img := range images {
+		if f.Tracking(img.FileURL) {
+			continue
+		}
+		w, h := img.PixelWidth, img.PixelHeight
+		if w == 0 && h == 0 {
+			w, h = attrPixels(img.Width), attrPixels(img.Height)
+		}
+		if f.TooSmall(w, h) {
+			continue
+		}
+		out = append(out, img)
+	}
+	return out, len(images) - len(out)
+}
+
+// attrPixels reads a width/height attribute ("16", "16px"). Percentages
+// and other units say nothing about the file and count as unknown.
+func attrPixels(v string) int {
+	v = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(v)), "px")
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return max(int(n), 1)
+}
@@ -0,0 +1,77 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   MODEL INFERENCE ENDPOINTS
+	==============================
+*/
+
+const (
+	InferenceTimeout    = 30 * time.Second
+	MaxInferenceReplies = 1024 * 1024
+)
+
+// inferenceEndpoint is an HTTP model server the enrichment stage posts
+// image bytes to and reads a JSON answer from. Models run out of process
+// (an ONNX runtime sidecar, a hosted API, ...) so the crawler stays a
+// plain Go binary.
+type inferenceEndpoint struct {
+	url   string
+	token string
+
+	// not crawlClient: model servers are trusted and usually local
+	client *http.Client
+}
+
+// newInferenceEndpoint reads <prefix>_URL and the optional <prefix>_TOKEN
+// (sent as a bearer token). It returns nil when no URL is configured.
+func newInferenceEndpoint(prefix string) *inferenceEndpoint {
+	link := env.Get(prefix+"_URL", "")
+	if link == "" {
+		return nil
+	}
+	return &inferenceEndpoint{
+		url:    link,
+		token:  env.Get(prefix+"_TOKEN", ""),
+		client: &http.Client{Timeout: env.Duration("IMG_INFERENCE_TIMEOUT", InferenceTimeout)},
+	}
+}
+
+// Post sends the image and decodes the JSON reply into out.
+func (p *inferenceEndpoint) Post(ctx context.Context, data []byte, contentType string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body := io.LimitReader(resp.Body, MaxInferenceReplies)
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(body, 512))
+		return fmt.Errorf("%s: %d %s", p.url, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(body).Decode(out)
+}
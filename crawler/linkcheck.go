@@ -0,0 +1,224 @@
+package crawler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   DEAD-LINK CHECKER
+	==============================
+*/
+
+const (
+	LinkCheckInterval = 7 * 24 * time.Hour
+	LinkCheckBatch    = 500
+	LinkCheckWorkers  = 4
+	// how often the recrawl scheduler runs a pass
+	LinkCheckPassInterval = time.Hour
+)
+
+// linkChecker re-validates stored file URLs with HEAD requests, the ones
+// checked longest ago first, through the per-host limiter and robots.txt.
+// A 404 or 410 marks the image dead (dead_since), which keeps it out of
+// the search index, and a later check finding it clears the mark. Other
+// answers, and no answer, prove nothing either way. With
+// IMG_DEAD_GRACE_DAYS set, images dead that long are removed.
+type linkChecker struct {
+	col     *mongo.Collection
+	limiter *hostLimiter
+	robots  *robotsCache
+
+	interval time.Duration // between checks of one image
+	batch    int64
+	workers  int
+	grace    time.Duration
+}
+
+func newLinkChecker(col *mongo.Collection, limiter *hostLimiter, robots *robotsCache) *linkChecker {
+	lc := &linkChecker{
+		col:      col,
+		limiter:  limiter,
+		robots:   robots,
+		interval: env.Duration("IMG_LINKCHECK_INTERVAL", LinkCheckInterval),
+		batch:    int64(max(env.Int("IMG_LINKCHECK_BATCH", LinkCheckBatch), 1)),
+		workers:  max(env.Int("IMG_LINKCHECK_WORKERS", LinkCheckWorkers), 1),
+	}
+	if days := env.Int("IMG_DEAD_GRACE_DAYS", 0); days > 0 {
+		lc.grace = time.Duration(days) * 24 * time.Hour
+	}
+	return lc
+}
+
+// linkCheckTarget is the part of a record a check needs.
+type linkCheckTarget struct {
+	ID      primitive.ObjectID `bson:"_id"`
+	FileURL string             `bson:"file_url"`
+	PageURL string             `bson:"page_url"`
+}
+
+// pass checks one batch of images that are due and then removes those
+// dead past the grace period.
+func (lc *linkChecker) pass(ctx context.Context, now time.Time) error {
+	filter := bson.M{
+		"deleted_at": store.Live,
+		"file_url":   bson.M{"$regex": "^https?://"},
+		"$or": bson.A{
+			bson.M{"checked_at": bson.M{"$lt": now.Add(-lc.interval)}},
+			bson.M{"checked_at": bson.M{"$exists": false}},
+		},
+	}
+	opts := options.Find().
+		SetSort(bson.M{"checked_at": 1}).
+		SetLimit(lc.batch).
+		SetProjection(bson.M{"file_url": 1, "page_url": 1})
+	cur, err := lc.col.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	var due []linkCheckTarget
+	if err := cur.All(ctx, &due); err != nil {
+		return err
+	}
+
+	var alive, dead, unknown atomic.Int64
+	jobs := make(chan linkCheckTarget)
+	var wg sync.WaitGroup
+	for i := 0; i < lc.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range jobs {
+				status, found := lc.check(ctx, t)
+				switch {
+				case status == http.StatusNotFound || status == http.StatusGone:
+					dead.Add(1)
+				case found:
+					alive.Add(1)
+				default:
+					unknown.Add(1)
+				}
+				if err := lc.record(ctx, t, status, found, time.Now().UTC()); err != nil {
+					slog.Error("Recording link check failed", "url", t.FileURL, "error", err)
+				}
+			}
+		}()
+	}
+	for _, t := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- t
+	}
+	close(jobs)
+	wg.Wait()
+	if len(due) > 0 {
+		slog.Info("Checked image links", "alive", alive.Load(), "dead", dead.Load(), "inconclusive", unknown.Load())
+	}
+
+	if lc.grace <= 0 || ctx.Err() != nil {
+		return nil
+	}
+	n, err := store.RemoveMatching(ctx, lc.col, bson.M{"dead_since": bson.M{"$lt": now.Add(-lc.grace)}}, store.TombstoneDead)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		slog.Info("Removed dead images", "count", n, "grace", lc.grace)
+	}
+	return nil
+}
+
+// check asks the host for one image. It returns the status, 0 when there
+// was no answer, and whether the image is there. Hosts that refuse HEAD
+// get a one-byte GET instead.
+func (lc *linkChecker) check(ctx context.Context, t linkCheckTarget) (int, bool) {
+	u, err := url.Parse(t.FileURL)
+	if err != nil || !lc.robots.Allowed(u) {
+		return 0, false
+	}
+	if err := lc.limiter.Wait(ctx, u); err != nil {
+		return 0, false
+	}
+	res, _ := headRequest(ctx, t.PageURL, t.FileURL)
+	if res.status != http.StatusMethodNotAllowed && res.status != http.StatusNotImplemented {
+		return res.status, res.ok
+	}
+
+	if err := lc.limiter.Wait(ctx, u); err != nil {
+		return 0, false
+	}
+	status := firstByteStatus(ctx, t.PageURL, t.FileURL)
+	return status, status == http.StatusOK || status == http.StatusPartialContent
+}
+
+// firstByteStatus GETs the first byte of link and returns the status, 0
+// when the request failed.
+func firstByteStatus(ctx context.Context, page, link string) int {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return 0
+	}
+	req.Header.Set("Accept", "image/*")
+	req.Header.Set("Referer", page)
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := crawlClient.Do(req)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// record stores a check's outcome. dead_since keeps the first 404 or 410
+// in a row, so the grace period runs from there.
+func (lc *linkChecker) record(ctx context.Context, t linkCheckTarget, status int, found bool, at time.Time) error {
+	update := bson.M{"$set": bson.M{"checked_at": at, "check_status": status}}
+	switch {
+	case status == http.StatusNotFound || status == http.StatusGone:
+		update["$min"] = bson.M{"dead_since": at}
+	case found:
+		update["$unset"] = bson.M{"dead_since": ""}
+	}
+	_, err := lc.col.UpdateOne(ctx, bson.M{"_id": t.ID, "deleted_at": store.Live}, update)
+	return err
+}
+
+// run makes a pass every LinkCheckPassInterval until ctx is done.
+func (lc *linkChecker) run(ctx context.Context) {
+	t := time.NewTicker(LinkCheckPassInterval)
+	defer t.Stop()
+	for {
+		if err := lc.pass(ctx, time.Now().UTC()); err != nil && ctx.Err() == nil {
+			slog.Error("Link check failed", "error", err)
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// CheckLinks makes one pass (IMG_MODE=linkcheck), e.g. from cron. The
+// recrawl scheduler also runs them in the background with
+// IMG_LINKCHECK=true.
+func CheckLinks(ctx context.Context, col *mongo.Collection) error {
+	robots := loadRobotsCache()
+	return newLinkChecker(col, loadHostLimiter(), robots).pass(ctx, time.Now().UTC())
+}
@@ -0,0 +1,136 @@
+package crawler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   LOGGING
+	==============================
+*/
+
+// ConfigureLogging installs the default logger: IMG_LOG_LEVEL (debug, info,
+// warn or error) and IMG_LOG_FORMAT (text, or json for log aggregation).
+// The standard log package, which some dependencies write to, goes through
+// it at info level.
+func ConfigureLogging(w io.Writer) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(env.Get("IMG_LOG_LEVEL", "info"))); err != nil {
+		return fmt.Errorf("IMG_LOG_LEVEL: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch format := strings.ToLower(env.Get("IMG_LOG_FORMAT", "text")); format {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("IMG_LOG_FORMAT must be text or json, not %q", format)
+	}
+	slog.SetDefault(slog.New(fieldHandler{h}))
+	return nil
+}
+
+// fieldHandler derives fields so call sites don't have to: domain from a
+// url attribute, and error_class from an error attribute. It also keeps
+// the latest errors for the admin dashboard.
+type fieldHandler struct {
+	slog.Handler
+}
+
+func (h fieldHandler) Handle(ctx context.Context, r slog.Record) error {
+	var extra []slog.Attr
+	hasDomain := false
+	logged := loggedError{Time: r.Time, Message: r.Message}
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case "domain":
+			hasDomain = true
+		case "url":
+			logged.URL = a.Value.String()
+			if u, err := url.Parse(logged.URL); err == nil && u.Hostname() != "" {
+				extra = append(extra, slog.String("domain", u.Hostname()))
+			}
+		case "error":
+			logged.Error = a.Value.String()
+			if err, ok := a.Value.Any().(error); ok {
+				logged.Class = errorClass(err)
+				extra = append(extra, slog.String("error_class", logged.Class))
+			}
+		}
+		return true
+	})
+	if r.Level >= slog.LevelError {
+		recentErrors.add(logged)
+	}
+	for _, a := range extra {
+		if a.Key == "domain" && hasDomain {
+			continue
+		}
+		r.AddAttrs(a)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h fieldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return fieldHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h fieldHandler) WithGroup(name string) slog.Handler {
+	return fieldHandler{h.Handler.WithGroup(name)}
+}
+
+// errorClass sorts an error into a coarse class to count and alert on.
+func errorClass(err error) string {
+	var (
+		status  *httpStatusError
+		dnsErr  *net.DNSError
+		netErr  net.Error
+		certErr *tls.CertificateVerificationError
+		authErr x509.UnknownAuthorityError
+		hostErr x509.HostnameError
+		recErr  tls.RecordHeaderError
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &status):
+		switch {
+		case status.Status == http.StatusTooManyRequests:
+			return "rate_limited"
+		case status.Status >= 500:
+			return "http_5xx"
+		default:
+			return "http_4xx"
+		}
+	case errors.Is(err, errNonPublicAddress):
+		return "blocked"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &certErr), errors.As(err, &authErr), errors.As(err, &hostErr), errors.As(err, &recErr):
+		return "tls"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection"
+	}
+	return "other"
+}
@@ -0,0 +1,49 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+)
+
+/*
+	==============================
+	   NSFW SCORING
+	==============================
+*/
+
+// nsfwScorer asks the IMG_NSFW_URL model server how likely an image is
+// to be explicit. The server gets the raw image bytes and answers
+//
+//	{"nsfw_score": 0.93}
+//
+// ("score" is accepted too), with 0 meaning safe and 1 explicit.
+type nsfwScorer struct {
+	endpoint *inferenceEndpoint
+}
+
+func newNSFWScorer() *nsfwScorer {
+	endpoint := newInferenceEndpoint("IMG_NSFW")
+	if endpoint == nil {
+		return nil
+	}
+	return &nsfwScorer{endpoint: endpoint}
+}
+
+func (s *nsfwScorer) Score(ctx context.Context, data []byte, contentType string) (float64, error) {
+	var reply struct {
+		NSFWScore *float64 `json:"nsfw_score"`
+		Score     *float64 `json:"score"`
+	}
+	if err := s.endpoint.Post(ctx, data, contentType, &reply); err != nil {
+		return 0, err
+	}
+
+	score := reply.NSFWScore
+	if score == nil {
+		score = reply.Score
+	}
+	if score == nil || *score < 0 || *score > 1 {
+		return 0, fmt.Errorf("nsfw reply has no score in [0,1]")
+	}
+	return *score, nil
+}
@@ -0,0 +1,146 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math/bits"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+	==============================
+	   NEAR-DUPLICATE DETECTION
+	==============================
+*/
+
+const (
+	DupHashDistance  = 3
+	MaxDupCandidates = 50
+
+	// the 64-bit hash is stored as this many 16-bit bands; two hashes
+	// within distance bands-1 share at least one band exactly, so the
+	// band index finds every candidate
+	dHashBands = 4
+)
+
+// dHash is a 64-bit difference hash: the image is shrunk to 9x8 grey
+// cells and each bit records whether a cell is brighter than its right
+// neighbour. Rescaling, recompression and small colour shifts leave it
+// (nearly) unchanged.
+func dHash(img image.Image) uint64 {
+	g := grayThumb(img, 9, 8)
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if g[y*9+x] < g[y*9+x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// grayThumb averages the luminance of img over a w x h grid. Large cells
+// are sampled on a 16x16 lattice, which is plenty for a hash and keeps
+// multi-megapixel photos cheap.
+func grayThumb(img image.Image, w, h int) []float64 {
+	b := img.Bounds()
+	out := make([]float64, w*h)
+	for cy := 0; cy < h; cy++ {
+		y0, y1 := cellSpan(b.Min.Y, b.Dy(), cy, h)
+		for cx := 0; cx < w; cx++ {
+			x0, x1 := cellSpan(b.Min.X, b.Dx(), cx, w)
+			sx, sy := max((x1-x0)/16, 1), max((y1-y0)/16, 1)
+
+			var sum float64
+			var n int
+			for y := y0; y < y1; y += sy {
+				for x := x0; x < x1; x += sx {
+					r, g, bl, _ := img.At(x, y).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+					n++
+				}
+			}
+			if n > 0 {
+				out[cy*w+cx] = sum / float64(n)
+			}
+		}
+	}
+	return out
+}
+
+// cellSpan is the [start, end) range of cell i out of n over size pixels;
+// every cell covers at least one pixel, even on images smaller than the grid.
+func cellSpan(origin, size, i, n int) (int, int) {
+	start := origin + i*size/n
+	end := origin + (i+1)*size/n
+	if end <= start {
+		end = start + 1
+	}
+	if start >= origin+size {
+		start, end = origin+size-1, origin+size
+	}
+	return start, end
+}
+
+func formatHash(h uint64) string {
+	return fmt.Sprintf("%016x", h)
+}
+
+// hashBands splits h into the tagged bands stored for candidate lookup.
+func hashBands(h uint64) []string {
+	out := make([]string, dHashBands)
+	width := 64 / dHashBands
+	for i := range out {
+		shift := 64 - width*(i+1)
+		out[i] = fmt.Sprintf("%d:%0*x", i, width/4, (h>>shift)&(1<<width-1))
+	}
+	return out
+}
+
+// dupGroup finds the group of a stored image within maxDist bits of h,
+// other than link itself. An image with no near twin starts its own group,
+// named after its own file URL.
+func dupGroup(ctx context.Context, col *mongo.Collection, link string, h uint64, maxDist int) (string, error) {
+	filter := bson.M{
+		"dhash_bands": bson.M{"$in": hashBands(h)},
+		"file_url":    bson.M{"$ne": link},
+	}
+	opts := options.Find().
+		SetProjection(bson.M{"file_url": 1, "dhash": 1, "dup_group": 1}).
+		SetLimit(MaxDupCandidates)
+
+	cur, err := col.Find(ctx, filter, opts)
+	if err != nil {
+		return "", err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var c struct {
+			FileURL  string `bson:"file_url"`
+			DHash    string `bson:"dhash"`
+			DupGroup string `bson:"dup_group"`
+		}
+		if err := cur.Decode(&c); err != nil {
+			return "", err
+		}
+		var other uint64
+		if _, err := fmt.Sscanf(c.DHash, "%x", &other); err != nil {
+			continue
+		}
+		if bits.OnesCount64(h^other) > maxDist {
+			continue
+		}
+		if c.DupGroup != "" {
+			return c.DupGroup, nil
+		}
+		return c.FileURL, nil
+	}
+	return link, cur.Err()
+}
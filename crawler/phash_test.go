@@ -0,0 +1,107 @@
+package crawler
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+	"reflect"
+	"testing"
+)
+
+// gradient draws a w x h grey image whose brightness at (x, y) is shade.
+func gradient(w, h int, shade func(x, y int) uint8) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetGray(x, y, color.Gray{Y: shade(x, y)})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	rising := func(w int) func(x, y int) uint8 { return func(x, _ int) uint8 { return uint8(x * 255 / w) } }
+	falling := func(w int) func(x, y int) uint8 { return func(x, _ int) uint8 { return uint8(255 - x*255/w) } }
+	checker := func(x, y int) uint8 {
+		if (x/20+y/20)%2 == 0 {
+			return 200
+		}
+		return 30
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+		want uint64
+	}{
+		{name: "brighter to the right", img: gradient(180, 80, rising(180)), want: 0xffffffffffffffff},
+		{name: "darker to the right", img: gradient(180, 80, falling(180)), want: 0},
+		{name: "flat", img: gradient(50, 50, func(int, int) uint8 { return 128 }), want: 0},
+		// cells repeat pixels: 0 0 0 85 85 85 170 170 170 on every row
+		{name: "smaller than the grid", img: gradient(3, 2, rising(3)), want: 0x2424242424242424},
+		{name: "offset bounds", img: gradient(180, 80, rising(180)).(*image.Gray).SubImage(image.Rect(90, 0, 180, 80)), want: 0xffffffffffffffff},
+		{name: "rescaled copy", img: gradient(180, 160, checker), want: dHash(gradient(90, 80, func(x, y int) uint8 { return checker(x*2, y*2) }))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dHash(tt.img); got != tt.want {
+				t.Errorf("dHash = %016x, want %016x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashBands(t *testing.T) {
+	tests := []struct {
+		hash uint64
+		want []string
+	}{
+		{hash: 0, want: []string{"0:0000", "1:0000", "2:0000", "3:0000"}},
+		{hash: 0x0123456789abcdef, want: []string{"0:0123", "1:4567", "2:89ab", "3:cdef"}},
+		{hash: 0xffff00000000ffff, want: []string{"0:ffff", "1:0000", "2:0000", "3:ffff"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(formatHash(tt.hash), func(t *testing.T) {
+			if got := hashBands(tt.hash); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hashBands(%016x) = %v, want %v", tt.hash, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashBandsFindNearHashes(t *testing.T) {
+	// hashes within dHashBands-1 bits always share a band, wherever the
+	// differing bits fall
+	base := uint64(0x0123456789abcdef)
+	flips := [][]int{{0}, {15, 16}, {3, 20, 40}, {63, 47, 31}, {0, 1, 2}}
+	for _, f := range flips {
+		other := base
+		for _, b := range f {
+			other ^= 1 << b
+		}
+		if d := bits.OnesCount64(base ^ other); d > dHashBands-1 {
+			t.Fatalf("test flips %d bits", d)
+		}
+		if !sharesBand(hashBands(base), hashBands(other)) {
+			t.Errorf("%016x and %016x share no band", base, other)
+		}
+	}
+
+	// one flipped bit in every band does not
+	if sharesBand(hashBands(base), hashBands(base^(1|1<<16|1<<32|1<<48))) {
+		t.Error("hashes differing in every band share one")
+	}
+}
+
+func sharesBand(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package crawler
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	==============================
+	   POLITENESS STATS
+	==============================
+*/
+
+// hostCounters is what trafficTransport counts for one host.
+type hostCounters struct {
+	requests  int64
+	errors    int64 // no answer, 429 or 5xx
+	throttled int64 // 429 or 503
+	latency   time.Duration
+}
+
+// trafficStats counts crawler requests per host, for every crawl in the
+// process. Latency is the time to the response headers.
+type trafficStats struct {
+	mu    sync.Mutex
+	hosts map[string]*hostCounters
+}
+
+var hostTraffic = &trafficStats{hosts: map[string]*hostCounters{}}
+
+func (s *trafficStats) record(host string, status int, latency time.Duration) {
+	host = strings.ToLower(host)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.hosts[host]
+	if h == nil {
+		h = &hostCounters{}
+		s.hosts[host] = h
+	}
+	h.requests++
+	h.latency += latency
+	if status == 0 || status == http.StatusTooManyRequests || status >= 500 {
+		h.errors++
+	}
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		h.throttled++
+	}
+}
+
+// trafficTransport feeds hostTraffic. It wraps the finished crawl
+// transport, so it sees every page, robots.txt, sitemap and image request.
+type trafficTransport struct {
+	base http.RoundTripper
+}
+
+func (t *trafficTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	status := 0
+	if err == nil {
+		status = resp.StatusCode
+	}
+	hostTraffic.record(req.URL.Hostname(), status, time.Since(start))
+	return resp, err
+}
+
+// hostBackoff is a host's state in a hostLimiter.
+type hostBackoff struct {
+	interval   time.Duration
+	crawlDelay time.Duration
+	slowed     bool
+	paused     time.Time
+}
+
+// Backoff returns the state of host's bucket, and false if the host was
+// never fetched through l.
+func (l *hostLimiter) Backoff(host string) (hostBackoff, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[host]
+	if !ok {
+		return hostBackoff{}, false
+	}
+	return hostBackoff{
+		interval:   b.interval,
+		crawlDelay: b.floor,
+		slowed:     b.interval > max(b.base, b.floor),
+		paused:     b.paused,
+	}, true
+}
+
+// politenessStats is one host's row in the status API.
+type politenessStats struct {
+	Domain      string     `json:"domain"`
+	Requests    int64      `json:"requests"`
+	Errors      int64      `json:"errors"`
+	Throttled   int64      `json:"throttled"`
+	ErrorRate   float64    `json:"error_rate"`
+	AvgLatency  float64    `json:"avg_latency_ms"`
+	Interval    float64    `json:"interval_ms"`
+	CrawlDelay  float64    `json:"crawl_delay_ms,omitempty"`
+	SlowedDown  bool       `json:"slowed_down,omitempty"`
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+}
+
+// politeness returns up to n hosts (all when n <= 0), the ones pushing back
+// hardest first: throttled, then by error rate, then by traffic. limiter,
+// when set, adds each host's backoff state.
+func (s *trafficStats) politeness(limiter *hostLimiter, n int, now time.Time) []politenessStats {
+	s.mu.Lock()
+	out := make([]politenessStats, 0, len(s.hosts))
+	for host, h := range s.hosts {
+		out = append(out, politenessStats{
+			Domain:     host,
+			Requests:   h.requests,
+			Errors:     h.errors,
+			Throttled:  h.throttled,
+			ErrorRate:  float64(h.errors) / float64(h.requests),
+			AvgLatency: h.latency.Seconds() * 1000 / float64(h.requests),
+		})
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(out, func(a, b politenessStats) int {
+		switch {
+		case a.Throttled != b.Throttled:
+			return int(b.Throttled - a.Throttled)
+		case a.ErrorRate != b.ErrorRate:
+			if a.ErrorRate > b.ErrorRate {
+				return -1
+			}
+			return 1
+		case a.Requests != b.Requests:
+			return int(b.Requests - a.Requests)
+		}
+		return strings.Compare(a.Domain, b.Domain)
+	})
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+
+	if limiter != nil {
+		for i := range out {
+			b, ok := limiter.Backoff(out[i].Domain)
+			if !ok {
+				continue
+			}
+			out[i].Interval = float64(b.interval.Milliseconds())
+			out[i].CrawlDelay = float64(b.crawlDelay.Milliseconds())
+			out[i].SlowedDown = b.slowed
+			if b.paused.After(now) {
+				paused := b.paused.UTC()
+				out[i].PausedUntil = &paused
+			}
+		}
+	}
+	return out
+}
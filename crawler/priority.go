@@ -0,0 +1,121 @@
+package crawler
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+)
+
+/*
+	==============================
+	   FRONTIER PRIORITY
+	==============================
+*/
+
+const (
+	GalleryPathBoost = 3.0
+	DepthPenalty     = 0.5
+)
+
+// galleryHints are path fragments that usually mark image-heavy pages.
+var galleryHints = []string{
+	"gallery", "galleries", "album", "photo", "picture", "pics",
+	"image", "portfolio", "wallpaper", "slideshow", "media",
+}
+
+// linkPriority estimates how many images a link will yield. Links found on
+// image-rich pages inherit part of their parent's yield, gallery-looking
+// paths get a fixed boost, and each level of depth costs a little so that
+// equally promising links stay roughly breadth-first.
+func linkPriority(link *url.URL, parentYield, level int) float64 {
+	score := math.Log1p(float64(parentYield))
+
+	path := strings.ToLower(link.Path)
+	for _, hint := range galleryHints {
+		if strings.Contains(path, hint) {
+			score += GalleryPathBoost
+			break
+		}
+	}
+
+	return score - float64(level)*DepthPenalty
+}
+
+/*
+	==============================
+	   CRAWL STRATEGY
+	==============================
+*/
+
+// crawlStrategy decides the order the frontier hands out tasks in. The
+// zero value is best-first.
+type crawlStrategy string
+
+const (
+	StrategyBestFirst crawlStrategy = "best-first"
+	StrategyBFS       crawlStrategy = "bfs"
+	StrategyDFS       crawlStrategy = "dfs"
+)
+
+func parseStrategy(raw string) (crawlStrategy, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", "best", "best-first":
+		return StrategyBestFirst, nil
+	case "bfs", "breadth-first":
+		return StrategyBFS, nil
+	case "dfs", "depth-first":
+		return StrategyDFS, nil
+	}
+	return "", fmt.Errorf("unknown crawl strategy %q (want bfs, dfs or best-first)", raw)
+}
+
+// score is the queue rank of t; higher pops first. BFS drains a level
+// before moving deeper, DFS always follows the deepest link found so far
+// (exhausting one section before the next), and best-first uses the
+// estimated image yield.
+func (s crawlStrategy) score(t Task) float64 {
+	switch s {
+	case StrategyBFS:
+		return -float64(t.Level)
+	case StrategyDFS:
+		return float64(t.Level)
+	}
+	return t.Priority
+}
+
+/*
+	==============================
+	   TASK HEAP
+	==============================
+*/
+
+type queuedTask struct {
+	Task
+	score float64
+	seq   uint64
+}
+
+// taskHeap is a max-heap on score; ties pop in insertion order.
+type taskHeap []queuedTask
+
+func (h taskHeap) Len() int { return len(h) }
+
+func (h taskHeap) Less(i, j int) bool {
+	if h[i].score != h[j].score {
+		return h[i].score > h[j].score
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h taskHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *taskHeap) Push(x interface{}) { *h = append(*h, x.(queuedTask)) }
+
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
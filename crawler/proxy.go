@@ -0,0 +1,176 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   PROXY CONFIG
+	==============================
+*/
+
+const (
+	ProxyMaxFailures = 3
+	ProxyCooldown    = 5 * time.Minute
+)
+
+// crawlTransport is used by every outbound crawler request. It starts as
+// the tuned transport from configureTransport and is replaced or wrapped
+// by the dial guard, proxies and header settings.
+var crawlTransport http.RoundTripper = http.DefaultTransport
+
+// loadProxyList reads proxies from IMG_PROXIES (comma separated) and
+// IMG_PROXY_FILE (one per line, # comments allowed). Supported schemes are
+// http, https and socks5.
+func loadProxyList() ([]*url.URL, error) {
+	raw := env.List("IMG_PROXIES")
+
+	if path := env.Get("IMG_PROXY_FILE", ""); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				raw = append(raw, line)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var out []*url.URL
+	for _, r := range raw {
+		u, err := url.Parse(r)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %q: %w", r, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("proxy %q: unsupported scheme %q", r, u.Scheme)
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+// configureProxies installs a rotating proxy transport when proxies are
+// configured.
+func configureProxies() error {
+	proxies, err := loadProxyList()
+	if err != nil {
+		return err
+	}
+	if len(proxies) == 0 {
+		return nil
+	}
+	slog.Info("Rotating requests across proxies", "proxies", len(proxies))
+	crawlTransport = newProxyTransport(proxies)
+	return nil
+}
+
+/*
+	==============================
+	   PROXY ROTATION
+	==============================
+*/
+
+type proxyState struct {
+	url      *url.URL
+	failures int
+	downTill time.Time
+}
+
+type proxyCtxKey struct{}
+
+// proxyTransport picks the next healthy proxy for each request in
+// round-robin order. A proxy that fails ProxyMaxFailures times in a row is
+// benched for ProxyCooldown.
+type proxyTransport struct {
+	base *http.Transport
+
+	mu      sync.Mutex
+	proxies []*proxyState
+	next    int
+}
+
+func newProxyTransport(proxies []*url.URL) *proxyTransport {
+	t := &proxyTransport{}
+	for _, p := range proxies {
+		t.proxies = append(t.proxies, &proxyState{url: p})
+	}
+
+	t.base = newTunedTransport(nil)
+	t.base.Proxy = func(req *http.Request) (*url.URL, error) {
+		p, _ := req.Context().Value(proxyCtxKey{}).(*proxyState)
+		if p == nil {
+			return nil, nil
+		}
+		return p.url, nil
+	}
+	return t
+}
+
+// pick returns the next healthy proxy. If every proxy is benched, the one
+// due back soonest is used rather than stalling the crawl.
+func (t *proxyTransport) pick() *proxyState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var soonest *proxyState
+	for i := 0; i < len(t.proxies); i++ {
+		p := t.proxies[(t.next+i)%len(t.proxies)]
+		if !p.downTill.After(now) {
+			t.next = (t.next + i + 1) % len(t.proxies)
+			return p
+		}
+		if soonest == nil || p.downTill.Before(soonest.downTill) {
+			soonest = p
+		}
+	}
+	return soonest
+}
+
+func (t *proxyTransport) report(p *proxyState, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if ok {
+		p.failures = 0
+		return
+	}
+	p.failures++
+	if p.failures >= ProxyMaxFailures {
+		p.downTill = time.Now().Add(ProxyCooldown)
+		p.failures = 0
+		slog.Warn("Proxy marked unhealthy", "proxy", p.url.Redacted(), "cooldown", ProxyCooldown)
+	}
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := t.pick()
+	req = req.WithContext(context.WithValue(req.Context(), proxyCtxKey{}, p))
+
+	resp, err := t.base.RoundTrip(req)
+	t.report(p, err == nil && resp.StatusCode != http.StatusProxyAuthRequired)
+	return resp, err
+}
@@ -0,0 +1,235 @@
+package crawler
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   PER-HOST RATE LIMITING
+	==============================
+*/
+
+// MaxHostInterval caps how far SlowDown stretches a host's interval.
+const MaxHostInterval = time.Minute
+
+// tokenBucket hands out one token per interval, allowing up to burst
+// requests back to back. Tokens may go negative: callers reserve a slot
+// and sleep until it comes due, which keeps waiters in FIFO order.
+type tokenBucket struct {
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+	paused   time.Time
+
+	base  time.Duration // the configured interval
+	floor time.Duration // the host's robots.txt Crawl-delay
+}
+
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	if b.interval <= 0 {
+		return 0
+	}
+
+	elapsed := now.Sub(b.last)
+	b.last = now
+	b.tokens += float64(elapsed) / float64(b.interval)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens * float64(b.interval))
+}
+
+// hostLimiter keeps one token bucket per host. Hosts crawl at the default
+// interval unless an override matches their domain.
+type hostLimiter struct {
+	interval  time.Duration
+	burst     int
+	overrides map[string]time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newHostLimiter(interval time.Duration, burst int, overrides map[string]time.Duration) *hostLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &hostLimiter{
+		interval:  interval,
+		burst:     burst,
+		overrides: overrides,
+		buckets:   map[string]*tokenBucket{},
+	}
+}
+
+// loadHostLimiter builds the limiter from IMG_DELAY, IMG_HOST_BURST and
+// IMG_HOST_DELAYS.
+func loadHostLimiter() *hostLimiter {
+	return newHostLimiter(
+		env.Duration("IMG_DELAY", ImageDelay),
+		env.Int("IMG_HOST_BURST", 1),
+		parseHostDelays(env.List("IMG_HOST_DELAYS")),
+	)
+}
+
+// Reconfigure applies new settings, read as loadHostLimiter does, without
+// forgetting what hosts told the crawler: a Crawl-delay still applies, as
+// do pauses, and a host slowed down after a 429 stays at least that slow.
+func (l *hostLimiter) Reconfigure() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.interval = env.Duration("IMG_DELAY", ImageDelay)
+	l.burst = max(env.Int("IMG_HOST_BURST", 1), 1)
+	l.overrides = parseHostDelays(env.List("IMG_HOST_DELAYS"))
+
+	for host, b := range l.buckets {
+		configured := l.intervalFor(host)
+		slowed := b.interval > max(b.base, b.floor)
+		b.base = configured
+		if slowed {
+			b.interval = max(b.interval, configured)
+		} else {
+			b.interval = max(configured, b.floor)
+		}
+		if b.floor == 0 {
+			b.burst = float64(l.burst)
+		}
+		b.tokens = min(b.tokens, b.burst)
+	}
+}
+
+// parseHostDelays reads "example.com=1s,cdn.example.net=200ms".
+func parseHostDelays(entries []string) map[string]time.Duration {
+	out := map[string]time.Duration{}
+	for _, e := range entries {
+		host, val, ok := strings.Cut(e, "=")
+		if !ok {
+			slog.Warn("Invalid host delay, expected host=duration", "value", e)
+			continue
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(val))
+		if err != nil {
+			slog.Warn("Invalid host delay", "value", e, "error", err)
+			continue
+		}
+		out[strings.TrimSpace(host)] = d
+	}
+	return out
+}
+
+// intervalFor returns the most specific override for host.
+func (l *hostLimiter) intervalFor(host string) time.Duration {
+	interval := l.interval
+	best := -1
+	for d, iv := range l.overrides {
+		if (host == d || strings.HasSuffix(host, "."+d)) && len(d) > best {
+			best = len(d)
+			interval = iv
+		}
+	}
+	return interval
+}
+
+// bucket returns the host's bucket; l.mu must be held.
+func (l *hostLimiter) bucket(host string) *tokenBucket {
+	b, ok := l.buckets[host]
+	if !ok {
+		interval := l.intervalFor(host)
+		b = &tokenBucket{
+			interval: interval,
+			burst:    float64(l.burst),
+			tokens:   float64(l.burst),
+			last:     time.Now(),
+			base:     interval,
+		}
+		l.buckets[host] = b
+	}
+	return b
+}
+
+// SetMinInterval makes sure the host of u is fetched at most once per d,
+// e.g. because its robots.txt asks for a Crawl-delay.
+func (l *hostLimiter) SetMinInterval(u *url.URL, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(u.Hostname())
+	b.floor = max(b.floor, d)
+	if b.interval < d {
+		b.interval = d
+		b.burst = 1
+		if b.tokens > 1 {
+			b.tokens = 1
+		}
+	}
+}
+
+// SlowDown doubles the host's interval, up to MaxHostInterval, after it
+// answered 429 Too Many Requests.
+func (l *hostLimiter) SlowDown(u *url.URL) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(u.Hostname())
+	if b.interval <= 0 {
+		b.interval = ImageDelay
+	} else {
+		b.interval *= 2
+	}
+	if b.interval > MaxHostInterval {
+		b.interval = MaxHostInterval
+	}
+	slog.Info("Slowing down host", "domain", u.Hostname(), "interval", b.interval)
+}
+
+// Pause holds every request to the host of u for d, e.g. for the
+// Retry-After of a 429 or 503.
+func (l *hostLimiter) Pause(u *url.URL, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if b := l.bucket(u.Hostname()); until.After(b.paused) {
+		b.paused = until
+		slog.Info("Pausing host", "domain", u.Hostname(), "duration", d)
+	}
+}
+
+// Wait blocks until the host of u may be fetched again.
+func (l *hostLimiter) Wait(ctx context.Context, u *url.URL) error {
+	l.mu.Lock()
+	b := l.bucket(u.Hostname())
+	now := time.Now()
+	wait := b.reserve(now)
+	if p := b.paused.Sub(now); p > wait {
+		wait = p
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
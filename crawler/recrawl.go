@@ -0,0 +1,202 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   RECRAWL CONFIG
+	==============================
+*/
+
+const (
+	RevisitDefault      = 24 * time.Hour
+	RevisitMin          = time.Hour
+	RevisitMax          = 30 * 24 * time.Hour
+	RecrawlBatchSize    = 100
+	RecrawlPollInterval = time.Minute
+)
+
+/*
+	==============================
+	   FRESHNESS TRACKING
+	==============================
+*/
+
+// scheduleRevisit sets the page's next visit. A page whose image set
+// changed since the last fetch is revisited twice as often; an unchanged
+// page backs off by half again, within [RevisitMin, RevisitMax].
+func scheduleRevisit(page, prev *store.PageRecord, changed bool, now time.Time) {
+	interval := RevisitDefault
+	if prev != nil && prev.RevisitInterval > 0 {
+		interval = prev.RevisitInterval
+		if changed {
+			interval /= 2
+		} else {
+			interval = interval * 3 / 2
+		}
+	}
+	if interval < RevisitMin {
+		interval = RevisitMin
+	}
+	if interval > RevisitMax {
+		interval = RevisitMax
+	}
+
+	page.RevisitInterval = interval
+	page.NextVisit = now.Add(interval)
+	if changed {
+		page.LastChanged = now
+	} else if prev != nil {
+		page.LastChanged = prev.LastChanged
+	}
+}
+
+// missingFrom returns the entries of before that are not in after.
+func missingFrom(before, after []string) []string {
+	present := make(map[string]bool, len(after))
+	for _, u := range after {
+		present[u] = true
+	}
+	var out []string
+	for _, u := range before {
+		if !present[u] {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+/*
+	==============================
+	   RECRAWL SCHEDULER
+	==============================
+*/
+
+// postponePage pushes back a page that could not be revisited, so a dead
+// or blocked page does not spin at the head of the schedule.
+func postponePage(ctx context.Context, sched store.Scheduler, page store.PageRecord, now time.Time) error {
+	interval := page.RevisitInterval
+	if interval <= 0 {
+		interval = RevisitDefault
+	}
+	return sched.PostponePage(ctx, page.PageURL, now.Add(interval))
+}
+
+// RunRecrawler revisits known pages as they come due until ctx is done.
+// Links found on revisited pages are not followed; discovery is the job
+// of the regular crawl. The retention sweep and dead link checks only run
+// against Mongo.
+func RunRecrawler(ctx context.Context, st store.Store) error {
+	sched, ok := store.Feature[store.Scheduler](st)
+	if !ok {
+		return fmt.Errorf("recrawl: %w", store.ErrUnsupported)
+	}
+	col := store.MongoCollection(st)
+	retention, err := loadRetentionPolicy()
+	if err != nil {
+		return err
+	}
+	if retention != nil && col == nil {
+		return fmt.Errorf("IMG_RETENTION_DAYS needs IMG_DB_DRIVER=mongo")
+	}
+	linkcheck := env.Get("IMG_LINKCHECK", "") == "true"
+	if linkcheck && col == nil {
+		return fmt.Errorf("IMG_LINKCHECK needs IMG_DB_DRIVER=mongo")
+	}
+	c, err := newImageCrawler(st, nil, env.List("IMG_ALLOWED_SITES"))
+	if err != nil {
+		return err
+	}
+	slog.Info("Starting recrawl scheduler", "workers", c.workers)
+	c.beginRun(ctx, "recrawl", nil)
+	defer c.endRun(ctx, nil)
+
+	work, cancelWork := workContext(ctx, env.Duration("IMG_SHUTDOWN_GRACE", ShutdownGrace))
+	defer cancelWork()
+
+	c.enricher.Start(work)
+	defer c.enricher.Close()
+	c.writer.Start(work)
+	defer c.writer.Close()
+	defer c.warc.Close()
+
+	// dead links are checked alongside, sharing the crawl's host limits
+	if linkcheck {
+		links := newLinkChecker(col, c.limiter, c.robots)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			links.run(ctx)
+		}()
+		defer func() { <-done }()
+	}
+
+	var swept time.Time
+	for ctx.Err() == nil {
+		if retention != nil && time.Since(swept) >= RetentionSweepInterval {
+			if err := retention.sweep(ctx, col, time.Now().UTC()); err != nil {
+				slog.Error("Retention sweep failed", "error", err)
+			}
+			swept = time.Now()
+		}
+
+		pages, err := sched.DuePages(ctx, time.Now().UTC(), RecrawlBatchSize)
+		if err != nil {
+			slog.Error("Loading due pages failed", "error", err)
+		}
+
+		if len(pages) == 0 {
+			t := time.NewTimer(RecrawlPollInterval)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+			}
+			continue
+		}
+
+		slog.Info("Revisiting due pages", "pages", len(pages))
+
+		// a fresh frontier per batch dedups pages that redirect to the
+		// same location without growing a seen set forever
+		c.frontier = newMemoryFrontier(0, 0)
+
+		jobs := make(chan store.PageRecord)
+		var wg sync.WaitGroup
+		for i := 0; i < c.workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for p := range jobs {
+					if _, ok := c.crawlPage(work, Task{Link: p.PageURL}); !ok {
+						if err := postponePage(work, sched, p, time.Now().UTC()); err != nil {
+							slog.Error("Postponing page failed", "url", p.PageURL, "error", err)
+						}
+						continue
+					}
+					c.pages.Add(1)
+					c.stats.page(p.PageURL)
+				}
+			}()
+		}
+		for _, p := range pages {
+			if ctx.Err() != nil {
+				break
+			}
+			jobs <- p
+		}
+		close(jobs)
+		wg.Wait()
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   REDIRECT POLICY
+	==============================
+*/
+
+const MaxRedirects = 5
+
+var maxRedirects = MaxRedirects
+
+func configureRedirects() {
+	maxRedirects = env.Int("IMG_MAX_REDIRECTS", MaxRedirects)
+}
+
+// checkRedirect caps redirect chains and refuses https -> http downgrades.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	prev := via[len(via)-1]
+	if prev.URL.Scheme == "https" && req.URL.Scheme == "http" {
+		return fmt.Errorf("refusing redirect downgrade %s -> %s", prev.URL, req.URL)
+	}
+	return nil
+}
+
+// redirectChain lists the URLs a response was redirected through, oldest
+// first, ending with the URL that produced resp.
+func redirectChain(resp *http.Response) []string {
+	var chain []string
+	for req := resp.Request; req != nil; {
+		chain = append([]string{req.URL.String()}, chain...)
+		if req.Response == nil {
+			break
+		}
+		req = req.Response.Request
+	}
+	return chain
+}
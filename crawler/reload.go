@@ -0,0 +1,108 @@
+package crawler
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   CONFIG RELOAD
+	==============================
+*/
+
+// crawlRules decide which links a crawl follows. A config reload swaps
+// them whole, so workers never see half of an update.
+type crawlRules struct {
+	sites   []string // the allowlist as given
+	allowed *domainMatcher
+	blocked *domainMatcher
+	filter  *urlFilter
+}
+
+// loadCrawlRules reads IMG_SUBDOMAIN_POLICY, IMG_BLOCKED_SITES and the URL
+// filters; allowed is the allowlist.
+func loadCrawlRules(allowed []string) (*crawlRules, error) {
+	filter, err := loadURLFilter()
+	if err != nil {
+		return nil, err
+	}
+	policy, err := parseDomainPolicy(env.Get("IMG_SUBDOMAIN_POLICY", string(PolicySubdomains)))
+	if err != nil {
+		return nil, err
+	}
+	return &crawlRules{
+		sites:   allowed,
+		allowed: newDomainMatcher(allowed, policy),
+		blocked: newDomainMatcher(env.List("IMG_BLOCKED_SITES"), PolicySubdomains),
+		filter:  filter,
+	}, nil
+}
+
+// reload applies the current settings to a running crawl: the allowed
+// and blocked sites, the URL filters and the rate limits. An empty
+// IMG_ALLOWED_SITES keeps the allowlist the crawl started with. Queued
+// links a new rule excludes are dropped when they come up. On an error
+// nothing changes.
+func (c *imageCrawler) reload() error {
+	allowed := env.List("IMG_ALLOWED_SITES")
+	if len(allowed) == 0 {
+		allowed = c.rules.Load().sites
+	}
+	rules, err := loadCrawlRules(allowed)
+	if err != nil {
+		return err
+	}
+	c.rules.Store(rules)
+	c.limiter.Reconfigure()
+	if slices.Contains(allowed, "*") {
+		slog.Info("Open crawl: every host is allowed unless listed in IMG_BLOCKED_SITES")
+	}
+	return nil
+}
+
+// reloadConfig re-reads .env, where it overrides the environment, and
+// applies it to the running crawler. Command line flags still win.
+// Settings removed from .env keep their old values.
+func reloadConfig() error {
+	if err := env.Reload(); err != nil {
+		return err
+	}
+
+	c := admin.current()
+	if c == nil {
+		slog.Info("Configuration reloaded; no crawl is running")
+		return nil
+	}
+	if err := c.reload(); err != nil {
+		return err
+	}
+	slog.Info("Configuration reloaded")
+	return nil
+}
+
+// WatchReloads reloads the configuration on every SIGHUP until ctx is
+// done.
+func WatchReloads(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				if err := reloadConfig(); err != nil {
+					slog.Error("Reloading configuration failed, keeping the old one", "error", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
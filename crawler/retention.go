@@ -0,0 +1,92 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   RETENTION
+	==============================
+*/
+
+const (
+	RetentionFlag   = "flag"
+	RetentionDelete = "delete"
+	// how often the recrawl scheduler sweeps
+	RetentionSweepInterval = time.Hour
+)
+
+// retentionPolicy expires images no crawl has re-confirmed within maxAge.
+// Every time a page lists an image its last_seen is refreshed, so an old
+// last_seen means a dead link or a page that dropped it. Flagged
+// images (stale: true) stay stored but are left out of the search index
+// until a crawl sees them again; deleted ones become tombstones.
+type retentionPolicy struct {
+	maxAge time.Duration
+	remove bool
+}
+
+// loadRetentionPolicy reads IMG_RETENTION_DAYS (0 keeps everything) and
+// IMG_RETENTION_ACTION, flag or delete.
+func loadRetentionPolicy() (*retentionPolicy, error) {
+	days := env.Int("IMG_RETENTION_DAYS", 0)
+	if days <= 0 {
+		return nil, nil
+	}
+	p := &retentionPolicy{maxAge: time.Duration(days) * 24 * time.Hour}
+	switch action := env.Get("IMG_RETENTION_ACTION", RetentionFlag); action {
+	case RetentionFlag:
+	case RetentionDelete:
+		p.remove = true
+	default:
+		return nil, fmt.Errorf("IMG_RETENTION_ACTION must be %s or %s", RetentionFlag, RetentionDelete)
+	}
+	return p, nil
+}
+
+// sweep flags or deletes the images not seen since the cutoff.
+func (p *retentionPolicy) sweep(ctx context.Context, col *mongo.Collection, now time.Time) error {
+	cutoff := now.Add(-p.maxAge)
+	filter := bson.M{"last_seen": bson.M{"$lt": cutoff}}
+	if p.remove {
+		n, err := store.RemoveMatching(ctx, col, filter, store.TombstoneExpired)
+		if err != nil {
+			return err
+		}
+		slog.Info("Deleted images not seen recently", "count", n, "cutoff", cutoff)
+		return nil
+	}
+
+	filter["stale"] = bson.M{"$ne": true}
+	filter["deleted_at"] = store.Live
+	res, err := col.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"stale": true}})
+	if err != nil {
+		return err
+	}
+	slog.Info("Flagged images not seen recently as stale", "count", res.ModifiedCount, "cutoff", cutoff)
+	return nil
+}
+
+// Expire applies the retention policy once (IMG_MODE=expire), e.g.
+// from cron. The recrawl scheduler also sweeps every
+// RetentionSweepInterval.
+func Expire(ctx context.Context, col *mongo.Collection) error {
+	policy, err := loadRetentionPolicy()
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		return fmt.Errorf("IMG_RETENTION_DAYS is not set")
+	}
+	return policy.sweep(ctx, col, time.Now().UTC())
+}
@@ -0,0 +1,231 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   RETRY CONFIG
+	==============================
+*/
+
+const (
+	RetryAttempts  = 3
+	RetryBaseDelay = 500 * time.Millisecond
+	RetryMaxDelay  = 10 * time.Second
+	MaxRetryAfter  = 10 * time.Minute
+)
+
+/*
+	==============================
+	   ERROR CLASSIFICATION
+	==============================
+*/
+
+type httpStatusError struct {
+	Status     int
+	RetryAfter time.Duration
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("http status %d", e.Status)
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of
+// seconds or an HTTP date. It returns 0 when the header is absent or bad.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// isTransient reports whether a fetch error is worth retrying: server
+// errors, rate limiting, timeouts and dropped connections.
+func isTransient(err error) bool {
+	var se *httpStatusError
+	if errors.As(err, &se) {
+		return se.Status >= 500 || se.Status == http.StatusTooManyRequests
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+/*
+	==============================
+	   RETRY POLICY
+	==============================
+*/
+
+type retryPolicy struct {
+	attempts      int
+	base          time.Duration
+	max           time.Duration
+	maxRetryAfter time.Duration
+}
+
+func loadRetryPolicy() retryPolicy {
+	p := retryPolicy{
+		attempts:      env.Int("IMG_RETRY_ATTEMPTS", RetryAttempts),
+		base:          env.Duration("IMG_RETRY_BASE_DELAY", RetryBaseDelay),
+		max:           env.Duration("IMG_RETRY_MAX_DELAY", RetryMaxDelay),
+		maxRetryAfter: env.Duration("IMG_MAX_RETRY_AFTER", MaxRetryAfter),
+	}
+	if p.attempts < 1 {
+		p.attempts = 1
+	}
+	return p
+}
+
+// backoff returns the delay before retry number n (starting at 1), using
+// exponential growth with "equal jitter" so retries from many workers
+// against one host don't line up.
+func (p retryPolicy) backoff(n int) time.Duration {
+	d := p.base
+	for i := 1; i < n && d < p.max; i++ {
+		d *= 2
+	}
+	if d > p.max {
+		d = p.max
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+/*
+	==============================
+	   FETCH WITH RETRIES
+	==============================
+*/
+
+// waitTurn waits for u's host's politeness slot, honouring its robots.txt
+// Crawl-delay.
+func (c *imageCrawler) waitTurn(ctx context.Context, u *url.URL) error {
+	if d := c.robots.CrawlDelay(u); d > 0 {
+		c.limiter.SetMinInterval(u, d)
+	}
+	return c.limiter.Wait(ctx, u)
+}
+
+// fetchPage downloads a page, retrying transient failures. Each attempt
+// waits for the host's politeness slot, which honours robots.txt
+// Crawl-delay. A 429 slows the host down, and a Retry-After on a 429 or
+// 503 pauses it. When retries run out, the last error is recorded in the
+// failures collection.
+func (c *imageCrawler) fetchPage(ctx context.Context, u *url.URL, prev *store.PageRecord) (_ *FetchResult, err error) {
+	link := u.String()
+	ctx, span := tracer.Start(ctx, "fetch", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("url", link)))
+	defer func() { endSpan(span, err) }()
+
+	for attempt := 1; attempt <= c.retry.attempts; attempt++ {
+		waited := time.Now()
+		if err := c.waitTurn(ctx, u); err != nil {
+			return nil, err
+		}
+		span.AddEvent("rate limit passed", trace.WithAttributes(
+			attribute.Int("attempt", attempt), attribute.Int64("waited_ms", time.Since(waited).Milliseconds())))
+
+		var res *FetchResult
+		res, err = c.fetchers.For(u).Fetch(ctx, link, prev)
+		if err == nil {
+			span.SetAttributes(attribute.Int("attempts", attempt), attribute.Bool("not_modified", res.NotModified))
+			return res, nil
+		}
+		if !isTransient(err) {
+			return nil, err
+		}
+		paused := c.throttle(u, err)
+		if attempt == c.retry.attempts {
+			break
+		}
+
+		// a paused host is held back by the limiter instead
+		if paused {
+			slog.Warn("Retrying once the host pause ends", "url", link, "attempt", attempt+1, "attempts", c.retry.attempts, "error", err)
+			continue
+		}
+		wait := c.retry.backoff(attempt)
+		slog.Warn("Retrying", "url", link, "wait", wait, "attempt", attempt+1, "attempts", c.retry.attempts, "error", err)
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		}
+	}
+
+	recordFetchFailure(ctx, c.failures, link, c.retry.attempts, err)
+	return nil, fmt.Errorf("giving up after %d attempts: %w", c.retry.attempts, err)
+}
+
+// throttle reacts to a host pushing back: 429 halves its rate, and a
+// Retry-After (capped at maxRetryAfter) pauses it outright. It reports
+// whether the host was paused.
+func (c *imageCrawler) throttle(u *url.URL, err error) bool {
+	var se *httpStatusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	if se.Status == http.StatusTooManyRequests {
+		c.limiter.SlowDown(u)
+	}
+	if se.RetryAfter <= 0 {
+		return false
+	}
+	c.limiter.Pause(u, min(se.RetryAfter, c.retry.maxRetryAfter))
+	return true
+}
+
+func recordFetchFailure(ctx context.Context, log store.FailureLog, link string, attempts int, cause error) {
+	if log == nil {
+		return
+	}
+	err := log.RecordFailure(ctx, store.FetchFailure{
+		PageURL:  link,
+		Reason:   cause.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		slog.Error("Recording fetch failure failed", "url", link, "error", err)
+	}
+}
@@ -0,0 +1,88 @@
+package crawler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := retryPolicy{base: 100 * time.Millisecond, max: time.Second}
+
+	tests := []struct {
+		retry int
+		full  time.Duration // the delay before jitter; backoff is within [full/2, full]
+	}{
+		{retry: 1, full: 100 * time.Millisecond},
+		{retry: 2, full: 200 * time.Millisecond},
+		{retry: 3, full: 400 * time.Millisecond},
+		{retry: 4, full: 800 * time.Millisecond},
+		{retry: 5, full: time.Second},
+		{retry: 50, full: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.retry), func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if d := p.backoff(tt.retry); d < tt.full/2 || d > tt.full {
+					t.Fatalf("backoff(%d) = %v, want within [%v, %v]", tt.retry, d, tt.full/2, tt.full)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{name: "absent", header: "", want: 0},
+		{name: "seconds", header: "120", want: 2 * time.Minute},
+		{name: "padded seconds", header: " 5 ", want: 5 * time.Second},
+		{name: "zero", header: "0", want: 0},
+		{name: "negative", header: "-3", want: 0},
+		{name: "HTTP date", header: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second},
+		{name: "date in the past", header: now.Add(-time.Hour).Format(http.TimeFormat), want: 0},
+		{name: "garbage", header: "soon", want: 0},
+		{name: "fractional seconds", header: "1.5", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.header, now); got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "server error", err: &httpStatusError{Status: 503}, want: true},
+		{name: "rate limited", err: &httpStatusError{Status: 429}, want: true},
+		{name: "not found", err: &httpStatusError{Status: 404}, want: false},
+		{name: "wrapped status", err: fmt.Errorf("fetch: %w", &httpStatusError{Status: 502}), want: true},
+		{name: "connection reset", err: fmt.Errorf("read: %w", syscall.ECONNRESET), want: true},
+		{name: "truncated body", err: io.ErrUnexpectedEOF, want: true},
+		{name: "other", err: fmt.Errorf("bad url"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.err); got != tt.want {
+				t.Errorf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
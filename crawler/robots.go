@@ -0,0 +1,365 @@
+package crawler
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   ROBOTS.TXT CONFIG
+	==============================
+*/
+
+const (
+	MaxRobotsSize = 512 * 1024
+	// RFC 9309 asks crawlers not to use a cached robots.txt for longer
+	RobotsMaxAge = 24 * time.Hour
+)
+
+/*
+	==============================
+	   ROBOTS.TXT PARSING
+	==============================
+*/
+
+type robotsRule struct {
+	path  string
+	allow bool
+}
+
+// robotsRules is the group of a robots.txt file that applies to us.
+type robotsRules struct {
+	rules       []robotsRule
+	crawlDelay  time.Duration
+	disallowAll bool
+}
+
+// parseRobots picks the group addressed to agent, falling back to "*".
+func parseRobots(r io.Reader, agent string) *robotsRules {
+	agent = strings.ToLower(agent)
+
+	type group struct {
+		agents []string
+		rules  []robotsRule
+		delay  time.Duration
+	}
+
+	var groups []*group
+	var cur *group
+	inAgents := false
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				cur = &group{}
+				groups = append(groups, cur)
+				inAgents = true
+			}
+			// an empty User-agent names no one, rather than matching
+			// every agent
+			if val != "" {
+				cur.agents = append(cur.agents, strings.ToLower(val))
+			}
+		case "allow", "disallow":
+			inAgents = false
+			if cur == nil {
+				continue
+			}
+			// an empty Disallow means "allow everything"
+			if val == "" {
+				continue
+			}
+			cur.rules = append(cur.rules, robotsRule{path: val, allow: key == "allow"})
+		case "crawl-delay":
+			inAgents = false
+			if cur == nil {
+				continue
+			}
+			if secs, err := strconv.ParseFloat(val, 64); err == nil && secs > 0 {
+				cur.delay = time.Duration(secs * float64(time.Second))
+			}
+		}
+	}
+
+	var specific, wildcard *group
+	for _, g := range groups {
+		for _, a := range g.agents {
+			if a == "*" {
+				if wildcard == nil {
+					wildcard = g
+				}
+			} else if strings.Contains(agent, a) && specific == nil {
+				specific = g
+			}
+		}
+	}
+
+	chosen := specific
+	if chosen == nil {
+		chosen = wildcard
+	}
+	if chosen == nil {
+		return &robotsRules{}
+	}
+	return &robotsRules{rules: chosen.rules, crawlDelay: chosen.delay}
+}
+
+// robotsPatternMatch matches a robots path pattern supporting "*" and a
+// trailing "$" anchor.
+func robotsPatternMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	if len(parts) == 1 {
+		return !anchored || rest == ""
+	}
+
+	for _, p := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, p)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(p):]
+	}
+
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(rest, last)
+	}
+	return strings.Contains(rest, last)
+}
+
+// Allowed applies the longest matching rule; Allow wins ties.
+func (r *robotsRules) Allowed(u *url.URL) bool {
+	if r.disallowAll {
+		return false
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+
+	best := -1
+	allowed := true
+	for _, rule := range r.rules {
+		if !robotsPatternMatch(rule.path, path) {
+			continue
+		}
+		if len(rule.path) > best || (len(rule.path) == best && rule.allow) {
+			best = len(rule.path)
+			allowed = rule.allow
+		}
+	}
+	return allowed
+}
+
+/*
+	==============================
+	   ROBOTS.TXT CACHE
+	==============================
+*/
+
+type robotsEntry struct {
+	ready   chan struct{}
+	rules   *robotsRules
+	fetched time.Time
+}
+
+// robotsCache fetches robots.txt once per scheme+host, and again once the
+// copy is older than maxAge.
+type robotsCache struct {
+	ignore *domainMatcher
+	maxAge time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*robotsEntry
+}
+
+func newRobotsCache(ignore *domainMatcher, maxAge time.Duration) *robotsCache {
+	return &robotsCache{
+		ignore:  ignore,
+		maxAge:  min(maxAge, RobotsMaxAge),
+		entries: map[string]*robotsEntry{},
+	}
+}
+
+// loadRobotsCache configures the cache from IMG_ROBOTS_IGNORE and
+// IMG_ROBOTS_MAX_AGE, which can only shorten RobotsMaxAge.
+func loadRobotsCache() *robotsCache {
+	return newRobotsCache(
+		newDomainMatcher(env.List("IMG_ROBOTS_IGNORE"), PolicySubdomains),
+		env.Duration("IMG_ROBOTS_MAX_AGE", RobotsMaxAge))
+}
+
+func (rc *robotsCache) ignored(u *url.URL) bool {
+	return rc.ignore.Match(u)
+}
+
+func (rc *robotsCache) rulesFor(u *url.URL) *robotsRules {
+	key := u.Scheme + "://" + u.Host
+
+	rc.mu.Lock()
+	e, ok := rc.entries[key]
+	if ok {
+		select {
+		case <-e.ready:
+			if time.Since(e.fetched) > rc.maxAge {
+				ok = false
+			}
+		default:
+		}
+	}
+	if !ok {
+		e = &robotsEntry{ready: make(chan struct{})}
+		rc.entries[key] = e
+	}
+	rc.mu.Unlock()
+
+	if ok {
+		<-e.ready
+		return e.rules
+	}
+
+	e.rules = fetchRobots(key + "/robots.txt")
+	e.fetched = time.Now()
+	close(e.ready)
+	return e.rules
+}
+
+func fetchRobots(link string) *robotsRules {
+	resp, err := crawlClient.Get(link)
+	if err != nil {
+		slog.Warn("robots.txt unavailable", "url", link, "error", err)
+		return &robotsRules{}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		// server errors mean "assume complete disallow" (RFC 9309)
+		slog.Warn("robots.txt failed, disallowing host", "url", link, "status", resp.StatusCode)
+		return &robotsRules{disallowAll: true}
+	case resp.StatusCode != http.StatusOK:
+		// missing or unreadable robots.txt means no restrictions
+		return &robotsRules{}
+	}
+
+	return parseRobots(io.LimitReader(resp.Body, MaxRobotsSize), robotsAgentToken())
+}
+
+// Allowed reports whether robots.txt permits fetching u.
+func (rc *robotsCache) Allowed(u *url.URL) bool {
+	if rc.ignored(u) {
+		return true
+	}
+	return rc.rulesFor(u).Allowed(u)
+}
+
+// CrawlDelay returns the Crawl-delay robots.txt asks of us for u's host,
+// or 0. The host limiter enforces it.
+func (rc *robotsCache) CrawlDelay(u *url.URL) time.Duration {
+	if rc.ignored(u) {
+		return 0
+	}
+	return rc.rulesFor(u).crawlDelay
+}
+
+/*
+	==============================
+	   PAGE ROBOTS DIRECTIVES
+	==============================
+*/
+
+// pageDirectives are the <meta name="robots"> / X-Robots-Tag rules that
+// matter to an image crawler. Only NoImageIndex keeps a page's images out
+// of the store: noindex is about the page itself, and its images may
+// still be indexed.
+type pageDirectives struct {
+	NoIndex      bool
+	NoImageIndex bool
+	NoFollow     bool
+}
+
+func (d *pageDirectives) apply(value string) {
+	for _, tok := range strings.Split(strings.ToLower(value), ",") {
+		switch strings.TrimSpace(tok) {
+		case "noindex":
+			d.NoIndex = true
+		case "noimageindex":
+			d.NoImageIndex = true
+		case "nofollow":
+			d.NoFollow = true
+		case "none":
+			d.NoIndex = true
+			d.NoImageIndex = true
+			d.NoFollow = true
+		}
+	}
+}
+
+// pageRobotsDirectives merges meta robots tags addressed to everyone or to
+// our agent with the page's X-Robots-Tag headers. Header values may be
+// scoped to an agent ("otherbot: noindex"); those for other agents are
+// ignored.
+func pageRobotsDirectives(doc *goquery.Document, robotsTags []string) pageDirectives {
+	agent := robotsAgentToken()
+	var d pageDirectives
+
+	doc.Find("meta[name]").Each(func(i int, m *goquery.Selection) {
+		name, _ := m.Attr("name")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "robots" || name == agent {
+			content, _ := m.Attr("content")
+			d.apply(content)
+		}
+	})
+
+	for _, tag := range robotsTags {
+		if scope, rest, ok := strings.Cut(tag, ":"); ok {
+			scope = strings.ToLower(strings.TrimSpace(scope))
+			if scope != "unavailable_after" && !strings.ContainsAny(scope, ", ") {
+				if scope == agent {
+					d.apply(rest)
+				}
+				continue
+			}
+		}
+		d.apply(tag)
+	}
+
+	return d
+}
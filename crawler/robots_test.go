@@ -0,0 +1,186 @@
+package crawler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRobots(t *testing.T) {
+	tests := []struct {
+		name    string
+		robots  string
+		agent   string
+		path    string
+		allowed bool
+		delay   time.Duration
+	}{
+		{
+			name:    "empty file allows everything",
+			robots:  "",
+			agent:   "imgbot",
+			path:    "/a",
+			allowed: true,
+		},
+		{
+			name:    "wildcard group applies",
+			robots:  "User-agent: *\nDisallow: /private\n",
+			agent:   "imgbot",
+			path:    "/private/x.html",
+			allowed: false,
+		},
+		{
+			name:    "our group wins over the wildcard",
+			robots:  "User-agent: *\nDisallow: /\n\nUser-agent: imgbot\nDisallow: /private\n",
+			agent:   "imgbot",
+			path:    "/public.html",
+			allowed: true,
+		},
+		{
+			name:    "other agents' groups are ignored",
+			robots:  "User-agent: otherbot\nDisallow: /\n",
+			agent:   "imgbot",
+			path:    "/a",
+			allowed: true,
+		},
+		{
+			name:    "agents sharing a group",
+			robots:  "User-agent: otherbot\nUser-agent: ImgBot\nDisallow: /a\n",
+			agent:   "imgbot",
+			path:    "/a",
+			allowed: false,
+		},
+		{
+			name:    "empty disallow allows everything",
+			robots:  "User-agent: *\nDisallow:\n",
+			agent:   "imgbot",
+			path:    "/a",
+			allowed: true,
+		},
+		{
+			name:    "longest match wins",
+			robots:  "User-agent: *\nDisallow: /img\nAllow: /img/public\n",
+			agent:   "imgbot",
+			path:    "/img/public/cat.jpg",
+			allowed: true,
+		},
+		{
+			name:    "allow wins a tie",
+			robots:  "User-agent: *\nDisallow: /a\nAllow: /a\n",
+			agent:   "imgbot",
+			path:    "/a",
+			allowed: true,
+		},
+		{
+			name:    "wildcards and end anchors",
+			robots:  "User-agent: *\nDisallow: /*.gif$\n",
+			agent:   "imgbot",
+			path:    "/images/cat.gif",
+			allowed: false,
+		},
+		{
+			name:    "anchored pattern needs the end",
+			robots:  "User-agent: *\nDisallow: /*.gif$\n",
+			agent:   "imgbot",
+			path:    "/images/cat.gif?size=2",
+			allowed: true,
+		},
+		{
+			name:    "query strings are matched",
+			robots:  "User-agent: *\nDisallow: /search?\n",
+			agent:   "imgbot",
+			path:    "/search?q=cats",
+			allowed: false,
+		},
+		{
+			name:    "comments are stripped",
+			robots:  "# hello\nUser-agent: * # everyone\nDisallow: /a # not /b\n",
+			agent:   "imgbot",
+			path:    "/b",
+			allowed: true,
+		},
+		{
+			name:    "crawl delay of the chosen group",
+			robots:  "User-agent: *\nCrawl-delay: 10\n\nUser-agent: imgbot\nCrawl-delay: 1.5\n",
+			agent:   "imgbot",
+			path:    "/",
+			allowed: true,
+			delay:   1500 * time.Millisecond,
+		},
+		{
+			name:    "empty user-agent names no one",
+			robots:  "User-agent:\nDisallow: /\n",
+			agent:   "imgbot",
+			path:    "/a",
+			allowed: true,
+		},
+		{
+			name:    "empty user-agent in a group is skipped",
+			robots:  "User-agent:\nUser-agent: *\nDisallow: /a\n",
+			agent:   "imgbot",
+			path:    "/a",
+			allowed: false,
+		},
+		{
+			name:    "bad crawl delay is ignored",
+			robots:  "User-agent: *\nCrawl-delay: soon\n",
+			agent:   "imgbot",
+			path:    "/",
+			allowed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := parseRobots(strings.NewReader(tt.robots), tt.agent)
+			u, err := url.Parse("https://example.test" + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rules.Allowed(u); got != tt.allowed {
+				t.Errorf("Allowed(%s) = %v, want %v", tt.path, got, tt.allowed)
+			}
+			if rules.crawlDelay != tt.delay {
+				t.Errorf("crawl delay = %v, want %v", rules.crawlDelay, tt.delay)
+			}
+		})
+	}
+}
+
+func TestRobotsCacheRefetch(t *testing.T) {
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/private/a.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc := newRobotsCache(newDomainMatcher(nil, PolicySubdomains), time.Hour)
+	for i := 0; i < 3; i++ {
+		if rc.Allowed(u) {
+			t.Fatal("disallowed path allowed")
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("fresh robots.txt fetched %d times, want once", n)
+	}
+
+	// an entry older than the max age is fetched again
+	rc.entries[u.Scheme+"://"+u.Host].fetched = time.Now().Add(-2 * time.Hour)
+	rc.Allowed(u)
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("stale robots.txt fetched %d times in all, want twice", n)
+	}
+
+	if got := newRobotsCache(nil, 48*time.Hour).maxAge; got != RobotsMaxAge {
+		t.Errorf("max age = %v, want it capped at %v", got, RobotsMaxAge)
+	}
+}
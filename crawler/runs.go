@@ -0,0 +1,171 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   CRAWL RUNS
+	==============================
+*/
+
+const (
+	RunsListed = 20
+
+	RunRunning     = "running"
+	RunCompleted   = "completed"
+	RunInterrupted = "interrupted"
+	RunFailed      = "failed"
+)
+
+// Every crawler is a run with its own ID, stamped as run_id on the image
+// and page records it writes; a new image also keeps it as first_run_id.
+// Stores with a run log describe each run, in crawl_runs with Mongo, so
+// runs can be listed and compared (image_crawler runs) and the images one
+// added removed again (image_crawler rollback <run-id>).
+
+func newRunID() string {
+	return primitive.NewObjectID().Hex()
+}
+
+// beginRun records the start of c's run. Without a run log there is
+// nowhere to record it, but records are still stamped.
+func (c *imageCrawler) beginRun(ctx context.Context, kind string, seeds []string) {
+	slog.Info("Crawl run", "run_id", c.runID, "kind", kind)
+	if c.runs == nil {
+		return
+	}
+	started, _ := c.stats.times()
+	c.runRecord = store.RunRecord{
+		ID:        c.runID,
+		Kind:      kind,
+		State:     RunRunning,
+		Seeds:     seeds,
+		Config:    configSnapshot(),
+		StartedAt: started.UTC(),
+	}
+	c.runRecord.Host, _ = os.Hostname()
+	if err := c.runs.SaveRun(ctx, c.runRecord); err != nil {
+		slog.Error("Recording crawl run failed", "run_id", c.runID, "error", err)
+	}
+}
+
+// endRun records how c's run ended and its totals.
+func (c *imageCrawler) endRun(ctx context.Context, err error) {
+	if c.runs == nil {
+		return
+	}
+	c.runRecord.State = RunCompleted
+	switch {
+	case err != nil:
+		c.runRecord.State = RunFailed
+		c.runRecord.Error = err.Error()
+	case ctx.Err() != nil:
+		c.runRecord.State = RunInterrupted
+	}
+	c.runRecord.FinishedAt = time.Now().UTC()
+	c.runRecord.Pages = c.pages.Load()
+	c.runRecord.Images = c.images.Load()
+	c.runRecord.Failures = c.stats.failures()
+	if err := c.runs.SaveRun(context.WithoutCancel(ctx), c.runRecord); err != nil {
+		slog.Error("Recording crawl run failed", "run_id", c.runID, "error", err)
+	}
+}
+
+// configSnapshot is every IMG_ setting, with secrets masked.
+func configSnapshot() map[string]string {
+	out := map[string]string{}
+	for _, kv := range os.Environ() {
+		key, val, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(key, "IMG_") {
+			continue
+		}
+		out[key] = redactSetting(key, val)
+	}
+	return out
+}
+
+func redactSetting(key, val string) string {
+	for _, secret := range []string{"TOKEN", "SECRET", "PASSWORD", "API_KEY"} {
+		if strings.Contains(key, secret) && val != "" {
+			return "[redacted]"
+		}
+	}
+	if u, err := url.Parse(val); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return val
+}
+
+// ListRuns prints the latest runs, newest first (image_crawler runs).
+func ListRuns(ctx context.Context, col *mongo.Collection, w io.Writer) error {
+	cur, err := col.Database().Collection(store.RunCollName).Find(ctx, bson.M{},
+		options.Find().SetSort(bson.M{"started_at": -1}).SetLimit(int64(max(env.Int("IMG_RUNS_LIMIT", RunsListed), 1))))
+	if err != nil {
+		return err
+	}
+	var runs []store.RunRecord
+	if err := cur.All(ctx, &runs); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%-24s  %-7s  %-11s  %-20s  %10s  %7s  %7s  %8s\n",
+		"RUN", "KIND", "STATE", "STARTED", "DURATION", "PAGES", "IMAGES", "FAILURES")
+	for _, r := range runs {
+		duration := "-"
+		if !r.FinishedAt.IsZero() {
+			duration = r.FinishedAt.Sub(r.StartedAt).Round(time.Second).String()
+		}
+		state := r.State
+		if !r.RolledBack.IsZero() {
+			state = "rolled back"
+		}
+		fmt.Fprintf(w, "%-24s  %-7s  %-11s  %-20s  %10s  %7d  %7d  %8d\n", r.ID, r.Kind, state,
+			r.StartedAt.Format(time.RFC3339), duration, r.Pages, r.Images, r.Failures)
+	}
+	return nil
+}
+
+// Rollback tombstones the live images the given runs added (image_crawler
+// rollback <run-id>...). Images they only saw again are left alone, and a
+// later crawl finding a rolled back image stores it again.
+func Rollback(ctx context.Context, col *mongo.Collection, ids []string) error {
+	if len(ids) == 0 {
+		return fmt.Errorf("no run IDs to roll back")
+	}
+	runs := col.Database().Collection(store.RunCollName)
+	for _, id := range ids {
+		var run store.RunRecord
+		if err := runs.FindOne(ctx, bson.M{"_id": id}).Decode(&run); err != nil {
+			return fmt.Errorf("run %s: %w", id, err)
+		}
+		if run.State == RunRunning {
+			slog.Warn("Rolling back a run that has not finished", "run_id", id)
+		}
+		n, err := store.RemoveMatching(ctx, col, bson.M{"first_run_id": id}, store.TombstoneRollback)
+		if err != nil {
+			return fmt.Errorf("run %s: %w", id, err)
+		}
+		if _, err := runs.UpdateOne(ctx, bson.M{"_id": id},
+			bson.M{"$set": bson.M{"rolled_back_at": time.Now().UTC()}}); err != nil {
+			return err
+		}
+		slog.Info("Rolled back crawl run", "run_id", id, "images", n)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"time"
+)
+
+/*
+	==============================
+	   GRACEFUL SHUTDOWN
+	==============================
+*/
+
+const (
+	ShutdownGrace  = 30 * time.Second
+	CheckpointFile = "frontier_checkpoint.json"
+)
+
+// workContext derives the context in-flight pages run under. It ignores
+// cancellation of stop, so a page that is being fetched when the crawl is
+// told to stop still gets its images saved, but it is cancelled grace
+// after stop fires so shutdown cannot hang on a slow host.
+func workContext(stop context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	work, cancel := context.WithCancel(context.WithoutCancel(stop))
+	go func() {
+		select {
+		case <-stop.Done():
+			slog.Info("Stopping: finishing in-flight pages", "grace", grace)
+			t := time.NewTimer(grace)
+			defer t.Stop()
+			select {
+			case <-t.C:
+				slog.Warn("Grace period over, abandoning in-flight pages")
+				cancel()
+			case <-work.Done():
+			}
+		case <-work.Done():
+		}
+	}()
+	return work, cancel
+}
+
+/*
+	==============================
+	   FRONTIER CHECKPOINT
+	==============================
+*/
+
+type frontierCheckpoint struct {
+	SavedAt   time.Time      `json:"saved_at"`
+	Processed int            `json:"processed"`
+	Claimed   map[string]int `json:"claimed"`
+	Seen      []string       `json:"seen"`
+	Queue     []Task         `json:"queue"`
+}
+
+// writeCheckpoint saves cp atomically (write to a temp file, then rename).
+func writeCheckpoint(path string, cp frontierCheckpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readCheckpoint loads a checkpoint; a missing file returns nil, nil.
+func readCheckpoint(path string) (*frontierCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp frontierCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
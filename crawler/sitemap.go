@@ -0,0 +1,176 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"image_crawler/extract"
+)
+
+/*
+	==============================
+	   SITEMAP CONFIG
+	==============================
+*/
+
+const (
+	MaxSitemapSize  = 50 * 1024 * 1024
+	MaxSitemapDepth = 3
+	MaxSitemapLinks = 50000
+)
+
+/*
+	==============================
+	   SITEMAP PARSING
+	==============================
+*/
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// sitemapDoc covers both <urlset> and <sitemapindex> documents.
+type sitemapDoc struct {
+	XMLName  xml.Name
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+func isSitemapURL(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	p := strings.ToLower(u.Path)
+	return strings.HasSuffix(p, ".xml") || strings.HasSuffix(p, ".xml.gz")
+}
+
+func downloadSitemap(ctx context.Context, link string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := crawlClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("sitemap status %d", resp.StatusCode)
+	}
+
+	body := bufio.NewReader(io.LimitReader(resp.Body, MaxSitemapSize))
+
+	// gzip is detected by magic bytes, since servers label .xml.gz files
+	// inconsistently (application/x-gzip, octet-stream, or even text/xml)
+	if magic, err := body.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(io.LimitReader(zr, MaxSitemapSize))
+	}
+
+	return io.ReadAll(body)
+}
+
+// crawlSitemap reads a sitemap seed and returns the pages it lists as
+// level 0 tasks. Listed pages are canonicalized and checked like links
+// found on a page (see linkTasks).
+func (c *imageCrawler) crawlSitemap(ctx context.Context, t Task) []Task {
+	pages, err := c.expandSitemap(ctx, t.Link)
+	if err != nil {
+		slog.Error("Reading sitemap failed", "url", t.Link, "error", err)
+	}
+	base, _ := url.Parse(t.Link)
+	var tasks []Task
+	for _, p := range pages {
+		u, err := extract.ResolveURL(base, p)
+		if err != nil {
+			continue
+		}
+		u = canonicalLink(u)
+		if !c.followable(u) {
+			continue
+		}
+		tasks = append(tasks, Task{Link: u.String(), Level: 0})
+	}
+	slog.Info("Seeded pages from sitemap", "url", t.Link, "pages", len(tasks), "listed", len(pages))
+	return tasks
+}
+
+// expandSitemap returns the page URLs listed in a sitemap, following
+// sitemap index files up to MaxSitemapDepth levels. Every sitemap is
+// checked against the allowed hosts and robots.txt, and waits for its
+// host's politeness slot, like a page.
+func (c *imageCrawler) expandSitemap(ctx context.Context, link string) ([]string, error) {
+	var pages []string
+	visited := map[string]bool{}
+
+	var walk func(link string, depth int) error
+	walk = func(link string, depth int) error {
+		if visited[link] || depth > MaxSitemapDepth || len(pages) >= MaxSitemapLinks {
+			return nil
+		}
+		visited[link] = true
+
+		u, err := url.Parse(link)
+		if err != nil {
+			return err
+		}
+		if !c.hostAllowed(u) {
+			slog.Info("Sitemap on a disallowed host", "url", link)
+			return nil
+		}
+		if !c.robots.Allowed(u) {
+			slog.Info("Blocked by robots.txt", "url", link)
+			return nil
+		}
+		if err := c.waitTurn(ctx, u); err != nil {
+			return err
+		}
+		data, err := downloadSitemap(ctx, link)
+		if err != nil {
+			return err
+		}
+
+		var doc sitemapDoc
+		if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil {
+			return fmt.Errorf("parse sitemap %s: %w", link, err)
+		}
+
+		for _, u := range doc.URLs {
+			if len(pages) >= MaxSitemapLinks {
+				break
+			}
+			if loc := strings.TrimSpace(u.Loc); loc != "" {
+				pages = append(pages, loc)
+			}
+		}
+
+		for _, sm := range doc.Sitemaps {
+			loc := strings.TrimSpace(sm.Loc)
+			if loc == "" {
+				continue
+			}
+			if err := walk(loc, depth+1); err != nil {
+				slog.Error("Reading sitemap failed", "url", loc, "error", err)
+			}
+		}
+		return nil
+	}
+
+	err := walk(link, 0)
+	return pages, err
+}
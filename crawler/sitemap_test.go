@@ -0,0 +1,62 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"image_crawler/store"
+)
+
+func TestCrawlSitemapFiltersPages(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	listed := []string{
+		srv.URL + "/a",
+		srv.URL + "/b;jsessionid=XYZ",
+		srv.URL + "/c?b=2&a=1",
+		srv.URL + "/login",
+		srv.URL + "/blocked/d",
+		srv.URL + "/x/x/x/x/x/x/x/x",
+		"http://other.test/e",
+		"ftp://" + strings.TrimPrefix(srv.URL, "http://") + "/f",
+	}
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("User-agent: *\nDisallow: /blocked\n"))
+	})
+	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><urlset>`))
+		for _, l := range listed {
+			w.Write([]byte("<url><loc>" + strings.ReplaceAll(l, "&", "&amp;") + "</loc></url>"))
+		}
+		w.Write([]byte(`</urlset>`))
+	})
+
+	t.Setenv("IMG_DB_DRIVER", store.StoreMemory)
+	t.Setenv("IMG_URL_EXCLUDE", "/login")
+	t.Setenv("IMG_DELAY", "1ms")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	st, err := store.Open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newImageCrawler(st, newMemoryFrontier(10, 0), []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.writer.Close()
+
+	got := taskLinks(c.crawlSitemap(ctx, Task{Link: srv.URL + "/sitemap.xml"}))
+	want := []string{srv.URL + "/a", srv.URL + "/b", srv.URL + "/c?a=1&b=2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("sitemap pages = %v, want %v", got, want)
+	}
+}
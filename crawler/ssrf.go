@@ -0,0 +1,111 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   SSRF PROTECTION
+	==============================
+*/
+
+// nonPublicNets are ranges a crawler should never reach by following a
+// link: private, loopback, link-local (cloud metadata lives at
+// 169.254.169.254), CGNAT, multicast and reserved space.
+var nonPublicNets = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// dialGuard vets every address the crawler connects to. Checking at dial
+// time, after DNS resolution, also catches hostnames that resolve (or
+// re-resolve) to internal addresses.
+type dialGuard struct {
+	allowAll bool
+	allowed  []netip.Prefix
+}
+
+func newDialGuard() (*dialGuard, error) {
+	g := &dialGuard{allowAll: env.Get("IMG_ALLOW_PRIVATE_NETS", "") == "true"}
+	for _, cidr := range env.List("IMG_ALLOWED_NETS") {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("IMG_ALLOWED_NETS: %w", err)
+		}
+		g.allowed = append(g.allowed, p)
+	}
+	return g, nil
+}
+
+func (g *dialGuard) permitted(ip netip.Addr) bool {
+	if g.allowAll {
+		return true
+	}
+	ip = ip.Unmap()
+	for _, p := range g.allowed {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	for _, p := range nonPublicNets {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+var errNonPublicAddress = errors.New("refusing to connect to non-public address")
+
+func (g *dialGuard) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("refusing to dial unresolved address %q", host)
+	}
+	if !g.permitted(ip) {
+		return fmt.Errorf("%w %s", errNonPublicAddress, ip)
+	}
+	return nil
+}
+
+// configureDialGuard makes direct crawler connections go through the
+// guard. Proxied requests are resolved by the proxy, so they are not
+// covered; configured proxies are trusted.
+func configureDialGuard() error {
+	g, err := newDialGuard()
+	if err != nil {
+		return err
+	}
+	if g.allowAll {
+		slog.Warn("SSRF protection disabled (IMG_ALLOW_PRIVATE_NETS)")
+	}
+
+	crawlTransport = newTunedTransport(g.control)
+	return nil
+}
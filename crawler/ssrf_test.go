@@ -0,0 +1,57 @@
+package crawler
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestDialGuardPermitted(t *testing.T) {
+	tests := []struct {
+		name  string
+		guard dialGuard
+		ip    string
+		want  bool
+	}{
+		{name: "public IPv4", ip: "93.184.216.34", want: true},
+		{name: "public IPv6", ip: "2606:2800:220:1::1", want: true},
+		{name: "loopback", ip: "127.0.0.1", want: false},
+		{name: "private", ip: "10.1.2.3", want: false},
+		{name: "private 172.16/12", ip: "172.31.255.255", want: false},
+		{name: "just past 172.16/12", ip: "172.32.0.1", want: true},
+		{name: "cloud metadata", ip: "169.254.169.254", want: false},
+		{name: "CGNAT", ip: "100.64.0.1", want: false},
+		{name: "unspecified", ip: "0.0.0.0", want: false},
+		{name: "multicast", ip: "239.1.1.1", want: false},
+		{name: "IPv6 loopback", ip: "::1", want: false},
+		{name: "IPv6 unique local", ip: "fd00::1", want: false},
+		{name: "IPv6 link-local", ip: "fe80::1", want: false},
+		{name: "IPv4-mapped loopback", ip: "::ffff:127.0.0.1", want: false},
+		{name: "IPv4-mapped public", ip: "::ffff:93.184.216.34", want: true},
+		{
+			name:  "allowed net",
+			guard: dialGuard{allowed: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}},
+			ip:    "10.0.0.7",
+			want:  true,
+		},
+		{
+			name:  "outside the allowed net",
+			guard: dialGuard{allowed: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}},
+			ip:    "10.0.1.7",
+			want:  false,
+		},
+		{
+			name:  "allow all",
+			guard: dialGuard{allowAll: true},
+			ip:    "127.0.0.1",
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.guard.permitted(netip.MustParseAddr(tt.ip)); got != tt.want {
+				t.Errorf("permitted(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+	==============================
+	   AMQP PIPELINE STAGES
+	==============================
+*/
+
+const (
+	StageFetch   = "fetch"
+	StageExtract = "extract"
+	StageEnrich  = "enrich"
+)
+
+// amqpStages splits a crawl shared through RabbitMQ into stages joined by
+// queues next to the task queue: the fetch stage sends each page it
+// downloads to <queue>.pages, the extract stage parses those, stores their
+// images and publishes their links as tasks, and sends each new image to
+// <queue>.images for the enrich stage. A process runs the stages listed
+// in IMG_AMQP_STAGES, all of them by default, so parsing and image
+// downloads can be scaled apart from fetching.
+//
+// The extract and enrich stages stop once their queue has been empty for
+// IMG_AMQP_IDLE_TIMEOUT and the stages before them in the same process
+// are done.
+type amqpStages struct {
+	conn   *amqp.Connection
+	pages  string
+	images string
+	run    map[string]bool
+	idle   time.Duration
+
+	pubMu sync.Mutex
+	pub   *amqp.Channel
+}
+
+func newAMQPStages(f *amqpFrontier, names []string) (*amqpStages, error) {
+	s := &amqpStages{
+		conn:   f.conn,
+		pages:  f.queue + ".pages",
+		images: f.queue + ".images",
+		run:    map[string]bool{},
+		idle:   f.idle,
+	}
+	if len(names) == 0 {
+		names = []string{StageFetch, StageExtract, StageEnrich}
+	}
+	for _, name := range names {
+		switch name = strings.ToLower(name); name {
+		case StageFetch, StageExtract, StageEnrich:
+			s.run[name] = true
+		default:
+			return nil, fmt.Errorf("IMG_AMQP_STAGES: unknown stage %q (have fetch, extract, enrich)", name)
+		}
+	}
+
+	var err error
+	if s.pub, err = f.conn.Channel(); err != nil {
+		return nil, err
+	}
+	for _, q := range []string{s.pages, s.images} {
+		if _, err := s.pub.QueueDeclare(q, true, false, false, false, nil); err != nil {
+			s.pub.Close()
+			return nil, fmt.Errorf("declaring queue %s: %w", q, err)
+		}
+	}
+	slog.Info("Running crawl stages", "stages", strings.Join(names, ","))
+	return s, nil
+}
+
+// runs reports whether this process runs stage. Without split stages it
+// runs all of them.
+func (s *amqpStages) runs(stage string) bool {
+	return s == nil || s.run[stage]
+}
+
+// fetchedPage is a page on its way from the fetch stage to extraction.
+type fetchedPage struct {
+	Task      Task     `json:"task"`
+	URL       string   `json:"url"` // after redirects
+	Redirects []string `json:"redirects,omitempty"`
+	Response  []byte   `json:"response"` // as responseBlock writes it
+}
+
+func (s *amqpStages) publish(ctx context.Context, queue, ctype string, body []byte) error {
+	s.pubMu.Lock()
+	defer s.pubMu.Unlock()
+	return s.pub.PublishWithContext(ctx, "", queue, false, false, amqp.Publishing{
+		ContentType:  ctype,
+		DeliveryMode: amqp.Persistent,
+		Body:         body,
+	})
+}
+
+func (s *amqpStages) publishPage(ctx context.Context, p fetchedPage) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return s.publish(ctx, s.pages, "application/json", body)
+}
+
+func (s *amqpStages) publishImage(ctx context.Context, link string) error {
+	return s.publish(ctx, s.images, "text/plain", []byte(link))
+}
+
+// consume runs handle on the deliveries of stage's queue with n workers,
+// when this process runs stage. Workers take no more deliveries once stop
+// is done, and otherwise quit when upstream is closed and the queue has
+// been idle for s.idle; handle runs under work. The returned channel is
+// closed when the workers are done.
+func (s *amqpStages) consume(stop, work context.Context, stage string, n int, upstream <-chan struct{},
+	handle func(ctx context.Context, body []byte)) <-chan struct{} {
+	done := make(chan struct{})
+	if s == nil || !s.run[stage] || stage == StageFetch {
+		close(done)
+		return done
+	}
+	queue := s.pages
+	if stage == StageEnrich {
+		queue = s.images
+	}
+
+	ch, err := s.conn.Channel()
+	if err == nil {
+		err = ch.Qos(n, 0, false)
+	}
+	var deliveries <-chan amqp.Delivery
+	if err == nil {
+		deliveries, err = ch.Consume(queue, "", false, false, false, false, nil)
+	}
+	if err != nil {
+		slog.Error("Starting crawl stage failed", "stage", stage, "error", err)
+		close(done)
+		return done
+	}
+
+	var ackMu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			idle := time.NewTimer(s.idle)
+			defer idle.Stop()
+			for {
+				select {
+				case d, ok := <-deliveries:
+					if !ok {
+						return
+					}
+					handle(work, d.Body)
+					var err error
+					ackMu.Lock()
+					if work.Err() != nil {
+						// cut short: leave it for the next process
+						err = d.Nack(false, true)
+					} else {
+						err = d.Ack(false)
+					}
+					ackMu.Unlock()
+					if err != nil {
+						slog.Error("Acknowledging crawl stage message failed", "stage", stage, "error", err)
+					}
+					idle.Reset(s.idle)
+				case <-idle.C:
+					select {
+					case <-upstream:
+						return
+					default:
+						idle.Reset(s.idle)
+					}
+				case <-stop.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		// deliveries prefetched but not handled go back to the queue
+		ch.Close()
+		close(done)
+	}()
+	return done
+}
+
+// Close stops publishing; consumers close their own channels.
+func (s *amqpStages) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.pubMu.Lock()
+	defer s.pubMu.Unlock()
+	return s.pub.Close()
+}
+
+// extractPage is the extract stage: it parses a page the fetch stage
+// queued and publishes its links.
+func (c *imageCrawler) extractPage(ctx context.Context, body []byte) {
+	var p fetchedPage
+	if err := json.Unmarshal(body, &p); err != nil {
+		slog.Error("Bad page in AMQP queue", "error", err)
+		return
+	}
+	ctx, span := tracer.Start(ctx, "crawl.extract", trace.WithNewRoot(), trace.WithAttributes(
+		attribute.String("url", p.URL), attribute.Int("depth", p.Task.Level)))
+	defer span.End()
+
+	parsed, err := url.Parse(p.URL)
+	if err != nil {
+		slog.Error("Bad page in AMQP queue", "url", p.URL, "error", err)
+		return
+	}
+	res, err := blockResult(p.URL, p.Response)
+	if err != nil {
+		slog.Error("Reading queued page failed", "url", p.URL, "error", err)
+		return
+	}
+	if res.Doc == nil {
+		slog.Error("Reading queued page failed", "url", p.URL, "error", "no document")
+		return
+	}
+	res.Redirects = p.Redirects
+	prev, err := c.store.LoadPage(ctx, p.Task.Link)
+	if err != nil {
+		slog.Error("Loading page metadata failed", "url", p.Task.Link, "error", err)
+	}
+
+	links := c.processPage(ctx, p.Task, p.URL, parsed, prev, res, time.Now())
+	if err := c.frontier.Push(ctx, links); err != nil {
+		slog.Error("Publishing links failed", "url", p.URL, "error", err)
+	}
+}
+
+// enrichImage is the enrich stage.
+func (c *imageCrawler) enrichImage(ctx context.Context, body []byte) {
+	c.enricher.run(ctx, enrichJob{link: string(body)})
+}
@@ -0,0 +1,56 @@
+package crawler
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"net/http"
+
+	"golang.org/x/image/draw"
+)
+
+/*
+	==============================
+	   STORED ORIGINALS / THUMBNAILS
+	==============================
+*/
+
+const (
+	ThumbnailSize    = 320
+	ThumbnailQuality = 80
+)
+
+// makeThumbnail scales img to fit in a size x size box (never enlarging)
+// and encodes it as JPEG.
+func makeThumbnail(img image.Image, size int) ([]byte, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(h*size/w, 1)
+		} else {
+			w, h = max(w*size/h, 1), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	// JPEG has no alpha: composite transparent images onto white
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: ThumbnailQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// originalKey is the blob key of a downloaded file, by content hash.
+func originalKey(hash string, data []byte) (string, string) {
+	mime := http.DetectContentType(data)
+	ext := dataURIFormats[mime]
+	if ext == "" {
+		ext = "bin"
+	}
+	return "originals/" + hash + "." + ext, mime
+}
@@ -0,0 +1,94 @@
+package crawler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   TRACING
+	==============================
+*/
+
+// tracer records the spans of the crawl pipeline: crawl.page for each
+// task, with fetch and extract under it, write_batch for each batch of
+// images stored (linked to the pages they came from), and enrich for each
+// image, under the page that found it. Store calls get spans of their own.
+// Until tracing is configured it is a no-op.
+var tracer = otel.Tracer("image_crawler")
+
+var tracerProvider *sdktrace.TracerProvider
+
+// ConfigureTracing exports spans over OTLP/HTTP to Jaeger, Tempo or a
+// collector when OTEL_EXPORTER_OTLP_ENDPOINT (or ..._TRACES_ENDPOINT) is
+// set, or IMG_TRACING=true for the default localhost:4318. The exporter,
+// sampler (OTEL_TRACES_SAMPLER) and resource (OTEL_SERVICE_NAME,
+// OTEL_RESOURCE_ATTRIBUTES) read the standard OTEL_* variables.
+func ConfigureTracing(ctx context.Context) error {
+	if env.Get("OTEL_EXPORTER_OTLP_ENDPOINT", "") == "" &&
+		env.Get("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "") == "" &&
+		env.Get("IMG_TRACING", "") != "true" {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "image_crawler")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return err
+	}
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("Exporting traces failed", "error", err)
+	}))
+	slog.Info("Tracing enabled")
+	return nil
+}
+
+// ShutdownTracing exports the spans still buffered.
+func ShutdownTracing() {
+	if tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		slog.Warn("Flushing traces failed", "error", err)
+	}
+}
+
+// TracingEnabled reports whether spans go anywhere.
+func TracingEnabled() bool {
+	return tracerProvider != nil
+}
+
+// endSpan marks span failed when err is set and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,130 @@
+package crawler
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   HTTP TRANSPORT CONFIG
+	==============================
+*/
+
+const (
+	MaxIdleConns        = 100
+	MaxIdleConnsPerHost = 8
+	IdleConnTimeout     = 90 * time.Second
+	TLSHandshakeTimeout = 10 * time.Second
+	DialTimeout         = 30 * time.Second
+)
+
+// transportConfig tunes the connection pool shared by every crawler
+// request. It is read from the environment by configureTransport.
+type transportConfig struct {
+	maxIdle        int
+	maxIdlePerHost int
+	idleTimeout    time.Duration
+	tlsTimeout     time.Duration
+	http2          bool
+}
+
+var transportTuning = transportConfig{
+	maxIdle:        MaxIdleConns,
+	maxIdlePerHost: MaxIdleConnsPerHost,
+	idleTimeout:    IdleConnTimeout,
+	tlsTimeout:     TLSHandshakeTimeout,
+	http2:          true,
+}
+
+// crawlClient is the one HTTP client all page, robots and sitemap fetches
+// go through, so keep-alive and HTTP/2 connections are reused across
+// pages. configureClient rebuilds it once crawlTransport is final.
+var crawlClient = &http.Client{
+	Timeout:       ImageTimeout,
+	Transport:     http.DefaultTransport,
+	CheckRedirect: checkRedirect,
+}
+
+// configureTransport reads the pool settings (IMG_MAX_IDLE_CONNS,
+// IMG_MAX_IDLE_CONNS_PER_HOST, IMG_IDLE_CONN_TIMEOUT,
+// IMG_TLS_HANDSHAKE_TIMEOUT, IMG_HTTP2) and the DNS cache settings
+// (IMG_DNS_CACHE, IMG_DNS_MAX_TTL, IMG_DNS_NEGATIVE_TTL), then installs
+// the base transport.
+func configureTransport() {
+	transportTuning = transportConfig{
+		maxIdle:        env.Int("IMG_MAX_IDLE_CONNS", MaxIdleConns),
+		maxIdlePerHost: env.Int("IMG_MAX_IDLE_CONNS_PER_HOST", MaxIdleConnsPerHost),
+		idleTimeout:    env.Duration("IMG_IDLE_CONN_TIMEOUT", IdleConnTimeout),
+		tlsTimeout:     env.Duration("IMG_TLS_HANDSHAKE_TIMEOUT", TLSHandshakeTimeout),
+		http2:          env.Get("IMG_HTTP2", "true") != "false",
+	}
+	if env.Get("IMG_DNS_CACHE", "true") != "false" {
+		crawlDNS = newDNSCache(
+			env.Duration("IMG_DNS_MAX_TTL", DNSMaxTTL),
+			env.Duration("IMG_DNS_NEGATIVE_TTL", DNSNegativeTTL),
+		)
+	}
+	crawlTransport = newTunedTransport(nil)
+}
+
+// configureClient points the shared client at the final crawlTransport,
+// after dial guard, proxies, headers and credentials have wrapped it, and
+// counts its requests for the status API.
+func configureClient() {
+	crawlTransport = &trafficTransport{base: crawlTransport}
+	crawlClient = &http.Client{
+		Timeout:       ImageTimeout,
+		Transport:     crawlTransport,
+		CheckRedirect: checkRedirect,
+	}
+	if crawlJar != nil {
+		crawlClient.Jar = crawlJar
+	}
+}
+
+// newTunedTransport builds a transport with the configured pool limits.
+// control, when set, vets every socket before it connects.
+func newTunedTransport(control func(network, address string, c syscall.RawConn) error) *http.Transport {
+	cfg := transportTuning
+
+	dialer := &net.Dialer{
+		Timeout:   DialTimeout,
+		KeepAlive: 30 * time.Second,
+		Control:   control,
+	}
+
+	dial := dialer.DialContext
+	if crawlDNS != nil {
+		dial = crawlDNS.DialContext(dial)
+	}
+
+	t := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dial,
+		ForceAttemptHTTP2:     cfg.http2,
+		MaxIdleConns:          cfg.maxIdle,
+		MaxIdleConnsPerHost:   cfg.maxIdlePerHost,
+		IdleConnTimeout:       cfg.idleTimeout,
+		TLSHandshakeTimeout:   cfg.tlsTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if !cfg.http2 {
+		// a non-nil empty map turns off the automatic HTTP/2 upgrade
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
+
+func logTransport() {
+	cfg := transportTuning
+	slog.Info("HTTP pool",
+		"idle_conns", cfg.maxIdle, "idle_conns_per_host", cfg.maxIdlePerHost, "idle_timeout", cfg.idleTimeout,
+		"tls_timeout", cfg.tlsTimeout, "http2", cfg.http2, "dns_cache", crawlDNS != nil)
+}
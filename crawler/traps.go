@@ -0,0 +1,182 @@
+package crawler
+
+import (
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   CRAWL TRAP CONFIG
+	==============================
+*/
+
+const (
+	TrapMaxPerPattern    = 100
+	TrapMaxQueryVariants = 20
+	TrapMaxURLLength     = 512
+	TrapMaxPathDepth     = 12
+	TrapMaxSegmentRepeat = 3
+)
+
+// sessionParams are query parameters that only carry a session ID.
+var sessionParams = map[string]bool{
+	"sid":          true,
+	"sessionid":    true,
+	"session_id":   true,
+	"phpsessid":    true,
+	"jsessionid":   true,
+	"aspsessionid": true,
+	"cfid":         true,
+	"cftoken":      true,
+}
+
+var digitRun = regexp.MustCompile(`[0-9]+`)
+
+/*
+	==============================
+	   URL CANONICALIZATION
+	==============================
+*/
+
+// canonicalLink strips session IDs (including ";jsessionid=" path
+// parameters) and sorts the query, so the same page reached through
+// different sessions or parameter orders dedups to one URL.
+func canonicalLink(u *url.URL) *url.URL {
+	c := *u
+
+	if i := strings.Index(strings.ToLower(c.Path), ";jsessionid="); i >= 0 {
+		c.Path = c.Path[:i]
+		c.RawPath = ""
+	}
+
+	if c.RawQuery != "" {
+		q := c.Query()
+		for key := range q {
+			if sessionParams[strings.ToLower(key)] {
+				q.Del(key)
+			}
+		}
+		// Encode sorts by key
+		c.RawQuery = q.Encode()
+	}
+
+	return &c
+}
+
+/*
+	==============================
+	   CRAWL TRAP DETECTION
+	==============================
+*/
+
+// trapDetector refuses links that look like an infinite URL space:
+//
+//   - overlong URLs, very deep paths and paths repeating a segment
+//   - too many distinct URLs sharing one shape, where the shape is the
+//     host and path with digit runs masked plus the sorted query keys
+//     (calendars: /events/2024/05/12, endless ?page=N)
+//   - too many distinct query-key combinations on one path (faceted
+//     navigation: ?color=&size=&sort=...)
+type trapDetector struct {
+	maxPerPattern int
+	maxVariants   int
+
+	mu       sync.Mutex
+	patterns map[string]map[string]bool
+	variants map[string]map[string]bool
+	flagged  map[string]bool
+}
+
+func newTrapDetector() *trapDetector {
+	return &trapDetector{
+		maxPerPattern: env.Int("IMG_TRAP_MAX_PER_PATTERN", TrapMaxPerPattern),
+		maxVariants:   env.Int("IMG_TRAP_MAX_QUERY_VARIANTS", TrapMaxQueryVariants),
+		patterns:      map[string]map[string]bool{},
+		variants:      map[string]map[string]bool{},
+		flagged:       map[string]bool{},
+	}
+}
+
+func urlShape(u *url.URL) (shape, path, keys string) {
+	path = u.Hostname() + u.EscapedPath()
+
+	names := make([]string, 0)
+	for k := range u.Query() {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	keys = strings.Join(names, "&")
+
+	shape = digitRun.ReplaceAllString(path, "N") + "?" + keys
+	return shape, path, keys
+}
+
+func hasRepeatedSegments(path string) bool {
+	counts := map[string]int{}
+	for _, seg := range strings.Split(path, "/") {
+		if seg == "" {
+			continue
+		}
+		counts[seg]++
+		if counts[seg] > TrapMaxSegmentRepeat {
+			return true
+		}
+	}
+	return false
+}
+
+// track records member under key and reports false when the key already
+// holds limit other members.
+func (d *trapDetector) track(sets map[string]map[string]bool, key, member string, limit int) bool {
+	set := sets[key]
+	if set == nil {
+		set = map[string]bool{}
+		sets[key] = set
+	}
+	if set[member] {
+		return true
+	}
+	if len(set) >= limit {
+		if !d.flagged[key] {
+			d.flagged[key] = true
+			slog.Warn("Crawl trap suspected, no longer following", "pattern", key)
+		}
+		return false
+	}
+	set[member] = true
+	return true
+}
+
+// Allow reports whether u may be enqueued, with a reason when it may not.
+func (d *trapDetector) Allow(u *url.URL) (bool, string) {
+	link := u.String()
+	if len(link) > TrapMaxURLLength {
+		return false, "url too long"
+	}
+	if strings.Count(u.Path, "/") > TrapMaxPathDepth {
+		return false, "path too deep"
+	}
+	if hasRepeatedSegments(u.Path) {
+		return false, "repeated path segments"
+	}
+
+	shape, path, keys := urlShape(u)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.maxPerPattern > 0 && !d.track(d.patterns, shape, link, d.maxPerPattern) {
+		return false, "too many urls with shape " + shape
+	}
+	if d.maxVariants > 0 && keys != "" && !d.track(d.variants, path, keys, d.maxVariants) {
+		return false, "too many query variants on " + path
+	}
+	return true, ""
+}
@@ -0,0 +1,125 @@
+package crawler
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalLink(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+		want string
+	}{
+		{name: "plain", link: "https://example.test/a", want: "https://example.test/a"},
+		{name: "query is sorted", link: "https://example.test/a?b=2&a=1", want: "https://example.test/a?a=1&b=2"},
+		{name: "session params dropped", link: "https://example.test/a?PHPSESSID=x&id=3&sid=y", want: "https://example.test/a?id=3"},
+		{name: "jsessionid path parameter dropped", link: "https://example.test/a;jsessionid=ABC?id=3", want: "https://example.test/a?id=3"},
+		{name: "only session params", link: "https://example.test/a?jsessionid=x", want: "https://example.test/a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := canonicalLink(u).String(); got != tt.want {
+				t.Errorf("canonicalLink(%s) = %s, want %s", tt.link, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrapDetector(t *testing.T) {
+	tests := []struct {
+		name    string
+		links   []string
+		allowed int // how many of links are allowed, in order
+	}{
+		{
+			name:    "ordinary links",
+			links:   []string{"https://example.test/a", "https://example.test/b?id=1"},
+			allowed: 2,
+		},
+		{
+			name:    "overlong URL",
+			links:   []string{"https://example.test/" + strings.Repeat("a", TrapMaxURLLength)},
+			allowed: 0,
+		},
+		{
+			name:    "deep path",
+			links:   []string{"https://example.test" + strings.Repeat("/d", TrapMaxPathDepth+1)},
+			allowed: 0,
+		},
+		{
+			name:    "repeated segment",
+			links:   []string{"https://example.test/x/a/x/b/x/c/x"},
+			allowed: 0,
+		},
+		{
+			name:    "calendar shape",
+			links:   numbered("https://example.test/events/2024/05/%d", 5),
+			allowed: 3,
+		},
+		{
+			name:    "paging shape",
+			links:   numbered("https://example.test/list?page=%d", 5),
+			allowed: 3,
+		},
+		{
+			name:    "a repeated URL is not a new member",
+			links:   []string{"https://example.test/p/1", "https://example.test/p/1", "https://example.test/p/1", "https://example.test/p/1"},
+			allowed: 4,
+		},
+		{
+			name: "faceted navigation",
+			links: []string{
+				"https://example.test/shop?color=red",
+				"https://example.test/shop?size=m",
+				"https://example.test/shop?sort=price",
+				"https://example.test/shop?color=red&size=m",
+				"https://example.test/shop?color=red&sort=price",
+			},
+			allowed: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &trapDetector{
+				maxPerPattern: 3,
+				maxVariants:   4,
+				patterns:      map[string]map[string]bool{},
+				variants:      map[string]map[string]bool{},
+				flagged:       map[string]bool{},
+			}
+			allowed := 0
+			for _, link := range tt.links {
+				u, err := url.Parse(link)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ok, reason := d.Allow(u)
+				if ok != (allowed < tt.allowed) {
+					t.Fatalf("Allow(%s) = %v (%s) after %d allowed, want %d allowed", link, ok, reason, allowed, tt.allowed)
+				}
+				if ok {
+					allowed++
+				} else if reason == "" {
+					t.Errorf("Allow(%s) refused without a reason", link)
+				}
+			}
+		})
+	}
+}
+
+func numbered(format string, n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf(format, i+1)
+	}
+	return out
+}
@@ -0,0 +1,139 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   URL INCLUDE/EXCLUDE FILTERS
+	==============================
+*/
+
+// urlFilterFile is the shape of IMG_URL_FILTERS_FILE:
+//
+//	{
+//	  "include": ["/gallery/"],
+//	  "exclude": ["/login", "/cart", "[?&]print=1"],
+//	  "domains": {
+//	    "example.com": {"exclude": ["/members/"]}
+//	  }
+//	}
+//
+// A domain entry replaces the global include and/or exclude list for that
+// domain and its subdomains; a list it leaves out falls back to the global
+// one.
+type urlFilterFile struct {
+	Include []string                 `json:"include"`
+	Exclude []string                 `json:"exclude"`
+	Domains map[string]urlFilterFile `json:"domains"`
+}
+
+type urlRules struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+type urlFilter struct {
+	global  urlRules
+	domains map[string]urlRules
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var out []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("url filter %q: %w", p, err)
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
+// loadURLFilter reads IMG_URL_FILTERS_FILE plus the whitespace separated
+// IMG_URL_INCLUDE / IMG_URL_EXCLUDE lists, which add to the file's global
+// lists.
+func loadURLFilter() (*urlFilter, error) {
+	var cfg urlFilterFile
+	if path := env.Get("IMG_URL_FILTERS_FILE", ""); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("IMG_URL_FILTERS_FILE: %w", err)
+		}
+	}
+	cfg.Include = append(cfg.Include, strings.Fields(env.Get("IMG_URL_INCLUDE", ""))...)
+	cfg.Exclude = append(cfg.Exclude, strings.Fields(env.Get("IMG_URL_EXCLUDE", ""))...)
+
+	f := &urlFilter{domains: map[string]urlRules{}}
+
+	var err error
+	if f.global.include, err = compilePatterns(cfg.Include); err != nil {
+		return nil, err
+	}
+	if f.global.exclude, err = compilePatterns(cfg.Exclude); err != nil {
+		return nil, err
+	}
+
+	for domain, d := range cfg.Domains {
+		rules := f.global
+		if d.Include != nil {
+			if rules.include, err = compilePatterns(d.Include); err != nil {
+				return nil, err
+			}
+		}
+		if d.Exclude != nil {
+			if rules.exclude, err = compilePatterns(d.Exclude); err != nil {
+				return nil, err
+			}
+		}
+		f.domains[strings.ToLower(domain)] = rules
+	}
+
+	return f, nil
+}
+
+// rulesFor picks the most specific domain override for host.
+func (f *urlFilter) rulesFor(host string) urlRules {
+	rules := f.global
+	best := -1
+	for d, r := range f.domains {
+		if (host == d || strings.HasSuffix(host, "."+d)) && len(d) > best {
+			best = len(d)
+			rules = r
+		}
+	}
+	return rules
+}
+
+// Allow applies the filters to the full URL. Excludes win; when include
+// patterns exist the URL must match one of them.
+func (f *urlFilter) Allow(u *url.URL) bool {
+	rules := f.rulesFor(strings.ToLower(u.Hostname()))
+	link := u.String()
+
+	for _, re := range rules.exclude {
+		if re.MatchString(link) {
+			return false
+		}
+	}
+	if len(rules.include) == 0 {
+		return true
+	}
+	for _, re := range rules.include {
+		if re.MatchString(link) {
+			return true
+		}
+	}
+	return false
+}
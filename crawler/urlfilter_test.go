@@ -0,0 +1,71 @@
+package crawler
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestURLFilterAllow(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "filters.json")
+	err := os.WriteFile(file, []byte(`{
+		"exclude": ["/login", "[?&]print=1"],
+		"domains": {
+			"example.com": {"include": ["/gallery/"]},
+			"img.example.com": {"exclude": ["/private/"]},
+			"Shop.test": {"include": [], "exclude": []}
+		}
+	}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("IMG_URL_FILTERS_FILE", file)
+	t.Setenv("IMG_URL_INCLUDE", "")
+	t.Setenv("IMG_URL_EXCLUDE", `\.pdf$ /cart`)
+
+	f, err := loadURLFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		link string
+		want bool
+	}{
+		{name: "no rules match", link: "https://other.test/a.html", want: true},
+		{name: "file exclude", link: "https://other.test/login?next=/", want: false},
+		{name: "file exclude on the query", link: "https://other.test/a?x=1&print=1", want: false},
+		{name: "env excludes add to the file's", link: "https://other.test/doc.pdf", want: false},
+		{name: "second env exclude", link: "https://other.test/cart/1", want: false},
+		{name: "domain include admits", link: "https://example.com/gallery/1", want: true},
+		{name: "domain include rejects the rest", link: "https://example.com/blog/1", want: false},
+		{name: "domain include covers subdomains", link: "https://www.example.com/blog/1", want: false},
+		{name: "domain keeps the global excludes", link: "https://example.com/gallery/login", want: false},
+		{name: "most specific domain wins", link: "https://img.example.com/blog/1", want: true},
+		{name: "its own exclude replaces the global", link: "https://img.example.com/login", want: true},
+		{name: "its own exclude applies", link: "https://img.example.com/private/a.jpg", want: false},
+		{name: "empty lists replace the global ones", link: "https://shop.test/cart/1", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Allow(u); got != tt.want {
+				t.Errorf("Allow(%s) = %v, want %v", tt.link, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadURLFilterBadPattern(t *testing.T) {
+	t.Setenv("IMG_URL_FILTERS_FILE", "")
+	t.Setenv("IMG_URL_EXCLUDE", "(unclosed")
+	if _, err := loadURLFilter(); err == nil {
+		t.Error("loadURLFilter accepted an invalid pattern")
+	}
+}
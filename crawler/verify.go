@@ -0,0 +1,144 @@
+package crawler
+
+import (
+	"context"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"image_crawler/extract"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   IMAGE URL VERIFICATION
+	==============================
+*/
+
+const MaxCachedVerifications = 5000
+
+// headResult is what a HEAD request told us about an image URL.
+type headResult struct {
+	ok            bool // false when the image is gone or is not an image
+	status        int  // 0 when no response came
+	contentType   string
+	contentLength int64
+}
+
+// verifyCache remembers HEAD answers per file URL, so an image shared by
+// every page of a site (logos, banners) is checked once.
+type verifyCache struct {
+	mu      sync.Mutex
+	entries map[string]headResult
+}
+
+func newVerifyCache() *verifyCache {
+	return &verifyCache{entries: map[string]headResult{}}
+}
+
+// verifyImages HEADs each image before it is stored when
+// IMG_VERIFY_IMAGES=true. Images that are gone (404/410) or served as
+// something other than an image are dropped; the rest get Content-Type
+// and Content-Length, and a Format corrected from the Content-Type when
+// the extension lies. Hosts that refuse HEAD or fail for other reasons
+// keep their images unverified.
+func (c *imageCrawler) verifyImages(ctx context.Context, page string, images []store.ImageRecord) []store.ImageRecord {
+	out := images[:0]
+	for _, img := range images {
+		if !strings.HasPrefix(img.FileURL, "http://") && !strings.HasPrefix(img.FileURL, "https://") {
+			out = append(out, img)
+			continue
+		}
+
+		res, ok := c.headImage(ctx, page, img.FileURL)
+		if !ok {
+			out = append(out, img)
+			continue
+		}
+		if !res.ok {
+			slog.Info("Dropping unreachable image", "url", img.FileURL)
+			continue
+		}
+
+		img.ContentType = res.contentType
+		img.ContentLength = res.contentLength
+		img.FileSize = res.contentLength
+		if f := extract.ImageFormat("", res.contentType); f != "" && f != img.Format {
+			img.Format = f
+		}
+		out = append(out, img)
+	}
+	return out
+}
+
+// headImage returns the cached or fresh HEAD result for link. The bool is
+// false when nothing could be learned.
+func (c *imageCrawler) headImage(ctx context.Context, page, link string) (headResult, bool) {
+	c.verified.mu.Lock()
+	res, ok := c.verified.entries[link]
+	c.verified.mu.Unlock()
+	if ok {
+		return res, true
+	}
+
+	u, err := url.Parse(link)
+	if err != nil || !c.robots.Allowed(u) {
+		return headResult{}, false
+	}
+	if err := c.limiter.Wait(ctx, u); err != nil {
+		return headResult{}, false
+	}
+
+	res, ok = headRequest(ctx, page, link)
+	if !ok {
+		return headResult{}, false
+	}
+
+	c.verified.mu.Lock()
+	if len(c.verified.entries) < MaxCachedVerifications {
+		c.verified.entries[link] = res
+	}
+	c.verified.mu.Unlock()
+	return res, true
+}
+
+func headRequest(ctx context.Context, page, link string) (headResult, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, link, nil)
+	if err != nil {
+		return headResult{}, false
+	}
+	req.Header.Set("Accept", "image/*")
+	// hotlink protection often rejects image requests without one
+	req.Header.Set("Referer", page)
+
+	resp, err := crawlClient.Do(req)
+	if err != nil {
+		slog.Error("Verifying image failed", "url", link, "error", err)
+		return headResult{}, false
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusGone:
+		return headResult{status: resp.StatusCode}, true
+	case resp.StatusCode != http.StatusOK:
+		return headResult{status: resp.StatusCode}, false
+	}
+
+	ctype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	res := headResult{
+		ok:            true,
+		status:        resp.StatusCode,
+		contentType:   ctype,
+		contentLength: max(resp.ContentLength, 0),
+	}
+	// an HTML error page answering 200 for a missing image
+	if ctype != "" && !strings.HasPrefix(ctype, "image/") && ctype != "application/octet-stream" {
+		res.ok = false
+	}
+	return res, true
+}
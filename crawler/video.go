@@ -0,0 +1,189 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"image_crawler/extract"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   VIDEO THUMBNAILS
+	==============================
+*/
+
+const (
+	VimeoOEmbedURL     = "https://vimeo.com/api/oembed.json?url="
+	MaxOEmbedSize      = 64 * 1024
+	MaxCachedVideoIDs  = 2000
+	VideoPosterSource  = "video_poster"
+	YouTubeThumbFormat = "https://i.ytimg.com/vi/%s/hqdefault.jpg"
+)
+
+var (
+	youtubeID = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	vimeoID   = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// videoEmbed identifies a video from an iframe src: its provider and ID.
+func videoEmbed(u *url.URL) (provider, id string) {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	switch host {
+	case "youtube.com", "youtube-nocookie.com", "m.youtube.com":
+		if len(parts) == 2 && (parts[0] == "embed" || parts[0] == "shorts") && youtubeID.MatchString(parts[1]) {
+			return "youtube", parts[1]
+		}
+		if parts[0] == "watch" {
+			if v := u.Query().Get("v"); youtubeID.MatchString(v) {
+				return "youtube", v
+			}
+		}
+	case "youtu.be":
+		if len(parts) == 1 && youtubeID.MatchString(parts[0]) {
+			return "youtube", parts[0]
+		}
+	case "player.vimeo.com":
+		if len(parts) == 2 && parts[0] == "video" && vimeoID.MatchString(parts[1]) {
+			return "vimeo", parts[1]
+		}
+	}
+	return "", ""
+}
+
+// videoImages returns <video poster> frames and thumbnails of embedded
+// YouTube and Vimeo players. YouTube thumbnails follow a fixed URL
+// scheme; Vimeo's are looked up once per video through its oEmbed API.
+func (c *imageCrawler) videoImages(ctx context.Context, page string, doc *goquery.Document) []store.ImageRecord {
+	base, _ := url.Parse(page)
+	domain := base.Hostname()
+
+	var out []store.ImageRecord
+	add := func(link, alt string) {
+		out = append(out, store.ImageRecord{
+			FileURL:    link,
+			AltText:    alt,
+			PageURL:    page,
+			DomainName: domain,
+			Format:     extract.ImageFormat(link, ""),
+			Source:     VideoPosterSource,
+			LastSeen:   time.Now().UTC(),
+		})
+	}
+
+	doc.Find("video[poster]").Each(func(_ int, v *goquery.Selection) {
+		raw, _ := v.Attr("poster")
+		u, err := extract.ResolveURL(base, raw)
+		if err != nil || !extract.IsImageURL(u.String()) {
+			return
+		}
+		add(u.String(), videoTitle(v))
+	})
+
+	doc.Find("iframe[src]").Each(func(_ int, f *goquery.Selection) {
+		raw, _ := f.Attr("src")
+		u, err := extract.ResolveURL(base, raw)
+		if err != nil {
+			return
+		}
+		switch provider, id := videoEmbed(u); provider {
+		case "youtube":
+			add(fmt.Sprintf(YouTubeThumbFormat, id), videoTitle(f))
+		case "vimeo":
+			if thumb := c.vimeoThumbnail(ctx, id); thumb != "" {
+				add(thumb, videoTitle(f))
+			}
+		}
+	})
+
+	return out
+}
+
+func videoTitle(s *goquery.Selection) string {
+	for _, a := range []string{"title", "aria-label"} {
+		if v, ok := s.Attr(a); ok && strings.TrimSpace(v) != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// vimeoCache remembers oEmbed answers per video ID, misses included as "".
+type vimeoCache struct {
+	mu      sync.Mutex
+	entries map[string]string
+}
+
+func newVimeoCache() *vimeoCache {
+	return &vimeoCache{entries: map[string]string{}}
+}
+
+func (c *imageCrawler) vimeoThumbnail(ctx context.Context, id string) string {
+	c.vimeo.mu.Lock()
+	thumb, ok := c.vimeo.entries[id]
+	c.vimeo.mu.Unlock()
+	if ok {
+		return thumb
+	}
+
+	thumb, err := fetchVimeoThumbnail(ctx, c.limiter, id)
+	if err != nil {
+		slog.Error("Fetching Vimeo thumbnail failed", "video", id, "error", err)
+		return ""
+	}
+
+	c.vimeo.mu.Lock()
+	if len(c.vimeo.entries) < MaxCachedVideoIDs {
+		c.vimeo.entries[id] = thumb
+	}
+	c.vimeo.mu.Unlock()
+	return thumb
+}
+
+func fetchVimeoThumbnail(ctx context.Context, limiter *hostLimiter, id string) (string, error) {
+	link := VimeoOEmbedURL + url.QueryEscape("https://vimeo.com/"+id)
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	if err := limiter.Wait(ctx, u); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := crawlClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// private or deleted videos answer 403/404: cache the miss
+	if resp.StatusCode != http.StatusOK {
+		return "", nil
+	}
+
+	var meta struct {
+		ThumbnailURL string `json:"thumbnail_url"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, MaxOEmbedSize)).Decode(&meta); err != nil {
+		return "", err
+	}
+	return meta.ThumbnailURL, nil
+}
@@ -0,0 +1,175 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   WARC ARCHIVE
+	==============================
+*/
+
+const (
+	// a file is closed and the next one started past this size
+	MaxWARCBytes = 1 << 30
+	WARCPrefix   = "image-crawl"
+)
+
+// warcWriter appends the HTML responses the crawl fetches to gzipped WARC
+// 1.1 files in IMG_WARC_DIR, one gzip member per record as readers
+// expect. Bodies are stored decoded: Content-Encoding and
+// Transfer-Encoding are dropped from the archived headers and
+// Content-Length states the stored length.
+type warcWriter struct {
+	dir    string
+	prefix string
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	serial int
+}
+
+// newWARCWriter returns nil when IMG_WARC_DIR is unset.
+func newWARCWriter() (*warcWriter, error) {
+	dir := env.Get("IMG_WARC_DIR", "")
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("IMG_WARC_DIR: %w", err)
+	}
+	return &warcWriter{dir: dir, prefix: env.Get("IMG_WARC_PREFIX", WARCPrefix)}, nil
+}
+
+// WriteResponse archives one fetched page.
+func (w *warcWriter) WriteResponse(target string, fetched time.Time, res *FetchResult) error {
+	return w.write([][2]string{
+		{"WARC-Type", "response"},
+		{"WARC-Target-URI", target},
+		{"WARC-Date", fetched.UTC().Format(time.RFC3339)},
+		{"Content-Type", "application/http;msgtype=response"},
+		{"WARC-Payload-Digest", warcDigest(res.Body)},
+	}, responseBlock(res))
+}
+
+// responseBlock serializes the response as received, with the decoded
+// body and the headers adjusted to match it.
+func responseBlock(res *FetchResult) []byte {
+	var block bytes.Buffer
+	fmt.Fprintf(&block, "%s %s\r\n", res.Proto, res.Status)
+	header := res.Header.Clone()
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", fmt.Sprint(len(res.Body)))
+	header.Write(&block)
+	block.WriteString("\r\n")
+	block.Write(res.Body)
+	return block.Bytes()
+}
+
+// Close finishes the current file.
+func (w *warcWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+func (w *warcWriter) write(fields [][2]string, block []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil || w.size >= MaxWARCBytes {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.f.Write(warcRecord(fields, block))
+	w.size += int64(n)
+	return err
+}
+
+// rotate closes the current file and opens the next, starting it with a
+// warcinfo record.
+func (w *warcWriter) rotate() error {
+	if w.f != nil {
+		if err := w.f.Close(); err != nil {
+			return err
+		}
+		w.f = nil
+	}
+	w.serial++
+	name := fmt.Sprintf("%s-%s-%05d.warc.gz", w.prefix, time.Now().UTC().Format("20060102150405"), w.serial)
+	f, err := os.OpenFile(filepath.Join(w.dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	w.f, w.size = f, 0
+
+	info := "software: image_crawler\r\nformat: WARC File Format 1.1\r\n"
+	n, err := w.f.Write(warcRecord([][2]string{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Date", time.Now().UTC().Format(time.RFC3339)},
+		{"WARC-Filename", name},
+		{"Content-Type", "application/warc-fields"},
+	}, []byte(info)))
+	w.size += int64(n)
+	return err
+}
+
+// warcRecord renders one gzipped record: the named header fields plus the
+// record id, block digest and length every record carries.
+func warcRecord(fields [][2]string, block []byte) []byte {
+	var rec bytes.Buffer
+	rec.WriteString("WARC/1.1\r\n")
+	fmt.Fprintf(&rec, "WARC-Record-ID: <urn:uuid:%s>\r\n", newUUID())
+	for _, f := range fields {
+		fmt.Fprintf(&rec, "%s: %s\r\n", f[0], strings.NewReplacer("\r", "", "\n", "").Replace(f[1]))
+	}
+	fmt.Fprintf(&rec, "WARC-Block-Digest: %s\r\n", warcDigest(block))
+	fmt.Fprintf(&rec, "Content-Length: %d\r\n\r\n", len(block))
+	rec.Write(block)
+	rec.WriteString("\r\n\r\n")
+
+	var out bytes.Buffer
+	zw := gzip.NewWriter(&out)
+	zw.Write(rec.Bytes())
+	zw.Close()
+	return out.Bytes()
+}
+
+// warcDigest is the SHA-1 digest WARC tools use, in base32.
+func warcDigest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,309 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   WARC INGESTION
+	==============================
+*/
+
+const (
+	// CommonCrawlBase resolves the relative paths in Common Crawl listings
+	// (warc.paths.gz).
+	CommonCrawlBase = "https://data.commoncrawl.org/"
+	// MaxWARCBlock bounds the records ingestion reads: an HTML page plus
+	// its headers. Larger records (video, archives) are skipped.
+	MaxWARCBlock = MaxImageBodySize + 64*1024
+)
+
+// IngestWARC builds the index from archived pages instead of live
+// fetches (IMG_MODE=warc). IMG_WARC_FILES lists WARC files, plain or
+// gzipped: local paths or globs, http(s) URLs, and Common Crawl
+// warc.paths(.gz) listings, which stand for every segment they name. Each
+// HTML response goes through the same extraction and filters as a crawled
+// page; nothing is fetched except what enrichment and the optional
+// verification, stylesheet and video lookups ask for. Without
+// IMG_ALLOWED_SITES every host is accepted.
+func IngestWARC(ctx context.Context, st store.Store) error {
+	sources, err := warcSources(ctx, env.List("IMG_WARC_FILES"))
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("IMG_WARC_FILES is empty")
+	}
+
+	allowed := env.List("IMG_ALLOWED_SITES")
+	if len(allowed) == 0 {
+		allowed = []string{"*"}
+	}
+	c, err := newImageCrawler(st, nil, allowed)
+	if err != nil {
+		return err
+	}
+	slog.Info("Ingesting WARC files", "files", len(sources), "workers", c.workers)
+	c.beginRun(ctx, "warc", sources)
+	defer c.endRun(ctx, nil)
+
+	work, cancelWork := workContext(ctx, env.Duration("IMG_SHUTDOWN_GRACE", ShutdownGrace))
+	defer cancelWork()
+	c.enricher.Start(work)
+	c.writer.Start(work)
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for src := range jobs {
+				pages, err := c.ingestWARC(ctx, src)
+				if err != nil {
+					slog.Error("Reading WARC file failed", "path", src, "error", err)
+				}
+				slog.Info("Ingested WARC file", "path", src, "pages", pages)
+			}
+		}()
+	}
+feed:
+	for _, src := range sources {
+		select {
+		case jobs <- src:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	c.writer.Close()
+	c.enricher.Close()
+
+	slog.Info("WARC ingestion done", "pages", c.pages.Load(), "images", c.images.Load())
+	return nil
+}
+
+// warcSources expands globs and Common Crawl path listings.
+func warcSources(ctx context.Context, entries []string) ([]string, error) {
+	var out []string
+	for _, e := range entries {
+		switch {
+		case strings.HasSuffix(e, ".paths") || strings.HasSuffix(e, ".paths.gz"):
+			r, err := openWARCSource(ctx, e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", e, err)
+			}
+			sc := bufio.NewScanner(r)
+			for sc.Scan() {
+				if p := strings.TrimSpace(sc.Text()); p != "" {
+					out = append(out, CommonCrawlBase+p)
+				}
+			}
+			r.Close()
+			if err := sc.Err(); err != nil {
+				return nil, fmt.Errorf("%s: %w", e, err)
+			}
+		case isRemote(e):
+			out = append(out, e)
+		default:
+			matches, err := filepath.Glob(e)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%s: no such file", e)
+			}
+			out = append(out, matches...)
+		}
+	}
+	return out, nil
+}
+
+func isRemote(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+// openWARCSource opens a local or remote file, gunzipping it when its name
+// ends in .gz. Multi-member gzip (one member per record) reads as one
+// stream.
+func openWARCSource(ctx context.Context, src string) (io.ReadCloser, error) {
+	var raw io.ReadCloser
+	if isRemote(src) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+		if err != nil {
+			return nil, err
+		}
+		// segments are around a gigabyte, so no overall timeout
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("status %s", resp.Status)
+		}
+		raw = resp.Body
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		raw = f
+	}
+
+	if !strings.HasSuffix(strings.ToLower(src), ".gz") {
+		return raw, nil
+	}
+	zr, err := gzip.NewReader(raw)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{zr, raw}, nil
+}
+
+// ingestWARC extracts images from every HTML response in one file and
+// returns how many pages it used.
+func (c *imageCrawler) ingestWARC(ctx context.Context, src string) (int, error) {
+	r, err := openWARCSource(ctx, src)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	wr := newWARCReader(r)
+	pages := 0
+	for ctx.Err() == nil {
+		header, block, err := wr.Next()
+		if err == io.EOF {
+			return pages, nil
+		}
+		if err != nil {
+			return pages, err
+		}
+		if header.Get("WARC-Type") != "response" ||
+			!strings.HasPrefix(header.Get("Content-Type"), "application/http") {
+			continue
+		}
+		if c.ingestResponse(ctx, header, block) {
+			pages++
+			c.pages.Add(1)
+			c.stats.page(strings.Trim(header.Get("WARC-Target-URI"), "<>"))
+		}
+	}
+	return pages, ctx.Err()
+}
+
+// ingestResponse handles one archived HTTP response, reporting whether it
+// was an HTML page the crawl would have taken.
+func (c *imageCrawler) ingestResponse(ctx context.Context, header textproto.MIMEHeader, block []byte) bool {
+	target := strings.Trim(header.Get("WARC-Target-URI"), "<>")
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !c.hostAllowed(u) || !c.rules.Load().filter.Allow(u) {
+		return false
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(block)), nil)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
+		return false
+	}
+	decoded, err := decodeBody(resp)
+	if err != nil {
+		return false
+	}
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(decoded, MaxImageBodySize))
+	if err != nil {
+		return false
+	}
+
+	if pageRobotsDirectives(doc, resp.Header.Values("X-Robots-Tag")).NoImageIndex {
+		slog.Info("Images not indexed (noimageindex)", "url", target)
+		return true
+	}
+
+	found := c.pageImages(ctx, target, doc)
+	// the image was seen when the page was archived, not now
+	if fetched, err := time.Parse(time.RFC3339, header.Get("WARC-Date")); err == nil {
+		for i := range found {
+			found[i].LastSeen = fetched.UTC()
+		}
+	}
+	c.writer.Add(ctx, found)
+	return true
+}
+
+// warcReader reads records one at a time. Blocks over MaxWARCBlock are
+// skipped without being held in memory.
+type warcReader struct {
+	r  *bufio.Reader
+	tp *textproto.Reader
+}
+
+func newWARCReader(r io.Reader) *warcReader {
+	br := bufio.NewReaderSize(r, 64*1024)
+	return &warcReader{r: br, tp: textproto.NewReader(br)}
+}
+
+// Next returns the header and block of the next record, with a nil block
+// when it was too large to read.
+func (w *warcReader) Next() (textproto.MIMEHeader, []byte, error) {
+	// the version line, after the blank lines ending the previous record
+	var version string
+	for version == "" {
+		line, err := w.tp.ReadLine()
+		if err != nil {
+			return nil, nil, err
+		}
+		version = strings.TrimSpace(line)
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, nil, fmt.Errorf("not a WARC record: %q", version)
+	}
+
+	header, err := w.tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return nil, nil, fmt.Errorf("bad WARC Content-Length %q", header.Get("Content-Length"))
+	}
+	if length > MaxWARCBlock {
+		_, err := io.CopyN(io.Discard, w.r, length)
+		return header, nil, err
+	}
+	block := make([]byte, length)
+	if _, err := io.ReadFull(w.r, block); err != nil {
+		return nil, nil, err
+	}
+	return header, block, nil
+}
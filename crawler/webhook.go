@@ -0,0 +1,174 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   WEBHOOKS
+	==============================
+*/
+
+const (
+	WebhookTimeout  = 10 * time.Second
+	WebhookAttempts = 3
+
+	CrawlStarted   = "crawl.started"
+	CrawlCompleted = "crawl.completed"
+	CrawlFailed    = "crawl.failed"
+)
+
+// webhook posts crawl lifecycle events as JSON to every URL in
+// IMG_WEBHOOK_URLS. The payload carries a text summary, so a Slack
+// incoming webhook can take it as is. With IMG_WEBHOOK_SECRET set, each
+// request is signed: X-Webhook-Signature is sha256= and the hex HMAC-SHA256
+// of the body.
+type webhook struct {
+	urls   []string
+	secret string
+
+	// not crawlClient: webhook receivers are configured by the operator
+	// and often internal
+	client *http.Client
+}
+
+// webhooks is nil when IMG_WEBHOOK_URLS is unset.
+var webhooks *webhook
+
+func configureWebhooks() {
+	urls := env.List("IMG_WEBHOOK_URLS")
+	if len(urls) == 0 {
+		return
+	}
+	webhooks = &webhook{
+		urls:   urls,
+		secret: env.Get("IMG_WEBHOOK_SECRET", ""),
+		client: &http.Client{Timeout: env.Duration("IMG_WEBHOOK_TIMEOUT", WebhookTimeout)},
+	}
+}
+
+// crawlEvent is the payload of every webhook.
+type crawlEvent struct {
+	Event       string     `json:"event"`
+	Text        string     `json:"text"`
+	RunID       string     `json:"run_id,omitempty"`
+	Time        time.Time  `json:"time"`
+	Host        string     `json:"host,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	Duration    float64    `json:"duration_seconds"`
+	Pages       int64      `json:"pages"`
+	Images      int64      `json:"images"`
+	Failures    int64      `json:"failures"` // pages that could not be fetched
+	Interrupted bool       `json:"interrupted,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// notifyCrawl sends event for crawler c, which is nil when the crawl failed
+// before it had one. It blocks until every receiver answered or gave up,
+// so the process doesn't exit before a failure is reported.
+func notifyCrawl(ctx context.Context, event string, c *imageCrawler, err error) {
+	if webhooks == nil {
+		return
+	}
+	now := time.Now().UTC()
+	ev := crawlEvent{Event: event, Time: now, Interrupted: ctx.Err() != nil}
+	ev.Host, _ = os.Hostname()
+	if c != nil {
+		ev.RunID = c.runID
+		started, _ := c.stats.times()
+		started = started.UTC()
+		ev.StartedAt = &started
+		ev.Duration = now.Sub(started).Seconds()
+		ev.Pages = c.pages.Load()
+		ev.Images = c.images.Load()
+		ev.Failures = c.stats.failures()
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	ev.Text = ev.summary()
+	webhooks.send(context.WithoutCancel(ctx), ev)
+}
+
+// summary is the one line chat receivers show.
+func (ev crawlEvent) summary() string {
+	switch ev.Event {
+	case CrawlStarted:
+		return fmt.Sprintf("Crawl started on %s", ev.Host)
+	case CrawlCompleted:
+		verb := "finished"
+		if ev.Interrupted {
+			verb = "stopped"
+		}
+		return fmt.Sprintf("Crawl %s on %s: %d pages, %d images, %d failures in %s", verb, ev.Host,
+			ev.Pages, ev.Images, ev.Failures, time.Duration(ev.Duration*float64(time.Second)).Round(time.Second))
+	case CrawlFailed:
+		if ev.StartedAt == nil {
+			return fmt.Sprintf("Crawl failed to start on %s: %s", ev.Host, ev.Error)
+		}
+	}
+	return fmt.Sprintf("Crawl failed on %s after %d pages and %d images: %s", ev.Host, ev.Pages, ev.Images, ev.Error)
+}
+
+// send posts ev to every receiver, retrying each a few times.
+func (w *webhook) send(ctx context.Context, ev crawlEvent) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		slog.Error("Encoding webhook failed", "event", ev.Event, "error", err)
+		return
+	}
+	for _, link := range w.urls {
+		delay := RetryBaseDelay
+		for attempt := 1; ; attempt++ {
+			err := w.post(ctx, link, body)
+			if err == nil {
+				break
+			}
+			if attempt == WebhookAttempts {
+				slog.Error("Webhook failed", "event", ev.Event, "url", link, "attempts", attempt, "error", err)
+				break
+			}
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+func (w *webhook) post(ctx context.Context, link string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, link, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(body)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%d %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   ELASTICSEARCH INDEX
+	==============================
+*/
+
+const (
+	ESIndex       = "images"
+	ESBulkSize    = 500
+	ESTimeout     = time.Minute
+	MaxESErrorLog = 5
+
+	FlavorElasticsearch = "elasticsearch"
+	FlavorOpenSearch    = "opensearch"
+)
+
+// runElasticIndex builds an Elasticsearch or OpenSearch index from
+// image_files (IMG_MODE=elastic), for relevance work Mongo text search
+// can't carry. Every run fills a fresh index named after IMG_ES_INDEX and
+// the time, then moves the IMG_ES_INDEX alias onto it and drops the old
+// ones, so searches never see a half-built index and removed images
+// disappear. IMG_ES_FLAVOR=opensearch maps embeddings as knn_vector, which
+// needs IMG_ES_EMBEDDING_DIMS; without it they are left out. Only
+// embeddings from IMG_CLIP_MODEL are indexed, so the vectors share a
+// space.
+func runElasticIndex(ctx context.Context, col *mongo.Collection) error {
+	es, err := newESClient()
+	if err != nil {
+		return err
+	}
+	alias := env.Get("IMG_ES_INDEX", ESIndex)
+	flavor := strings.ToLower(env.Get("IMG_ES_FLAVOR", FlavorElasticsearch))
+	if flavor != FlavorElasticsearch && flavor != FlavorOpenSearch {
+		return fmt.Errorf("IMG_ES_FLAVOR must be %s or %s", FlavorElasticsearch, FlavorOpenSearch)
+	}
+	dims := env.Int("IMG_ES_EMBEDDING_DIMS", 0)
+	withVectors := flavor == FlavorElasticsearch || dims > 0
+	model := env.Get("IMG_CLIP_MODEL", "clip")
+
+	name := alias + "-" + time.Now().UTC().Format("20060102150405")
+	if err := es.do(ctx, http.MethodPut, "/"+name, esIndexBody(flavor, dims, withVectors), nil); err != nil {
+		return fmt.Errorf("create index %s: %w", name, err)
+	}
+	slog.Info("Indexing image_files", "index", name)
+
+	filter := bson.M{
+		"corrupt":    bson.M{"$ne": true},
+		"stale":      bson.M{"$ne": true},
+		"dead_since": bson.M{"$exists": false},
+		"deleted_at": store.Live,
+	}
+	cur, err := col.Find(ctx, filter, options.Find().SetBatchSize(ESBulkSize))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	var bulk bytes.Buffer
+	pending, indexed, failed := 0, 0, 0
+	flush := func() error {
+		if pending == 0 {
+			return nil
+		}
+		n, err := es.bulk(ctx, &bulk)
+		if err != nil {
+			return err
+		}
+		indexed += pending - n
+		failed += n
+		bulk.Reset()
+		pending = 0
+		return nil
+	}
+
+	for cur.Next(ctx) {
+		var doc bson.D
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+		id, _ := lookupField(doc, "_id")
+		src := make(map[string]interface{}, len(doc))
+		for _, e := range doc {
+			if e.Key != "_id" {
+				src[e.Key] = store.PlainValue(e.Value)
+			}
+		}
+		if !withVectors || src["embedding_model"] != model {
+			delete(src, "embedding")
+		}
+
+		action, _ := json.Marshal(map[string]interface{}{"index": map[string]interface{}{"_index": name, "_id": store.PlainValue(id)}})
+		body, err := json.Marshal(src)
+		if err != nil {
+			slog.Error("Encoding a record failed", "id", id, "error", err)
+			continue
+		}
+		bulk.Write(action)
+		bulk.WriteByte('\n')
+		bulk.Write(body)
+		bulk.WriteByte('\n')
+		if pending++; pending >= ESBulkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if err := es.do(ctx, http.MethodPost, "/"+name+"/_refresh", nil, nil); err != nil {
+		return err
+	}
+	if err := es.swapAlias(ctx, alias, name); err != nil {
+		return err
+	}
+	slog.Info("Indexed images", "index", name, "count", indexed, "failed", failed, "alias", alias)
+	return nil
+}
+
+// esIndexBody is the settings and mapping of a fresh index. Text fields use
+// the english analyzer with a raw keyword alongside; identifiers and
+// buckets are keywords. Dynamic mapping is off so stray fields stay in
+// _source without growing the mapping.
+func esIndexBody(flavor string, dims int, withVectors bool) io.Reader {
+	keyword := map[string]interface{}{"type": "keyword"}
+	text := map[string]interface{}{
+		"type":     "text",
+		"analyzer": "english",
+		"fields":   map[string]interface{}{"raw": map[string]interface{}{"type": "keyword", "ignore_above": 256}},
+	}
+	long := map[string]interface{}{"type": "long"}
+	float := map[string]interface{}{"type": "float"}
+	date := map[string]interface{}{"type": "date"}
+
+	props := map[string]interface{}{
+		"alt_text":        text,
+		"caption_text":    text,
+		"context_text":    text,
+		"page_title":      text,
+		"nearest_heading": text,
+		"creator":         text,
+
+		"file_url":        keyword,
+		"page_url":        keyword,
+		"known_urls":      keyword,
+		"stored_url":      keyword,
+		"thumbnail_url":   keyword,
+		"domain_name":     keyword,
+		"format":          keyword,
+		"content_type":    keyword,
+		"license":         keyword,
+		"license_url":     keyword,
+		"source":          keyword,
+		"alt_source":      keyword,
+		"orientation":     keyword,
+		"content_hash":    keyword,
+		"dhash":           keyword,
+		"dhash_bands":     keyword,
+		"dup_group":       keyword,
+		"dominant_colors": keyword,
+		"color_buckets":   keyword,
+		"embedding_model": keyword,
+
+		"file_size":      long,
+		"content_length": long,
+		"pixel_width":    long,
+		"pixel_height":   long,
+		"srcset_width":   long,
+		"faces_count":    long,
+		"times_seen":     long,
+		"aspect_ratio":   float,
+		"nsfw_score":     float,
+		"first_seen":     date,
+		"last_seen":      date,
+		"enriched_at":    date,
+		"corrupt":        map[string]interface{}{"type": "boolean"},
+		"stale":          map[string]interface{}{"type": "boolean"},
+
+		"exif": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"make":        keyword,
+				"model":       keyword,
+				"orientation": long,
+				"taken_at":    date,
+				"gps":         map[string]interface{}{"type": "geo_point"},
+			},
+		},
+	}
+
+	settings := map[string]interface{}{}
+	if withVectors {
+		if flavor == FlavorOpenSearch {
+			settings["index.knn"] = true
+			props["embedding"] = map[string]interface{}{
+				"type":      "knn_vector",
+				"dimension": dims,
+				"method": map[string]interface{}{
+					"name":       "hnsw",
+					"space_type": "cosinesimil",
+					"engine":     "lucene",
+				},
+			}
+		} else {
+			vector := map[string]interface{}{"type": "dense_vector", "index": true, "similarity": "cosine"}
+			if dims > 0 {
+				// otherwise taken from the first document
+				vector["dims"] = dims
+			}
+			props["embedding"] = vector
+		}
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"settings": settings,
+		"mappings": map[string]interface{}{"dynamic": false, "properties": props},
+	})
+	return bytes.NewReader(data)
+}
+
+/*
+	==============================
+	   ELASTICSEARCH CLIENT
+	==============================
+*/
+
+// esClient is the handful of REST calls indexing needs. IMG_ES_API_KEY or
+// IMG_ES_USER and IMG_ES_PASSWORD authenticate when set.
+type esClient struct {
+	base     string
+	apiKey   string
+	user     string
+	password string
+	http     *http.Client
+}
+
+func newESClient() (*esClient, error) {
+	base := strings.TrimRight(env.Get("IMG_ES_URL", ""), "/")
+	if base == "" {
+		return nil, fmt.Errorf("IMG_ES_URL is not set")
+	}
+	return &esClient{
+		base:     base,
+		apiKey:   env.Get("IMG_ES_API_KEY", ""),
+		user:     env.Get("IMG_ES_USER", ""),
+		password: env.Get("IMG_ES_PASSWORD", ""),
+		http:     &http.Client{Timeout: env.Duration("IMG_ES_TIMEOUT", ESTimeout)},
+	}, nil
+}
+
+// do sends one request and decodes a JSON reply into out when given.
+// Replies outside 2xx come back as errors carrying the start of the body.
+func (c *esClient) do(ctx context.Context, method, path string, body io.Reader, out interface{}) error {
+	return c.send(ctx, method, path, "application/json", body, out)
+}
+
+func (c *esClient) send(ctx context.Context, method, path, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	switch {
+	case c.apiKey != "":
+		req.Header.Set("Authorization", "ApiKey "+c.apiKey)
+	case c.user != "":
+		req.SetBasicAuth(c.user, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &esError{Status: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type esError struct {
+	Status int
+	Body   string
+}
+
+func (e *esError) Error() string {
+	return fmt.Sprintf("elasticsearch: status %d: %s", e.Status, e.Body)
+}
+
+// bulk sends NDJSON actions to _bulk and returns how
+// many items failed, logging the first few.
+func (c *esClient) bulk(ctx context.Context, body *bytes.Buffer) (int, error) {
+	var reply struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string          `json:"_id"`
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := c.send(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body, &reply); err != nil {
+		return 0, err
+	}
+	if !reply.Errors {
+		return 0, nil
+	}
+	failed := 0
+	for _, item := range reply.Items {
+		for _, r := range item {
+			if r.Status/100 == 2 {
+				continue
+			}
+			if failed++; failed <= MaxESErrorLog {
+				slog.Error("Indexing a record failed", "id", r.ID, "reason", string(r.Error))
+			}
+		}
+	}
+	return failed, nil
+}
+
+// swapAlias points alias at index alone and deletes the indices it
+// pointed at before.
+func (c *esClient) swapAlias(ctx context.Context, alias, index string) error {
+	var current map[string]json.RawMessage
+	err := c.do(ctx, http.MethodGet, "/_alias/"+alias, nil, &current)
+	if e, ok := err.(*esError); ok && e.Status == http.StatusNotFound {
+		current, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	actions := []map[string]interface{}{
+		{"add": map[string]interface{}{"index": index, "alias": alias}},
+	}
+	var old []string
+	for name := range current {
+		if name != index {
+			old = append(old, name)
+			actions = append(actions, map[string]interface{}{"remove": map[string]interface{}{"index": name, "alias": alias}})
+		}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"actions": actions})
+	if err := c.do(ctx, http.MethodPost, "/_aliases", bytes.NewReader(data), nil); err != nil {
+		return fmt.Errorf("alias %s: %w", alias, err)
+	}
+
+	for _, name := range old {
+		if err := c.do(ctx, http.MethodDelete, "/"+name, nil, nil); err != nil {
+			slog.Error("Deleting old index failed", "index", name, "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   EXPORT
+	==============================
+*/
+
+const (
+	ExportJSONL   = "jsonl"
+	ExportCSV     = "csv"
+	ExportParquet = "parquet"
+
+	DefaultExportFields = "file_url,alt_text,caption_text,page_url,domain_name,format," +
+		"pixel_width,pixel_height,file_size,license,first_seen,last_seen,times_seen"
+	ExportBatchSize = 1000
+)
+
+// runExport streams image_files to IMG_EXPORT_FILE (stdout when unset) as
+// JSON Lines, CSV or Parquet (IMG_EXPORT_FORMAT). IMG_EXPORT_FIELDS picks
+// the fields, dotted paths included; "*" exports whole records as JSON
+// Lines and every ImageRecord field as CSV or Parquet. IMG_EXPORT_QUERY is
+// a Mongo filter in extended JSON, e.g.
+// {"format": "png", "pixel_width": {"$gte": 1024}}.
+func runExport(ctx context.Context, col *mongo.Collection) error {
+	format := strings.ToLower(env.Get("IMG_EXPORT_FORMAT", ExportJSONL))
+	if format != ExportJSONL && format != ExportCSV && format != ExportParquet {
+		return fmt.Errorf("IMG_EXPORT_FORMAT must be %s, %s or %s", ExportJSONL, ExportCSV, ExportParquet)
+	}
+
+	fields := env.List("IMG_EXPORT_FIELDS")
+	if len(fields) == 0 {
+		fields = strings.Split(DefaultExportFields, ",")
+	}
+	all := len(fields) == 1 && fields[0] == "*"
+	if all && format != ExportJSONL {
+		// tabular formats need their columns up front
+		fields, all = imageRecordFields(), false
+	}
+
+	filter := bson.D{}
+	if q := env.Get("IMG_EXPORT_QUERY", ""); q != "" {
+		if err := bson.UnmarshalExtJSON([]byte(q), false, &filter); err != nil {
+			return fmt.Errorf("IMG_EXPORT_QUERY: %w", err)
+		}
+	}
+
+	opts := options.Find().SetBatchSize(ExportBatchSize)
+	if !all {
+		proj := bson.D{}
+		hasID := false
+		for _, f := range fields {
+			proj = append(proj, bson.E{Key: f, Value: 1})
+			hasID = hasID || f == "_id"
+		}
+		if !hasID {
+			proj = append(proj, bson.E{Key: "_id", Value: 0})
+		}
+		opts.SetProjection(proj)
+	}
+
+	var out io.Writer = os.Stdout
+	if path := env.Get("IMG_EXPORT_FILE", ""); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	buf := bufio.NewWriter(out)
+
+	cur, err := col.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	var n int
+	switch format {
+	case ExportJSONL:
+		n, err = exportJSONL(ctx, cur, buf, fields, all)
+	case ExportCSV:
+		n, err = exportCSV(ctx, cur, buf, fields)
+	case ExportParquet:
+		n, err = exportParquet(ctx, cur, buf, fields)
+	}
+	if err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	slog.Info("Exported images", "count", n, "format", format)
+	return nil
+}
+
+func exportJSONL(ctx context.Context, cur *mongo.Cursor, w *bufio.Writer, fields []string, all bool) (int, error) {
+	n := 0
+	for cur.Next(ctx) {
+		var doc bson.D
+		if err := cur.Decode(&doc); err != nil {
+			return n, err
+		}
+
+		// written by hand so keys keep the order fields were asked for
+		keys := fields
+		if all {
+			keys = make([]string, len(doc))
+			for i, e := range doc {
+				keys[i] = e.Key
+			}
+		}
+		w.WriteByte('{')
+		for i, k := range keys {
+			v, _ := lookupField(doc, k)
+			key, _ := json.Marshal(k)
+			val, err := json.Marshal(store.PlainValue(v))
+			if err != nil {
+				return n, fmt.Errorf("field %s: %w", k, err)
+			}
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.Write(key)
+			w.WriteByte(':')
+			w.Write(val)
+		}
+		w.WriteString("}\n")
+		n++
+	}
+	return n, cur.Err()
+}
+
+func exportCSV(ctx context.Context, cur *mongo.Cursor, w *bufio.Writer, fields []string) (int, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(fields); err != nil {
+		return 0, err
+	}
+	row := make([]string, len(fields))
+	n := 0
+	for cur.Next(ctx) {
+		var doc bson.D
+		if err := cur.Decode(&doc); err != nil {
+			return n, err
+		}
+		for i, k := range fields {
+			v, _ := lookupField(doc, k)
+			row[i] = csvCell(store.PlainValue(v))
+		}
+		if err := cw.Write(row); err != nil {
+			return n, err
+		}
+		n++
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return n, err
+	}
+	return n, cur.Err()
+}
+
+// lookupField follows a dotted path through embedded documents.
+func lookupField(doc bson.D, path string) (interface{}, bool) {
+	key, rest, nested := strings.Cut(path, ".")
+	for _, e := range doc {
+		if e.Key != key {
+			continue
+		}
+		if !nested {
+			return e.Value, true
+		}
+		if sub, ok := e.Value.(bson.D); ok {
+			return lookupField(sub, rest)
+		}
+		return nil, false
+	}
+	return nil, false
+}
+
+// csvCell writes scalars as they are and lists and documents as JSON.
+func csvCell(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case bool:
+		return strconv.FormatBool(x)
+	case int32:
+		return strconv.FormatInt(int64(x), 10)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,89 @@
+package extract
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   CSS BACKGROUND IMAGES
+	==============================
+*/
+
+var (
+	cssBackgroundDecl = regexp.MustCompile(`(?i)background(?:-image)?\s*:\s*([^;}]*)`)
+	cssURL            = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)\s]*))\s*\)`)
+)
+
+// CSSBackgroundURLs returns the url(...) references inside background and
+// background-image declarations of css, resolved against base.
+func CSSBackgroundURLs(base *url.URL, css string) []string {
+	var out []string
+	for _, decl := range cssBackgroundDecl.FindAllStringSubmatch(css, -1) {
+		for _, m := range cssURL.FindAllStringSubmatch(decl[1], -1) {
+			raw := strings.TrimSpace(m[1] + m[2] + m[3])
+			if raw == "" {
+				continue
+			}
+			u, err := url.Parse(raw)
+			if err != nil {
+				continue
+			}
+			out = append(out, canonicalImageURL(base.ResolveReference(u)))
+		}
+	}
+	return out
+}
+
+// BackgroundRecord is the record of an image set as a CSS background.
+func BackgroundRecord(page, domain, link, label string) store.ImageRecord {
+	return store.ImageRecord{
+		FileURL:    link,
+		AltText:    label,
+		PageURL:    page,
+		DomainName: domain,
+		Format:     ImageFormat(link, ""),
+		Source:     "css",
+		LastSeen:   time.Now().UTC(),
+	}
+}
+
+// Backgrounds finds images set through inline style attributes
+// and <style> blocks. An element's aria-label or title stands in for alt
+// text.
+func Backgrounds(page string, doc *goquery.Document) []store.ImageRecord {
+	base, _ := url.Parse(page)
+	domain := base.Hostname()
+
+	var out []store.ImageRecord
+
+	doc.Find("[style]").Each(func(_ int, el *goquery.Selection) {
+		style, _ := el.Attr("style")
+		label, _ := el.Attr("aria-label")
+		if label == "" {
+			label, _ = el.Attr("title")
+		}
+		for _, link := range CSSBackgroundURLs(base, style) {
+			if IsImageURL(link) {
+				out = append(out, BackgroundRecord(page, domain, link, label))
+			}
+		}
+	})
+
+	doc.Find("style").Each(func(_ int, el *goquery.Selection) {
+		for _, link := range CSSBackgroundURLs(base, el.Text()) {
+			if IsImageURL(link) {
+				out = append(out, BackgroundRecord(page, domain, link, ""))
+			}
+		}
+	})
+
+	return out
+}
@@ -0,0 +1,136 @@
+package extract
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+/*
+	==============================
+	   IMAGE URL CANONICALIZATION
+	==============================
+*/
+
+// trackingParams are query parameters that only attribute a visit. Any
+// key starting with "utm_" is dropped as well.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"igshid":  true,
+	"_ga":     true,
+	"_gl":     true,
+	"ref_src": true,
+}
+
+// resizeParams are the query parameters image servers commonly resize or
+// re-encode on. They are only dropped from URLs whose path already names
+// an image file, where the query can't be choosing which image is served.
+var resizeParams = map[string]bool{
+	"w":       true,
+	"h":       true,
+	"width":   true,
+	"height":  true,
+	"fit":     true,
+	"crop":    true,
+	"resize":  true,
+	"dpr":     true,
+	"quality": true,
+	"auto":    true,
+	"zoom":    true,
+	"strip":   true,
+}
+
+var (
+	// "w_300,h_200,c_fill" or "f_auto": a Cloudinary transformation segment
+	cloudinaryTransform = regexp.MustCompile(`^[a-z]{1,3}_[^/,]+(,[a-z]{1,3}_[^/,]+)*$`)
+	// "photo-300x200.jpg": a WordPress intermediate size of photo.jpg
+	wordpressSize = regexp.MustCompile(`-[0-9]+x[0-9]+(\.[A-Za-z0-9]+)$`)
+	photonHost    = regexp.MustCompile(`^i[0-9]\.wp\.com$`)
+)
+
+// cdnRule rewrites the URLs of one image CDN to its original asset.
+type cdnRule struct {
+	match   func(u *url.URL) bool
+	rewrite func(u *url.URL)
+}
+
+var cdnRules = []cdnRule{
+	{
+		// res.cloudinary.com/<cloud>/image/upload/<transforms>/v123/<id>.jpg
+		match: func(u *url.URL) bool {
+			return hostIs(u, "cloudinary.com") && strings.Contains(u.Path, "/image/upload/")
+		},
+		rewrite: func(u *url.URL) {
+			head, tail, _ := strings.Cut(u.Path, "/image/upload/")
+			segs := strings.Split(tail, "/")
+			for len(segs) > 1 && cloudinaryTransform.MatchString(segs[0]) {
+				segs = segs[1:]
+			}
+			u.Path = head + "/image/upload/" + strings.Join(segs, "/")
+			u.RawPath = ""
+		},
+	},
+	{
+		// every imgix parameter is a rendering instruction
+		match:   func(u *url.URL) bool { return hostIs(u, "imgix.net") },
+		rewrite: func(u *url.URL) { u.RawQuery = "" },
+	},
+	{
+		// Jetpack's Photon proxy: i0.wp.com/example.com/photo.jpg?resize=300,200
+		match:   func(u *url.URL) bool { return photonHost.MatchString(strings.ToLower(u.Hostname())) },
+		rewrite: func(u *url.URL) { u.RawQuery = "" },
+	},
+	{
+		// wp-content/uploads/2024/05/photo-300x200.jpg, on any host
+		match: func(u *url.URL) bool { return strings.Contains(u.Path, "/wp-content/uploads/") },
+		rewrite: func(u *url.URL) {
+			u.Path = wordpressSize.ReplaceAllString(u.Path, "$1")
+			u.RawPath = ""
+		},
+	},
+}
+
+// hostIs reports whether u is on domain or one of its subdomains.
+func hostIs(u *url.URL, domain string) bool {
+	h := strings.ToLower(u.Hostname())
+	return h == domain || strings.HasSuffix(h, "."+domain)
+}
+
+// canonicalImageURL maps a resolved image link to the URL of the original
+// asset, so the renditions a page requests at different sizes
+// (photo.jpg?w=300&fit=crop, photo.jpg?w=600) are stored once. The
+// matching CDN rule runs first; tracking parameters are then dropped
+// everywhere, and resize parameters wherever the path is an image file.
+func canonicalImageURL(u *url.URL) string {
+	c := *u
+	for _, rule := range cdnRules {
+		if rule.match(&c) {
+			rule.rewrite(&c)
+		}
+	}
+
+	if c.RawQuery != "" {
+		isFile := IsImageURL(path.Base(c.Path))
+		q := c.Query()
+		changed := false
+		for key := range q {
+			k := strings.ToLower(key)
+			if trackingParams[k] || strings.HasPrefix(k, "utm_") || (isFile && resizeParams[k]) {
+				q.Del(key)
+				changed = true
+			}
+		}
+		// re-encoding reorders the query, so leave untouched ones as they
+		// were in case the server signs them
+		if changed {
+			c.RawQuery = q.Encode()
+		}
+	}
+	return c.String()
+}
@@ -0,0 +1,82 @@
+package extract
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestCanonicalImageURL(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+		want string
+	}{
+		{
+			name: "plain URL is untouched",
+			link: "https://example.test/img/cat.jpg",
+			want: "https://example.test/img/cat.jpg",
+		},
+		{
+			name: "cloudinary transforms are stripped",
+			link: "https://res.cloudinary.com/demo/image/upload/w_300,h_200,c_fill/f_auto/v123/cat.jpg",
+			want: "https://res.cloudinary.com/demo/image/upload/v123/cat.jpg",
+		},
+		{
+			name: "cloudinary keeps the asset when it looks like a transform",
+			link: "https://res.cloudinary.com/demo/image/upload/w_300/c_cat",
+			want: "https://res.cloudinary.com/demo/image/upload/c_cat",
+		},
+		{
+			name: "imgix drops the whole query",
+			link: "https://shop.imgix.net/cat.png?w=400&blur=20&s=abc",
+			want: "https://shop.imgix.net/cat.png",
+		},
+		{
+			name: "photon drops the whole query",
+			link: "https://i2.wp.com/example.test/cat.jpg?resize=300,200&ssl=1",
+			want: "https://i2.wp.com/example.test/cat.jpg",
+		},
+		{
+			name: "wordpress intermediate size",
+			link: "https://example.test/wp-content/uploads/2024/05/cat-300x200.jpg",
+			want: "https://example.test/wp-content/uploads/2024/05/cat.jpg",
+		},
+		{
+			name: "wordpress size outside uploads is kept",
+			link: "https://example.test/img/cat-300x200.jpg",
+			want: "https://example.test/img/cat-300x200.jpg",
+		},
+		{
+			name: "resize params dropped from image files",
+			link: "https://example.test/cat.jpg?w=300&fit=crop&id=7",
+			want: "https://example.test/cat.jpg?id=7",
+		},
+		{
+			name: "resize params kept when the query picks the image",
+			link: "https://example.test/image.php?id=7&w=300",
+			want: "https://example.test/image.php?id=7&w=300",
+		},
+		{
+			name: "tracking params dropped everywhere",
+			link: "https://example.test/image.php?id=7&utm_source=x&UTM_Medium=y&fbclid=z",
+			want: "https://example.test/image.php?id=7",
+		},
+		{
+			name: "untouched query keeps its order",
+			link: "https://example.test/image.php?z=1&a=2",
+			want: "https://example.test/image.php?z=1&a=2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := canonicalImageURL(u); got != tt.want {
+				t.Errorf("canonicalImageURL(%s) = %s, want %s", tt.link, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package extract
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+/*
+	==============================
+	   IMAGE TEXT CONTEXT
+	==============================
+*/
+
+const MaxContextChars = 400
+
+var (
+	anyHeading     = map[string]bool{"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true}
+	sectionHeading = map[string]bool{"h1": true, "h2": true, "h3": true}
+)
+
+// PageTitle is the document's <title>, whitespace collapsed.
+func PageTitle(doc *goquery.Document) string {
+	return collapseSpace(doc.Find("title").First().Text())
+}
+
+// precedingHeading returns the text of the closest heading (one of tags)
+// before n in document order, whether a sibling of n or of an ancestor.
+func precedingHeading(n *html.Node, tags map[string]bool) string {
+	for cur := n; cur != nil; cur = cur.Parent {
+		for s := cur.PrevSibling; s != nil; s = s.PrevSibling {
+			if h := lastHeading(s, tags); h != nil {
+				return collapseSpace(goquery.NewDocumentFromNode(h).Text())
+			}
+		}
+	}
+	return ""
+}
+
+// lastHeading finds the last heading inside n, n included.
+func lastHeading(n *html.Node, tags map[string]bool) *html.Node {
+	if n.Type != html.ElementNode {
+		return nil
+	}
+	if tags[n.Data] {
+		return n
+	}
+	for c := n.LastChild; c != nil; c = c.PrevSibling {
+		if h := lastHeading(c, tags); h != nil {
+			return h
+		}
+	}
+	return nil
+}
+
+// imageContext gathers the text around an <img>: the preceding heading
+// and the paragraph or list item holding the image, or failing that the
+// paragraphs just before and after it. Whitespace is collapsed and the
+// result cut to MaxContextChars.
+func imageContext(tag *goquery.Selection) string {
+	var parts []string
+	if h := precedingHeading(tag.Get(0), anyHeading); h != "" {
+		parts = append(parts, h)
+	}
+
+	if block := tag.Closest("p, li, td, dd, blockquote, figure"); block.Length() > 0 {
+		text := block.Clone()
+		text.Find("figcaption, script, style").Remove()
+		parts = append(parts, collapseSpace(text.Text()))
+	} else {
+		// step out of wrappers such as <a> or <picture> around the image
+		el := tag
+		for p := el.Parent(); p.Length() > 0 && goquery.NodeName(p) != "body" && p.Children().Length() == 1; p = el.Parent() {
+			el = p
+		}
+		parts = append(parts,
+			collapseSpace(el.PrevAllFiltered("p").First().Text()),
+			collapseSpace(el.NextAllFiltered("p").First().Text()),
+		)
+	}
+
+	var kept []string
+	for _, p := range parts {
+		if p != "" {
+			kept = append(kept, p)
+		}
+	}
+	return truncateText(strings.Join(kept, " "), MaxContextChars)
+}
+
+// imageAltText is the <img>'s alt text or, when that is empty, the first
+// other label a screen reader would fall back to: aria-label, the text of
+// the elements named by aria-labelledby, title, and then the aria-label
+// or title of a link wrapping the image. source names where the text
+// came from ("alt", "aria-label", "link-title", ...).
+func imageAltText(doc *goquery.Document, tag *goquery.Selection) (text, source string) {
+	attr := func(sel *goquery.Selection, name string) string {
+		v, _ := sel.Attr(name)
+		return collapseSpace(v)
+	}
+
+	if t := attr(tag, "alt"); t != "" {
+		return t, "alt"
+	}
+	if t := attr(tag, "aria-label"); t != "" {
+		return t, "aria-label"
+	}
+	if ids := strings.Fields(attr(tag, "aria-labelledby")); len(ids) > 0 {
+		var parts []string
+		for _, id := range ids {
+			label := doc.Find("[id]").FilterFunction(func(_ int, el *goquery.Selection) bool {
+				v, _ := el.Attr("id")
+				return v == id
+			})
+			if t := collapseSpace(label.First().Text()); t != "" {
+				parts = append(parts, t)
+			}
+		}
+		if len(parts) > 0 {
+			return strings.Join(parts, " "), "aria-labelledby"
+		}
+	}
+	if t := attr(tag, "title"); t != "" {
+		return t, "title"
+	}
+	if link := tag.Closest("a"); link.Length() > 0 {
+		if t := attr(link, "aria-label"); t != "" {
+			return t, "link-label"
+		}
+		if t := attr(link, "title"); t != "" {
+			return t, "link-title"
+		}
+	}
+	return "", ""
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// truncateText cuts s to at most max bytes, on a word boundary if one is
+// reasonably close, without splitting a UTF-8 sequence.
+func truncateText(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	if i := strings.LastIndexByte(s[:cut], ' '); i > max/2 {
+		cut = i
+	}
+	return s[:cut]
+}
@@ -0,0 +1,190 @@
+// Package extract finds the images a parsed HTML page refers to: <img>
+// and <picture> elements, noscript fallbacks, og:image and similar meta
+// tags, JSON-LD, CSS backgrounds and lazy-load attributes, along with the
+// text, license and variants of each.
+package extract
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   DOMAIN & URL HELPERS
+	==============================
+*/
+
+// ResolveURL resolves href against base, keeping only http(s) links, and
+// drops the fragment.
+func ResolveURL(base *url.URL, href string) (*url.URL, error) {
+	if strings.TrimSpace(href) == "" {
+		return nil, fmt.Errorf("empty link")
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return nil, err
+	}
+	if !ref.IsAbs() {
+		ref = base.ResolveReference(ref)
+	}
+	if ref.Scheme != "http" && ref.Scheme != "https" {
+		return nil, fmt.Errorf("bad scheme")
+	}
+	ref.Fragment = ""
+	return ref, nil
+}
+
+/*
+	==============================
+	  IMAGE FORMAT FILTER
+	==============================
+*/
+
+// IsImageURL reports whether src ends in a known image file extension.
+func IsImageURL(src string) bool {
+	src = strings.ToLower(src)
+
+	if strings.HasPrefix(src, "data:") {
+		return false
+	}
+
+	allowed := []string{".jpg", ".jpeg", ".png", ".webp", ".gif", ".avif", ".bmp"}
+
+	for _, ext := range allowed {
+		if strings.HasSuffix(src, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+	==============================
+	  IMAGE EXTRACTION LOGIC
+	==============================
+*/
+
+// ImgTags finds the <img> elements of page, with their <picture>, srcset
+// and lazy-load variants, alt and caption text and context.
+func ImgTags(page string, doc *goquery.Document) []store.ImageRecord {
+	base, _ := url.Parse(page)
+	domain := base.Hostname()
+	title := PageTitle(doc)
+
+	var out []store.ImageRecord
+
+	doc.Find("img").Each(func(i int, tag *goquery.Selection) {
+
+		// every rendition of this image: lazy-load attributes, srcset,
+		// <picture> sources and src, unless a lazy loader only put a
+		// placeholder there
+		variants := srcsetVariants(base, tag, "", "")
+		variants = append(variants, pictureVariants(base, tag)...)
+		if rawSrc, _ := tag.Attr("src"); strings.TrimSpace(rawSrc) != "" && !hasLazyAttr(tag) {
+			if imgURL, err := url.Parse(strings.TrimSpace(rawSrc)); err == nil {
+				link := canonicalImageURL(base.ResolveReference(imgURL))
+				variants = append(variants, store.ImageVariant{FileURL: link, Format: ImageFormat(link, ""), Density: 1})
+			}
+		}
+
+		// EXTENSION FILTER
+		kept := variants[:0]
+		for _, v := range variants {
+			if IsImageURL(v.FileURL) {
+				kept = append(kept, v)
+			}
+		}
+		variants = kept
+
+		best, ok := bestVariant(variants)
+		if !ok {
+			return
+		}
+		// renditions that only differed in resize parameters are one file now
+		variants = dedupeVariants(variants)
+		if len(variants) < 2 {
+			variants = nil
+		}
+
+		alt, altSource := imageAltText(doc, tag)
+		w, _ := tag.Attr("width")
+		h, _ := tag.Attr("height")
+
+		// capture figcaption
+		caption := ""
+		if parentFig := tag.ParentsFiltered("figure"); parentFig.Length() > 0 {
+			caption = strings.TrimSpace(parentFig.Find("figcaption").Text())
+		}
+
+		out = append(out, store.ImageRecord{
+			FileURL:        best.FileURL,
+			AltText:        alt,
+			AltSource:      altSource,
+			CaptionText:    caption,
+			ContextText:    imageContext(tag),
+			PageTitle:      title,
+			NearestHeading: precedingHeading(tag.Get(0), sectionHeading),
+			PageURL:        page,
+			DomainName:     domain,
+			Format:         best.Format,
+			Width:          w,
+			Height:         h,
+			SrcsetWidth:    best.Width,
+			Variants:       variants,
+			Source:         "img",
+			LastSeen:       time.Now().UTC(),
+		})
+	})
+
+	return out
+}
+
+// Dedupe merges repeats of a logical image (see variantKey) into the
+// first record found. Fields the first leaves empty are filled from later
+// ones, so an <img> without alt text picks up og:image:alt for the same
+// file, and differing renditions are kept together as its variants.
+func Dedupe(images []store.ImageRecord) []store.ImageRecord {
+	index := map[string]int{}
+	var out []store.ImageRecord
+	for _, img := range images {
+		key := variantKey(img.FileURL)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(out)
+			out = append(out, img)
+			continue
+		}
+		first := &out[i]
+		mergeVariants(first, img)
+		if first.AltText == "" {
+			first.AltText, first.AltSource = img.AltText, img.AltSource
+		}
+		if first.CaptionText == "" {
+			first.CaptionText = img.CaptionText
+		}
+		if first.ContextText == "" {
+			first.ContextText = img.ContextText
+		}
+		if first.Width == "" && first.Height == "" {
+			first.Width, first.Height = img.Width, img.Height
+		}
+		if first.License == "" && first.LicenseURL == "" {
+			first.License, first.LicenseURL = img.License, img.LicenseURL
+		}
+		if first.LicensePage == "" {
+			first.LicensePage = img.LicensePage
+		}
+		if first.Creator == "" {
+			first.Creator = img.Creator
+		}
+	}
+	return out
+}
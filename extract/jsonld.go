@@ -0,0 +1,188 @@
+package extract
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   JSON-LD IMAGES
+	==============================
+*/
+
+// JSONLD reads schema.org data from application/ld+json
+// blocks: ImageObject nodes anywhere in the graph (caption, license,
+// creator, contentUrl), plus the image of any other node such as a
+// Product or Article, which may be a URL, a list of URLs or an
+// ImageObject. A plain URL takes the owning node's name as its alt text.
+func JSONLD(page string, doc *goquery.Document) []store.ImageRecord {
+	base, _ := url.Parse(page)
+	domain := base.Hostname()
+
+	var out []store.ImageRecord
+
+	emit := func(raw, alt string) *store.ImageRecord {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || raw == "" {
+			return nil
+		}
+		link := canonicalImageURL(base.ResolveReference(u))
+		if !IsImageURL(link) {
+			return nil
+		}
+		out = append(out, store.ImageRecord{
+			FileURL:    link,
+			AltText:    alt,
+			PageURL:    page,
+			DomainName: domain,
+			Format:     ImageFormat(link, ""),
+			Source:     "jsonld",
+			LastSeen:   time.Now().UTC(),
+		})
+		return &out[len(out)-1]
+	}
+
+	var walk func(v interface{}, owner string)
+	walk = func(v interface{}, owner string) {
+		switch node := v.(type) {
+		case []interface{}:
+			for _, item := range node {
+				walk(item, owner)
+			}
+		case map[string]interface{}:
+			name := ldText(node["name"])
+			if name == "" {
+				name = ldText(node["headline"])
+			}
+			if name == "" {
+				name = owner
+			}
+
+			if ldIsType(node, "ImageObject") {
+				link := ldText(node["contentUrl"])
+				if link == "" {
+					link = ldText(node["url"])
+				}
+				alt := ldText(node["name"])
+				if alt == "" {
+					alt = owner
+				}
+				if img := emit(link, alt); img != nil {
+					img.CaptionText = ldText(node["caption"])
+					setLDLicense(img, node)
+					img.Creator = ldName(node["creator"])
+					if img.Creator == "" {
+						img.Creator = ldName(node["author"])
+					}
+					img.Width = ldText(node["width"])
+					img.Height = ldText(node["height"])
+				}
+			}
+
+			for key, child := range node {
+				if key != "image" && key != "primaryImageOfPage" {
+					walk(child, name)
+					continue
+				}
+				for _, item := range ldList(child) {
+					if s, ok := item.(string); ok {
+						// a bare URL shares the license of the node listing it
+						if img := emit(s, name); img != nil {
+							setLDLicense(img, node)
+						}
+					} else {
+						walk(item, name)
+					}
+				}
+			}
+		}
+	}
+
+	doc.Find(`script[type="application/ld+json"]`).Each(func(_ int, s *goquery.Selection) {
+		var data interface{}
+		if err := json.Unmarshal([]byte(s.Text()), &data); err != nil {
+			// hand-written JSON-LD is often broken; skip the block
+			return
+		}
+		walk(data, "")
+	})
+
+	return out
+}
+
+// setLDLicense copies schema.org license and acquireLicensePage onto img.
+func setLDLicense(img *store.ImageRecord, node map[string]interface{}) {
+	if link := ldRef(node["license"]); link != "" {
+		img.LicenseURL = link
+		img.License = normalizeLicense(link)
+	}
+	img.LicensePage = ldRef(node["acquireLicensePage"])
+}
+
+func ldList(v interface{}) []interface{} {
+	if list, ok := v.([]interface{}); ok {
+		return list
+	}
+	return []interface{}{v}
+}
+
+func ldIsType(node map[string]interface{}, want string) bool {
+	for _, t := range ldList(node["@type"]) {
+		if s, ok := t.(string); ok && (s == want || strings.HasSuffix(s, "/"+want)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ldText flattens a text value: a string, a number or a {"@value": ...}.
+func ldText(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return strings.TrimSpace(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case map[string]interface{}:
+		return ldText(t["@value"])
+	case []interface{}:
+		if len(t) > 0 {
+			return ldText(t[0])
+		}
+	}
+	return ""
+}
+
+// ldRef returns a link-like value: a URL string or a node's @id / url.
+func ldRef(v interface{}) string {
+	if m, ok := v.(map[string]interface{}); ok {
+		if id := ldText(m["@id"]); id != "" {
+			return id
+		}
+		return ldText(m["url"])
+	}
+	return ldText(v)
+}
+
+// ldName returns the names of people or organisations, comma separated.
+func ldName(v interface{}) string {
+	var names []string
+	for _, item := range ldList(v) {
+		switch t := item.(type) {
+		case string:
+			names = append(names, strings.TrimSpace(t))
+		case map[string]interface{}:
+			if n := ldText(t["name"]); n != "" {
+				names = append(names, n)
+			}
+		}
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,125 @@
+package extract
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   LAZY-LOAD ATTRIBUTES
+	==============================
+*/
+
+// DefaultLazyAttrs are the attributes lazy-loading scripts (lazysizes,
+// lozad, Flickity, theme-specific loaders) keep the real image in until it
+// scrolls into view.
+const DefaultLazyAttrs = "data-src,data-lazy-src,data-original,data-img,data-image," +
+	"data-srcset,data-lazy-srcset,data-flickity-lazyload,data-flickity-lazyload-srcset," +
+	"data-bg,data-background-image,data-bgset"
+
+// lazyAttrs is DefaultLazyAttrs or the IMG_LAZY_ATTRS override.
+var lazyAttrs = strings.Split(DefaultLazyAttrs, ",")
+
+var (
+	attrName = regexp.MustCompile(`^[a-z][a-z0-9_.:-]*$`)
+	// "[(max-width: 500px)]": a media query in a lazysizes data-bgset
+	bgsetMedia = regexp.MustCompile(`\[[^\]]*\]`)
+)
+
+// ConfigureLazyLoad reads IMG_LAZY_ATTRS, a comma-separated list that
+// replaces DefaultLazyAttrs.
+func ConfigureLazyLoad() error {
+	attrs := env.List("IMG_LAZY_ATTRS")
+	if len(attrs) == 0 {
+		return nil
+	}
+	for i, a := range attrs {
+		a = strings.ToLower(a)
+		if !attrName.MatchString(a) {
+			return fmt.Errorf("IMG_LAZY_ATTRS: invalid attribute name %q", a)
+		}
+		attrs[i] = a
+	}
+	lazyAttrs = attrs
+	return nil
+}
+
+// lazySelector matches elements carrying any lazy-load attribute.
+func lazySelector() string {
+	sel := make([]string, len(lazyAttrs))
+	for i, a := range lazyAttrs {
+		sel[i] = "[" + a + "]"
+	}
+	return strings.Join(sel, ",")
+}
+
+func hasLazyAttr(el *goquery.Selection) bool {
+	for _, a := range lazyAttrs {
+		if v, ok := el.Attr(a); ok && strings.TrimSpace(v) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// lazyValue turns a lazy-load attribute into srcset syntax. Besides plain
+// URLs and srcsets, loaders accept url(...) wrappers (data-bg) and
+// "|"-separated sets with bracketed media queries (data-bgset).
+func lazyValue(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if m := cssURL.FindStringSubmatch(raw); m != nil && strings.HasPrefix(strings.ToLower(raw), "url(") {
+		return strings.TrimSpace(m[1] + m[2] + m[3])
+	}
+	raw = bgsetMedia.ReplaceAllString(raw, "")
+	return strings.ReplaceAll(raw, "|", ",")
+}
+
+// lazyVariants parses every lazy-load attribute on el, srcset-style, so a
+// single URL and a list of sized candidates are both understood.
+// Placeholder data: URIs are skipped.
+func lazyVariants(base *url.URL, el *goquery.Selection, mime, media string) []store.ImageVariant {
+	var out []store.ImageVariant
+	for _, a := range lazyAttrs {
+		v, ok := el.Attr(a)
+		if !ok || strings.TrimSpace(v) == "" {
+			continue
+		}
+		out = append(out, parseVariants(base, lazyValue(v), mime, media)...)
+	}
+	return out
+}
+
+// LazyBackgrounds finds images that loaders set as a CSS background
+// on elements other than <img> and <source>.
+func LazyBackgrounds(page string, doc *goquery.Document) []store.ImageRecord {
+	base, _ := url.Parse(page)
+	domain := base.Hostname()
+
+	var out []store.ImageRecord
+	doc.Find(lazySelector()).Not("img, source").Each(func(_ int, el *goquery.Selection) {
+		var kept []store.ImageVariant
+		for _, v := range lazyVariants(base, el, "", "") {
+			if IsImageURL(v.FileURL) {
+				kept = append(kept, v)
+			}
+		}
+		best, ok := bestVariant(kept)
+		if !ok {
+			return
+		}
+		label, _ := el.Attr("aria-label")
+		if label == "" {
+			label, _ = el.Attr("title")
+		}
+		out = append(out, BackgroundRecord(page, domain, best.FileURL, label))
+	})
+	return out
+}
@@ -0,0 +1,163 @@
+package extract
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   LICENSE DETECTION
+	==============================
+*/
+
+// ccText spots license names written out in text or alt attributes:
+// "CC BY-SA 4.0", "CC-BY 2.0", "CC0".
+var ccText = regexp.MustCompile(`(?i)\bCC[- ]?(0|BY(?:[- ](?:SA|ND|NC))*)\b(?:[- ]v?([0-9](?:\.[0-9])?))?`)
+
+// normalizeLicense turns a license URL or name into a short identifier:
+// CC-BY-SA-4.0, CC0-1.0, PDM-1.0. Creative Commons deed URLs and the
+// badge images hosted by CC (i.creativecommons.org, licensebuttons.net)
+// are recognised; anything else returns "".
+func normalizeLicense(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+
+	if u, err := url.Parse(raw); err == nil && u.Host != "" {
+		host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+		parts := strings.Split(strings.Trim(strings.ToLower(u.Path), "/"), "/")
+		switch host {
+		case "creativecommons.org", "i.creativecommons.org", "licensebuttons.net", "mirrors.creativecommons.org":
+			return ccFromPath(parts)
+		}
+		return ""
+	}
+
+	m := ccText.FindStringSubmatch(raw)
+	if m == nil {
+		return ""
+	}
+	kind := strings.ToUpper(strings.ReplaceAll(m[1], " ", "-"))
+	if kind == "0" {
+		return "CC0-1.0"
+	}
+	id := "CC-" + kind
+	if m[2] != "" {
+		id += "-" + m[2]
+	}
+	return id
+}
+
+// ccFromPath reads /licenses/by-sa/4.0/, /publicdomain/zero/1.0/ and the
+// badge forms /l/by-sa/4.0/88x31.png and /p/zero/1.0/88x31.png.
+func ccFromPath(parts []string) string {
+	if len(parts) < 3 {
+		return ""
+	}
+	kind, version := parts[1], parts[2]
+	switch parts[0] {
+	case "licenses", "l":
+		return "CC-" + strings.ToUpper(kind) + "-" + version
+	case "publicdomain", "p":
+		switch kind {
+		case "zero":
+			return "CC0-" + version
+		case "mark":
+			return "PDM-" + version
+		}
+	}
+	return ""
+}
+
+// licenseIn finds a license declared inside sel: a rel="license" link, or
+// a CC badge image. It returns the normalized id and the URL it came from.
+func licenseIn(base *url.URL, sel *goquery.Selection) (string, string) {
+	var id, link string
+
+	sel.Find(`a[rel~="license"], link[rel~="license"]`).EachWithBreak(func(_ int, a *goquery.Selection) bool {
+		href, _ := a.Attr("href")
+		u, err := ResolveURL(base, href)
+		if err != nil {
+			return true
+		}
+		id, link = normalizeLicense(u.String()), u.String()
+		if id == "" {
+			id = normalizeLicense(a.Text())
+		}
+		return false
+	})
+	if link != "" {
+		return id, link
+	}
+
+	sel.Find("img[src]").EachWithBreak(func(_ int, img *goquery.Selection) bool {
+		src, _ := img.Attr("src")
+		u, err := ResolveURL(base, src)
+		if err != nil {
+			return true
+		}
+		if id = normalizeLicense(u.String()); id != "" {
+			link = u.String()
+			return false
+		}
+		return true
+	})
+	return id, link
+}
+
+// ApplyLicenses fills in licenses for records that have none: from a
+// license declared inside the <figure> around the image, else from the
+// page-wide one.
+func ApplyLicenses(page string, doc *goquery.Document, images []store.ImageRecord) {
+	base, _ := url.Parse(page)
+	pageID, pageLink := licenseIn(base, doc.Selection)
+
+	figures := map[string][2]string{}
+	doc.Find("figure").Each(func(_ int, fig *goquery.Selection) {
+		id, link := licenseIn(base, fig)
+		if link == "" {
+			return
+		}
+		fig.Find("img").Each(func(_ int, img *goquery.Selection) {
+			for _, v := range imageVariantsOf(base, img) {
+				figures[v] = [2]string{id, link}
+			}
+		})
+	})
+
+	for i := range images {
+		img := &images[i]
+		if img.License != "" || img.LicenseURL != "" {
+			continue
+		}
+		if l, ok := figures[img.FileURL]; ok {
+			img.License, img.LicenseURL = l[0], l[1]
+		} else if pageLink != "" {
+			img.License, img.LicenseURL = pageID, pageLink
+		}
+	}
+}
+
+// imageVariantsOf lists the URLs an <img> could have been recorded under.
+func imageVariantsOf(base *url.URL, img *goquery.Selection) []string {
+	var out []string
+	if src, ok := img.Attr("src"); ok {
+		if u, err := ResolveURL(base, src); err == nil {
+			out = append(out, u.String())
+		}
+	}
+	for _, v := range srcsetVariants(base, img, "", "") {
+		out = append(out, v.FileURL)
+	}
+	for _, v := range pictureVariants(base, img) {
+		out = append(out, v.FileURL)
+	}
+	return out
+}
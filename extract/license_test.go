@@ -0,0 +1,36 @@
+package extract
+
+import "testing"
+
+func TestNormalizeLicense(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "empty", raw: "  ", want: ""},
+		{name: "deed URL", raw: "https://creativecommons.org/licenses/by-sa/4.0/", want: "CC-BY-SA-4.0"},
+		{name: "deed URL with www and no slash", raw: "http://www.creativecommons.org/licenses/by-nc-nd/3.0", want: "CC-BY-NC-ND-3.0"},
+		{name: "public domain dedication", raw: "https://creativecommons.org/publicdomain/zero/1.0/", want: "CC0-1.0"},
+		{name: "public domain mark", raw: "https://creativecommons.org/publicdomain/mark/1.0/", want: "PDM-1.0"},
+		{name: "badge image", raw: "https://i.creativecommons.org/l/by/3.0/88x31.png", want: "CC-BY-3.0"},
+		{name: "licensebuttons badge", raw: "https://licensebuttons.net/p/zero/1.0/88x31.png", want: "CC0-1.0"},
+		{name: "CC page that is no license", raw: "https://creativecommons.org/about/", want: ""},
+		{name: "other host", raw: "https://example.test/licenses/by/4.0/", want: ""},
+		{name: "written out", raw: "Licensed under CC BY-SA 4.0", want: "CC-BY-SA-4.0"},
+		{name: "lowercase with hyphen", raw: "cc-by 2.0", want: "CC-BY-2.0"},
+		{name: "spaces between terms", raw: "CC BY NC v3.0", want: "CC-BY-NC-3.0"},
+		{name: "no version", raw: "CC BY-ND", want: "CC-BY-ND"},
+		{name: "CC0", raw: "Released as CC0", want: "CC0-1.0"},
+		{name: "CC inside a word", raw: "ACCBY 4.0", want: ""},
+		{name: "all rights reserved", raw: "All rights reserved", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeLicense(tt.raw); got != tt.want {
+				t.Errorf("normalizeLicense(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package extract
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   SOCIAL META IMAGES
+	==============================
+*/
+
+// Meta reads Open Graph (og:image with its :alt, :width,
+// :height and :type properties) and Twitter Card (twitter:image,
+// twitter:image:alt) tags. These usually name the page's representative
+// image at full size, so they are worth having even when an <img> shows
+// the same picture smaller.
+//
+// Open Graph properties are structured: og:image:* tags describe the
+// og:image that precedes them.
+func Meta(page string, doc *goquery.Document) []store.ImageRecord {
+	base, _ := url.Parse(page)
+	domain := base.Hostname()
+
+	var out []store.ImageRecord
+	current := -1
+	twitter := -1
+
+	add := func(raw, source string) int {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || raw == "" {
+			return -1
+		}
+		link := canonicalImageURL(base.ResolveReference(u))
+		if !IsImageURL(link) {
+			return -1
+		}
+		out = append(out, store.ImageRecord{
+			FileURL:    link,
+			PageURL:    page,
+			DomainName: domain,
+			Format:     ImageFormat(link, ""),
+			Source:     source,
+			LastSeen:   time.Now().UTC(),
+		})
+		return len(out) - 1
+	}
+
+	doc.Find("meta").Each(func(_ int, m *goquery.Selection) {
+		name, ok := m.Attr("property")
+		if !ok {
+			name, _ = m.Attr("name")
+		}
+		content, _ := m.Attr("content")
+		content = strings.TrimSpace(content)
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "og:image", "og:image:url":
+			// sites often give both for one image; keep its properties together
+			if current >= 0 {
+				if u, err := url.Parse(content); err == nil && canonicalImageURL(base.ResolveReference(u)) == out[current].FileURL {
+					return
+				}
+			}
+			current = add(content, "og")
+		case "og:image:secure_url":
+			if current >= 0 {
+				if u, err := url.Parse(content); err == nil && content != "" {
+					out[current].FileURL = canonicalImageURL(base.ResolveReference(u))
+				}
+			} else {
+				current = add(content, "og")
+			}
+		case "og:image:alt":
+			if current >= 0 {
+				out[current].AltText = content
+			}
+		case "og:image:width":
+			if current >= 0 {
+				out[current].Width = content
+			}
+		case "og:image:height":
+			if current >= 0 {
+				out[current].Height = content
+			}
+		case "og:image:type":
+			if current >= 0 {
+				if f := ImageFormat("", content); f != "" {
+					out[current].Format = f
+				}
+			}
+		case "twitter:image", "twitter:image:src":
+			twitter = add(content, "twitter")
+		case "twitter:image:alt":
+			if twitter >= 0 {
+				out[twitter].AltText = content
+			}
+		}
+	})
+
+	return out
+}
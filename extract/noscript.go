@@ -0,0 +1,54 @@
+package extract
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   NOSCRIPT FALLBACK IMAGES
+	==============================
+*/
+
+// Noscript recovers images that lazy-loading scripts only
+// show inside <noscript>. The HTML parser runs with scripting enabled, so
+// noscript content arrives as raw text; it is parsed again here as a
+// fragment. The caption and text context of the <noscript> itself still
+// apply.
+func Noscript(page string, doc *goquery.Document) []store.ImageRecord {
+	var out []store.ImageRecord
+
+	doc.Find("noscript").Each(func(_ int, ns *goquery.Selection) {
+		inner := ns.Text()
+		if !strings.Contains(strings.ToLower(inner), "<img") {
+			return
+		}
+		frag, err := goquery.NewDocumentFromReader(strings.NewReader(inner))
+		if err != nil {
+			return
+		}
+
+		caption := ""
+		if fig := ns.ParentsFiltered("figure"); fig.Length() > 0 {
+			caption = strings.TrimSpace(fig.Find("figcaption").Text())
+		}
+
+		context := imageContext(ns)
+		heading := precedingHeading(ns.Get(0), sectionHeading)
+		for _, img := range ImgTags(page, frag) {
+			img.Source = "noscript"
+			if img.CaptionText == "" {
+				img.CaptionText = caption
+			}
+			img.ContextText = context
+			img.NearestHeading = heading
+			out = append(out, img)
+		}
+	})
+
+	return out
+}
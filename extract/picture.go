@@ -0,0 +1,186 @@
+package extract
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   IMAGE VARIANTS
+	==============================
+*/
+
+// formatRank orders formats when two variants have the same resolution.
+var formatRank = map[string]int{
+	"avif": 6, "webp": 5, "jpg": 4, "png": 3, "gif": 2, "bmp": 1,
+}
+
+// ImageFormat names the format of link, trusting a <source type> when
+// one is given.
+func ImageFormat(link, mime string) string {
+	switch strings.ToLower(strings.TrimSpace(mime)) {
+	case "image/avif":
+		return "avif"
+	case "image/webp":
+		return "webp"
+	case "image/jpeg", "image/jpg":
+		return "jpg"
+	case "image/png":
+		return "png"
+	case "image/gif":
+		return "gif"
+	case "image/bmp":
+		return "bmp"
+	}
+
+	lower := strings.ToLower(link)
+	switch {
+	case strings.Contains(lower, ".jpg"), strings.Contains(lower, ".jpeg"):
+		return "jpg"
+	case strings.Contains(lower, ".png"):
+		return "png"
+	case strings.Contains(lower, ".webp"):
+		return "webp"
+	case strings.Contains(lower, ".gif"):
+		return "gif"
+	case strings.Contains(lower, ".avif"):
+		return "avif"
+	case strings.Contains(lower, ".bmp"):
+		return "bmp"
+	}
+	return ""
+}
+
+// srcsetVariants resolves the srcset of tag and the lazy-load attributes
+// standing in for it. The lazy ones come first, so when a placeholder in
+// srcset ties with the real image, bestVariant keeps the real one.
+func srcsetVariants(base *url.URL, tag *goquery.Selection, mime, media string) []store.ImageVariant {
+	out := lazyVariants(base, tag, mime, media)
+	if v, ok := tag.Attr("srcset"); ok && strings.TrimSpace(v) != "" {
+		out = append(out, parseVariants(base, v, mime, media)...)
+	}
+	return out
+}
+
+// parseVariants resolves the candidates of one srcset value.
+func parseVariants(base *url.URL, srcset, mime, media string) []store.ImageVariant {
+	var out []store.ImageVariant
+	for _, c := range parseSrcset(srcset) {
+		if strings.HasPrefix(strings.ToLower(c.URL), "data:") {
+			continue
+		}
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			continue
+		}
+		link := canonicalImageURL(base.ResolveReference(u))
+		out = append(out, store.ImageVariant{
+			FileURL: link,
+			Format:  ImageFormat(link, mime),
+			Media:   media,
+			Width:   c.Width,
+			Density: c.Density,
+		})
+	}
+	return out
+}
+
+// pictureVariants collects the <source> renditions when img sits inside
+// a <picture>.
+func pictureVariants(base *url.URL, img *goquery.Selection) []store.ImageVariant {
+	pic := img.Parent()
+	if goquery.NodeName(pic) != "picture" {
+		return nil
+	}
+
+	var out []store.ImageVariant
+	pic.ChildrenFiltered("source").Each(func(_ int, src *goquery.Selection) {
+		mime, _ := src.Attr("type")
+		media, _ := src.Attr("media")
+		out = append(out, srcsetVariants(base, src, mime, media)...)
+	})
+	return out
+}
+
+// bestVariant picks the highest resolution, then the best format. A
+// width descriptor beats a density one, since only it states real pixels.
+func bestVariant(vs []store.ImageVariant) (store.ImageVariant, bool) {
+	better := func(a, b store.ImageVariant) bool {
+		if a.Width != b.Width {
+			return a.Width > b.Width
+		}
+		if a.Density != b.Density {
+			return a.Density > b.Density
+		}
+		return formatRank[a.Format] > formatRank[b.Format]
+	}
+
+	var best store.ImageVariant
+	found := false
+	for _, v := range vs {
+		if !found || better(v, best) {
+			best = v
+			found = true
+		}
+	}
+	return best, found
+}
+
+// dedupeVariants keeps the first rendition listed for each URL.
+func dedupeVariants(vs []store.ImageVariant) []store.ImageVariant {
+	seen := map[string]bool{}
+	var out []store.ImageVariant
+	for _, v := range vs {
+		if !seen[v.FileURL] {
+			seen[v.FileURL] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// variantSuffix matches the name of a density rendition, "photo@2x.png".
+var variantSuffix = regexp.MustCompile(`@[0-9]+(\.[0-9]+)?x$`)
+
+// variantKey identifies a logical image across its renditions. URLs are
+// already canonical (see canonicalImageURL), so what is left to ignore is
+// the file extension and a density suffix: photo.webp, photo.jpg and
+// photo@2x.jpg are one image.
+func variantKey(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	ext := path.Ext(u.Path)
+	u.Path = variantSuffix.ReplaceAllString(strings.TrimSuffix(u.Path, ext), "")
+	u.RawPath = ""
+	return u.String()
+}
+
+// variantsOf lists the renditions a record stands for: its variants, or
+// just its own file.
+func variantsOf(img *store.ImageRecord) []store.ImageVariant {
+	if len(img.Variants) > 0 {
+		return img.Variants
+	}
+	return []store.ImageVariant{{FileURL: img.FileURL, Format: img.Format, Width: img.SrcsetWidth, Density: 1}}
+}
+
+// mergeVariants folds the renditions of img into first and points first
+// at the best of them.
+func mergeVariants(first *store.ImageRecord, img store.ImageRecord) {
+	vs := dedupeVariants(append(variantsOf(first), variantsOf(&img)...))
+	best, _ := bestVariant(vs)
+	first.FileURL, first.Format, first.SrcsetWidth = best.FileURL, best.Format, best.Width
+	first.Variants = nil
+	if len(vs) > 1 {
+		first.Variants = vs
+	}
+}
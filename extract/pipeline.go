@@ -0,0 +1,143 @@
+package extract
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   EXTRACTOR PIPELINE
+	==============================
+*/
+
+// DefaultChain is the order the built-in extractors run in. srcset,
+// <picture> and lazy-load renditions are variants of an <img>, so "img"
+// covers them; og:image and similar tags are "meta".
+const DefaultChain = "img,noscript,meta,jsonld,background,lazy-background"
+
+// PageContext is the parsed page an Extractor works on.
+type PageContext struct {
+	Context context.Context
+	URL     string
+	Base    *url.URL
+	Doc     *goquery.Document
+}
+
+// NewPageContext parses pageURL as the base images are resolved against.
+func NewPageContext(ctx context.Context, pageURL string, doc *goquery.Document) *PageContext {
+	base, _ := url.Parse(pageURL)
+	if base == nil {
+		base = &url.URL{}
+	}
+	return &PageContext{Context: ctx, URL: pageURL, Base: base, Doc: doc}
+}
+
+// Extractor finds one kind of image reference on a page. Records it
+// returns are deduplicated, titled and licensed by the caller.
+type Extractor interface {
+	Extract(page *PageContext) []store.ImageRecord
+}
+
+// Func adapts a plain function to Extractor.
+type Func func(page *PageContext) []store.ImageRecord
+
+func (f Func) Extract(page *PageContext) []store.ImageRecord { return f(page) }
+
+// docFunc adapts the built-in extractors, which only need the page URL and
+// document.
+func docFunc(f func(page string, doc *goquery.Document) []store.ImageRecord) Func {
+	return func(p *PageContext) []store.ImageRecord { return f(p.URL, p.Doc) }
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Extractor{
+		"img":             docFunc(ImgTags),
+		"noscript":        docFunc(Noscript),
+		"meta":            docFunc(Meta),
+		"jsonld":          docFunc(JSONLD),
+		"background":      docFunc(Backgrounds),
+		"lazy-background": docFunc(LazyBackgrounds),
+	}
+)
+
+// Register makes e available under name to chains built afterwards. It
+// panics if the name is taken, like database/sql.Register.
+func Register(name string, e Extractor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if e == nil {
+		panic("extract: Register extractor is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("extract: Register called twice for " + name)
+	}
+	registry[name] = e
+}
+
+// Lookup returns the extractor registered under name.
+func Lookup(name string) (Extractor, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	e, ok := registry[name]
+	return e, ok
+}
+
+// Names lists the registered extractors, sorted.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Chain runs extractors in order and concatenates what they find.
+type Chain []Extractor
+
+func (c Chain) Extract(page *PageContext) []store.ImageRecord {
+	var out []store.ImageRecord
+	for _, e := range c {
+		out = append(out, e.Extract(page)...)
+	}
+	return out
+}
+
+// NewChain builds a chain from extractor names, looking each up in local
+// before the registry. Unknown names are an error; repeats run once.
+func NewChain(names []string, local map[string]Extractor) (Chain, error) {
+	var chain Chain
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		e, ok := local[name]
+		if !ok {
+			e, ok = Lookup(name)
+		}
+		if !ok {
+			known := Names()
+			for n := range local {
+				known = append(known, n)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown extractor %q (have %s)", name, strings.Join(known, ", "))
+		}
+		chain = append(chain, e)
+	}
+	return chain, nil
+}
@@ -0,0 +1,109 @@
+package extract
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+	==============================
+	   SRCSET PARSING
+	==============================
+*/
+
+// srcsetCandidate is one entry of a srcset attribute. Width is the "w"
+// descriptor and Density the "x" descriptor; an entry without either has
+// Density 1.
+type srcsetCandidate struct {
+	URL     string
+	Width   int
+	Density float64
+}
+
+func isSrcsetSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// parseSrcset follows the HTML srcset parsing rules closely enough for
+// real pages: a URL runs to the next whitespace, so URLs containing
+// commas (common on image CDNs) survive, and a trailing comma on the URL
+// ends a candidate with no descriptors. Malformed descriptors drop the
+// candidate.
+func parseSrcset(raw string) []srcsetCandidate {
+	var out []srcsetCandidate
+	i := 0
+	for i < len(raw) {
+		for i < len(raw) && (isSrcsetSpace(raw[i]) || raw[i] == ',') {
+			i++
+		}
+		if i >= len(raw) {
+			break
+		}
+
+		start := i
+		for i < len(raw) && !isSrcsetSpace(raw[i]) {
+			i++
+		}
+		link := raw[start:i]
+
+		var descriptors string
+		if strings.HasSuffix(link, ",") {
+			link = strings.TrimRight(link, ",")
+		} else {
+			start = i
+			depth := 0
+			for i < len(raw) {
+				c := raw[i]
+				if c == '(' {
+					depth++
+				} else if c == ')' && depth > 0 {
+					depth--
+				} else if c == ',' && depth == 0 {
+					break
+				}
+				i++
+			}
+			descriptors = raw[start:i]
+		}
+
+		if link == "" {
+			continue
+		}
+		if c, ok := parseSrcsetDescriptors(link, descriptors); ok {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func parseSrcsetDescriptors(link, descriptors string) (srcsetCandidate, bool) {
+	c := srcsetCandidate{URL: link}
+	for _, d := range strings.Fields(descriptors) {
+		if len(d) < 2 {
+			return c, false
+		}
+		num, unit := d[:len(d)-1], d[len(d)-1]
+		switch unit {
+		case 'w', 'W':
+			n, err := strconv.Atoi(num)
+			if err != nil || n <= 0 || c.Width != 0 {
+				return c, false
+			}
+			c.Width = n
+		case 'x', 'X':
+			f, err := strconv.ParseFloat(num, 64)
+			if err != nil || f <= 0 || c.Density != 0 {
+				return c, false
+			}
+			c.Density = f
+		case 'h', 'H':
+			// height descriptors are allowed but carry nothing we use
+		default:
+			return c, false
+		}
+	}
+	if c.Width == 0 && c.Density == 0 {
+		c.Density = 1
+	}
+	return c, true
+}
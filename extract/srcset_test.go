@@ -0,0 +1,77 @@
+package extract
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSrcset(t *testing.T) {
+	tests := []struct {
+		name   string
+		srcset string
+		want   []srcsetCandidate
+	}{
+		{name: "empty", srcset: "", want: nil},
+		{
+			name:   "bare URL",
+			srcset: "/a.jpg",
+			want:   []srcsetCandidate{{URL: "/a.jpg", Density: 1}},
+		},
+		{
+			name:   "width descriptors",
+			srcset: "/a.jpg 480w, /b.jpg 960w",
+			want:   []srcsetCandidate{{URL: "/a.jpg", Width: 480}, {URL: "/b.jpg", Width: 960}},
+		},
+		{
+			name:   "density descriptors",
+			srcset: "/a.jpg 1x,/b.jpg 2.5x",
+			want:   []srcsetCandidate{{URL: "/a.jpg", Density: 1}, {URL: "/b.jpg", Density: 2.5}},
+		},
+		{
+			name:   "commas inside URLs",
+			srcset: "https://cdn.test/c_fill,w_400/a.jpg 400w, https://cdn.test/c_fill,w_800/a.jpg 800w",
+			want: []srcsetCandidate{
+				{URL: "https://cdn.test/c_fill,w_400/a.jpg", Width: 400},
+				{URL: "https://cdn.test/c_fill,w_800/a.jpg", Width: 800},
+			},
+		},
+		{
+			name:   "trailing comma ends a candidate",
+			srcset: "/a.jpg, /b.jpg 2x",
+			want:   []srcsetCandidate{{URL: "/a.jpg", Density: 1}, {URL: "/b.jpg", Density: 2}},
+		},
+		{
+			name:   "odd whitespace",
+			srcset: "\n\t/a.jpg\t100w ,\n  /b.jpg   200w  ",
+			want:   []srcsetCandidate{{URL: "/a.jpg", Width: 100}, {URL: "/b.jpg", Width: 200}},
+		},
+		{
+			name:   "height descriptor is ignored",
+			srcset: "/a.jpg 300w 200h",
+			want:   []srcsetCandidate{{URL: "/a.jpg", Width: 300}},
+		},
+		{
+			name:   "uppercase units",
+			srcset: "/a.jpg 300W, /b.jpg 2X",
+			want:   []srcsetCandidate{{URL: "/a.jpg", Width: 300}, {URL: "/b.jpg", Density: 2}},
+		},
+		{
+			name:   "malformed descriptors drop the candidate",
+			srcset: "/a.jpg big, /b.jpg 0w, /c.jpg -1x, /d.jpg 100w 200w, /e.jpg 640w",
+			want:   []srcsetCandidate{{URL: "/e.jpg", Width: 640}},
+		},
+		{
+			name:   "only commas",
+			srcset: " , ,, ",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSrcset(tt.srcset); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSrcset(%q) = %+v, want %+v", tt.srcset, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,8 @@
 # image_indexer.py
 """
 Image Indexer (Option D).
-Indexes fields: alt text, caption text, page URL tokens, filename tokens, domain, format.
+Indexes fields: alt text, caption text, surrounding text, page title, nearest heading,
+page URL tokens, filename tokens, domain, format.
 Writes:
  - image_documents collection: per-image metadata + token length
  - image_terms collection: inverted index entries with idf and postings (doc_id, tf)
@@ -15,7 +16,7 @@ from urllib.parse import urlparse, unquote
 from os.path import basename
 
 from dotenv import load_dotenv
-from pymongo import MongoClient
+from pymongo import MongoClient, TEXT
 from pymongo.errors import PyMongoError
 
 load_dotenv()
@@ -32,6 +33,22 @@ db = client[IMG_DB_NAME]
 IMAGE_COLL = db["image_files"]       # source collection (from crawler)
 IMAGE_DOCS_COLL = db["image_documents"]
 IMAGE_INDEX_COLL = db["image_terms"]
+IMAGE_STATS_COLL = db["image_index_stats"]  # corpus size and average length, for BM25
+IMAGE_SUGGEST_COLL = db["image_suggestions"]  # alt/caption words and word pairs, for autocomplete
+
+# Phrases in fewer images than this are not suggested (typos, one-offs)
+SUGGEST_MIN_COUNT = 2
+
+# Weighted text index the search API queries with $text. It must match
+# TEXT_INDEX_WEIGHTS in backend/main.py, which creates it on startup too.
+TEXT_INDEX_NAME = "image_text"
+TEXT_INDEX_WEIGHTS = {
+    "alt_text": 10,
+    "caption_text": 8,
+    "page_title": 4,
+    "nearest_heading": 3,
+    "context_text": 1,
+}
 
 # ---------------- tokenization / stopwords ----------------
 
@@ -88,13 +105,50 @@ def build_image_index():
         "file_url": 1,
         "alt_text": 1,
         "caption_text": 1,
+        "context_text": 1,
+        "page_title": 1,
+        "nearest_heading": 1,
+        "license": 1,
+        "license_url": 1,
+        "dup_group": 1,
+        "dhash": 1,
+        "dhash_bands": 1,
+        "known_urls": 1,
+        "dominant_colors": 1,
+        "color_buckets": 1,
+        "thumbnail_url": 1,
+        "nsfw_score": 1,
+        "embedding": 1,
+        "embedding_model": 1,
+        "faces_count": 1,
+        "aspect_ratio": 1,
+        "orientation": 1,
+        "file_size": 1,
+        "content_length": 1,
+        "pixel_width": 1,
+        "pixel_height": 1,
+        "first_seen": 1,
+        "last_seen": 1,
+        "times_seen": 1,
+        "time_fetched": 1,
         "page_url": 1,
         "domain_name": 1,
         "format": 1,
     }
 
     try:
-        cursor = IMAGE_COLL.find({}, projection)
+        # files enrichment could not decode, images the retention sweep
+        # flagged stale, links the checker found dead, and tombstones of
+        # removed images are kept out of the index
+        cursor = IMAGE_COLL.find(
+            {
+                "corrupt": {"$ne": True},
+                "stale": {"$ne": True},
+                "dead_since": {"$exists": False},
+                "deleted_at": {"$exists": False},
+            },
+            projection,
+        )
     except PyMongoError as e:
         print("Failed to query image_files collection:", e)
         return
@@ -114,6 +168,7 @@ def build_image_index():
     print(f"Found {len(images)} images. Building index...")
 
     inverted_index = defaultdict(lambda: defaultdict(int))  # term -> {doc_id: tf}
+    suggestion_counts = Counter()  # word or word pair -> number of images
     doc_lengths = {}
     doc_metadata = {}
 
@@ -123,12 +178,38 @@ def build_image_index():
             file_url = img.get("file_url") or img.get("image_url") or ""
             alt = img.get("alt_text") or img.get("alt") or ""
             caption = img.get("caption_text") or img.get("caption") or ""
+            context = img.get("context_text") or ""
+            title = img.get("page_title") or ""
+            heading = img.get("nearest_heading") or ""
+            license_id = img.get("license") or ""
+            license_url = img.get("license_url") or ""
+            dup_group = img.get("dup_group") or ""
+            dhash = img.get("dhash") or ""
+            dhash_bands = img.get("dhash_bands") or []
+            known_urls = img.get("known_urls") or []
+            colors = img.get("dominant_colors") or []
+            color_buckets = img.get("color_buckets") or []
+            thumbnail_url = img.get("thumbnail_url") or ""
+            nsfw_score = img.get("nsfw_score")  # None when never scored
+            embedding = img.get("embedding")
+            embedding_model = img.get("embedding_model") or ""
+            faces_count = img.get("faces_count")  # None when never checked
+            aspect_ratio = img.get("aspect_ratio")  # None until dimensions are known
+            orientation = img.get("orientation") or ""
+            file_size = img.get("file_size") or img.get("content_length")  # None when unknown
+            pixel_width = img.get("pixel_width")
+            pixel_height = img.get("pixel_height")
+            # the search API still calls the last sighting time_fetched;
+            # records not yet migrated only have that
+            time_fetched = img.get("last_seen") or img.get("time_fetched")
+            first_seen = img.get("first_seen") or time_fetched
+            times_seen = img.get("times_seen") or 1
             page_url = img.get("page_url") or img.get("parent_url") or ""
             domain = img.get("domain_name") or img.get("site_name") or ""
             fmt = img.get("format") or img.get("image_type") or ""
 
             # Build a combined text that we will tokenize
-            # Components: alt, caption, filename tokens, page tokens, domain, format
+            # Components: alt, caption, surrounding text, title, heading, filename tokens, page tokens, domain, format
             filename = ""
             try:
                 filename = basename(urlparse(file_url).path)
@@ -140,6 +221,12 @@ def build_image_index():
                 parts.append(alt)
             if caption:
                 parts.append(caption)
+            if context:
+                parts.append(context)
+            if title:
+                parts.append(title)
+            if heading:
+                parts.append(heading)
             if filename:
                 # split filename into words
                 parts.append(re.sub(r"[-_]+", " ", filename))
@@ -164,19 +251,51 @@ def build_image_index():
 
             doc_lengths[doc_id] = len(tokens)
 
-            # snippet: prefer caption > alt > filename > page_url (short)
-            snippet = caption or alt or (filename if filename else (page_url[:300] if page_url else ""))
+            # snippet: prefer caption > alt > surrounding text > filename > page_url (short)
+            snippet = caption or alt or context or (filename if filename else (page_url[:300] if page_url else ""))
 
             doc_metadata[doc_id] = {
                 "file_url": file_url,
                 "alt_text": alt,
                 "caption_text": caption,
+                "context_text": context,
+                "page_title": title,
+                "nearest_heading": heading,
+                "license": license_id,
+                "license_url": license_url,
+                "dup_group": dup_group,
+                "dhash": dhash,
+                "dhash_bands": dhash_bands,
+                "known_urls": known_urls,
+                "dominant_colors": colors,
+                "color_buckets": color_buckets,
+                "thumbnail_url": thumbnail_url,
+                "nsfw_score": nsfw_score,
+                "embedding": embedding,
+                "embedding_model": embedding_model,
+                "faces_count": faces_count,
+                "aspect_ratio": aspect_ratio,
+                "orientation": orientation,
+                "file_size": file_size,
+                "pixel_width": pixel_width,
+                "pixel_height": pixel_height,
+                "time_fetched": time_fetched,
+                "first_seen": first_seen,
+                "times_seen": times_seen,
                 "page_url": page_url,
                 "domain_name": domain,
                 "format": fmt,
                 "snippet": snippet
             }
 
+            # autocomplete draws on what describes the image itself
+            phrases = set()
+            for text in (alt, caption):
+                words = tokenize(text)
+                phrases.update(words)
+                phrases.update(f"{a} {b}" for a, b in zip(words, words[1:]))
+            suggestion_counts.update(phrases)
+
             tf_counter = Counter(tokens)
             for term, tf in tf_counter.items():
                 inverted_index[term][doc_id] += tf
@@ -220,22 +339,62 @@ def build_image_index():
     print("Inserting image metadata documents...")
     docs_bulk = []
     for doc_id, meta in doc_metadata.items():
-        docs_bulk.append({
+        doc = {
             "_id": doc_id,
             "file_url": meta["file_url"],
             "alt_text": meta["alt_text"],
             "caption_text": meta["caption_text"],
+            "context_text": meta["context_text"],
+            "page_title": meta["page_title"],
+            "nearest_heading": meta["nearest_heading"],
+            "license": meta["license"],
+            "license_url": meta["license_url"],
+            "dup_group": meta["dup_group"],
+            "dhash": meta["dhash"],
+            "dhash_bands": meta["dhash_bands"],
+            "known_urls": meta["known_urls"],
+            "dominant_colors": meta["dominant_colors"],
+            "color_buckets": meta["color_buckets"],
+            "thumbnail_url": meta["thumbnail_url"],
+            "nsfw_score": meta["nsfw_score"],
+            "embedding_model": meta["embedding_model"],
+            "faces_count": meta["faces_count"],
+            "aspect_ratio": meta["aspect_ratio"],
+            "orientation": meta["orientation"],
+            "file_size": meta["file_size"],
+            "pixel_width": meta["pixel_width"],
+            "pixel_height": meta["pixel_height"],
+            "time_fetched": meta["time_fetched"],
+            "first_seen": meta["first_seen"],
+            "times_seen": meta["times_seen"],
             "page_url": meta["page_url"],
             "domain_name": meta["domain_name"],
             "format": meta["format"],
             "length": doc_lengths[doc_id],
             "snippet": meta["snippet"]
-        })
+        }
+        # only embedded images carry a vector; the semantic search looks
+        # for the field, so it must be absent rather than null
+        if meta["embedding"]:
+            doc["embedding"] = meta["embedding"]
+        docs_bulk.append(doc)
 
     if docs_bulk:
         IMAGE_DOCS_COLL.insert_many(docs_bulk)
     print(f"Inserted {len(docs_bulk)} documents into 'image_documents' collection.")
 
+    # dropping the collection dropped its text index as well
+    IMAGE_DOCS_COLL.create_index(
+        [(field, TEXT) for field in TEXT_INDEX_WEIGHTS],
+        name=TEXT_INDEX_NAME,
+        weights=TEXT_INDEX_WEIGHTS,
+        default_language="english",
+    )
+    for field in ("domain_name", "format", "pixel_width", "time_fetched", "orientation", "color_buckets", "dhash_bands",
+                  "nsfw_score", "page_url"):
+        IMAGE_DOCS_COLL.create_index(field)
+    print("Created text and filter indexes on 'image_documents'.")
+
     print("Inserting index terms (this may take a moment)...")
     batch_size = 1000
     for i in range(0, len(index_docs), batch_size):
@@ -243,6 +402,24 @@ def build_image_index():
         IMAGE_INDEX_COLL.insert_many(batch)
         print(f"Inserted {i + len(batch)} / {len(index_docs)} index terms...")
 
+    IMAGE_INDEX_COLL.create_index("term")
+
+    # the phrase is the _id, so prefix queries run on the _id index
+    IMAGE_SUGGEST_COLL.drop()
+    suggestions = [
+        {"_id": phrase, "count": count}
+        for phrase, count in suggestion_counts.items()
+        if count >= SUGGEST_MIN_COUNT
+    ]
+    if suggestions:
+        IMAGE_SUGGEST_COLL.insert_many(suggestions)
+    print(f"Stored {len(suggestions)} autocomplete phrases.")
+    IMAGE_STATS_COLL.replace_one(
+        {"_id": "corpus"},
+        {"num_docs": num_docs, "avg_length": sum(doc_lengths.values()) / num_docs},
+        upsert=True,
+    )
+
     print("Image index build complete ✅")
 
 
@@ -0,0 +1,699 @@
+// Regenerate imagesearchpb with `buf generate` from the repository root
+// (buf.gen.yaml; needs protoc-gen-go and protoc-gen-go-grpc on PATH).
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: imagesearch.proto
+
+package imagesearchpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type CrawlState int32
+
+const (
+	CrawlState_CRAWL_STATE_UNSPECIFIED CrawlState = 0
+	// no crawl has run yet
+	CrawlState_CRAWL_STATE_IDLE     CrawlState = 1
+	CrawlState_CRAWL_STATE_RUNNING  CrawlState = 2
+	CrawlState_CRAWL_STATE_FINISHED CrawlState = 3
+	CrawlState_CRAWL_STATE_FAILED   CrawlState = 4
+)
+
+// Enum value maps for CrawlState.
+var (
+	CrawlState_name = map[int32]string{
+		0: "CRAWL_STATE_UNSPECIFIED",
+		1: "CRAWL_STATE_IDLE",
+		2: "CRAWL_STATE_RUNNING",
+		3: "CRAWL_STATE_FINISHED",
+		4: "CRAWL_STATE_FAILED",
+	}
+	CrawlState_value = map[string]int32{
+		"CRAWL_STATE_UNSPECIFIED": 0,
+		"CRAWL_STATE_IDLE":        1,
+		"CRAWL_STATE_RUNNING":     2,
+		"CRAWL_STATE_FINISHED":    3,
+		"CRAWL_STATE_FAILED":      4,
+	}
+)
+
+func (x CrawlState) Enum() *CrawlState {
+	p := new(CrawlState)
+	*p = x
+	return p
+}
+
+func (x CrawlState) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (CrawlState) Descriptor() protoreflect.EnumDescriptor {
+	return file_imagesearch_proto_enumTypes[0].Descriptor()
+}
+
+func (CrawlState) Type() protoreflect.EnumType {
+	return &file_imagesearch_proto_enumTypes[0]
+}
+
+func (x CrawlState) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use CrawlState.Descriptor instead.
+func (CrawlState) EnumDescriptor() ([]byte, []int) {
+	return file_imagesearch_proto_rawDescGZIP(), []int{0}
+}
+
+type SearchImagesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Query string                 `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	// results to stream; 0 means the server default
+	Limit int32 `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+	// rank by embedding similarity instead of text
+	Semantic bool `protobuf:"varint,3,opt,name=semantic,proto3" json:"semantic,omitempty"`
+	// safe search tier: off, moderate or strict; empty for the API default
+	Safe string `protobuf:"bytes,4,opt,name=safe,proto3" json:"safe,omitempty"`
+	// filters, as the HTTP API takes them; format and domain are
+	// comma-separated lists
+	License       string `protobuf:"bytes,5,opt,name=license,proto3" json:"license,omitempty"`
+	Format        string `protobuf:"bytes,6,opt,name=format,proto3" json:"format,omitempty"`
+	Domain        string `protobuf:"bytes,7,opt,name=domain,proto3" json:"domain,omitempty"`
+	Orientation   string `protobuf:"bytes,8,opt,name=orientation,proto3" json:"orientation,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchImagesRequest) Reset() {
+	*x = SearchImagesRequest{}
+	mi := &file_imagesearch_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchImagesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchImagesRequest) ProtoMessage() {}
+
+func (x *SearchImagesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_imagesearch_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchImagesRequest.ProtoReflect.Descriptor instead.
+func (*SearchImagesRequest) Descriptor() ([]byte, []int) {
+	return file_imagesearch_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SearchImagesRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *SearchImagesRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *SearchImagesRequest) GetSemantic() bool {
+	if x != nil {
+		return x.Semantic
+	}
+	return false
+}
+
+func (x *SearchImagesRequest) GetSafe() string {
+	if x != nil {
+		return x.Safe
+	}
+	return ""
+}
+
+func (x *SearchImagesRequest) GetLicense() string {
+	if x != nil {
+		return x.License
+	}
+	return ""
+}
+
+func (x *SearchImagesRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *SearchImagesRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *SearchImagesRequest) GetOrientation() string {
+	if x != nil {
+		return x.Orientation
+	}
+	return ""
+}
+
+type ImageResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	FileUrl       string                 `protobuf:"bytes,2,opt,name=file_url,json=fileUrl,proto3" json:"file_url,omitempty"`
+	Alt           string                 `protobuf:"bytes,3,opt,name=alt,proto3" json:"alt,omitempty"`
+	Caption       string                 `protobuf:"bytes,4,opt,name=caption,proto3" json:"caption,omitempty"`
+	PageUrl       string                 `protobuf:"bytes,5,opt,name=page_url,json=pageUrl,proto3" json:"page_url,omitempty"`
+	Domain        string                 `protobuf:"bytes,6,opt,name=domain,proto3" json:"domain,omitempty"`
+	Format        string                 `protobuf:"bytes,7,opt,name=format,proto3" json:"format,omitempty"`
+	License       string                 `protobuf:"bytes,8,opt,name=license,proto3" json:"license,omitempty"`
+	LicenseUrl    string                 `protobuf:"bytes,9,opt,name=license_url,json=licenseUrl,proto3" json:"license_url,omitempty"`
+	ThumbnailUrl  string                 `protobuf:"bytes,10,opt,name=thumbnail_url,json=thumbnailUrl,proto3" json:"thumbnail_url,omitempty"`
+	FaviconUrl    string                 `protobuf:"bytes,11,opt,name=favicon_url,json=faviconUrl,proto3" json:"favicon_url,omitempty"`
+	Width         int32                  `protobuf:"varint,12,opt,name=width,proto3" json:"width,omitempty"`
+	Height        int32                  `protobuf:"varint,13,opt,name=height,proto3" json:"height,omitempty"`
+	Score         float64                `protobuf:"fixed64,14,opt,name=score,proto3" json:"score,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ImageResult) Reset() {
+	*x = ImageResult{}
+	mi := &file_imagesearch_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ImageResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImageResult) ProtoMessage() {}
+
+func (x *ImageResult) ProtoReflect() protoreflect.Message {
+	mi := &file_imagesearch_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImageResult.ProtoReflect.Descriptor instead.
+func (*ImageResult) Descriptor() ([]byte, []int) {
+	return file_imagesearch_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ImageResult) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ImageResult) GetFileUrl() string {
+	if x != nil {
+		return x.FileUrl
+	}
+	return ""
+}
+
+func (x *ImageResult) GetAlt() string {
+	if x != nil {
+		return x.Alt
+	}
+	return ""
+}
+
+func (x *ImageResult) GetCaption() string {
+	if x != nil {
+		return x.Caption
+	}
+	return ""
+}
+
+func (x *ImageResult) GetPageUrl() string {
+	if x != nil {
+		return x.PageUrl
+	}
+	return ""
+}
+
+func (x *ImageResult) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *ImageResult) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *ImageResult) GetLicense() string {
+	if x != nil {
+		return x.License
+	}
+	return ""
+}
+
+func (x *ImageResult) GetLicenseUrl() string {
+	if x != nil {
+		return x.LicenseUrl
+	}
+	return ""
+}
+
+func (x *ImageResult) GetThumbnailUrl() string {
+	if x != nil {
+		return x.ThumbnailUrl
+	}
+	return ""
+}
+
+func (x *ImageResult) GetFaviconUrl() string {
+	if x != nil {
+		return x.FaviconUrl
+	}
+	return ""
+}
+
+func (x *ImageResult) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *ImageResult) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *ImageResult) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+type SubmitSeedsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// page or sitemap URLs
+	Seeds         []string `protobuf:"bytes,1,rep,name=seeds,proto3" json:"seeds,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitSeedsRequest) Reset() {
+	*x = SubmitSeedsRequest{}
+	mi := &file_imagesearch_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitSeedsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitSeedsRequest) ProtoMessage() {}
+
+func (x *SubmitSeedsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_imagesearch_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitSeedsRequest.ProtoReflect.Descriptor instead.
+func (*SubmitSeedsRequest) Descriptor() ([]byte, []int) {
+	return file_imagesearch_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SubmitSeedsRequest) GetSeeds() []string {
+	if x != nil {
+		return x.Seeds
+	}
+	return nil
+}
+
+type SubmitSeedsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// pages queued, sitemaps counted by the pages they listed
+	Queued int32 `protobuf:"varint,1,opt,name=queued,proto3" json:"queued,omitempty"`
+	// seeds that are not crawlable URLs or are outside the allowed sites
+	Rejected []string `protobuf:"bytes,2,rep,name=rejected,proto3" json:"rejected,omitempty"`
+	// whether a new crawl was started rather than the running one extended
+	Started       bool `protobuf:"varint,3,opt,name=started,proto3" json:"started,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitSeedsResponse) Reset() {
+	*x = SubmitSeedsResponse{}
+	mi := &file_imagesearch_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitSeedsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitSeedsResponse) ProtoMessage() {}
+
+func (x *SubmitSeedsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_imagesearch_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitSeedsResponse.ProtoReflect.Descriptor instead.
+func (*SubmitSeedsResponse) Descriptor() ([]byte, []int) {
+	return file_imagesearch_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SubmitSeedsResponse) GetQueued() int32 {
+	if x != nil {
+		return x.Queued
+	}
+	return 0
+}
+
+func (x *SubmitSeedsResponse) GetRejected() []string {
+	if x != nil {
+		return x.Rejected
+	}
+	return nil
+}
+
+func (x *SubmitSeedsResponse) GetStarted() bool {
+	if x != nil {
+		return x.Started
+	}
+	return false
+}
+
+type GetCrawlStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCrawlStatusRequest) Reset() {
+	*x = GetCrawlStatusRequest{}
+	mi := &file_imagesearch_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCrawlStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCrawlStatusRequest) ProtoMessage() {}
+
+func (x *GetCrawlStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_imagesearch_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCrawlStatusRequest.ProtoReflect.Descriptor instead.
+func (*GetCrawlStatusRequest) Descriptor() ([]byte, []int) {
+	return file_imagesearch_proto_rawDescGZIP(), []int{4}
+}
+
+type CrawlStatus struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	State        CrawlState             `protobuf:"varint,1,opt,name=state,proto3,enum=imagesearch.v1.CrawlState" json:"state,omitempty"`
+	StartedAt    *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	FinishedAt   *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"`
+	PagesCrawled int64                  `protobuf:"varint,4,opt,name=pages_crawled,json=pagesCrawled,proto3" json:"pages_crawled,omitempty"`
+	ImagesSaved  int64                  `protobuf:"varint,5,opt,name=images_saved,json=imagesSaved,proto3" json:"images_saved,omitempty"`
+	SeedsQueued  int64                  `protobuf:"varint,6,opt,name=seeds_queued,json=seedsQueued,proto3" json:"seeds_queued,omitempty"`
+	// why the crawl failed
+	Error         string `protobuf:"bytes,7,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CrawlStatus) Reset() {
+	*x = CrawlStatus{}
+	mi := &file_imagesearch_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CrawlStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CrawlStatus) ProtoMessage() {}
+
+func (x *CrawlStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_imagesearch_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CrawlStatus.ProtoReflect.Descriptor instead.
+func (*CrawlStatus) Descriptor() ([]byte, []int) {
+	return file_imagesearch_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *CrawlStatus) GetState() CrawlState {
+	if x != nil {
+		return x.State
+	}
+	return CrawlState_CRAWL_STATE_UNSPECIFIED
+}
+
+func (x *CrawlStatus) GetStartedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartedAt
+	}
+	return nil
+}
+
+func (x *CrawlStatus) GetFinishedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FinishedAt
+	}
+	return nil
+}
+
+func (x *CrawlStatus) GetPagesCrawled() int64 {
+	if x != nil {
+		return x.PagesCrawled
+	}
+	return 0
+}
+
+func (x *CrawlStatus) GetImagesSaved() int64 {
+	if x != nil {
+		return x.ImagesSaved
+	}
+	return 0
+}
+
+func (x *CrawlStatus) GetSeedsQueued() int64 {
+	if x != nil {
+		return x.SeedsQueued
+	}
+	return 0
+}
+
+func (x *CrawlStatus) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_imagesearch_proto protoreflect.FileDescriptor
+
+const file_imagesearch_proto_rawDesc = "" +
+	"\n" +
+	"\x11imagesearch.proto\x12\x0eimagesearch.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xdd\x01\n" +
+	"\x13SearchImagesRequest\x12\x14\n" +
+	"\x05query\x18\x01 \x01(\tR\x05query\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\x05R\x05limit\x12\x1a\n" +
+	"\bsemantic\x18\x03 \x01(\bR\bsemantic\x12\x12\n" +
+	"\x04safe\x18\x04 \x01(\tR\x04safe\x12\x18\n" +
+	"\alicense\x18\x05 \x01(\tR\alicense\x12\x16\n" +
+	"\x06format\x18\x06 \x01(\tR\x06format\x12\x16\n" +
+	"\x06domain\x18\a \x01(\tR\x06domain\x12 \n" +
+	"\vorientation\x18\b \x01(\tR\vorientation\"\xf4\x02\n" +
+	"\vImageResult\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x19\n" +
+	"\bfile_url\x18\x02 \x01(\tR\afileUrl\x12\x10\n" +
+	"\x03alt\x18\x03 \x01(\tR\x03alt\x12\x18\n" +
+	"\acaption\x18\x04 \x01(\tR\acaption\x12\x19\n" +
+	"\bpage_url\x18\x05 \x01(\tR\apageUrl\x12\x16\n" +
+	"\x06domain\x18\x06 \x01(\tR\x06domain\x12\x16\n" +
+	"\x06format\x18\a \x01(\tR\x06format\x12\x18\n" +
+	"\alicense\x18\b \x01(\tR\alicense\x12\x1f\n" +
+	"\vlicense_url\x18\t \x01(\tR\n" +
+	"licenseUrl\x12#\n" +
+	"\rthumbnail_url\x18\n" +
+	" \x01(\tR\fthumbnailUrl\x12\x1f\n" +
+	"\vfavicon_url\x18\v \x01(\tR\n" +
+	"faviconUrl\x12\x14\n" +
+	"\x05width\x18\f \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\r \x01(\x05R\x06height\x12\x14\n" +
+	"\x05score\x18\x0e \x01(\x01R\x05score\"*\n" +
+	"\x12SubmitSeedsRequest\x12\x14\n" +
+	"\x05seeds\x18\x01 \x03(\tR\x05seeds\"c\n" +
+	"\x13SubmitSeedsResponse\x12\x16\n" +
+	"\x06queued\x18\x01 \x01(\x05R\x06queued\x12\x1a\n" +
+	"\brejected\x18\x02 \x03(\tR\brejected\x12\x18\n" +
+	"\astarted\x18\x03 \x01(\bR\astarted\"\x17\n" +
+	"\x15GetCrawlStatusRequest\"\xb8\x02\n" +
+	"\vCrawlStatus\x120\n" +
+	"\x05state\x18\x01 \x01(\x0e2\x1a.imagesearch.v1.CrawlStateR\x05state\x129\n" +
+	"\n" +
+	"started_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\tstartedAt\x12;\n" +
+	"\vfinished_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"finishedAt\x12#\n" +
+	"\rpages_crawled\x18\x04 \x01(\x03R\fpagesCrawled\x12!\n" +
+	"\fimages_saved\x18\x05 \x01(\x03R\vimagesSaved\x12!\n" +
+	"\fseeds_queued\x18\x06 \x01(\x03R\vseedsQueued\x12\x14\n" +
+	"\x05error\x18\a \x01(\tR\x05error*\x8a\x01\n" +
+	"\n" +
+	"CrawlState\x12\x1b\n" +
+	"\x17CRAWL_STATE_UNSPECIFIED\x10\x00\x12\x14\n" +
+	"\x10CRAWL_STATE_IDLE\x10\x01\x12\x17\n" +
+	"\x13CRAWL_STATE_RUNNING\x10\x02\x12\x18\n" +
+	"\x14CRAWL_STATE_FINISHED\x10\x03\x12\x16\n" +
+	"\x12CRAWL_STATE_FAILED\x10\x042\x8f\x02\n" +
+	"\vImageSearch\x12R\n" +
+	"\fSearchImages\x12#.imagesearch.v1.SearchImagesRequest\x1a\x1b.imagesearch.v1.ImageResult0\x01\x12V\n" +
+	"\vSubmitSeeds\x12\".imagesearch.v1.SubmitSeedsRequest\x1a#.imagesearch.v1.SubmitSeedsResponse\x12T\n" +
+	"\x0eGetCrawlStatus\x12%.imagesearch.v1.GetCrawlStatusRequest\x1a\x1b.imagesearch.v1.CrawlStatusB\x1dZ\x1bimage_crawler/imagesearchpbb\x06proto3"
+
+var (
+	file_imagesearch_proto_rawDescOnce sync.Once
+	file_imagesearch_proto_rawDescData []byte
+)
+
+func file_imagesearch_proto_rawDescGZIP() []byte {
+	file_imagesearch_proto_rawDescOnce.Do(func() {
+		file_imagesearch_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_imagesearch_proto_rawDesc), len(file_imagesearch_proto_rawDesc)))
+	})
+	return file_imagesearch_proto_rawDescData
+}
+
+var file_imagesearch_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_imagesearch_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_imagesearch_proto_goTypes = []any{
+	(CrawlState)(0),               // 0: imagesearch.v1.CrawlState
+	(*SearchImagesRequest)(nil),   // 1: imagesearch.v1.SearchImagesRequest
+	(*ImageResult)(nil),           // 2: imagesearch.v1.ImageResult
+	(*SubmitSeedsRequest)(nil),    // 3: imagesearch.v1.SubmitSeedsRequest
+	(*SubmitSeedsResponse)(nil),   // 4: imagesearch.v1.SubmitSeedsResponse
+	(*GetCrawlStatusRequest)(nil), // 5: imagesearch.v1.GetCrawlStatusRequest
+	(*CrawlStatus)(nil),           // 6: imagesearch.v1.CrawlStatus
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+}
+var file_imagesearch_proto_depIdxs = []int32{
+	0, // 0: imagesearch.v1.CrawlStatus.state:type_name -> imagesearch.v1.CrawlState
+	7, // 1: imagesearch.v1.CrawlStatus.started_at:type_name -> google.protobuf.Timestamp
+	7, // 2: imagesearch.v1.CrawlStatus.finished_at:type_name -> google.protobuf.Timestamp
+	1, // 3: imagesearch.v1.ImageSearch.SearchImages:input_type -> imagesearch.v1.SearchImagesRequest
+	3, // 4: imagesearch.v1.ImageSearch.SubmitSeeds:input_type -> imagesearch.v1.SubmitSeedsRequest
+	5, // 5: imagesearch.v1.ImageSearch.GetCrawlStatus:input_type -> imagesearch.v1.GetCrawlStatusRequest
+	2, // 6: imagesearch.v1.ImageSearch.SearchImages:output_type -> imagesearch.v1.ImageResult
+	4, // 7: imagesearch.v1.ImageSearch.SubmitSeeds:output_type -> imagesearch.v1.SubmitSeedsResponse
+	6, // 8: imagesearch.v1.ImageSearch.GetCrawlStatus:output_type -> imagesearch.v1.CrawlStatus
+	6, // [6:9] is the sub-list for method output_type
+	3, // [3:6] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_imagesearch_proto_init() }
+func file_imagesearch_proto_init() {
+	if File_imagesearch_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_imagesearch_proto_rawDesc), len(file_imagesearch_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_imagesearch_proto_goTypes,
+		DependencyIndexes: file_imagesearch_proto_depIdxs,
+		EnumInfos:         file_imagesearch_proto_enumTypes,
+		MessageInfos:      file_imagesearch_proto_msgTypes,
+	}.Build()
+	File_imagesearch_proto = out.File
+	file_imagesearch_proto_goTypes = nil
+	file_imagesearch_proto_depIdxs = nil
+}
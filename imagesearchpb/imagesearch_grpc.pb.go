@@ -0,0 +1,231 @@
+// Regenerate imagesearchpb with `buf generate` from the repository root
+// (buf.gen.yaml; needs protoc-gen-go and protoc-gen-go-grpc on PATH).
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: imagesearch.proto
+
+package imagesearchpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	ImageSearch_SearchImages_FullMethodName   = "/imagesearch.v1.ImageSearch/SearchImages"
+	ImageSearch_SubmitSeeds_FullMethodName    = "/imagesearch.v1.ImageSearch/SubmitSeeds"
+	ImageSearch_GetCrawlStatus_FullMethodName = "/imagesearch.v1.ImageSearch/GetCrawlStatus"
+)
+
+// ImageSearchClient is the client API for ImageSearch service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ImageSearch is the gRPC face of the search API and of a crawler running
+// with IMG_MODE=serve.
+type ImageSearchClient interface {
+	// SearchImages streams ranked results, best first.
+	SearchImages(ctx context.Context, in *SearchImagesRequest, opts ...grpc.CallOption) (ImageSearch_SearchImagesClient, error)
+	// SubmitSeeds adds pages to the running crawl, or starts a crawl from
+	// them when none is running.
+	SubmitSeeds(ctx context.Context, in *SubmitSeedsRequest, opts ...grpc.CallOption) (*SubmitSeedsResponse, error)
+	// GetCrawlStatus reports on the current or last crawl.
+	GetCrawlStatus(ctx context.Context, in *GetCrawlStatusRequest, opts ...grpc.CallOption) (*CrawlStatus, error)
+}
+
+type imageSearchClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewImageSearchClient(cc grpc.ClientConnInterface) ImageSearchClient {
+	return &imageSearchClient{cc}
+}
+
+func (c *imageSearchClient) SearchImages(ctx context.Context, in *SearchImagesRequest, opts ...grpc.CallOption) (ImageSearch_SearchImagesClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ImageSearch_ServiceDesc.Streams[0], ImageSearch_SearchImages_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &imageSearchSearchImagesClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ImageSearch_SearchImagesClient interface {
+	Recv() (*ImageResult, error)
+	grpc.ClientStream
+}
+
+type imageSearchSearchImagesClient struct {
+	grpc.ClientStream
+}
+
+func (x *imageSearchSearchImagesClient) Recv() (*ImageResult, error) {
+	m := new(ImageResult)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *imageSearchClient) SubmitSeeds(ctx context.Context, in *SubmitSeedsRequest, opts ...grpc.CallOption) (*SubmitSeedsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubmitSeedsResponse)
+	err := c.cc.Invoke(ctx, ImageSearch_SubmitSeeds_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageSearchClient) GetCrawlStatus(ctx context.Context, in *GetCrawlStatusRequest, opts ...grpc.CallOption) (*CrawlStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CrawlStatus)
+	err := c.cc.Invoke(ctx, ImageSearch_GetCrawlStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ImageSearchServer is the server API for ImageSearch service.
+// All implementations must embed UnimplementedImageSearchServer
+// for forward compatibility
+//
+// ImageSearch is the gRPC face of the search API and of a crawler running
+// with IMG_MODE=serve.
+type ImageSearchServer interface {
+	// SearchImages streams ranked results, best first.
+	SearchImages(*SearchImagesRequest, ImageSearch_SearchImagesServer) error
+	// SubmitSeeds adds pages to the running crawl, or starts a crawl from
+	// them when none is running.
+	SubmitSeeds(context.Context, *SubmitSeedsRequest) (*SubmitSeedsResponse, error)
+	// GetCrawlStatus reports on the current or last crawl.
+	GetCrawlStatus(context.Context, *GetCrawlStatusRequest) (*CrawlStatus, error)
+	mustEmbedUnimplementedImageSearchServer()
+}
+
+// UnimplementedImageSearchServer must be embedded to have forward compatible implementations.
+type UnimplementedImageSearchServer struct {
+}
+
+func (UnimplementedImageSearchServer) SearchImages(*SearchImagesRequest, ImageSearch_SearchImagesServer) error {
+	return status.Errorf(codes.Unimplemented, "method SearchImages not implemented")
+}
+func (UnimplementedImageSearchServer) SubmitSeeds(context.Context, *SubmitSeedsRequest) (*SubmitSeedsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitSeeds not implemented")
+}
+func (UnimplementedImageSearchServer) GetCrawlStatus(context.Context, *GetCrawlStatusRequest) (*CrawlStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCrawlStatus not implemented")
+}
+func (UnimplementedImageSearchServer) mustEmbedUnimplementedImageSearchServer() {}
+
+// UnsafeImageSearchServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ImageSearchServer will
+// result in compilation errors.
+type UnsafeImageSearchServer interface {
+	mustEmbedUnimplementedImageSearchServer()
+}
+
+func RegisterImageSearchServer(s grpc.ServiceRegistrar, srv ImageSearchServer) {
+	s.RegisterService(&ImageSearch_ServiceDesc, srv)
+}
+
+func _ImageSearch_SearchImages_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SearchImagesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ImageSearchServer).SearchImages(m, &imageSearchSearchImagesServer{ServerStream: stream})
+}
+
+type ImageSearch_SearchImagesServer interface {
+	Send(*ImageResult) error
+	grpc.ServerStream
+}
+
+type imageSearchSearchImagesServer struct {
+	grpc.ServerStream
+}
+
+func (x *imageSearchSearchImagesServer) Send(m *ImageResult) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ImageSearch_SubmitSeeds_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitSeedsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageSearchServer).SubmitSeeds(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageSearch_SubmitSeeds_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageSearchServer).SubmitSeeds(ctx, req.(*SubmitSeedsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageSearch_GetCrawlStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCrawlStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageSearchServer).GetCrawlStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageSearch_GetCrawlStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageSearchServer).GetCrawlStatus(ctx, req.(*GetCrawlStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ImageSearch_ServiceDesc is the grpc.ServiceDesc for ImageSearch service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ImageSearch_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "imagesearch.v1.ImageSearch",
+	HandlerType: (*ImageSearchServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SubmitSeeds",
+			Handler:    _ImageSearch_SubmitSeeds_Handler,
+		},
+		{
+			MethodName: "GetCrawlStatus",
+			Handler:    _ImageSearch_GetCrawlStatus_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SearchImages",
+			Handler:       _ImageSearch_SearchImages_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "imagesearch.proto",
+}
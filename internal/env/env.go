@@ -0,0 +1,93 @@
+// Package env reads settings from the environment, where .env and command
+// line flags put them.
+package env
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+/*
+	==============================
+	   ENV HELPERS
+	==============================
+*/
+
+// Get returns the value of key, or fallback when it is unset or empty.
+func Get(key, fallback string) string {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	return v
+}
+
+// List splits a comma-separated setting, dropping blank entries.
+func List(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Int parses key as an integer, warning and using fallback when it isn't
+// one.
+func Int(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		slog.Warn("Invalid setting, using the default", "key", key, "value", v, "default", fallback)
+		return fallback
+	}
+	return n
+}
+
+// Duration parses key as a time.Duration, warning and using fallback when
+// it isn't one.
+func Duration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil {
+		slog.Warn("Invalid setting, using the default", "key", key, "value", v, "default", fallback)
+		return fallback
+	}
+	return d
+}
+
+// overrides holds the settings given as command line flags, which a
+// config reload puts back over .env.
+var overrides = map[string]string{}
+
+// Override sets key for good, over anything .env says.
+func Override(key, v string) error {
+	overrides[key] = v
+	return os.Setenv(key, v)
+}
+
+// Reload re-reads .env, where it overrides the environment, and puts
+// the command line flags back over it.
+func Reload() error {
+	if err := godotenv.Overload(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for key, v := range overrides {
+		os.Setenv(key, v)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/joho/godotenv"
+
+	"image_crawler/crawler"
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	      MAIN
+	==============================
+*/
+
+func main() {
+	godotenv.Load()
+	cmd, args, err := parseCommandLine(os.Args[1:])
+	if err != nil {
+		os.Exit(exitCode(err))
+	}
+	if err := crawler.ConfigureLogging(os.Stderr); err != nil {
+		fatal("Invalid logging configuration", "error", err)
+	}
+	if err := crawler.ConfigureTracing(context.Background()); err != nil {
+		fatal("Invalid tracing configuration", "error", err)
+	}
+	defer crawler.ShutdownTracing()
+
+	// SIGINT/SIGTERM stop the crawl gracefully; a second signal kills it
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-sigCtx.Done()
+		stop()
+	}()
+	ctx := sigCtx
+
+	// the recrawl scheduler and the gRPC server run until the process is
+	// stopped
+	if timeout := env.Duration("IMG_CRAWL_TIMEOUT", CrawlTimeout); cmd.name == "crawl" && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if err := crawler.Configure(); err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+
+	st, err := store.Open(ctx)
+	if err != nil {
+		fatal("Opening the store failed", "error", err)
+	}
+	defer st.Close(context.Background())
+	if crawler.TracingEnabled() {
+		st = store.NewTraced(st)
+	}
+
+	// a dry run prints the writes of a crawl instead of making them
+	if env.Get("IMG_DRY_RUN", "") == "true" {
+		if !cmd.dryRun {
+			fatal("IMG_DRY_RUN only applies to the crawl, serve and warc commands", "command", cmd.name)
+		}
+		st = store.NewDryRun(st, os.Stdout)
+		slog.Info("Dry run: nothing is stored, writes are printed")
+	}
+
+	col := store.MongoCollection(st)
+	if col == nil && cmd.mongo {
+		fatal("This command needs IMG_DB_DRIVER=mongo", "command", cmd.name)
+	}
+	if col != nil && cmd.name != "migrate" {
+		store.WarnPendingMigrations(ctx, col.Database())
+	}
+
+	if cmd.crawls {
+		if err := crawler.StartAdminServer(ctx, cmd.name); err != nil {
+			fatal("Starting the admin dashboard failed", "error", err)
+		}
+		crawler.WatchReloads(ctx)
+		crawler.LogRuntimeStats(ctx)
+	}
+
+	if err := cmd.run(ctx, st, col, args); err != nil {
+		fatal("Command failed", "command", cmd.name, "error", err)
+	}
+	slog.Info("Shutdown complete")
+}
+
+// fatal logs msg as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   PARQUET EXPORT
+	==============================
+*/
+
+// columnKind is how an exported field is typed in Parquet.
+type columnKind int
+
+const (
+	columnString columnKind = iota
+	columnInt
+	columnDouble
+	columnFloat
+	columnBool
+	columnTime
+	// documents and lists of documents, as JSON text
+	columnJSON
+)
+
+type parquetColumn struct {
+	field    string // path in the record
+	name     string // column name: dots become underscores
+	kind     columnKind
+	repeated bool
+}
+
+// imageRecordFields lists the top-level fields of ImageRecord.
+func imageRecordFields() []string {
+	t := reflect.TypeOf(store.ImageRecord{})
+	var out []string
+	for i := 0; i < t.NumField(); i++ {
+		if name := bsonName(t.Field(i)); name != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func bsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("bson"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// fieldType finds the Go type ImageRecord stores a (dotted) field as.
+func fieldType(path string) (reflect.Type, bool) {
+	t := reflect.TypeOf(store.ImageRecord{})
+	for _, part := range strings.Split(path, ".") {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return nil, false
+		}
+		found := false
+		for i := 0; i < t.NumField(); i++ {
+			if bsonName(t.Field(i)) == part {
+				t, found = t.Field(i).Type, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return t, true
+}
+
+// columnFor types a field from its ImageRecord declaration: dimensions
+// and sizes as integers, scores as doubles, times as timestamps, string
+// lists as repeated strings. Fields ImageRecord doesn't declare are
+// exported as text.
+func columnFor(field string) parquetColumn {
+	col := parquetColumn{field: field, name: strings.ReplaceAll(field, ".", "_"), kind: columnString}
+	if field == "_id" {
+		return col
+	}
+	t, ok := fieldType(field)
+	if !ok {
+		return col
+	}
+	if t.Kind() == reflect.Slice {
+		if kind := scalarKind(t.Elem()); kind != columnJSON {
+			col.kind, col.repeated = kind, true
+			return col
+		}
+	}
+	col.kind = scalarKind(t)
+	return col
+}
+
+func scalarKind(t reflect.Type) columnKind {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return columnTime
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return columnString
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return columnInt
+	case reflect.Float64:
+		return columnDouble
+	case reflect.Float32:
+		return columnFloat
+	case reflect.Bool:
+		return columnBool
+	}
+	return columnJSON
+}
+
+func (col parquetColumn) node() parquet.Node {
+	var n parquet.Node
+	switch col.kind {
+	case columnInt:
+		n = parquet.Int(64)
+	case columnDouble:
+		n = parquet.Leaf(parquet.DoubleType)
+	case columnFloat:
+		n = parquet.Leaf(parquet.FloatType)
+	case columnBool:
+		n = parquet.Leaf(parquet.BooleanType)
+	case columnTime:
+		n = parquet.Timestamp(parquet.Millisecond)
+	default:
+		n = parquet.String()
+	}
+	if col.repeated {
+		return parquet.Repeated(n)
+	}
+	return parquet.Optional(n)
+}
+
+// value converts a decoded BSON value to what the column holds, nil when
+// it is missing or of another type.
+func (col parquetColumn) value(v interface{}) interface{} {
+	if col.repeated {
+		a, _ := v.(bson.A)
+		out := make([]interface{}, 0, len(a))
+		for _, e := range a {
+			if x := col.scalar(e); x != nil {
+				out = append(out, x)
+			}
+		}
+		return out
+	}
+	return col.scalar(v)
+}
+
+func (col parquetColumn) scalar(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch col.kind {
+	case columnInt:
+		switch x := v.(type) {
+		case int32:
+			return int64(x)
+		case int64:
+			return x
+		case float64:
+			return int64(x)
+		}
+	case columnDouble, columnFloat:
+		var f float64
+		switch x := v.(type) {
+		case int32:
+			f = float64(x)
+		case int64:
+			f = float64(x)
+		case float64:
+			f = x
+		default:
+			return nil
+		}
+		if col.kind == columnFloat {
+			return float32(f)
+		}
+		return f
+	case columnBool:
+		if b, ok := v.(bool); ok {
+			return b
+		}
+	case columnTime:
+		if d, ok := v.(primitive.DateTime); ok {
+			return d.Time().UTC()
+		}
+	default:
+		if s, ok := v.(string); ok {
+			return s
+		}
+		x := store.PlainValue(v)
+		if s, ok := x.(string); ok {
+			return s
+		}
+		data, err := json.Marshal(x)
+		if err != nil {
+			return nil
+		}
+		return string(data)
+	}
+	return nil
+}
+
+// exportParquet writes one row group per ExportBatchSize records.
+func exportParquet(ctx context.Context, cur *mongo.Cursor, w io.Writer, fields []string) (int, error) {
+	cols := make([]parquetColumn, len(fields))
+	group := parquet.Group{}
+	for i, f := range fields {
+		cols[i] = columnFor(f)
+		group[cols[i].name] = cols[i].node()
+	}
+	pw := parquet.NewWriter(w, parquet.NewSchema("image", group), parquet.Compression(&parquet.Snappy))
+
+	n := 0
+	for cur.Next(ctx) {
+		var doc bson.D
+		if err := cur.Decode(&doc); err != nil {
+			return n, err
+		}
+		row := make(map[string]interface{}, len(cols))
+		for _, col := range cols {
+			v, _ := lookupField(doc, col.field)
+			row[col.name] = col.value(v)
+		}
+		if err := pw.Write(row); err != nil {
+			return n, err
+		}
+		n++
+		if n%ExportBatchSize == 0 {
+			if err := pw.Flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return n, err
+	}
+	return n, pw.Close()
+}
@@ -0,0 +1,148 @@
+// Package search is a client for the HTTP search API (backend/main.py),
+// which owns ranking. The gRPC server's SearchImages goes through it.
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	==============================
+	   SEARCH API CLIENT
+	==============================
+*/
+
+const (
+	// results asked of the search API per page
+	PageSize = 50
+	Timeout  = 30 * time.Second
+)
+
+// Client queries the search API at BaseURL, e.g. http://localhost:8000.
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// NewClient returns a client for the API at baseURL.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		HTTP:    &http.Client{Timeout: Timeout},
+	}
+}
+
+// Query is one page of a search. Semantic searches go to /search/semantic
+// and ignore the structured filters, which only apply to text search.
+type Query struct {
+	Text     string
+	Limit    int // PageSize when 0
+	Cursor   string
+	Safe     string
+	Semantic bool
+
+	License     string
+	Format      string
+	Domain      string
+	Orientation string
+}
+
+// Result is one image found.
+type Result struct {
+	ID           string  `json:"id"`
+	FileURL      string  `json:"file_url"`
+	Alt          string  `json:"alt"`
+	Caption      string  `json:"caption"`
+	PageURL      string  `json:"page_url"`
+	Domain       string  `json:"domain"`
+	Format       string  `json:"format"`
+	License      string  `json:"license"`
+	LicenseURL   string  `json:"license_url"`
+	ThumbnailURL string  `json:"thumbnail_url"`
+	FaviconURL   string  `json:"favicon_url"`
+	Width        int32   `json:"width"`
+	Height       int32   `json:"height"`
+	Score        float64 `json:"score"`
+}
+
+// Page is one page of results. NextCursor, passed back as Query.Cursor,
+// fetches the next; it is empty on the last page.
+type Page struct {
+	Results    []Result `json:"results"`
+	NextCursor string   `json:"next_cursor"`
+}
+
+// APIError is a search API answer other than 200.
+type APIError struct {
+	Status int
+	Detail interface{}
+	text   string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("search API: %s: %v", e.text, e.Detail)
+}
+
+// Search fetches one page of results. Errors from the API itself are
+// *APIError; others mean it couldn't be reached or answered garbage.
+func (c *Client) Search(ctx context.Context, q Query) (*Page, error) {
+	v := url.Values{}
+	v.Set("q", q.Text)
+	if q.Limit <= 0 {
+		q.Limit = PageSize
+	}
+	v.Set("limit", strconv.Itoa(q.Limit))
+	if q.Cursor != "" {
+		v.Set("cursor", q.Cursor)
+	}
+	if q.Safe != "" {
+		v.Set("safe", q.Safe)
+	}
+
+	endpoint := "/search/semantic"
+	if !q.Semantic {
+		endpoint = "/search/images"
+		for name, val := range map[string]string{
+			"license":     q.License,
+			"format":      q.Format,
+			"domain":      q.Domain,
+			"orientation": q.Orientation,
+		} {
+			if val != "" {
+				v.Set(name, val)
+			}
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+endpoint+"?"+v.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Detail interface{} `json:"detail"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return nil, &APIError{Status: resp.StatusCode, Detail: body.Detail, text: resp.Status}
+	}
+
+	var page Page
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("search API: %w", err)
+	}
+	return &page, nil
+}
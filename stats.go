@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"image_crawler/internal/env"
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   COLLECTION STATS
+	==============================
+*/
+
+const StatsTopDomains = 20
+
+// collectionStats summarizes what is stored. Live images are the ones that
+// are not tombstones; stale and dead ones are live but left out of the
+// search index.
+type collectionStats struct {
+	Images     int64            `json:"images"`
+	Live       int64            `json:"live"`
+	Enriched   int64            `json:"enriched"`
+	Stale      int64            `json:"stale"`
+	Dead       int64            `json:"dead"`
+	Tombstones map[string]int64 `json:"tombstones"` // by delete_reason
+	Pages      int64            `json:"pages"`
+	Domains    []domainCount    `json:"top_domains"`
+}
+
+type domainCount struct {
+	Domain string `json:"domain"`
+	Images int64  `json:"images"`
+}
+
+func loadCollectionStats(ctx context.Context, col *mongo.Collection, top int) (*collectionStats, error) {
+	st := &collectionStats{Tombstones: map[string]int64{}}
+	counts := []struct {
+		n      *int64
+		filter bson.M
+	}{
+		{&st.Images, bson.M{}},
+		{&st.Live, bson.M{"deleted_at": store.Live}},
+		{&st.Enriched, bson.M{"deleted_at": store.Live, "pixel_width": bson.M{"$gt": 0}}},
+		{&st.Stale, bson.M{"deleted_at": store.Live, "stale": true}},
+		{&st.Dead, bson.M{"deleted_at": store.Live, "dead_since": bson.M{"$exists": true}}},
+	}
+	for _, c := range counts {
+		n, err := col.CountDocuments(ctx, c.filter)
+		if err != nil {
+			return nil, err
+		}
+		*c.n = n
+	}
+
+	var err error
+	st.Pages, err = col.Database().Collection(store.PageCollName).EstimatedDocumentCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reasons []struct {
+		Reason string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err := aggregate(ctx, col, &reasons, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"deleted_at": bson.M{"$exists": true}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$delete_reason", "count": bson.M{"$sum": 1}}}},
+	}); err != nil {
+		return nil, err
+	}
+	for _, r := range reasons {
+		st.Tombstones[r.Reason] = r.Count
+	}
+
+	if err := aggregate(ctx, col, &st.Domains, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"deleted_at": store.Live}}},
+		{{Key: "$group", Value: bson.M{"_id": "$domain_name", "images": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "images", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: top}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "domain": "$_id", "images": 1}}},
+	}); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func aggregate(ctx context.Context, col *mongo.Collection, out interface{}, pipeline mongo.Pipeline) error {
+	cur, err := col.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	return cur.All(ctx, out)
+}
+
+func (st *collectionStats) writeText(w io.Writer) {
+	fmt.Fprintf(w, "images      %d\n", st.Images)
+	fmt.Fprintf(w, "  live      %d\n", st.Live)
+	fmt.Fprintf(w, "  enriched  %d\n", st.Enriched)
+	fmt.Fprintf(w, "  stale     %d\n", st.Stale)
+	fmt.Fprintf(w, "  dead      %d\n", st.Dead)
+
+	reasons := make([]string, 0, len(st.Tombstones))
+	for r := range st.Tombstones {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+	for _, r := range reasons {
+		fmt.Fprintf(w, "  removed (%s)  %d\n", r, st.Tombstones[r])
+	}
+	fmt.Fprintf(w, "pages       %d\n", st.Pages)
+
+	if len(st.Domains) > 0 {
+		fmt.Fprintf(w, "\ntop domains\n")
+		for _, d := range st.Domains {
+			fmt.Fprintf(w, "  %-40s %d\n", d.Domain, d.Images)
+		}
+	}
+}
+
+// runStats prints collection stats to w, as JSON with IMG_STATS_JSON=true.
+func runStats(ctx context.Context, col *mongo.Collection, w io.Writer) error {
+	st, err := loadCollectionStats(ctx, col, max(env.Int("IMG_STATS_TOP", StatsTopDomains), 1))
+	if err != nil {
+		return err
+	}
+	if env.Get("IMG_STATS_JSON", "") == "true" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+	st.writeText(w)
+	return nil
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+	==============================
+	   EXACT DUPLICATE MERGING
+	==============================
+*/
+
+// The same file is often served from several URLs (CDN hosts, cache
+// busting query strings, copies on other sites). Once the enrichment stage
+// has the bytes, the first record stored for a SHA-256 keeps the file and
+// later URLs for it are only added to its known_urls.
+
+// ContentHash is the SHA-256 of a file, hex encoded, as stored in
+// content_hash.
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// MergeByContentHash folds link into an existing record with the same
+// hash, deleting link's own records. It reports whether such a record
+// existed. A taken down file counts, so its copies stay removed too. Two
+// workers racing on the same new file can both keep theirs; that only
+// costs a duplicate, never a lost image.
+func (s *mongoStore) MergeByContentHash(ctx context.Context, link, hash string) (bool, error) {
+	res, err := s.col.UpdateOne(ctx,
+		bson.M{
+			"content_hash":  hash,
+			"file_url":      bson.M{"$ne": link},
+			"delete_reason": bson.M{"$nin": revivableReasons},
+		},
+		bson.M{"$addToSet": bson.M{"known_urls": link}})
+	if err != nil {
+		return false, err
+	}
+	if res.MatchedCount == 0 {
+		return false, nil
+	}
+	_, err = s.col.DeleteMany(ctx, bson.M{"file_url": link})
+	return true, err
+}
+
+// aliases reports which links were merged into another record. Saving one
+// only refreshes that record, so pages listing an already merged URL do not
+// store it as a new image again. It also reports the links that must not be
+// stored at all: those with a tombstone that stays, and those merged into
+// any tombstone.
+func (s *mongoStore) aliases(ctx context.Context, links []string) (merged, removed map[string]bool, err error) {
+	cur, err := s.col.Find(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"known_urls": bson.M{"$in": links}},
+			bson.M{"file_url": bson.M{"$in": links}, "delete_reason": bson.M{"$exists": true, "$nin": revivableReasons}},
+		}},
+		options.Find().SetProjection(bson.M{"_id": 0, "file_url": 1, "known_urls": 1, "delete_reason": 1}))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cur.Close(ctx)
+
+	want := make(map[string]bool, len(links))
+	for _, l := range links {
+		want[l] = true
+	}
+	merged, removed = map[string]bool{}, map[string]bool{}
+	for cur.Next(ctx) {
+		var rec struct {
+			FileURL      string   `bson:"file_url"`
+			KnownURLs    []string `bson:"known_urls"`
+			DeleteReason string   `bson:"delete_reason"`
+		}
+		if err := cur.Decode(&rec); err != nil {
+			return nil, nil, err
+		}
+		dead := rec.DeleteReason != ""
+		if dead && want[rec.FileURL] && !revivable(rec.DeleteReason) {
+			removed[rec.FileURL] = true
+		}
+		for _, k := range rec.KnownURLs {
+			if k == rec.FileURL || !want[k] {
+				continue
+			}
+			if dead {
+				removed[k] = true
+			} else {
+				merged[k] = true
+			}
+		}
+	}
+	return merged, removed, cur.Err()
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+/*
+	==============================
+	   DRY RUN
+	==============================
+*/
+
+// dryRunStore wraps the configured store for IMG_DRY_RUN=true: reads go
+// through, so a dry run sees what is already stored, but every write is
+// printed as a JSON line instead. Records are shown as they would be
+// stored, by their bson field names. The optional features are printed
+// too when the store behind supports them; the ones that need Mongo
+// directly (favicons, near duplicate groups) are off.
+type dryRunStore struct {
+	Store
+
+	mu  sync.Mutex
+	out *json.Encoder
+}
+
+// NewDryRun wraps s so writes are printed to w instead of made.
+func NewDryRun(s Store, w io.Writer) Store {
+	return &dryRunStore{Store: s, out: json.NewEncoder(w)}
+}
+
+// dryRunWrite is one line of dry run output.
+type dryRunWrite struct {
+	Op       string                 `json:"op"`
+	Page     string                 `json:"page,omitempty"`
+	FileURL  string                 `json:"file_url,omitempty"`
+	FileURLs []string               `json:"file_urls,omitempty"`
+	Record   map[string]interface{} `json:"record,omitempty"`
+	At       *time.Time             `json:"at,omitempty"`
+	Reason   string                 `json:"reason,omitempty"`
+	Known    bool                   `json:"known,omitempty"`
+}
+
+func (s *dryRunStore) print(w dryRunWrite) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.out.Encode(w)
+}
+
+func (s *dryRunStore) SaveImages(ctx context.Context, imgs []ImageRecord) error {
+	for _, img := range imgs {
+		rec, err := bsonFields(img)
+		if err != nil {
+			return err
+		}
+		if err := s.print(dryRunWrite{Op: "save_image", FileURL: img.FileURL, Record: rec}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *dryRunStore) RemoveImages(ctx context.Context, page string, fileURLs []string) error {
+	return s.print(dryRunWrite{Op: "remove_images", Page: page, FileURLs: fileURLs})
+}
+
+func (s *dryRunStore) MarkSeen(ctx context.Context, fileURLs []string, at time.Time) error {
+	return s.print(dryRunWrite{Op: "mark_seen", FileURLs: fileURLs, At: &at})
+}
+
+func (s *dryRunStore) SavePage(ctx context.Context, page PageRecord) error {
+	rec, err := bsonFields(page)
+	if err != nil {
+		return err
+	}
+	return s.print(dryRunWrite{Op: "save_page", Page: page.PageURL, Record: rec})
+}
+
+// MergeByContentHash reports no match, so the image is shown being saved
+// under its own URL.
+func (s *dryRunStore) MergeByContentHash(ctx context.Context, link, hash string) (bool, error) {
+	return false, nil
+}
+
+func (s *dryRunStore) UpdateImage(ctx context.Context, link string, set Update, known bool) error {
+	rec, err := bsonFields(set)
+	if err != nil {
+		return err
+	}
+	return s.print(dryRunWrite{Op: "update_image", FileURL: link, Record: rec, Known: known})
+}
+
+func (s *dryRunStore) RemoveImage(ctx context.Context, link, reason string) error {
+	return s.print(dryRunWrite{Op: "remove_image", FileURL: link, Reason: reason})
+}
+
+// DuePages reads through, like the other reads.
+func (s *dryRunStore) DuePages(ctx context.Context, now time.Time, limit int) ([]PageRecord, error) {
+	sch, ok := s.Store.(Scheduler)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return sch.DuePages(ctx, now, limit)
+}
+
+func (s *dryRunStore) PostponePage(ctx context.Context, link string, until time.Time) error {
+	return s.print(dryRunWrite{Op: "postpone_page", Page: link, At: &until})
+}
+
+func (s *dryRunStore) RecordFailure(ctx context.Context, f FetchFailure) error {
+	return s.print(dryRunWrite{Op: "fetch_failure", Page: f.PageURL, At: &f.FailedAt, Reason: f.Reason})
+}
+
+func (s *dryRunStore) SaveRun(ctx context.Context, run RunRecord) error {
+	rec, err := bsonFields(run)
+	if err != nil {
+		return err
+	}
+	return s.print(dryRunWrite{Op: "save_run", Record: rec})
+}
+
+func (s *dryRunStore) unwrap() Store { return s.Store }
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+/*
+	==============================
+	   OPTIONAL FEATURES
+	==============================
+*/
+
+// ErrUnsupported is returned by the traced and dry run wrappers for a
+// feature the store behind them does not have.
+var ErrUnsupported = errors.New("not supported by this store")
+
+// Scheduler is implemented by stores that can hand pages to the recrawl
+// scheduler. DuePages returns pages whose next visit has come, oldest
+// first, pages without a next visit counting as due. PostponePage moves
+// a page's next visit to until.
+type Scheduler interface {
+	DuePages(ctx context.Context, now time.Time, limit int) ([]PageRecord, error)
+	PostponePage(ctx context.Context, link string, until time.Time) error
+}
+
+// FailureLog is implemented by stores that keep the last failure of each
+// page that could not be fetched.
+type FailureLog interface {
+	RecordFailure(ctx context.Context, f FetchFailure) error
+}
+
+// RunLog is implemented by stores that describe crawl runs, so they can
+// be listed and rolled back. SaveRun replaces the run's record.
+type RunLog interface {
+	SaveRun(ctx context.Context, run RunRecord) error
+}
+
+// wrapper is a store that passes calls on to another, such as the traced
+// and dry run stores.
+type wrapper interface {
+	unwrap() Store
+}
+
+// Feature returns s as T, one of the optional feature interfaces, when
+// the provider behind s implements it. Wrappers implement every feature
+// by passing it on, so they are looked through to the provider.
+func Feature[T any](s Store) (T, bool) {
+	var zero T
+	base := s
+	for {
+		w, ok := base.(wrapper)
+		if !ok {
+			break
+		}
+		base = w.unwrap()
+	}
+	if _, ok := base.(T); !ok {
+		return zero, false
+	}
+	t, ok := s.(T)
+	return t, ok
+}
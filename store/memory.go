@@ -0,0 +1,320 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	==============================
+	   MEMORY STORE
+	==============================
+*/
+
+// memoryStore keeps records in process memory (IMG_DB_DRIVER=memory), for
+// dry runs of a crawl configuration and for tests that shouldn't need a
+// database. Nothing survives the process.
+type memoryStore struct {
+	mu     sync.Mutex
+	images map[string]*memoryImage
+	pages  map[string]PageRecord
+	// known URL -> record it was merged into, including a record's own
+	merged map[string]string
+
+	failures map[string]FetchFailure
+	runs     map[string]RunRecord
+}
+
+type memoryImage struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+	timesSeen int
+	known     []string
+	doc       map[string]interface{}
+}
+
+// see records a sighting at at.
+func (r *memoryImage) see(at time.Time) {
+	if r.firstSeen.IsZero() || at.Before(r.firstSeen) {
+		r.firstSeen = at
+	}
+	if at.After(r.lastSeen) {
+		r.lastSeen = at
+	}
+	r.timesSeen++
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		images:   map[string]*memoryImage{},
+		pages:    map[string]PageRecord{},
+		merged:   map[string]string{},
+		failures: map[string]FetchFailure{},
+		runs:     map[string]RunRecord{},
+	}
+}
+
+// SaveImages stores the batch one image at a time.
+func (s *memoryStore) SaveImages(ctx context.Context, imgs []ImageRecord) error {
+	for _, img := range imgs {
+		if err := s.saveImage(ctx, img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) saveImage(ctx context.Context, img ImageRecord) error {
+	fields, err := recordFields(img)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if canonical, ok := s.merged[img.FileURL]; ok && canonical != img.FileURL {
+		s.images[canonical].see(img.LastSeen)
+		return nil
+	}
+	rec := s.images[img.FileURL]
+	if rec == nil {
+		rec = &memoryImage{doc: map[string]interface{}{}}
+		s.images[img.FileURL] = rec
+	}
+	rec.see(img.LastSeen)
+	for k, v := range fields {
+		rec.doc[k] = v
+	}
+	return nil
+}
+
+func (s *memoryStore) RemoveImages(ctx context.Context, page string, fileURLs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, link := range fileURLs {
+		if rec := s.images[link]; rec != nil && rec.doc["page_url"] == page {
+			s.remove(link)
+		}
+	}
+	return nil
+}
+
+// MarkSeen sees each record once, however many of its URLs are listed.
+func (s *memoryStore) MarkSeen(ctx context.Context, fileURLs []string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := map[string]bool{}
+	for _, link := range fileURLs {
+		if canonical, ok := s.merged[link]; ok {
+			link = canonical
+		}
+		if rec := s.images[link]; rec != nil && !seen[link] {
+			seen[link] = true
+			rec.see(at)
+			delete(rec.doc, "stale")
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) LoadPage(ctx context.Context, link string) (*PageRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	page, ok := s.pages[link]
+	if !ok {
+		return nil, nil
+	}
+	return &page, nil
+}
+
+func (s *memoryStore) SavePage(ctx context.Context, page PageRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages[page.PageURL] = page
+	return nil
+}
+
+func (s *memoryStore) Enriched(ctx context.Context, link, marker string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.merged[link]; ok {
+		return true, nil
+	}
+	rec := s.images[link]
+	if rec == nil {
+		return false, nil
+	}
+	_, ok := rec.doc[marker]
+	return ok, nil
+}
+
+func (s *memoryStore) MergeByContentHash(ctx context.Context, link, hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for url, rec := range s.images {
+		if url == link || rec.doc["content_hash"] != hash {
+			continue
+		}
+		s.addKnown(url, link)
+		s.remove(link)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *memoryStore) UpdateImage(ctx context.Context, link string, set Update, known bool) error {
+	fields, err := recordFields(set)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := s.images[link]
+	if rec == nil {
+		return nil
+	}
+	for k, v := range fields {
+		rec.doc[k] = v
+	}
+	if known {
+		s.addKnown(link, link)
+	}
+	return nil
+}
+
+func (s *memoryStore) RemoveImage(ctx context.Context, link, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(link)
+	return nil
+}
+
+func (s *memoryStore) Search(ctx context.Context, q Query) ([]ImageRecord, error) {
+	text := strings.ToLower(q.Text)
+	matches := func(doc map[string]interface{}) bool {
+		for field, v := range map[string]string{"domain_name": q.Domain, "format": q.Format, "license": q.License} {
+			if v != "" && doc[field] != v {
+				return false
+			}
+		}
+		if text == "" {
+			return true
+		}
+		for _, field := range []string{"alt_text", "caption_text", "page_title"} {
+			if s, _ := doc[field].(string); strings.Contains(strings.ToLower(s), text) {
+				return true
+			}
+		}
+		return false
+	}
+
+	s.mu.Lock()
+	var out []ImageRecord
+	for link, rec := range s.images {
+		if !matches(rec.doc) {
+			continue
+		}
+		img, err := decodeRecord(rec.doc)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		img.FileURL = link
+		img.KnownURLs = slices.Clone(rec.known)
+		img.FirstSeen = rec.firstSeen
+		img.LastSeen = rec.lastSeen
+		img.TimesSeen = rec.timesSeen
+		out = append(out, img)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].LastSeen.Equal(out[j].LastSeen) {
+			return out[i].LastSeen.After(out[j].LastSeen)
+		}
+		return out[i].FileURL < out[j].FileURL
+	})
+	if len(out) > q.limit() {
+		out = out[:q.limit()]
+	}
+	return out, nil
+}
+
+func (s *memoryStore) DuePages(ctx context.Context, now time.Time, limit int) ([]PageRecord, error) {
+	s.mu.Lock()
+	var due []PageRecord
+	for _, page := range s.pages {
+		if !page.NextVisit.After(now) {
+			due = append(due, page)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextVisit.Equal(due[j].NextVisit) {
+			return due[i].NextVisit.Before(due[j].NextVisit)
+		}
+		return due[i].PageURL < due[j].PageURL
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func (s *memoryStore) PostponePage(ctx context.Context, link string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if page, ok := s.pages[link]; ok {
+		page.NextVisit = until
+		s.pages[link] = page
+	}
+	return nil
+}
+
+func (s *memoryStore) RecordFailure(ctx context.Context, f FetchFailure) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[f.PageURL] = f
+	return nil
+}
+
+func (s *memoryStore) SaveRun(ctx context.Context, run RunRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs[run.ID] = run
+	return nil
+}
+
+func (s *memoryStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// addKnown lists link on the record for canonical. Callers hold mu.
+func (s *memoryStore) addKnown(canonical, link string) {
+	rec := s.images[canonical]
+	if !slices.Contains(rec.known, link) {
+		rec.known = append(rec.known, link)
+	}
+	s.merged[link] = canonical
+}
+
+// remove drops a record along with the URLs merged into it. Callers hold
+// mu.
+func (s *memoryStore) remove(link string) {
+	rec := s.images[link]
+	if rec == nil {
+		return
+	}
+	for _, k := range rec.known {
+		if s.merged[k] == link {
+			delete(s.merged, k)
+		}
+	}
+	delete(s.images, link)
+}
@@ -0,0 +1,235 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+	==============================
+	   SCHEMA MIGRATIONS
+	==============================
+*/
+
+const (
+	MigrationCollName  = "migrations"
+	MigrationBatchSize = 1000
+)
+
+// migration brings image records below its version up to it. up is nil
+// when stamping the version is all there is to do.
+type migration struct {
+	version int
+	name    string
+	up      func(ctx context.Context, db *mongo.Database) (int64, error)
+}
+
+// migrations run in order, each once. Append new ones at the end; never
+// renumber or edit one that has shipped.
+var migrations = []migration{
+	{1, "schema-version", nil},
+	{2, "orientation-from-pixels", backfillOrientation},
+	{3, "crawl-history", backfillCrawlHistory},
+}
+
+// SchemaVersion is the layout new records are written in, stored as their
+// schema_version. They get it on insert only: a re-crawl rewrites the
+// crawl fields of an old record but not what enrichment stored, so it must
+// not skip that record's backfills.
+var SchemaVersion = migrations[len(migrations)-1].version
+
+// appliedMigration is a migration's row in the migrations collection. It
+// is inserted when a run claims the migration, so two processes never
+// apply the same one, and finished when it is done.
+type appliedMigration struct {
+	Version    int       `bson:"_id"`
+	Name       string    `bson:"name"`
+	StartedAt  time.Time `bson:"started_at"`
+	FinishedAt time.Time `bson:"finished_at,omitempty"`
+	Records    int64     `bson:"records,omitempty"`
+}
+
+// Migrate applies every pending migration (IMG_MODE=migrate). A
+// migration that fails is released so the next run retries it.
+func Migrate(ctx context.Context, db *mongo.Database) error {
+	applied := db.Collection(MigrationCollName)
+	images := db.Collection(ImageCollName)
+
+	ran := 0
+	for _, m := range migrations {
+		_, err := applied.InsertOne(ctx, appliedMigration{Version: m.version, Name: m.name, StartedAt: time.Now().UTC()})
+		if mongo.IsDuplicateKeyError(err) {
+			var prev appliedMigration
+			if err := applied.FindOne(ctx, bson.M{"_id": m.version}).Decode(&prev); err != nil {
+				return err
+			}
+			if !prev.FinishedAt.IsZero() {
+				continue
+			}
+			return fmt.Errorf("migration %d (%s) was started at %s and never finished; "+
+				"if no other process is running it, delete its record from %s and retry",
+				m.version, m.name, prev.StartedAt.Format(time.RFC3339), MigrationCollName)
+		}
+		if err != nil {
+			return err
+		}
+
+		slog.Info("Applying migration", "version", m.version, "name", m.name)
+		n, err := m.apply(ctx, db, images)
+		if err != nil {
+			applied.DeleteOne(context.WithoutCancel(ctx), bson.M{"_id": m.version})
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		_, err = applied.UpdateOne(ctx, bson.M{"_id": m.version},
+			bson.M{"$set": bson.M{"finished_at": time.Now().UTC(), "records": n}})
+		if err != nil {
+			return err
+		}
+		slog.Info("Migration applied", "version", m.version, "name", m.name, "records", n)
+		ran++
+	}
+	slog.Info("Schema is up to date", "version", SchemaVersion, "applied", ran)
+	return nil
+}
+
+// apply runs up and then stamps the version on every record below it.
+func (m migration) apply(ctx context.Context, db *mongo.Database, images *mongo.Collection) (int64, error) {
+	var n int64
+	if m.up != nil {
+		var err error
+		if n, err = m.up(ctx, db); err != nil {
+			return n, err
+		}
+	}
+	res, err := images.UpdateMany(ctx, belowVersion(m.version), bson.M{"$set": bson.M{"schema_version": m.version}})
+	if err != nil {
+		return n, err
+	}
+	return max(n, res.ModifiedCount), nil
+}
+
+// belowVersion matches records older than version, unversioned ones too.
+func belowVersion(version int) bson.M {
+	return bson.M{"schema_version": bson.M{"$not": bson.M{"$gte": version}}}
+}
+
+// WarnPendingMigrations logs the migrations this database hasn't had, so
+// other modes don't run on an old layout unnoticed.
+func WarnPendingMigrations(ctx context.Context, db *mongo.Database) {
+	cur, err := db.Collection(MigrationCollName).Find(ctx, bson.M{"finished_at": bson.M{"$exists": true}})
+	if err != nil {
+		slog.Error("Checking migrations failed", "error", err)
+		return
+	}
+	var done []appliedMigration
+	if err := cur.All(ctx, &done); err != nil {
+		slog.Error("Checking migrations failed", "error", err)
+		return
+	}
+	finished := map[int]bool{}
+	for _, d := range done {
+		finished[d.Version] = true
+	}
+	pending := 0
+	for _, m := range migrations {
+		if !finished[m.version] {
+			pending++
+		}
+	}
+	if pending > 0 {
+		slog.Warn("Schema migrations are pending; run image_crawler migrate", "pending", pending)
+	}
+}
+
+/*
+	==============================
+	   MIGRATION STEPS
+	==============================
+*/
+
+// backfillOrientation adds aspect_ratio and orientation to records
+// enriched before they were stored.
+func backfillOrientation(ctx context.Context, db *mongo.Database) (int64, error) {
+	col := db.Collection(ImageCollName)
+	filter := belowVersion(2)
+	filter["pixel_width"] = bson.M{"$gt": 0}
+	filter["pixel_height"] = bson.M{"$gt": 0}
+	filter["orientation"] = bson.M{"$exists": false}
+	cur, err := col.Find(ctx, filter, options.Find().
+		SetProjection(bson.M{"pixel_width": 1, "pixel_height": 1, "exif.orientation": 1}).
+		SetBatchSize(MigrationBatchSize))
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	var n int64
+	var models []mongo.WriteModel
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		res, err := col.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if res != nil {
+			n += res.ModifiedCount
+		}
+		models = models[:0]
+		return err
+	}
+
+	for cur.Next(ctx) {
+		var rec struct {
+			ID     primitive.ObjectID `bson:"_id"`
+			Width  int                `bson:"pixel_width"`
+			Height int                `bson:"pixel_height"`
+			Exif   *struct {
+				Orientation int `bson:"orientation"`
+			} `bson:"exif"`
+		}
+		if err := cur.Decode(&rec); err != nil {
+			return n, err
+		}
+		update := bson.M{}
+		SetDimensions(update, rec.Width, rec.Height, rec.Exif != nil && rec.Exif.Orientation >= 5)
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": rec.ID}).
+			SetUpdate(bson.M{"$set": update}))
+		if len(models) >= MigrationBatchSize {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return n, err
+	}
+	return n, flush()
+}
+
+// backfillCrawlHistory turns time_fetched, the time of the last crawl that
+// saw an image, into last_seen, first_seen and times_seen. Crawls that ran
+// before the migration may already have set them, so the earliest and
+// latest times win and the old sighting is counted once.
+func backfillCrawlHistory(ctx context.Context, db *mongo.Database) (int64, error) {
+	res, err := db.Collection(ImageCollName).UpdateMany(ctx,
+		bson.M{"time_fetched": bson.M{"$exists": true}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"last_seen":  bson.M{"$max": bson.A{"$last_seen", "$time_fetched"}},
+				"first_seen": bson.M{"$min": bson.A{"$first_seen", "$time_fetched"}},
+				"times_seen": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$times_seen", 0}}, 1}},
+			}}},
+			{{Key: "$unset", Value: "time_fetched"}},
+		})
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
@@ -0,0 +1,316 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   MONGO STORE
+	==============================
+*/
+
+// mongoStore is the image_files collection, with pages kept alongside it.
+type mongoStore struct {
+	client *mongo.Client
+	col    *mongo.Collection
+}
+
+func newMongoStore(ctx context.Context) (*mongoStore, error) {
+	uri := env.Get("IMG_DB_URI", "")
+	db := env.Get("IMG_DB_NAME", "image_indexer_db")
+
+	if uri == "" {
+		return nil, fmt.Errorf("IMG_DB_URI not provided")
+	}
+
+	clientOpts := options.Client().ApplyURI(uri)
+	client, err := mongo.Connect(ctx, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+
+	if err := ensureMongoIndexes(ctx, client.Database(db)); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	return &mongoStore{client: client, col: client.Database(db).Collection(ImageCollName)}, nil
+}
+
+// mongoIndexes are the indexes the crawler's reads and upserts depend on.
+// The search API creates the ones it queries, the text index on
+// image_documents among them, when it starts.
+var mongoIndexes = []struct {
+	coll    string
+	indexes []mongo.IndexModel
+}{
+	{ImageCollName, []mongo.IndexModel{
+		// upserts and enrichment address records by URL
+		{Keys: bson.D{{Key: "file_url", Value: 1}}, Options: options.Index().SetUnique(true)},
+		// lookups done on every stored image once duplicates have been merged
+		{Keys: bson.D{{Key: "known_urls", Value: 1}}},
+		{Keys: bson.D{{Key: "content_hash", Value: 1}}},
+		// a page's images are refreshed and removed together
+		{Keys: bson.D{{Key: "page_url", Value: 1}}},
+		// newest images per domain, for exports and freshness checks
+		{Keys: bson.D{{Key: "domain_name", Value: 1}, {Key: "last_seen", Value: -1}}},
+		// the retention sweep
+		{Keys: bson.D{{Key: "last_seen", Value: 1}}},
+		// near-duplicate candidates
+		{Keys: bson.D{{Key: "dhash_bands", Value: 1}}},
+		// the dead-link checker, oldest check first
+		{Keys: bson.D{{Key: "checked_at", Value: 1}}},
+		{Keys: bson.D{{Key: "dead_since", Value: 1}}, Options: options.Index().SetSparse(true)},
+		// consumers syncing deletions
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+		// rolling back a crawl run
+		{Keys: bson.D{{Key: "first_run_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+	}},
+	{PageCollName, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "page_url", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "next_visit", Value: 1}}},
+	}},
+	{FailureCollName, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "page_url", Value: 1}}, Options: options.Index().SetUnique(true)},
+	}},
+	{DomainCollName, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "domain", Value: 1}}, Options: options.Index().SetUnique(true)},
+	}},
+	{RunCollName, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "started_at", Value: -1}}},
+	}},
+}
+
+// ensureMongoIndexes creates any missing index. An index that can't be
+// built is an error: the crawler would otherwise run on collection scans,
+// or store duplicates where it expects a unique key.
+func ensureMongoIndexes(ctx context.Context, db *mongo.Database) error {
+	for _, c := range mongoIndexes {
+		_, err := db.Collection(c.coll).Indexes().CreateMany(ctx, c.indexes)
+		if err == nil {
+			continue
+		}
+		var cmdErr mongo.CommandError
+		switch {
+		case mongo.IsDuplicateKeyError(err):
+			err = fmt.Errorf("%w (the collection holds duplicate records; remove them and restart)", err)
+		case errors.As(err, &cmdErr) && (cmdErr.Code == 85 || cmdErr.Code == 86):
+			// IndexOptionsConflict, IndexKeySpecsConflict
+			err = fmt.Errorf("%w (an index on the same keys exists with other options; drop it and restart)", err)
+		}
+		return fmt.Errorf("creating indexes on %s: %w", c.coll, err)
+	}
+	return nil
+}
+
+// SaveImages upserts a batch with one unordered BulkWrite. Within a batch
+// the last copy of a URL wins. Removed images are skipped unless their
+// tombstone is one a crawl lifts. last_seen only moves forward and
+// first_seen only back, so archives ingested late don't rewrite them.
+func (s *mongoStore) SaveImages(ctx context.Context, imgs []ImageRecord) error {
+	latest := make(map[string]int, len(imgs))
+	links := make([]string, 0, len(imgs))
+	for i, img := range imgs {
+		if _, ok := latest[img.FileURL]; !ok {
+			links = append(links, img.FileURL)
+		}
+		latest[img.FileURL] = i
+	}
+	merged, removed, err := s.aliases(ctx, links)
+	if err != nil {
+		return err
+	}
+
+	models := make([]mongo.WriteModel, 0, len(latest))
+	for i, img := range imgs {
+		if latest[img.FileURL] != i || removed[img.FileURL] {
+			continue
+		}
+		seen := img.LastSeen
+		if merged[img.FileURL] {
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"known_urls": img.FileURL, "file_url": bson.M{"$ne": img.FileURL}}).
+				SetUpdate(bson.M{
+					"$max":   bson.M{"last_seen": seen},
+					"$min":   bson.M{"first_seen": seen},
+					"$inc":   bson.M{"times_seen": 1},
+					"$unset": bson.M{"stale": ""},
+				}))
+			continue
+		}
+		img.LastSeen = time.Time{}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"file_url": img.FileURL}).
+			SetUpdate(bson.M{
+				"$set":         img,
+				"$max":         bson.M{"last_seen": seen},
+				"$min":         bson.M{"first_seen": seen},
+				"$inc":         bson.M{"times_seen": 1},
+				"$unset":       bson.M{"stale": "", "deleted_at": "", "delete_reason": ""},
+				"$setOnInsert": bson.M{"schema_version": SchemaVersion, "first_run_id": img.RunID},
+			}).
+			SetUpsert(true))
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	_, err = s.col.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// RemoveImages tombstones images that were last seen on page but are no
+// longer there.
+func (s *mongoStore) RemoveImages(ctx context.Context, page string, fileURLs []string) error {
+	if len(fileURLs) == 0 {
+		return nil
+	}
+	filter := bson.M{"page_url": page, "file_url": bson.M{"$in": fileURLs}}
+	_, err := RemoveMatching(ctx, s.col, filter, TombstoneGone)
+	return err
+}
+
+func (s *mongoStore) MarkSeen(ctx context.Context, fileURLs []string, at time.Time) error {
+	if len(fileURLs) == 0 {
+		return nil
+	}
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{"file_url": bson.M{"$in": fileURLs}},
+			bson.M{"known_urls": bson.M{"$in": fileURLs}},
+		},
+		"deleted_at": Live,
+	}
+	update := bson.M{
+		"$max":   bson.M{"last_seen": at},
+		"$inc":   bson.M{"times_seen": 1},
+		"$unset": bson.M{"stale": ""},
+	}
+	_, err := s.col.UpdateMany(ctx, filter, update)
+	return err
+}
+
+func (s *mongoStore) LoadPage(ctx context.Context, link string) (*PageRecord, error) {
+	var rec PageRecord
+	err := s.col.Database().Collection(PageCollName).FindOne(ctx, bson.M{"page_url": link}).Decode(&rec)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (s *mongoStore) SavePage(ctx context.Context, page PageRecord) error {
+	filter := bson.M{"page_url": page.PageURL}
+	update := bson.M{"$set": page}
+	opts := options.Update().SetUpsert(true)
+
+	_, err := s.col.Database().Collection(PageCollName).UpdateOne(ctx, filter, update, opts)
+	return err
+}
+
+func (s *mongoStore) Enriched(ctx context.Context, link, marker string) (bool, error) {
+	n, err := s.col.CountDocuments(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"file_url": link, marker: bson.M{"$exists": true}},
+			bson.M{"file_url": link, "deleted_at": bson.M{"$exists": true}},
+			bson.M{"known_urls": link},
+		}},
+		options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+func (s *mongoStore) UpdateImage(ctx context.Context, link string, set Update, known bool) error {
+	change := bson.M{"$set": bson.M(set)}
+	if known {
+		change["$addToSet"] = bson.M{"known_urls": link}
+	}
+	_, err := s.col.UpdateMany(ctx, bson.M{"file_url": link, "deleted_at": Live}, change)
+	return err
+}
+
+func (s *mongoStore) RemoveImage(ctx context.Context, link, reason string) error {
+	_, err := RemoveMatching(ctx, s.col, bson.M{"file_url": link}, reason)
+	return err
+}
+
+func (s *mongoStore) Search(ctx context.Context, q Query) ([]ImageRecord, error) {
+	filter := bson.M{"deleted_at": Live}
+	if q.Text != "" {
+		text := primitive.Regex{Pattern: regexp.QuoteMeta(q.Text), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"alt_text": text},
+			bson.M{"caption_text": text},
+			bson.M{"page_title": text},
+		}
+	}
+	for field, v := range map[string]string{"domain_name": q.Domain, "format": q.Format, "license": q.License} {
+		if v != "" {
+			filter[field] = v
+		}
+	}
+	opts := options.Find().SetSort(bson.M{"last_seen": -1}).SetLimit(int64(q.limit()))
+	cur, err := s.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var out []ImageRecord
+	err = cur.All(ctx, &out)
+	return out, err
+}
+
+func (s *mongoStore) DuePages(ctx context.Context, now time.Time, limit int) ([]PageRecord, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"next_visit": bson.M{"$lte": now}},
+		bson.M{"next_visit": bson.M{"$exists": false}},
+	}}
+	opts := options.Find().SetSort(bson.M{"next_visit": 1}).SetLimit(int64(limit))
+
+	cur, err := s.col.Database().Collection(PageCollName).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var pages []PageRecord
+	err = cur.All(ctx, &pages)
+	return pages, err
+}
+
+func (s *mongoStore) PostponePage(ctx context.Context, link string, until time.Time) error {
+	filter := bson.M{"page_url": link}
+	update := bson.M{"$set": bson.M{"next_visit": until}}
+	_, err := s.col.Database().Collection(PageCollName).UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (s *mongoStore) RecordFailure(ctx context.Context, f FetchFailure) error {
+	opts := options.Update().SetUpsert(true)
+	_, err := s.col.Database().Collection(FailureCollName).UpdateOne(ctx,
+		bson.M{"page_url": f.PageURL}, bson.M{"$set": f}, opts)
+	return err
+}
+
+func (s *mongoStore) SaveRun(ctx context.Context, run RunRecord) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := s.col.Database().Collection(RunCollName).ReplaceOne(ctx, bson.M{"_id": run.ID}, run, opts)
+	return err
+}
+
+func (s *mongoStore) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
@@ -0,0 +1,388 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   POSTGRES STORE
+	==============================
+*/
+
+// Images are kept as a JSONB document per file URL, the same fields Mongo
+// stores, with the columns lookups need generated from it. known_urls and
+// time_fetched, which holds last_seen, are real columns since they are
+// updated in place. Pages get
+// a plain table. Needs PostgreSQL 12 or later for the generated columns.
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS image_files (
+	file_url     text PRIMARY KEY,
+	known_urls   text[] NOT NULL DEFAULT '{}',
+	time_fetched timestamptz NOT NULL,
+	doc          jsonb NOT NULL,
+	page_url     text GENERATED ALWAYS AS (doc->>'page_url') STORED,
+	domain_name  text GENERATED ALWAYS AS (doc->>'domain_name') STORED,
+	format       text GENERATED ALWAYS AS (doc->>'format') STORED,
+	content_hash text GENERATED ALWAYS AS (doc->>'content_hash') STORED,
+	pixel_width  integer GENERATED ALWAYS AS ((doc->>'pixel_width')::integer) STORED,
+	pixel_height integer GENERATED ALWAYS AS ((doc->>'pixel_height')::integer) STORED
+);
+CREATE INDEX IF NOT EXISTS image_files_page_url ON image_files (page_url);
+CREATE INDEX IF NOT EXISTS image_files_domain_name ON image_files (domain_name);
+CREATE INDEX IF NOT EXISTS image_files_content_hash ON image_files (content_hash);
+CREATE INDEX IF NOT EXISTS image_files_known_urls ON image_files USING gin (known_urls);
+
+CREATE TABLE IF NOT EXISTS pages (
+	page_url         text PRIMARY KEY,
+	final_url        text NOT NULL,
+	redirects        text[],
+	etag             text NOT NULL,
+	last_modified    text NOT NULL,
+	image_count      integer NOT NULL,
+	image_urls       text[],
+	out_links        text[],
+	time_fetched     timestamptz NOT NULL,
+	revisit_interval bigint NOT NULL,
+	next_visit       timestamptz NOT NULL,
+	last_changed     timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS pages_next_visit ON pages (next_visit);
+`
+
+// postgresStore keeps records in the database at IMG_PG_URL. With
+// IMG_PG_VECTOR_DIMS set, embeddings go to a pgvector column of that size
+// with an HNSW cosine index instead of the document. The search API still
+// reads Mongo. It schedules recrawls, but keeps no fetch failures or crawl
+// runs, and the crawler refuses the Mongo-only features.
+type postgresStore struct {
+	pool   *pgxpool.Pool
+	vector bool
+}
+
+func newPostgresStore(ctx context.Context) (*postgresStore, error) {
+	uri := env.Get("IMG_PG_URL", "")
+	if uri == "" {
+		return nil, fmt.Errorf("IMG_PG_URL not provided")
+	}
+	pool, err := pgxpool.New(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	s := &postgresStore{pool: pool}
+	if err := s.migrate(ctx, env.Int("IMG_PG_VECTOR_DIMS", 0)); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	slog.Info("Storing records in Postgres")
+	return s, nil
+}
+
+func (s *postgresStore) migrate(ctx context.Context, dims int) error {
+	if _, err := s.pool.Exec(ctx, postgresSchema); err != nil {
+		return fmt.Errorf("postgres schema: %w", err)
+	}
+	if dims <= 0 {
+		return nil
+	}
+	_, err := s.pool.Exec(ctx, fmt.Sprintf(`
+CREATE EXTENSION IF NOT EXISTS vector;
+ALTER TABLE image_files ADD COLUMN IF NOT EXISTS embedding vector(%d);
+CREATE INDEX IF NOT EXISTS image_files_embedding ON image_files USING hnsw (embedding vector_cosine_ops);
+`, dims))
+	if err != nil {
+		return fmt.Errorf("pgvector: %w", err)
+	}
+	s.vector = true
+	return nil
+}
+
+// SaveImages stores the batch one image at a time.
+func (s *postgresStore) SaveImages(ctx context.Context, imgs []ImageRecord) error {
+	for _, img := range imgs {
+		if err := s.saveImage(ctx, img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pgSeen records a sighting of a record: time_fetched only moves forward
+// and first_seen only back, as in Mongo. $1 is the time, in both forms.
+const pgSeen = `time_fetched = greatest(time_fetched, $1::timestamptz),
+	doc = doc || jsonb_build_object(
+		'first_seen', least(doc->>'first_seen', $2::text),
+		'times_seen', coalesce((doc->>'times_seen')::integer, 0) + 1)`
+
+func (s *postgresStore) saveImage(ctx context.Context, img ImageRecord) error {
+	seen, first := img.LastSeen, img.LastSeen.UTC().Format(time.RFC3339)
+	// a URL merged into another record only refreshes that record, and
+	// isn't stored again when that record was removed
+	var dead *bool
+	err := s.pool.QueryRow(ctx,
+		`SELECT bool_or(doc ? 'deleted_at') FROM image_files WHERE known_urls @> ARRAY[$1::text] AND file_url <> $1`,
+		img.FileURL).Scan(&dead)
+	if err != nil {
+		return err
+	}
+	if dead != nil {
+		if !*dead {
+			_, err = s.pool.Exec(ctx,
+				`UPDATE image_files SET `+pgSeen+` WHERE known_urls @> ARRAY[$3::text] AND file_url <> $3 AND `+pgLive,
+				seen, first, img.FileURL)
+		}
+		return err
+	}
+
+	img.FirstSeen, img.TimesSeen = seen, 1
+	doc, err := recordJSON(img)
+	if err != nil {
+		return err
+	}
+	// like $set in Mongo: fields the crawl doesn't know (enrichment)
+	// survive a re-crawl. A tombstone stays unless the reason is one a new
+	// sighting lifts.
+	_, err = s.pool.Exec(ctx, `
+INSERT INTO image_files (file_url, time_fetched, doc) VALUES ($3, $1, $4::jsonb)
+ON CONFLICT (file_url) DO UPDATE
+SET time_fetched = greatest(image_files.time_fetched, $1::timestamptz),
+	doc = (image_files.doc - 'deleted_at' - 'delete_reason') || EXCLUDED.doc || jsonb_build_object(
+		'first_seen', least(image_files.doc->>'first_seen', $2::text),
+		'times_seen', coalesce((image_files.doc->>'times_seen')::integer, 0) + 1)
+WHERE NOT image_files.doc ? 'delete_reason' OR image_files.doc->>'delete_reason' = ANY($5)`,
+		seen, first, img.FileURL, doc, revivableReasons)
+	return err
+}
+
+func (s *postgresStore) RemoveImages(ctx context.Context, page string, fileURLs []string) error {
+	if len(fileURLs) == 0 {
+		return nil
+	}
+	return s.tombstone(ctx, TombstoneGone, `page_url = $4 AND file_url = ANY($5)`, page, fileURLs)
+}
+
+// pgLive matches records that are not tombstones.
+const pgLive = `NOT doc ? 'deleted_at'`
+
+// tombstone turns the live records matching where, whose arguments are
+// numbered from $4 on, into tombstones: the fields in tombstoneFields,
+// deleted_at and delete_reason.
+func (s *postgresStore) tombstone(ctx context.Context, reason, where string, args ...interface{}) error {
+	query := `
+UPDATE image_files SET doc = coalesce(
+	(SELECT jsonb_object_agg(key, value) FROM jsonb_each(doc) WHERE key = ANY($1)), '{}')
+	|| jsonb_build_object('deleted_at', $2::text, 'delete_reason', $3::text)`
+	if s.vector {
+		query += `, embedding = NULL`
+	}
+	at := time.Now().UTC().Format(time.RFC3339)
+	_, err := s.pool.Exec(ctx, query+` WHERE (`+where+`) AND `+pgLive,
+		append([]interface{}{tombstoneFields, at, reason}, args...)...)
+	return err
+}
+
+func (s *postgresStore) MarkSeen(ctx context.Context, fileURLs []string, at time.Time) error {
+	if len(fileURLs) == 0 {
+		return nil
+	}
+	_, err := s.pool.Exec(ctx,
+		`UPDATE image_files SET `+pgSeen+` WHERE (file_url = ANY($3) OR known_urls && $3::text[]) AND `+pgLive,
+		at, at.UTC().Format(time.RFC3339), fileURLs)
+	return err
+}
+
+// pgPageColumns are the pages columns scanPGPage reads, in order.
+const pgPageColumns = `page_url, final_url, redirects, etag, last_modified, image_count, image_urls, out_links,
+	time_fetched, revisit_interval, next_visit, last_changed`
+
+func scanPGPage(row pgx.Row) (PageRecord, error) {
+	var rec PageRecord
+	var interval int64
+	err := row.Scan(
+		&rec.PageURL, &rec.FinalURL, &rec.Redirects, &rec.ETag, &rec.LastModified, &rec.ImageCount,
+		&rec.ImageURLs, &rec.OutLinks, &rec.TimeFetched, &interval, &rec.NextVisit, &rec.LastChanged)
+	rec.RevisitInterval = time.Duration(interval)
+	return rec, err
+}
+
+func (s *postgresStore) LoadPage(ctx context.Context, link string) (*PageRecord, error) {
+	rec, err := scanPGPage(s.pool.QueryRow(ctx, `SELECT `+pgPageColumns+` FROM pages WHERE page_url = $1`, link))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (s *postgresStore) SavePage(ctx context.Context, page PageRecord) error {
+	_, err := s.pool.Exec(ctx, `
+INSERT INTO pages (page_url, final_url, redirects, etag, last_modified, image_count, image_urls, out_links,
+	time_fetched, revisit_interval, next_visit, last_changed)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+ON CONFLICT (page_url) DO UPDATE SET
+	final_url = EXCLUDED.final_url, redirects = EXCLUDED.redirects, etag = EXCLUDED.etag,
+	last_modified = EXCLUDED.last_modified, image_count = EXCLUDED.image_count,
+	image_urls = EXCLUDED.image_urls, out_links = EXCLUDED.out_links,
+	time_fetched = EXCLUDED.time_fetched, revisit_interval = EXCLUDED.revisit_interval,
+	next_visit = EXCLUDED.next_visit, last_changed = EXCLUDED.last_changed`,
+		page.PageURL, page.FinalURL, page.Redirects, page.ETag, page.LastModified, page.ImageCount,
+		page.ImageURLs, page.OutLinks, page.TimeFetched, int64(page.RevisitInterval), page.NextVisit, page.LastChanged)
+	return err
+}
+
+func (s *postgresStore) Enriched(ctx context.Context, link, marker string) (bool, error) {
+	var done bool
+	err := s.pool.QueryRow(ctx, `
+SELECT EXISTS (
+	SELECT 1 FROM image_files
+	WHERE (file_url = $1 AND (doc ? $2 OR doc ? 'deleted_at')) OR known_urls @> ARRAY[$1::text]
+)`, link, marker).Scan(&done)
+	return done, err
+}
+
+func (s *postgresStore) MergeByContentHash(ctx context.Context, link, hash string) (bool, error) {
+	// an UPDATE counts the rows it matched, as UpdateOne does in Mongo;
+	// not into a record a new sighting would bring back
+	tag, err := s.pool.Exec(ctx, `
+UPDATE image_files SET known_urls = CASE WHEN known_urls @> ARRAY[$1::text]
+	THEN known_urls ELSE array_append(known_urls, $1::text) END
+WHERE file_url = (
+	SELECT file_url FROM image_files WHERE content_hash = $2 AND file_url <> $1
+		AND NOT coalesce(doc->>'delete_reason' = ANY($3), false)
+	LIMIT 1
+)`, link, hash, revivableReasons)
+	if err != nil || tag.RowsAffected() == 0 {
+		return false, err
+	}
+	_, err = s.pool.Exec(ctx, `DELETE FROM image_files WHERE file_url = $1`, link)
+	return true, err
+}
+
+func (s *postgresStore) UpdateImage(ctx context.Context, link string, set Update, known bool) error {
+	var embedding *string
+	if v, ok := set["embedding"].([]float32); ok && s.vector {
+		lit := vectorLiteral(v)
+		embedding = &lit
+		set = maps.Clone(set)
+		delete(set, "embedding")
+	}
+	doc, err := recordJSON(set)
+	if err != nil {
+		return err
+	}
+
+	query := `
+UPDATE image_files SET doc = doc || $2::jsonb,
+	known_urls = CASE WHEN $3 AND NOT known_urls @> ARRAY[$1::text]
+		THEN array_append(known_urls, $1::text) ELSE known_urls END`
+	args := []interface{}{link, doc, known}
+	if embedding != nil {
+		query += `, embedding = $4::vector`
+		args = append(args, *embedding)
+	}
+	_, err = s.pool.Exec(ctx, query+` WHERE file_url = $1 AND `+pgLive, args...)
+	return err
+}
+
+func (s *postgresStore) RemoveImage(ctx context.Context, link, reason string) error {
+	return s.tombstone(ctx, reason, "file_url = $4", link)
+}
+
+func (s *postgresStore) Search(ctx context.Context, q Query) ([]ImageRecord, error) {
+	where := []string{pgLive}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if q.Text != "" {
+		p := arg(likePattern(q.Text))
+		where = append(where, fmt.Sprintf(
+			`(doc->>'alt_text' ILIKE %[1]s OR doc->>'caption_text' ILIKE %[1]s OR doc->>'page_title' ILIKE %[1]s)`, p))
+	}
+	if q.Domain != "" {
+		where = append(where, "domain_name = "+arg(q.Domain))
+	}
+	if q.Format != "" {
+		where = append(where, "format = "+arg(q.Format))
+	}
+	if q.License != "" {
+		where = append(where, "doc->>'license' = "+arg(q.License))
+	}
+	query := `SELECT file_url, known_urls, time_fetched, doc FROM image_files WHERE ` + strings.Join(where, " AND ")
+	query += ` ORDER BY time_fetched DESC, file_url LIMIT ` + arg(q.limit())
+
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ImageRecord
+	for rows.Next() {
+		var link string
+		var known []string
+		var seen time.Time
+		var doc map[string]interface{}
+		if err := rows.Scan(&link, &known, &seen, &doc); err != nil {
+			return nil, err
+		}
+		img, err := decodeRecord(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", link, err)
+		}
+		img.FileURL, img.KnownURLs, img.LastSeen = link, known, seen
+		out = append(out, img)
+	}
+	return out, rows.Err()
+}
+
+func (s *postgresStore) DuePages(ctx context.Context, now time.Time, limit int) ([]PageRecord, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT `+pgPageColumns+` FROM pages WHERE next_visit <= $1 ORDER BY next_visit LIMIT $2`, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var pages []PageRecord
+	for rows.Next() {
+		page, err := scanPGPage(rows)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+	return pages, rows.Err()
+}
+
+func (s *postgresStore) PostponePage(ctx context.Context, link string, until time.Time) error {
+	_, err := s.pool.Exec(ctx, `UPDATE pages SET next_visit = $2 WHERE page_url = $1`, link, until)
+	return err
+}
+
+func (s *postgresStore) Close(ctx context.Context) error {
+	s.pool.Close()
+	return nil
+}
+
+// vectorLiteral is pgvector's text form, e.g. [0.1,0.2].
+func vectorLiteral(v []float32) string {
+	parts := make([]string, len(v))
+	for i, f := range v {
+		parts[i] = strconv.FormatFloat(float64(f), 'g', -1, 32)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
@@ -0,0 +1,30 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"image_crawler/internal/env"
+)
+
+// TestPostgresRoundTrip needs a scratch database in IMG_TEST_PG_URL; it
+// empties image_files and pages first.
+func TestPostgresRoundTrip(t *testing.T) {
+	uri := env.Get("IMG_TEST_PG_URL", "")
+	if uri == "" {
+		t.Skip("IMG_TEST_PG_URL not set")
+	}
+	t.Setenv("IMG_PG_URL", uri)
+	t.Setenv("IMG_PG_VECTOR_DIMS", "")
+
+	ctx := context.Background()
+	s, err := newPostgresStore(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+	if _, err := s.pool.Exec(ctx, `TRUNCATE image_files, pages`); err != nil {
+		t.Fatal(err)
+	}
+	testStoreRoundTrip(t, s)
+}
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	ImageCollName   = "image_files"
+	PageCollName    = "pages"
+	DomainCollName  = "domains"
+	FailureCollName = "fetch_failures"
+	RunCollName     = "crawl_runs"
+)
+
+/*
+	==============================
+	  MONGODB STRUCT
+	==============================
+*/
+
+// ImageRecord is one image, keyed by its file URL, as the crawl finds it
+// and enrichment completes it.
+type ImageRecord struct {
+	FileURL        string         `bson:"file_url"`
+	AltText        string         `bson:"alt_text"`
+	AltSource      string         `bson:"alt_source,omitempty"` // attribute AltText came from
+	CaptionText    string         `bson:"caption_text"`
+	ContextText    string         `bson:"context_text,omitempty"`
+	PageTitle      string         `bson:"page_title,omitempty"`
+	NearestHeading string         `bson:"nearest_heading,omitempty"`
+	PageURL        string         `bson:"page_url"`
+	DomainName     string         `bson:"domain_name"`
+	Format         string         `bson:"format"`
+	ContentType    string         `bson:"content_type,omitempty"`
+	ContentLength  int64          `bson:"content_length,omitempty"`
+	FileSize       int64          `bson:"file_size,omitempty"` // best known size in bytes
+	Width          string         `bson:"width"`
+	Height         string         `bson:"height"`
+	SrcsetWidth    int            `bson:"srcset_width,omitempty"`
+	Variants       []ImageVariant `bson:"variants,omitempty"`
+	License        string         `bson:"license,omitempty"`
+	LicenseURL     string         `bson:"license_url,omitempty"`
+	LicensePage    string         `bson:"license_page,omitempty"`
+	Creator        string         `bson:"creator,omitempty"`
+	ContentHash    string         `bson:"content_hash,omitempty"`
+	KnownURLs      []string       `bson:"known_urls,omitempty"`
+	Source         string         `bson:"source,omitempty"`
+	// when the crawl saw the image; the store keeps the latest of these as
+	// last_seen, along with first_seen and times_seen
+	LastSeen  time.Time `bson:"last_seen,omitempty"`
+	FirstSeen time.Time `bson:"first_seen,omitempty"`
+	TimesSeen int       `bson:"times_seen,omitempty"`
+
+	// filled in by the enrichment stage, never by page crawling; Width and
+	// Height above are whatever the HTML claimed
+	PixelWidth  int       `bson:"pixel_width,omitempty"`
+	PixelHeight int       `bson:"pixel_height,omitempty"`
+	AspectRatio float64   `bson:"aspect_ratio,omitempty"`
+	Orientation string    `bson:"orientation,omitempty"`
+	Exif        *ExifData `bson:"exif,omitempty"`
+	DHash       string    `bson:"dhash,omitempty"`
+	DHashBands  []string  `bson:"dhash_bands,omitempty"`
+	DupGroup    string    `bson:"dup_group,omitempty"`
+	EnrichedAt  time.Time `bson:"enriched_at,omitempty"`
+	Corrupt     bool      `bson:"corrupt,omitempty"`
+	DecodeError string    `bson:"decode_error,omitempty"`
+	Oversized   bool      `bson:"oversized,omitempty"`
+	// set by the retention sweep, cleared when a crawl sees the image again
+	Stale bool `bson:"stale,omitempty"`
+	// kept by the dead-link checker
+	CheckedAt   time.Time `bson:"checked_at,omitempty"`
+	CheckStatus int       `bson:"check_status,omitempty"`
+	DeadSince   time.Time `bson:"dead_since,omitempty"`
+	// set on tombstones, which keep only the record's keys
+	DeletedAt    time.Time `bson:"deleted_at,omitempty"`
+	DeleteReason string    `bson:"delete_reason,omitempty"`
+
+	// blob store copies, when enabled
+	StoredURL    string `bson:"stored_url,omitempty"`
+	ThumbnailURL string `bson:"thumbnail_url,omitempty"`
+
+	// model scores, when enabled; a pointer so a safe 0 is still stored
+	NSFWScore      *float64  `bson:"nsfw_score,omitempty"`
+	Embedding      []float32 `bson:"embedding,omitempty"`
+	EmbeddingModel string    `bson:"embedding_model,omitempty"`
+	FacesCount     *int      `bson:"faces_count,omitempty"`
+
+	DominantColors []string `bson:"dominant_colors,omitempty"`
+	ColorBuckets   []string `bson:"color_buckets,omitempty"`
+
+	// the crawl run that wrote the record last; Mongo also keeps the one
+	// that added it as first_run_id
+	RunID string `bson:"run_id,omitempty"`
+}
+
+// PageRecord is the fetch metadata kept per crawled page, so re-crawls can
+// send conditional requests and still follow links on unchanged pages.
+type PageRecord struct {
+	PageURL      string    `bson:"page_url"`
+	FinalURL     string    `bson:"final_url"`
+	Redirects    []string  `bson:"redirects,omitempty"`
+	ETag         string    `bson:"etag"`
+	LastModified string    `bson:"last_modified"`
+	ImageCount   int       `bson:"image_count"`
+	ImageURLs    []string  `bson:"image_urls"`
+	OutLinks     []string  `bson:"out_links"`
+	TimeFetched  time.Time `bson:"time_fetched"`
+
+	// freshness tracking for the recrawl scheduler
+	RevisitInterval time.Duration `bson:"revisit_interval"`
+	NextVisit       time.Time     `bson:"next_visit"`
+	LastChanged     time.Time     `bson:"last_changed"`
+
+	// the crawl run that fetched it last; only Mongo stores it
+	RunID string `bson:"run_id,omitempty"`
+}
+
+// FetchFailure is the last failure of a page that could not be fetched.
+type FetchFailure struct {
+	PageURL  string    `bson:"page_url"`
+	Reason   string    `bson:"reason"`
+	Attempts int       `bson:"attempts"`
+	FailedAt time.Time `bson:"failed_at"`
+}
+
+// RunRecord describes one crawl run, from its start until it ends.
+type RunRecord struct {
+	ID         string            `bson:"_id"`
+	Kind       string            `bson:"kind"` // crawl, serve, recrawl or warc
+	Host       string            `bson:"host,omitempty"`
+	State      string            `bson:"state"`
+	Seeds      []string          `bson:"seeds,omitempty"`
+	Config     map[string]string `bson:"config"`
+	StartedAt  time.Time         `bson:"started_at"`
+	FinishedAt time.Time         `bson:"finished_at,omitempty"`
+	Pages      int64             `bson:"pages"`
+	Images     int64             `bson:"images"`
+	Failures   int64             `bson:"failures"`
+	Error      string            `bson:"error,omitempty"`
+	RolledBack time.Time         `bson:"rolled_back_at,omitempty"`
+}
+
+// ImageVariant is one rendition of a logical image: the plain src, a
+// srcset entry, or a <source> inside <picture>.
+type ImageVariant struct {
+	FileURL string  `bson:"file_url"`
+	Format  string  `bson:"format"`
+	Media   string  `bson:"media,omitempty"`
+	Width   int     `bson:"width,omitempty"`
+	Density float64 `bson:"density,omitempty"`
+}
+
+// ExifData is the subset of EXIF worth searching on.
+type ExifData struct {
+	Make        string    `bson:"make,omitempty"`
+	Model       string    `bson:"model,omitempty"`
+	Orientation int       `bson:"orientation,omitempty"`
+	TakenAt     time.Time `bson:"taken_at,omitempty"`
+	GPS         *GeoPoint `bson:"gps,omitempty"`
+}
+
+// GeoPoint is a GeoJSON point, so the field can carry a 2dsphere index.
+type GeoPoint struct {
+	Type        string    `bson:"type"`
+	Coordinates []float64 `bson:"coordinates"` // longitude, latitude
+}
+
+// Aspect ratios are width/height as displayed: PanoramaRatio:1 and wider
+// counts as a panorama, and within SquareTolerance of 1 as square.
+const (
+	PanoramaRatio   = 2.0
+	SquareTolerance = 0.05
+)
+
+// imageOrientation buckets an aspect ratio as landscape, portrait, square
+// or panorama.
+func imageOrientation(ratio float64) string {
+	switch {
+	case ratio >= PanoramaRatio:
+		return "panorama"
+	case math.Abs(ratio-1) <= SquareTolerance:
+		return "square"
+	case ratio > 1:
+		return "landscape"
+	}
+	return "portrait"
+}
+
+// SetDimensions records the pixel size plus the aspect ratio and
+// orientation it is shown at. rotated is set when EXIF says the image is
+// displayed turned by 90 degrees, which swaps the shown sides.
+func SetDimensions(update map[string]interface{}, w, h int, rotated bool) {
+	update["pixel_width"], update["pixel_height"] = w, h
+	if rotated {
+		w, h = h, w
+	}
+	ratio := math.Round(float64(w)/float64(h)*1000) / 1000
+	update["aspect_ratio"] = ratio
+	update["orientation"] = imageOrientation(ratio)
+}
@@ -0,0 +1,464 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   SQLITE STORE
+	==============================
+*/
+
+const SQLitePath = "image_crawler.db"
+
+// Images are a JSON document per file URL, the fields Mongo stores, with
+// the columns lookups need generated from it. URLs merged into a record
+// get a row in known_urls instead of an array. Times are RFC 3339 text,
+// with fixed-width fractions in the columns; time_fetched holds last_seen.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS image_files (
+	file_url     TEXT PRIMARY KEY,
+	time_fetched TEXT NOT NULL,
+	doc          TEXT NOT NULL,
+	page_url     TEXT GENERATED ALWAYS AS (json_extract(doc, '$.page_url')) VIRTUAL,
+	domain_name  TEXT GENERATED ALWAYS AS (json_extract(doc, '$.domain_name')) VIRTUAL,
+	content_hash TEXT GENERATED ALWAYS AS (json_extract(doc, '$.content_hash')) VIRTUAL
+);
+CREATE INDEX IF NOT EXISTS image_files_page_url ON image_files (page_url);
+CREATE INDEX IF NOT EXISTS image_files_domain_name ON image_files (domain_name);
+CREATE INDEX IF NOT EXISTS image_files_content_hash ON image_files (content_hash);
+
+CREATE TABLE IF NOT EXISTS known_urls (
+	url      TEXT NOT NULL,
+	file_url TEXT NOT NULL REFERENCES image_files (file_url) ON DELETE CASCADE,
+	PRIMARY KEY (url, file_url)
+);
+CREATE INDEX IF NOT EXISTS known_urls_file_url ON known_urls (file_url);
+
+CREATE TABLE IF NOT EXISTS pages (
+	page_url         TEXT PRIMARY KEY,
+	final_url        TEXT NOT NULL,
+	redirects        TEXT NOT NULL,
+	etag             TEXT NOT NULL,
+	last_modified    TEXT NOT NULL,
+	image_count      INTEGER NOT NULL,
+	image_urls       TEXT NOT NULL,
+	out_links        TEXT NOT NULL,
+	time_fetched     TEXT NOT NULL,
+	revisit_interval INTEGER NOT NULL,
+	next_visit       TEXT NOT NULL,
+	last_changed     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS pages_next_visit ON pages (next_visit);
+`
+
+// sqliteStore keeps records in one local file, IMG_SQLITE_PATH, for small
+// crawls that shouldn't need a database server. Writes go through a single
+// connection, so workers queue instead of failing on a busy database. As
+// with Postgres, the search API still reads Mongo, and only recrawl
+// scheduling of the optional features is supported.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func newSQLiteStore(ctx context.Context) (*sqliteStore, error) {
+	path := env.Get("IMG_SQLITE_PATH", SQLitePath)
+	pragmas := url.Values{"_pragma": {"foreign_keys(1)", "journal_mode(WAL)", "busy_timeout(5000)"}}
+	db, err := sql.Open("sqlite", "file:"+path+"?"+pragmas.Encode())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite schema: %w", err)
+	}
+	slog.Info("Storing records in SQLite", "path", path)
+	return &sqliteStore{db: db}, nil
+}
+
+// SaveImages stores the batch one image at a time.
+func (s *sqliteStore) SaveImages(ctx context.Context, imgs []ImageRecord) error {
+	for _, img := range imgs {
+		if err := s.saveImage(ctx, img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) saveImage(ctx context.Context, img ImageRecord) error {
+	// a URL merged into another record only refreshes that record, and
+	// isn't stored again when that record was removed
+	var merged, dead sql.NullBool
+	err := s.db.QueryRowContext(ctx, `
+SELECT count(*) > 0, max(json_type(doc, '$.deleted_at') IS NOT NULL) FROM image_files
+WHERE file_url IN (SELECT file_url FROM known_urls WHERE url = ?1 AND file_url <> ?1)`,
+		img.FileURL).Scan(&merged, &dead)
+	if err != nil {
+		return err
+	}
+	if merged.Bool {
+		if !dead.Bool {
+			_, err = s.see(ctx, img.LastSeen,
+				`file_url IN (SELECT file_url FROM known_urls WHERE url = ?3 AND file_url <> ?3)`, img.FileURL)
+		}
+		return err
+	}
+
+	// a tombstone stays unless the reason is one a new sighting lifts
+	var reason sql.NullString
+	err = s.db.QueryRowContext(ctx,
+		`SELECT json_extract(doc, '$.delete_reason') FROM image_files WHERE file_url = ?`,
+		img.FileURL).Scan(&reason)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case reason.Valid && !revivable(reason.String):
+		return nil
+	case reason.Valid:
+		if _, err := s.db.ExecContext(ctx,
+			`UPDATE image_files SET doc = json_remove(doc, '$.deleted_at', '$.delete_reason') WHERE file_url = ?`,
+			img.FileURL); err != nil {
+			return err
+		}
+	}
+
+	img.FirstSeen, img.TimesSeen = img.LastSeen, 1
+	fields, err := recordFields(img)
+	if err != nil {
+		return err
+	}
+	doc, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO image_files (file_url, time_fetched, doc) VALUES (?, ?, ?) ON CONFLICT (file_url) DO NOTHING`,
+		img.FileURL, sqliteTime(img.LastSeen), string(doc))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	// like $set in Mongo: fields the crawl doesn't know (enrichment)
+	// survive a re-crawl
+	delete(fields, "first_seen")
+	delete(fields, "times_seen")
+	if err := s.setFields(ctx, img.FileURL, fields); err != nil {
+		return err
+	}
+	_, err = s.see(ctx, img.LastSeen, "file_url = ?3", img.FileURL)
+	return err
+}
+
+// see records a sighting at at of the live records matching where, whose
+// arguments are numbered from ?3 on. As in Mongo, time_fetched only moves
+// forward and first_seen only back.
+func (s *sqliteStore) see(ctx context.Context, at time.Time, where string, args ...interface{}) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+UPDATE image_files SET time_fetched = max(time_fetched, ?1),
+	doc = json_set(doc,
+		'$.first_seen', coalesce(min(json_extract(doc, '$.first_seen'), ?2), ?2),
+		'$.times_seen', coalesce(json_extract(doc, '$.times_seen'), 0) + 1)
+WHERE (`+where+`) AND `+sqliteLive, append([]interface{}{sqliteTime(at), at.UTC().Format(time.RFC3339)}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// setFields replaces the given top-level fields of link's document, unless
+// it is a tombstone.
+func (s *sqliteStore) setFields(ctx context.Context, link string, fields map[string]interface{}) error {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var expr strings.Builder
+	var args []interface{}
+	expr.WriteString("json_set(doc")
+	for _, k := range keys {
+		v, err := json.Marshal(fields[k])
+		if err != nil {
+			return fmt.Errorf("field %s: %w", k, err)
+		}
+		expr.WriteString(", ?, json(?)")
+		args = append(args, `$."`+k+`"`, string(v))
+	}
+	expr.WriteString(")")
+
+	args = append(args, link)
+	_, err := s.db.ExecContext(ctx, "UPDATE image_files SET doc = "+expr.String()+" WHERE file_url = ? AND "+sqliteLive, args...)
+	return err
+}
+
+func (s *sqliteStore) RemoveImages(ctx context.Context, page string, fileURLs []string) error {
+	if len(fileURLs) == 0 {
+		return nil
+	}
+	list, _ := json.Marshal(fileURLs)
+	return s.tombstone(ctx, TombstoneGone,
+		`page_url = ?4 AND file_url IN (SELECT value FROM json_each(?5))`, page, string(list))
+}
+
+// sqliteLive matches records that are not tombstones.
+const sqliteLive = `json_type(doc, '$.deleted_at') IS NULL`
+
+// tombstone turns the live records matching where, whose arguments are
+// numbered from ?4 on, into tombstones: the fields in tombstoneFields,
+// deleted_at and delete_reason.
+func (s *sqliteStore) tombstone(ctx context.Context, reason, where string, args ...interface{}) error {
+	keep, _ := json.Marshal(tombstoneFields)
+	at := time.Now().UTC().Format(time.RFC3339)
+	_, err := s.db.ExecContext(ctx, `
+UPDATE image_files SET doc = json_set(
+	(SELECT json_group_object(key, value) FROM json_each(image_files.doc)
+		WHERE key IN (SELECT value FROM json_each(?1))),
+	'$.deleted_at', ?2, '$.delete_reason', ?3)
+WHERE (`+where+`) AND `+sqliteLive,
+		append([]interface{}{string(keep), at, reason}, args...)...)
+	return err
+}
+
+func (s *sqliteStore) MarkSeen(ctx context.Context, fileURLs []string, at time.Time) error {
+	if len(fileURLs) == 0 {
+		return nil
+	}
+	list, _ := json.Marshal(fileURLs)
+	_, err := s.see(ctx, at, `file_url IN (SELECT value FROM json_each(?3))
+	OR file_url IN (SELECT file_url FROM known_urls WHERE url IN (SELECT value FROM json_each(?3)))`,
+		string(list))
+	return err
+}
+
+// sqlitePageColumns are the pages columns scanSQLitePage reads, in order.
+const sqlitePageColumns = `page_url, final_url, redirects, etag, last_modified, image_count, image_urls, out_links,
+	time_fetched, revisit_interval, next_visit, last_changed`
+
+func scanSQLitePage(row interface{ Scan(dest ...any) error }) (*PageRecord, error) {
+	var rec PageRecord
+	var redirects, images, links, fetched, next, changed string
+	var interval int64
+	err := row.Scan(
+		&rec.PageURL, &rec.FinalURL, &redirects, &rec.ETag, &rec.LastModified, &rec.ImageCount,
+		&images, &links, &fetched, &interval, &next, &changed)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range []struct {
+		src string
+		dst *[]string
+	}{{redirects, &rec.Redirects}, {images, &rec.ImageURLs}, {links, &rec.OutLinks}} {
+		if err := json.Unmarshal([]byte(l.src), l.dst); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range []struct {
+		src string
+		dst *time.Time
+	}{{fetched, &rec.TimeFetched}, {next, &rec.NextVisit}, {changed, &rec.LastChanged}} {
+		if *t.dst, err = parseSQLiteTime(t.src); err != nil {
+			return nil, err
+		}
+	}
+	rec.RevisitInterval = time.Duration(interval)
+	return &rec, nil
+}
+
+func (s *sqliteStore) LoadPage(ctx context.Context, link string) (*PageRecord, error) {
+	rec, err := scanSQLitePage(s.db.QueryRowContext(ctx,
+		`SELECT `+sqlitePageColumns+` FROM pages WHERE page_url = ?`, link))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return rec, err
+}
+
+func (s *sqliteStore) SavePage(ctx context.Context, page PageRecord) error {
+	redirects, _ := json.Marshal(page.Redirects)
+	images, _ := json.Marshal(page.ImageURLs)
+	links, _ := json.Marshal(page.OutLinks)
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO pages (page_url, final_url, redirects, etag, last_modified, image_count, image_urls, out_links,
+	time_fetched, revisit_interval, next_visit, last_changed)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (page_url) DO UPDATE SET
+	final_url = excluded.final_url, redirects = excluded.redirects, etag = excluded.etag,
+	last_modified = excluded.last_modified, image_count = excluded.image_count,
+	image_urls = excluded.image_urls, out_links = excluded.out_links,
+	time_fetched = excluded.time_fetched, revisit_interval = excluded.revisit_interval,
+	next_visit = excluded.next_visit, last_changed = excluded.last_changed`,
+		page.PageURL, page.FinalURL, string(redirects), page.ETag, page.LastModified, page.ImageCount,
+		string(images), string(links), sqliteTime(page.TimeFetched), int64(page.RevisitInterval),
+		sqliteTime(page.NextVisit), sqliteTime(page.LastChanged))
+	return err
+}
+
+func (s *sqliteStore) Enriched(ctx context.Context, link, marker string) (bool, error) {
+	var done bool
+	err := s.db.QueryRowContext(ctx, `
+SELECT EXISTS (SELECT 1 FROM image_files WHERE file_url = ?1
+		AND (json_type(doc, ?2) IS NOT NULL OR json_type(doc, '$.deleted_at') IS NOT NULL))
+	OR EXISTS (SELECT 1 FROM known_urls WHERE url = ?1)`,
+		link, `$."`+marker+`"`).Scan(&done)
+	return done, err
+}
+
+func (s *sqliteStore) MergeByContentHash(ctx context.Context, link, hash string) (bool, error) {
+	// not into a record a new sighting would bring back
+	var canonical string
+	reasons, _ := json.Marshal(revivableReasons)
+	err := s.db.QueryRowContext(ctx, `
+SELECT file_url FROM image_files WHERE content_hash = ?1 AND file_url <> ?2
+	AND coalesce(json_extract(doc, '$.delete_reason') IN (SELECT value FROM json_each(?3)), 0) = 0
+LIMIT 1`, hash, link, string(reasons)).Scan(&canonical)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO known_urls (url, file_url) VALUES (?, ?)`, link, canonical); err != nil {
+		return false, err
+	}
+	_, err = s.db.ExecContext(ctx, `DELETE FROM image_files WHERE file_url = ?`, link)
+	return true, err
+}
+
+func (s *sqliteStore) UpdateImage(ctx context.Context, link string, set Update, known bool) error {
+	fields, err := recordFields(set)
+	if err != nil {
+		return err
+	}
+	if err := s.setFields(ctx, link, fields); err != nil {
+		return err
+	}
+	if !known {
+		return nil
+	}
+	// only when the record exists, as $addToSet on no match adds nothing
+	_, err = s.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO known_urls (url, file_url)
+	SELECT ?1, file_url FROM image_files WHERE file_url = ?1 AND `+sqliteLive,
+		link)
+	return err
+}
+
+func (s *sqliteStore) RemoveImage(ctx context.Context, link, reason string) error {
+	return s.tombstone(ctx, reason, "file_url = ?4", link)
+}
+
+func (s *sqliteStore) Search(ctx context.Context, q Query) ([]ImageRecord, error) {
+	where := []string{sqliteLive}
+	var args []interface{}
+	if q.Text != "" {
+		where = append(where, `(json_extract(doc, '$.alt_text') LIKE ?1 ESCAPE '\'
+	OR json_extract(doc, '$.caption_text') LIKE ?1 ESCAPE '\'
+	OR json_extract(doc, '$.page_title') LIKE ?1 ESCAPE '\')`)
+	}
+	// LIKE ignores ASCII case in SQLite
+	args = append(args, likePattern(q.Text))
+	for field, v := range map[string]string{"domain_name": q.Domain, "format": q.Format, "license": q.License} {
+		if v != "" {
+			args = append(args, v)
+			where = append(where, fmt.Sprintf("json_extract(doc, '$.%s') = ?%d", field, len(args)))
+		}
+	}
+	query := `
+SELECT file_url, time_fetched, doc,
+	(SELECT json_group_array(url) FROM known_urls k WHERE k.file_url = image_files.file_url)
+FROM image_files WHERE ` + strings.Join(where, " AND ")
+	args = append(args, q.limit())
+	query += fmt.Sprintf(` ORDER BY time_fetched DESC, file_url LIMIT ?%d`, len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ImageRecord
+	for rows.Next() {
+		var link, seen, doc, known string
+		if err := rows.Scan(&link, &seen, &doc, &known); err != nil {
+			return nil, err
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(doc), &fields); err != nil {
+			return nil, fmt.Errorf("%s: %w", link, err)
+		}
+		img, err := decodeRecord(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", link, err)
+		}
+		img.FileURL = link
+		if err := json.Unmarshal([]byte(known), &img.KnownURLs); err != nil {
+			return nil, err
+		}
+		if img.LastSeen, err = parseSQLiteTime(seen); err != nil {
+			return nil, err
+		}
+		out = append(out, img)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) DuePages(ctx context.Context, now time.Time, limit int) ([]PageRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+sqlitePageColumns+` FROM pages WHERE next_visit <= ? ORDER BY next_visit LIMIT ?`,
+		sqliteTime(now), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var pages []PageRecord
+	for rows.Next() {
+		page, err := scanSQLitePage(rows)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, *page)
+	}
+	return pages, rows.Err()
+}
+
+func (s *sqliteStore) PostponePage(ctx context.Context, link string, until time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE pages SET next_visit = ? WHERE page_url = ?`, sqliteTime(until), link)
+	return err
+}
+
+func (s *sqliteStore) Close(ctx context.Context) error {
+	return s.db.Close()
+}
+
+// sqliteTimeFormat is RFC 3339 with every fractional digit kept, so times
+// stored as text sort in time order.
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+func parseSQLiteTime(s string) (time.Time, error) {
+	return time.Parse(sqliteTimeFormat, s)
+}
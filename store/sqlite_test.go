@@ -0,0 +1,400 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testSQLiteStore opens a store on a private in-memory database; the
+// store's single connection keeps it alive until Close.
+func testSQLiteStore(t *testing.T) *sqliteStore {
+	t.Helper()
+	t.Setenv("IMG_SQLITE_PATH", ":memory:")
+	s, err := newSQLiteStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close(context.Background()) })
+	return s
+}
+
+func TestSQLiteTimeOrder(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	// RFC 3339 with trimmed fractions sorts "…:05Z" after "…:05.5Z"
+	offsets := []time.Duration{
+		1500 * time.Millisecond,
+		time.Second,
+		0,
+		250 * time.Millisecond,
+		time.Second + time.Nanosecond,
+		100 * time.Millisecond,
+		2 * time.Second,
+	}
+	ordered := []time.Duration{
+		0,
+		100 * time.Millisecond,
+		250 * time.Millisecond,
+		time.Second,
+		time.Second + time.Nanosecond,
+		1500 * time.Millisecond,
+		2 * time.Second,
+	}
+
+	ctx := context.Background()
+	s := testSQLiteStore(t)
+	for _, off := range offsets {
+		page := PageRecord{PageURL: fmt.Sprintf("https://example.test/%d", off), NextVisit: base.Add(off)}
+		if err := s.SavePage(ctx, page); err != nil {
+			t.Fatal(err)
+		}
+		img := ImageRecord{FileURL: fmt.Sprintf("https://example.test/%d.jpg", off), LastSeen: base.Add(off)}
+		if err := s.SaveImages(ctx, []ImageRecord{img}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("due pages", func(t *testing.T) {
+		pages, err := s.DuePages(ctx, base.Add(time.Second+time.Nanosecond), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := ordered[:5]
+		if len(pages) != len(want) {
+			t.Fatalf("got %d due pages, want %d", len(pages), len(want))
+		}
+		for i, p := range pages {
+			if got := p.NextVisit.Sub(base); got != want[i] {
+				t.Errorf("due page %d visits at +%v, want +%v", i, got, want[i])
+			}
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		imgs, err := s.Search(ctx, Query{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(imgs) != len(ordered) {
+			t.Fatalf("got %d images, want %d", len(imgs), len(ordered))
+		}
+		for i, img := range imgs {
+			if got, want := img.LastSeen.Sub(base), ordered[len(ordered)-1-i]; got != want {
+				t.Errorf("image %d last seen at +%v, want +%v", i, got, want)
+			}
+		}
+	})
+
+	t.Run("last seen only moves forward", func(t *testing.T) {
+		link := fmt.Sprintf("https://example.test/%d.jpg", time.Second)
+		if err := s.MarkSeen(ctx, []string{link}, base.Add(999*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.MarkSeen(ctx, []string{link}, base.Add(time.Second+10*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		imgs, err := s.Search(ctx, Query{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, img := range imgs {
+			if img.FileURL == link {
+				if got, want := img.LastSeen.Sub(base), time.Second+10*time.Millisecond; got != want {
+					t.Errorf("last seen at +%v, want +%v", got, want)
+				}
+				return
+			}
+		}
+		t.Errorf("%s not found", link)
+	})
+}
+
+func TestSQLiteRoundTrip(t *testing.T) {
+	testStoreRoundTrip(t, testSQLiteStore(t))
+}
+
+// testStoreRoundTrip runs a store through a crawl's writes: saving and
+// re-seeing images, enrichment, merging by content hash, removal,
+// tombstones and recrawl scheduling. st must start empty.
+func testStoreRoundTrip(t *testing.T, st Store) {
+	ctx := context.Background()
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(n int) time.Time { return t0.Add(time.Duration(n) * time.Hour) }
+	const page = "https://example.test/gallery"
+	cat := ImageRecord{FileURL: "https://example.test/cat.jpg", PageURL: page, DomainName: "example.test",
+		AltText: "A red cat", Format: "jpg", LastSeen: at(1)}
+	dog := ImageRecord{FileURL: "https://example.test/dog.jpg", PageURL: page, DomainName: "example.test",
+		AltText: "A dog", Format: "jpg", LastSeen: at(1)}
+	copyURL := "https://cdn.example.test/cat-copy.jpg"
+
+	find := func(t *testing.T, link string) *ImageRecord {
+		t.Helper()
+		imgs, err := st.Search(ctx, Query{Limit: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, img := range imgs {
+			if img.FileURL == link {
+				return &img
+			}
+		}
+		return nil
+	}
+
+	t.Run("save", func(t *testing.T) {
+		if err := st.SaveImages(ctx, []ImageRecord{cat, dog}); err != nil {
+			t.Fatal(err)
+		}
+		got := find(t, cat.FileURL)
+		if got == nil {
+			t.Fatal("saved image not found")
+		}
+		if got.AltText != cat.AltText || got.PageURL != page || got.Format != "jpg" {
+			t.Errorf("stored %+v", got)
+		}
+		if !got.LastSeen.Equal(at(1)) || !got.FirstSeen.Equal(at(1)) || got.TimesSeen != 1 {
+			t.Errorf("seen %v to %v, %d times; want %v once", got.FirstSeen, got.LastSeen, got.TimesSeen, at(1))
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		imgs, err := st.Search(ctx, Query{Text: "RED CAT", Domain: "example.test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(imgs) != 1 || imgs[0].FileURL != cat.FileURL {
+			t.Errorf("search found %v, want only %s", imgs, cat.FileURL)
+		}
+	})
+
+	t.Run("seen again", func(t *testing.T) {
+		again := cat
+		again.AltText, again.LastSeen = "A red cat asleep", at(3)
+		// an archive ingested late
+		early := again
+		early.LastSeen = at(0)
+		if err := st.SaveImages(ctx, []ImageRecord{again, early}); err != nil {
+			t.Fatal(err)
+		}
+		got := find(t, cat.FileURL)
+		if got == nil {
+			t.Fatal("image lost")
+		}
+		if !got.FirstSeen.Equal(at(0)) || !got.LastSeen.Equal(at(3)) || got.TimesSeen != 3 {
+			t.Errorf("seen %v to %v, %d times; want %v to %v, 3 times", got.FirstSeen, got.LastSeen, got.TimesSeen, at(0), at(3))
+		}
+		if got.AltText != "A red cat asleep" {
+			t.Errorf("alt text %q was not refreshed", got.AltText)
+		}
+	})
+
+	t.Run("enrich", func(t *testing.T) {
+		if done, err := st.Enriched(ctx, cat.FileURL, "pixel_width"); err != nil || done {
+			t.Fatalf("Enriched before enrichment = %v, %v", done, err)
+		}
+		if err := st.UpdateImage(ctx, cat.FileURL, Update{"pixel_width": 640, "content_hash": "h1"}, true); err != nil {
+			t.Fatal(err)
+		}
+		if done, err := st.Enriched(ctx, cat.FileURL, "pixel_width"); err != nil || !done {
+			t.Errorf("Enriched after enrichment = %v, %v", done, err)
+		}
+		// a re-crawl keeps what enrichment added
+		again := cat
+		again.LastSeen = at(4)
+		if err := st.SaveImages(ctx, []ImageRecord{again}); err != nil {
+			t.Fatal(err)
+		}
+		if got := find(t, cat.FileURL); got == nil || got.PixelWidth != 640 || got.ContentHash != "h1" {
+			t.Errorf("enrichment lost: %+v", got)
+		}
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		dup := cat
+		dup.FileURL, dup.LastSeen = copyURL, at(4)
+		if err := st.SaveImages(ctx, []ImageRecord{dup}); err != nil {
+			t.Fatal(err)
+		}
+		merged, err := st.MergeByContentHash(ctx, copyURL, "h1")
+		if err != nil || !merged {
+			t.Fatalf("MergeByContentHash = %v, %v", merged, err)
+		}
+		if find(t, copyURL) != nil {
+			t.Error("merged copy is still stored")
+		}
+		if done, err := st.Enriched(ctx, copyURL, "pixel_width"); err != nil || !done {
+			t.Errorf("Enriched on the merged copy = %v, %v", done, err)
+		}
+		if merged, err := st.MergeByContentHash(ctx, dog.FileURL, "h2"); err != nil || merged {
+			t.Errorf("MergeByContentHash with no twin = %v, %v", merged, err)
+		}
+
+		// the copy only refreshes the record it was merged into
+		dup.LastSeen = at(5)
+		if err := st.SaveImages(ctx, []ImageRecord{dup}); err != nil {
+			t.Fatal(err)
+		}
+		if find(t, copyURL) != nil {
+			t.Error("merged copy was stored again")
+		}
+		got := find(t, cat.FileURL)
+		if got == nil || !got.LastSeen.Equal(at(5)) {
+			t.Fatalf("sighting of the copy did not reach the original: %+v", got)
+		}
+		if !slices.Contains(got.KnownURLs, copyURL) {
+			t.Errorf("known URLs %v miss %s", got.KnownURLs, copyURL)
+		}
+
+		if err := st.MarkSeen(ctx, []string{copyURL}, at(6)); err != nil {
+			t.Fatal(err)
+		}
+		if got := find(t, cat.FileURL); got == nil || !got.LastSeen.Equal(at(6)) {
+			t.Errorf("MarkSeen of the copy did not reach the original: %+v", got)
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		// only from the page the image was on
+		if err := st.RemoveImages(ctx, "https://example.test/other", []string{dog.FileURL}); err != nil {
+			t.Fatal(err)
+		}
+		if find(t, dog.FileURL) == nil {
+			t.Fatal("image removed from a page it was not on")
+		}
+		if err := st.RemoveImages(ctx, page, []string{dog.FileURL}); err != nil {
+			t.Fatal(err)
+		}
+		if find(t, dog.FileURL) != nil {
+			t.Error("removed image is still found")
+		}
+		if err := st.RemoveImage(ctx, cat.FileURL, TombstoneTiny); err != nil {
+			t.Fatal(err)
+		}
+		if find(t, cat.FileURL) != nil {
+			t.Error("removed image is still found")
+		}
+		if done, err := st.Enriched(ctx, cat.FileURL, "pixel_width"); err != nil || !done {
+			t.Errorf("Enriched on a removed image = %v, %v", done, err)
+		}
+	})
+
+	t.Run("tombstones", func(t *testing.T) {
+		// an image only gone from its page comes back when seen again
+		back := dog
+		back.LastSeen = at(7)
+		if err := st.SaveImages(ctx, []ImageRecord{back}); err != nil {
+			t.Fatal(err)
+		}
+		got := find(t, dog.FileURL)
+		if got == nil {
+			t.Fatal("image gone from its page did not come back")
+		}
+		if got.AltText != dog.AltText || !got.FirstSeen.Equal(at(1)) || got.TimesSeen != 2 {
+			t.Errorf("revived %+v", got)
+		}
+
+		// one removed for another reason stays removed, copies included
+		again, dup := cat, cat
+		again.LastSeen = at(7)
+		dup.FileURL, dup.LastSeen = copyURL, at(7)
+		if err := st.SaveImages(ctx, []ImageRecord{again, dup}); err != nil {
+			t.Fatal(err)
+		}
+		if find(t, cat.FileURL) != nil || find(t, copyURL) != nil {
+			t.Error("image removed as tiny was stored again")
+		}
+		if err := st.MarkSeen(ctx, []string{cat.FileURL}, at(8)); err != nil {
+			t.Fatal(err)
+		}
+		if find(t, cat.FileURL) != nil {
+			t.Error("MarkSeen revived a removed image")
+		}
+
+		// a new URL with its content merges into the tombstone
+		twin := cat
+		twin.FileURL, twin.LastSeen = "https://mirror.example.test/cat.jpg", at(8)
+		if err := st.SaveImages(ctx, []ImageRecord{twin}); err != nil {
+			t.Fatal(err)
+		}
+		if merged, err := st.MergeByContentHash(ctx, twin.FileURL, "h1"); err != nil || !merged {
+			t.Fatalf("MergeByContentHash into a tombstone = %v, %v", merged, err)
+		}
+		if err := st.SaveImages(ctx, []ImageRecord{twin}); err != nil {
+			t.Fatal(err)
+		}
+		if find(t, twin.FileURL) != nil {
+			t.Error("copy of a removed image was stored")
+		}
+
+		// but not into one a sighting would bring back
+		if err := st.UpdateImage(ctx, dog.FileURL, Update{"content_hash": "h2"}, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.RemoveImages(ctx, page, []string{dog.FileURL}); err != nil {
+			t.Fatal(err)
+		}
+		other := dog
+		other.FileURL = "https://mirror.example.test/dog.jpg"
+		if err := st.SaveImages(ctx, []ImageRecord{other}); err != nil {
+			t.Fatal(err)
+		}
+		if merged, err := st.MergeByContentHash(ctx, other.FileURL, "h2"); err != nil || merged {
+			t.Errorf("MergeByContentHash into a gone image = %v, %v", merged, err)
+		}
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		sched, ok := Feature[Scheduler](st)
+		if !ok {
+			t.Fatal("store schedules no recrawls")
+		}
+		pages := []PageRecord{
+			{PageURL: page + "/2", NextVisit: at(2), RevisitInterval: time.Hour},
+			{PageURL: page + "/1", FinalURL: page + "/one", Redirects: []string{page + "/1", page + "/one"},
+				ETag: `"v1"`, ImageCount: 2, ImageURLs: []string{cat.FileURL, dog.FileURL},
+				TimeFetched: at(0), NextVisit: at(1), RevisitInterval: 24 * time.Hour},
+			{PageURL: page + "/later", NextVisit: at(10)},
+		}
+		for _, p := range pages {
+			if err := st.SavePage(ctx, p); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := st.LoadPage(ctx, page+"/1")
+		if err != nil || got == nil {
+			t.Fatalf("LoadPage = %v, %v", got, err)
+		}
+		if got.FinalURL != pages[1].FinalURL || got.ETag != `"v1"` || got.ImageCount != 2 ||
+			!slices.Equal(got.Redirects, pages[1].Redirects) || !slices.Equal(got.ImageURLs, pages[1].ImageURLs) ||
+			!got.TimeFetched.Equal(at(0)) || got.RevisitInterval != 24*time.Hour {
+			t.Errorf("LoadPage = %+v", got)
+		}
+		if missing, err := st.LoadPage(ctx, page+"/none"); err != nil || missing != nil {
+			t.Errorf("LoadPage of an unknown page = %v, %v", missing, err)
+		}
+
+		due, err := sched.DuePages(ctx, at(5), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) != 2 || due[0].PageURL != page+"/1" || due[1].PageURL != page+"/2" {
+			t.Fatalf("DuePages = %v, want /1 then /2", due)
+		}
+		if err := sched.PostponePage(ctx, page+"/1", at(7)); err != nil {
+			t.Fatal(err)
+		}
+		due, err = sched.DuePages(ctx, at(5), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) != 1 || due[0].PageURL != page+"/2" {
+			t.Errorf("DuePages after postponing = %v, want /2", due)
+		}
+		if due, err := sched.DuePages(ctx, at(20), 1); err != nil || len(due) != 1 {
+			t.Errorf("DuePages with limit 1 = %d pages, %v", len(due), err)
+		}
+	})
+}
@@ -0,0 +1,254 @@
+// Package store keeps image and page records: the Store interface, its
+// Mongo, Postgres, SQLite and in-memory providers, and the Mongo schema
+// (indexes, tombstones and migrations).
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   RECORD STORE
+	==============================
+*/
+
+const (
+	StoreMongo    = "mongo"
+	StorePostgres = "postgres"
+	StoreSQLite   = "sqlite"
+	StoreMemory   = "memory"
+)
+
+// Store keeps what the crawl and enrichment write: image records, keyed
+// by file URL, and the fetch metadata of each page. Mongo is the main
+// provider; the others back small or SQL-based deployments.
+//
+// SaveImages upserts a batch of images, except URLs merged into another
+// record, which is only refreshed. MarkSeen records another sighting of
+// the records stored under, or merged from, fileURLs without rewriting
+// them. Enriched reports whether link already has marker set or was
+// merged away. MergeByContentHash folds link into an existing record of
+// the same file and reports whether there was one. UpdateImage sets
+// fields on link's record, adding link to its known_urls when known is
+// set. RemoveImages and RemoveImage leave a tombstone in Mongo, which
+// records reason; the other stores delete.
+//
+// Search is a plain lookup for tools and tests, not the ranked search
+// the search API serves.
+type Store interface {
+	SaveImages(ctx context.Context, imgs []ImageRecord) error
+	RemoveImages(ctx context.Context, page string, fileURLs []string) error
+	MarkSeen(ctx context.Context, fileURLs []string, at time.Time) error
+	LoadPage(ctx context.Context, link string) (*PageRecord, error)
+	SavePage(ctx context.Context, page PageRecord) error
+
+	Enriched(ctx context.Context, link, marker string) (bool, error)
+	MergeByContentHash(ctx context.Context, link, hash string) (bool, error)
+	UpdateImage(ctx context.Context, link string, set Update, known bool) error
+	RemoveImage(ctx context.Context, link, reason string) error
+
+	Search(ctx context.Context, q Query) ([]ImageRecord, error)
+
+	Close(ctx context.Context) error
+}
+
+// Update holds the fields UpdateImage sets, named as records store them
+// (e.g. "pixel_width"), with plain Go values.
+type Update map[string]interface{}
+
+// SearchLimit is the number of results a Query without a Limit gets.
+const SearchLimit = 50
+
+// Query selects live images for Search. Text matches alt text, caption
+// and page title, ignoring case; the other fields must match exactly when
+// set. Results come most recently seen first.
+type Query struct {
+	Text    string
+	Domain  string
+	Format  string
+	License string
+	Limit   int
+}
+
+func (q Query) limit() int {
+	if q.Limit <= 0 {
+		return SearchLimit
+	}
+	return q.Limit
+}
+
+// Open connects the store named by IMG_DB_DRIVER: mongo (the
+// default), postgres, sqlite or memory.
+func Open(ctx context.Context) (Store, error) {
+	switch kind := strings.ToLower(env.Get("IMG_DB_DRIVER", StoreMongo)); kind {
+	case StoreMongo:
+		return newMongoStore(ctx)
+	case StorePostgres:
+		return newPostgresStore(ctx)
+	case StoreSQLite:
+		return newSQLiteStore(ctx)
+	case StoreMemory:
+		return newMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown IMG_DB_DRIVER %q", kind)
+	}
+}
+
+// MongoCollection returns the image_files collection behind s, or nil for
+// other stores, the dry run included. Only features that exist for Mongo
+// alone use it: near duplicate groups, favicons, retention, link checks
+// and the admin commands. Each refuses to start without it, or says it is
+// off; features other stores can have go through Feature instead.
+func MongoCollection(s Store) *mongo.Collection {
+	switch s := s.(type) {
+	case *mongoStore:
+		return s.col
+	case *tracedStore:
+		return MongoCollection(s.Store)
+	}
+	return nil
+}
+
+// recordFields renders a record or an update the way Mongo stores it, by
+// its bson field names, for the SQL stores. file_url, known_urls and
+// last_seen have columns of their own and are left out; last_seen's is
+// named time_fetched.
+func recordFields(v interface{}) (map[string]interface{}, error) {
+	fields, err := bsonFields(v)
+	if err != nil {
+		return nil, err
+	}
+	delete(fields, "file_url")
+	delete(fields, "known_urls")
+	delete(fields, "last_seen")
+	return fields, nil
+}
+
+// bsonFields renders v as Mongo would store it, with plain values.
+func bsonFields(v interface{}) (map[string]interface{}, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return PlainValue(doc).(map[string]interface{}), nil
+}
+
+// decodeRecord reads a record back from the plain fields recordFields
+// and bsonFields produce, in which times are RFC 3339 strings and, after
+// a trip through JSON, numbers are float64.
+func decodeRecord(fields map[string]interface{}) (ImageRecord, error) {
+	var rec ImageRecord
+	data, err := bson.Marshal(withTimes(fields, reflect.TypeOf(rec)))
+	if err != nil {
+		return rec, err
+	}
+	dec, err := bson.NewDecoder(bsonrw.NewBSONDocumentReader(data))
+	if err != nil {
+		return rec, err
+	}
+	dec.AllowTruncatingDoubles()
+	err = dec.Decode(&rec)
+	return rec, err
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// withTimes parses the strings held by the time fields of struct type t,
+// at any depth, so they decode into time.Time again.
+func withTimes(fields map[string]interface{}, t reflect.Type) map[string]interface{} {
+	out := maps.Clone(fields)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("bson"), ",")
+		v, ok := out[name]
+		if !ok {
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer || ft.Kind() == reflect.Slice {
+			ft = ft.Elem()
+		}
+		switch {
+		case ft == timeType:
+			if s, ok := v.(string); ok {
+				if at, err := time.Parse(time.RFC3339Nano, s); err == nil {
+					out[name] = at
+				}
+			}
+		case ft.Kind() == reflect.Struct:
+			switch v := v.(type) {
+			case map[string]interface{}:
+				out[name] = withTimes(v, ft)
+			case []interface{}:
+				items := make([]interface{}, len(v))
+				for j, item := range v {
+					if m, ok := item.(map[string]interface{}); ok {
+						items[j] = withTimes(m, ft)
+					} else {
+						items[j] = item
+					}
+				}
+				out[name] = items
+			}
+		}
+	}
+	return out
+}
+
+// likePattern matches text anywhere, for LIKE/ILIKE with ESCAPE '\'.
+func likePattern(text string) string {
+	r := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return "%" + r.Replace(text) + "%"
+}
+
+func recordJSON(v interface{}) ([]byte, error) {
+	fields, err := recordFields(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// PlainValue turns BSON values into plain JSON ones: ObjectIDs as hex,
+// dates as RFC 3339, embedded documents as objects.
+func PlainValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case primitive.ObjectID:
+		return x.Hex()
+	case primitive.DateTime:
+		return x.Time().UTC().Format(time.RFC3339)
+	case primitive.Binary:
+		return x.Data
+	case bson.D:
+		m := make(map[string]interface{}, len(x))
+		for _, e := range x {
+			m[e.Key] = PlainValue(e.Value)
+		}
+		return m
+	case bson.A:
+		a := make([]interface{}, len(x))
+		for i, e := range x {
+			a[i] = PlainValue(e)
+		}
+		return a
+	}
+	return v
+}
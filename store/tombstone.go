@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+	==============================
+	   TOMBSTONES
+	==============================
+*/
+
+// why an image was removed
+const (
+	TombstoneGone     = "gone"     // no longer on its page
+	TombstoneExpired  = "expired"  // the retention sweep
+	TombstoneTiny     = "tiny"     // an icon or a beacon
+	TombstoneCorrupt  = "corrupt"  // IMG_MODE=purge
+	TombstoneTakedown = "takedown" // DMCA or operator request
+	TombstoneDead     = "dead"     // answered 404 or 410 past the grace period
+	TombstoneRollback = "rollback" // added by a crawl run that was rolled back
+)
+
+// Removing an image from Mongo replaces its record with a tombstone: its
+// keys, deleted_at and delete_reason. Downstream consumers sync deletions
+// by deleted_at, and crawls don't store a removed image again. Images that
+// were only gone, expired or rolled back come back when a crawl finds them
+// again; for the other reasons the tombstone stays, and a file with the same content
+// hash found under a new URL is merged into it, so it stays removed too.
+// The SQL stores keep tombstones the same way, in the document; the memory
+// store deletes records outright.
+
+// revivableReasons are the reasons a crawl seeing the image again lifts.
+var revivableReasons = []string{TombstoneGone, TombstoneExpired, TombstoneRollback}
+
+func revivable(reason string) bool {
+	return slices.Contains(revivableReasons, reason)
+}
+
+// tombstoneFields are the document fields a tombstone keeps in the SQL
+// stores, which hold file_url, known_urls and last_seen in columns.
+var tombstoneFields = []string{
+	"page_url", "domain_name", "content_hash", "schema_version", "first_run_id", "first_seen", "times_seen",
+}
+
+// Live matches records that are not tombstones.
+var Live = bson.M{"$exists": false}
+
+// tombstone is the pipeline update that turns records into tombstones.
+func tombstone(reason string, at time.Time) mongo.Pipeline {
+	return mongo.Pipeline{{{Key: "$replaceWith", Value: bson.M{
+		"_id":            "$_id",
+		"file_url":       "$file_url",
+		"known_urls":     "$known_urls",
+		"page_url":       "$page_url",
+		"domain_name":    "$domain_name",
+		"content_hash":   "$content_hash",
+		"schema_version": "$schema_version",
+		"first_run_id":   "$first_run_id",
+		"first_seen":     "$first_seen",
+		"last_seen":      "$last_seen",
+		"times_seen":     "$times_seen",
+		"deleted_at":     at,
+		"delete_reason":  reason,
+	}}}}
+}
+
+// RemoveMatching tombstones the live records matching filter.
+func RemoveMatching(ctx context.Context, col *mongo.Collection, filter bson.M, reason string) (int64, error) {
+	filter["deleted_at"] = Live
+	res, err := col.UpdateMany(ctx, filter, tombstone(reason, time.Now().UTC()))
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// TakeDown tombstones link for good: the record stored for it, or the one
+// it was merged into, or a new tombstone if it was never stored, so a
+// crawl finding it later doesn't store it.
+func TakeDown(ctx context.Context, col *mongo.Collection, link string) error {
+	at := time.Now().UTC()
+	res, err := col.UpdateMany(ctx,
+		bson.M{"$or": bson.A{bson.M{"file_url": link}, bson.M{"known_urls": link}}},
+		tombstone(TombstoneTakedown, at))
+	if err != nil || res.MatchedCount > 0 {
+		return err
+	}
+	_, err = col.UpdateOne(ctx, bson.M{"file_url": link}, tombstone(TombstoneTakedown, at),
+		options.Update().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"image_crawler/internal/env"
+)
+
+/*
+	==============================
+	   TRACED STORE
+	==============================
+*/
+
+// tracedStore gives each store call a span, so slow writes show up in
+// the trace of the page or image they were made for.
+type tracedStore struct {
+	Store
+	system string
+}
+
+var tracer = otel.Tracer("image_crawler/store")
+
+// NewTraced wraps s so every call gets a span.
+func NewTraced(s Store) Store {
+	return &tracedStore{Store: s, system: env.Get("IMG_DB_DRIVER", StoreMongo)}
+}
+
+func (s *tracedStore) start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "store."+op, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, attribute.String("db.system", s.system))...))
+}
+
+func (s *tracedStore) SaveImages(ctx context.Context, imgs []ImageRecord) (err error) {
+	ctx, span := s.start(ctx, "SaveImages", attribute.Int("images", len(imgs)))
+	defer func() { endSpan(span, err) }()
+	return s.Store.SaveImages(ctx, imgs)
+}
+
+func (s *tracedStore) RemoveImages(ctx context.Context, page string, fileURLs []string) (err error) {
+	ctx, span := s.start(ctx, "RemoveImages", attribute.String("page.url", page), attribute.Int("images", len(fileURLs)))
+	defer func() { endSpan(span, err) }()
+	return s.Store.RemoveImages(ctx, page, fileURLs)
+}
+
+func (s *tracedStore) MarkSeen(ctx context.Context, fileURLs []string, at time.Time) (err error) {
+	ctx, span := s.start(ctx, "MarkSeen", attribute.Int("images", len(fileURLs)))
+	defer func() { endSpan(span, err) }()
+	return s.Store.MarkSeen(ctx, fileURLs, at)
+}
+
+func (s *tracedStore) LoadPage(ctx context.Context, link string) (_ *PageRecord, err error) {
+	ctx, span := s.start(ctx, "LoadPage", attribute.String("page.url", link))
+	defer func() { endSpan(span, err) }()
+	return s.Store.LoadPage(ctx, link)
+}
+
+func (s *tracedStore) SavePage(ctx context.Context, page PageRecord) (err error) {
+	ctx, span := s.start(ctx, "SavePage", attribute.String("page.url", page.FinalURL))
+	defer func() { endSpan(span, err) }()
+	return s.Store.SavePage(ctx, page)
+}
+
+func (s *tracedStore) Enriched(ctx context.Context, link, marker string) (_ bool, err error) {
+	ctx, span := s.start(ctx, "Enriched", attribute.String("image.url", link))
+	defer func() { endSpan(span, err) }()
+	return s.Store.Enriched(ctx, link, marker)
+}
+
+func (s *tracedStore) MergeByContentHash(ctx context.Context, link, hash string) (_ bool, err error) {
+	ctx, span := s.start(ctx, "MergeByContentHash", attribute.String("image.url", link))
+	defer func() { endSpan(span, err) }()
+	return s.Store.MergeByContentHash(ctx, link, hash)
+}
+
+func (s *tracedStore) UpdateImage(ctx context.Context, link string, set Update, known bool) (err error) {
+	ctx, span := s.start(ctx, "UpdateImage", attribute.String("image.url", link))
+	defer func() { endSpan(span, err) }()
+	return s.Store.UpdateImage(ctx, link, set, known)
+}
+
+func (s *tracedStore) RemoveImage(ctx context.Context, link, reason string) (err error) {
+	ctx, span := s.start(ctx, "RemoveImage", attribute.String("image.url", link), attribute.String("reason", reason))
+	defer func() { endSpan(span, err) }()
+	return s.Store.RemoveImage(ctx, link, reason)
+}
+
+func (s *tracedStore) Search(ctx context.Context, q Query) (_ []ImageRecord, err error) {
+	ctx, span := s.start(ctx, "Search", attribute.String("query", q.Text))
+	defer func() { endSpan(span, err) }()
+	return s.Store.Search(ctx, q)
+}
+
+func (s *tracedStore) DuePages(ctx context.Context, now time.Time, limit int) (_ []PageRecord, err error) {
+	sch, ok := s.Store.(Scheduler)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	ctx, span := s.start(ctx, "DuePages", attribute.Int("limit", limit))
+	defer func() { endSpan(span, err) }()
+	return sch.DuePages(ctx, now, limit)
+}
+
+func (s *tracedStore) PostponePage(ctx context.Context, link string, until time.Time) (err error) {
+	sch, ok := s.Store.(Scheduler)
+	if !ok {
+		return ErrUnsupported
+	}
+	ctx, span := s.start(ctx, "PostponePage", attribute.String("page.url", link))
+	defer func() { endSpan(span, err) }()
+	return sch.PostponePage(ctx, link, until)
+}
+
+func (s *tracedStore) RecordFailure(ctx context.Context, f FetchFailure) (err error) {
+	log, ok := s.Store.(FailureLog)
+	if !ok {
+		return ErrUnsupported
+	}
+	ctx, span := s.start(ctx, "RecordFailure", attribute.String("page.url", f.PageURL))
+	defer func() { endSpan(span, err) }()
+	return log.RecordFailure(ctx, f)
+}
+
+func (s *tracedStore) SaveRun(ctx context.Context, run RunRecord) (err error) {
+	log, ok := s.Store.(RunLog)
+	if !ok {
+		return ErrUnsupported
+	}
+	ctx, span := s.start(ctx, "SaveRun", attribute.String("run.id", run.ID))
+	defer func() { endSpan(span, err) }()
+	return log.SaveRun(ctx, run)
+}
+
+func (s *tracedStore) unwrap() Store { return s.Store }
+
+// endSpan marks span failed when err is set and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
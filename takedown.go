@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"image_crawler/store"
+)
+
+/*
+	==============================
+	   TAKEDOWN
+	==============================
+*/
+
+// runTakedown removes the image URLs given as arguments, or one per line
+// on stdin (image_crawler takedown).
+func runTakedown(ctx context.Context, col *mongo.Collection, links []string) error {
+	if len(links) == 0 {
+		var err error
+		if links, err = readLines(os.Stdin); err != nil {
+			return err
+		}
+	}
+	if len(links) == 0 {
+		return fmt.Errorf("no image URLs to take down")
+	}
+	for _, link := range links {
+		if err := store.TakeDown(ctx, col, link); err != nil {
+			return fmt.Errorf("%s: %w", link, err)
+		}
+	}
+	slog.Info("Took down images", "count", len(links))
+	return nil
+}
+
+// readLines returns the non-blank lines of r, trimmed.
+func readLines(r io.Reader) ([]string, error) {
+	var out []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out, sc.Err()
+}